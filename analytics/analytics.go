@@ -0,0 +1,306 @@
+// Package analytics keeps daily rollups of platform metrics for the superadmin dashboard
+// and analytics pages, so they read a handful of precomputed rows instead of counting
+// every user and company on each page load.
+package analytics
+
+import (
+	"cargozig_api/models"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshDays is how many recent days of event metrics a refresh recounts. Older days only
+// change when records are deleted, and are recounted by Rebuild.
+const RefreshDays = 2
+
+// RecentDays is the window Summary reports recent activity over
+const RecentDays = 30
+
+// Intervals a series can be bucketed by
+var Intervals = map[string]bool{"day": true, "week": true, "month": true}
+
+// eventQueries select the dimension, day and count of records created since @since, per
+// event metric. Users with several roles count towards each of them.
+var eventQueries = map[string]string{
+	models.MetricSignups: `
+		SELECT role, (created_at AT TIME ZONE 'UTC')::date, COUNT(*)
+		FROM users, unnest(roles) AS role
+		WHERE deleted_at IS NULL AND created_at >= @since
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT 'total', (created_at AT TIME ZONE 'UTC')::date, COUNT(*)
+		FROM users
+		WHERE deleted_at IS NULL AND created_at >= @since
+		GROUP BY 2`,
+	models.MetricCompaniesCreated: `
+		SELECT COALESCE(NULLIF(company_type, ''), 'unknown'), (created_at AT TIME ZONE 'UTC')::date, COUNT(*)
+		FROM companies
+		WHERE deleted_at IS NULL AND created_at >= @since
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT 'total', (created_at AT TIME ZONE 'UTC')::date, COUNT(*)
+		FROM companies
+		WHERE deleted_at IS NULL AND created_at >= @since
+		GROUP BY 2`,
+	models.MetricContacts: `
+		SELECT COALESCE(NULLIF(status, ''), 'new'), (created_at AT TIME ZONE 'UTC')::date, COUNT(*)
+		FROM contacts
+		WHERE deleted_at IS NULL AND created_at >= @since
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT 'total', (created_at AT TIME ZONE 'UTC')::date, COUNT(*)
+		FROM contacts
+		WHERE deleted_at IS NULL AND created_at >= @since
+		GROUP BY 2`,
+}
+
+// snapshotQueries select the dimension and count of each snapshot metric as of @now
+var snapshotQueries = map[string]string{
+	models.MetricUsers: `
+		SELECT 'total', COUNT(*) FROM users WHERE deleted_at IS NULL
+		UNION ALL
+		SELECT role, COUNT(*) FROM users, unnest(roles) AS role WHERE deleted_at IS NULL GROUP BY 1`,
+	models.MetricCompanies: `
+		SELECT COALESCE(NULLIF(company_type, ''), 'unknown') || CASE WHEN verified THEN ':verified' ELSE ':unverified' END, COUNT(*)
+		FROM companies
+		WHERE deleted_at IS NULL
+		GROUP BY 1`,
+	models.MetricActiveUsers: `
+		SELECT w.dimension, COUNT(u.id)
+		FROM (VALUES ('1d', interval '1 day'), ('7d', interval '7 days'), ('30d', interval '30 days')) AS w(dimension, span)
+		LEFT JOIN users u ON u.deleted_at IS NULL AND u.active AND u.last_login >= CAST(@now AS timestamptz) - w.span
+		GROUP BY 1`,
+}
+
+// Known reports whether metric is one the rollups keep
+func Known(metric string) bool {
+	return eventQueries[metric] != "" || snapshotQueries[metric] != ""
+}
+
+// Metrics lists every metric the rollups keep
+func Metrics() []string {
+	return []string{
+		models.MetricSignups, models.MetricCompaniesCreated, models.MetricContacts,
+		models.MetricUsers, models.MetricCompanies, models.MetricActiveUsers,
+	}
+}
+
+// day formats the UTC day of t for the rollups' date buckets
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// Refresh recounts the last RefreshDays of event metrics and takes today's snapshots. The
+// first refresh, with no rollups yet, counts everything.
+func Refresh(db *gorm.DB) error {
+	var exists bool
+	err := db.Raw("SELECT EXISTS (SELECT 1 FROM analytics_rollups WHERE metric = ? AND deleted_at IS NULL)", models.MetricSignups).
+		Scan(&exists).Error
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return Rebuild(db)
+	}
+	// From the start of the first day, as the rollups for that whole day are replaced
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-RefreshDays)
+	return refresh(db, since)
+}
+
+// Rebuild recounts every day of the event metrics, picking up records deleted since they
+// were counted, and takes today's snapshots
+func Rebuild(db *gorm.DB) error {
+	return refresh(db, time.Time{})
+}
+
+// refreshLockKey is the Postgres advisory lock refreshes take, see refresh
+var refreshLockKey = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("analytics-refresh"))
+	return int64(h.Sum64())
+}()
+
+// refresh replaces the event rollups from since onwards and today's snapshots in one
+// transaction, so readers never see a half refreshed day. Refreshes wait for each other,
+// as two at once would insert the same rollups.
+func refresh(db *gorm.DB, since time.Time) error {
+	now := time.Now()
+	rows := int64(0)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", refreshLockKey).Error; err != nil {
+			return err
+		}
+
+		for metric, query := range eventQueries {
+			args := map[string]interface{}{"metric": metric, "since": since, "from": day(since), "now": now}
+			if err := tx.Exec("DELETE FROM analytics_rollups WHERE metric = @metric AND bucket >= CAST(@from AS date)", args).Error; err != nil {
+				return err
+			}
+			result := tx.Exec(`INSERT INTO analytics_rollups (metric, dimension, bucket, count, created_at, updated_at)
+				SELECT @metric, dimension, bucket, count, @now, @now FROM (`+query+`) AS r(dimension, bucket, count)`, args)
+			if result.Error != nil {
+				return fmt.Errorf("%s: %v", metric, result.Error)
+			}
+			rows += result.RowsAffected
+		}
+
+		for metric, query := range snapshotQueries {
+			args := map[string]interface{}{"metric": metric, "today": day(now), "now": now}
+			if err := tx.Exec("DELETE FROM analytics_rollups WHERE metric = @metric AND bucket = CAST(@today AS date)", args).Error; err != nil {
+				return err
+			}
+			result := tx.Exec(`INSERT INTO analytics_rollups (metric, dimension, bucket, count, created_at, updated_at)
+				SELECT @metric, dimension, CAST(@today AS date), count, @now, @now FROM (`+query+`) AS s(dimension, count)`, args)
+			if result.Error != nil {
+				return fmt.Errorf("%s: %v", metric, result.Error)
+			}
+			rows += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Analytics rollups: %d rows written since %s\n", rows, day(since))
+	return nil
+}
+
+// Point is one bucket of a series
+type Point struct {
+	Bucket    time.Time `json:"bucket"`
+	Dimension string    `json:"dimension"`
+	Count     int64     `json:"count"`
+}
+
+// Series returns a metric's rollups from from up to but excluding to, bucketed by interval.
+// Event metrics are summed over each bucket; snapshot metrics take the last snapshot in it.
+func Series(db *gorm.DB, metric, interval string, from, to time.Time) ([]Point, error) {
+	if !Known(metric) {
+		return nil, fmt.Errorf("unknown metric %q", metric)
+	}
+	if !Intervals[interval] {
+		return nil, fmt.Errorf("interval must be day, week or month")
+	}
+
+	// interval is one of Intervals, so it can be written into the query. Passed as a
+	// parameter, the DISTINCT ON and ORDER BY expressions would no longer match.
+	args := map[string]interface{}{"metric": metric, "from": day(from), "to": day(to)}
+	where := "metric = @metric AND deleted_at IS NULL AND bucket >= CAST(@from AS date) AND bucket < CAST(@to AS date)"
+	trunc := "date_trunc('" + interval + "', analytics_rollups.bucket)"
+
+	var query string
+	if eventQueries[metric] != "" {
+		query = `SELECT ` + trunc + `::date AS bucket, dimension, SUM(count) AS count
+			FROM analytics_rollups WHERE ` + where + `
+			GROUP BY 1, 2 ORDER BY 1, 2`
+	} else {
+		query = `SELECT DISTINCT ON (` + trunc + `, dimension) ` + trunc + `::date AS bucket, dimension, count
+			FROM analytics_rollups WHERE ` + where + `
+			ORDER BY ` + trunc + `, dimension, analytics_rollups.bucket DESC`
+	}
+
+	var points []Point
+	if err := db.Raw(query, args).Scan(&points).Error; err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// Summary is the platform as of the latest snapshot, with activity over the last RecentDays
+type Summary struct {
+	AsOf        *time.Time       `json:"as_of"`        // When the snapshot was taken; nil when counted live
+	Users       map[string]int64 `json:"users"`        // By role, plus "total"
+	Companies   map[string]int64 `json:"companies"`    // By type and verification, e.g. "carrier:verified"
+	ActiveUsers map[string]int64 `json:"active_users"` // By window, e.g. "7d"
+	Recent      map[string]int64 `json:"recent"`       // Event metric totals, e.g. signups
+}
+
+// TotalCompanies counts companies of every type
+func (s *Summary) TotalCompanies() int64 {
+	var total int64
+	for _, count := range s.Companies {
+		total += count
+	}
+	return total
+}
+
+// VerifiedCompanies counts verified companies of every type
+func (s *Summary) VerifiedCompanies() int64 {
+	var total int64
+	for dimension, count := range s.Companies {
+		if strings.HasSuffix(dimension, ":verified") {
+			total += count
+		}
+	}
+	return total
+}
+
+// Latest returns the summary from the latest snapshot. Until the first refresh has run, the
+// snapshot is counted live instead.
+func Latest(db *gorm.DB) (*Summary, error) {
+	summary := &Summary{
+		Users:       map[string]int64{},
+		Companies:   map[string]int64{},
+		ActiveUsers: map[string]int64{},
+		Recent:      map[string]int64{},
+	}
+	byMetric := map[string]map[string]int64{
+		models.MetricUsers:       summary.Users,
+		models.MetricCompanies:   summary.Companies,
+		models.MetricActiveUsers: summary.ActiveUsers,
+	}
+
+	var snapshot []models.AnalyticsRollup
+	err := db.Where("metric IN ?", []string{models.MetricUsers, models.MetricCompanies, models.MetricActiveUsers}).
+		Where("bucket = (?)", db.Model(&models.AnalyticsRollup{}).Select("MAX(bucket)").Where("metric = ?", models.MetricUsers)).
+		Find(&snapshot).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshot) > 0 {
+		asOf := snapshot[0].UpdatedAt
+		summary.AsOf = &asOf
+		for _, rollup := range snapshot {
+			byMetric[rollup.Metric][rollup.Dimension] = rollup.Count
+		}
+	} else {
+		for metric, query := range snapshotQueries {
+			var counts []struct {
+				Dimension string
+				Count     int64
+			}
+			err := db.Raw("SELECT dimension, count FROM ("+query+") AS s(dimension, count)", map[string]interface{}{"now": time.Now()}).
+				Scan(&counts).Error
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range counts {
+				byMetric[metric][c.Dimension] = c.Count
+			}
+		}
+	}
+
+	var recent []struct {
+		Metric string
+		Count  int64
+	}
+	err = db.Model(&models.AnalyticsRollup{}).Select("metric, SUM(count) AS count").
+		Where("metric IN ? AND dimension = ?", []string{models.MetricSignups, models.MetricCompaniesCreated, models.MetricContacts}, models.DimensionTotal).
+		Where("bucket >= CAST(? AS date)", day(time.Now().AddDate(0, 0, 1-RecentDays))).
+		Group("metric").Scan(&recent).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recent {
+		summary.Recent[r.Metric] = r.Count
+	}
+
+	return summary, nil
+}
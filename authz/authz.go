@@ -0,0 +1,138 @@
+// Package authz works out what a user may do in their active company. Roles are defined
+// in the database (models.RoleDefinition): a user's permissions are everything their
+// roles and personal grants allow, less anything denied by a role or by the user's own
+// denies. Middleware and handlers all go through User.HasPermission once Resolve has run.
+package authz
+
+import (
+	"cargozig_api/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// cacheTTL is how long a company's role definitions are reused before reloading
+const cacheTTL = 30 * time.Second
+
+type cacheEntry struct {
+	roles  map[models.Role]*models.RoleDefinition
+	loaded time.Time
+}
+
+// cache holds role definitions by company ID
+var cache sync.Map
+
+// Invalidate drops the cached role definitions, call it after changing a role
+func Invalidate() {
+	cache.Range(func(key, _ interface{}) bool {
+		cache.Delete(key)
+		return true
+	})
+}
+
+// Roles returns the roles available in a company: the platform roles, with any of the
+// company's own roles replacing a platform role of the same name
+func Roles(db *gorm.DB, companyID uuid.UUID) (map[models.Role]*models.RoleDefinition, error) {
+	if v, ok := cache.Load(companyID); ok {
+		if entry := v.(cacheEntry); time.Since(entry.loaded) < cacheTTL {
+			return entry.roles, nil
+		}
+	}
+
+	var definitions []models.RoleDefinition
+	if err := db.Where("company_id IS NULL OR company_id = ?", companyID).
+		Order("company_id NULLS FIRST").Find(&definitions).Error; err != nil {
+		return nil, err
+	}
+
+	roles := make(map[models.Role]*models.RoleDefinition, len(definitions))
+	for i := range definitions {
+		roles[definitions[i].Name] = &definitions[i]
+	}
+
+	// Until the roles are seeded the built in permissions still apply
+	if len(roles) == 0 {
+		for name, permissions := range models.DefaultRolePermissions {
+			roles[name] = &models.RoleDefinition{Name: name, Permissions: permissions, BuiltIn: true}
+		}
+	}
+
+	cache.Store(companyID, cacheEntry{roles: roles, loaded: time.Now()})
+	return roles, nil
+}
+
+// RoleExists reports whether role can be assigned in the company
+func RoleExists(db *gorm.DB, companyID uuid.UUID, role models.Role) (bool, error) {
+	roles, err := Roles(db, companyID)
+	if err != nil {
+		return false, err
+	}
+	_, ok := roles[role]
+	return ok, nil
+}
+
+// Resolve works out the user's permissions in their active company and stores them on
+// the user, after which User.HasPermission answers from them. Apply any company
+// membership first so its roles and grants are the ones used.
+func Resolve(db *gorm.DB, user *models.User) error {
+	roles, err := Roles(db, user.CompanyID)
+	if err != nil {
+		return err
+	}
+
+	set := models.PermissionSet{
+		Allowed: map[models.Permission]bool{},
+		Denied:  map[models.Permission]bool{},
+	}
+	for _, name := range user.Roles {
+		role, ok := roles[name]
+		if !ok {
+			continue
+		}
+		for _, p := range role.Permissions {
+			set.Allowed[p] = true
+		}
+		for _, p := range role.Denies {
+			set.Denied[p] = true
+		}
+	}
+	for _, p := range user.Permissions {
+		set.Allowed[p] = true
+	}
+	for _, p := range user.DeniedPermissions {
+		set.Denied[p] = true
+	}
+
+	user.Resolved = &set
+	return nil
+}
+
+// SeedRoles stores the built in roles as platform roles the first time the platform starts,
+// so they can be edited like any other role
+func SeedRoles(db *gorm.DB) error {
+	for name, permissions := range models.DefaultRolePermissions {
+		var count int64
+		if err := db.Model(&models.RoleDefinition{}).
+			Where("company_id IS NULL AND name = ?", name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		role := models.RoleDefinition{
+			Name:        name,
+			Permissions: models.PermissionArray(permissions),
+			Denies:      models.PermissionArray{},
+			BuiltIn:     true,
+		}
+		if err := db.Create(&role).Error; err != nil {
+			return err
+		}
+	}
+
+	Invalidate()
+	return nil
+}
@@ -0,0 +1,160 @@
+package authz
+
+import (
+	"cargozig_api/models"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Action is something an actor does to a resource, named "<resource>:<verb>"
+type Action string
+
+// Kind is the resource type the action applies to, e.g. "load"
+func (a Action) Kind() string {
+	kind, _, _ := strings.Cut(string(a), ":")
+	return kind
+}
+
+// Effect is what a matching rule does to the decision
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Request is what a rule is evaluated against
+type Request struct {
+	DB       *gorm.DB
+	Actor    *models.User
+	Action   Action
+	Resource interface{}
+}
+
+// Rule is a Go-defined policy rule. A rule applies to its actions when the actor holds
+// its permission (if any); When then decides whether it matches and explains why.
+type Rule struct {
+	Name       string
+	Actions    []Action // Empty applies to every action
+	Effect     Effect
+	Permission models.Permission
+	When       func(r *Request) (bool, string)
+}
+
+// Step records how one rule was evaluated
+type Step struct {
+	Rule    string `json:"rule"`
+	Effect  Effect `json:"effect"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// Decision is the outcome of Authorize and the reasoning behind it
+type Decision struct {
+	Allowed  bool   `json:"allowed"`
+	Action   Action `json:"action"`
+	Resource string `json:"resource"`
+	Rule     string `json:"rule,omitempty"` // The rule that decided, empty for the default deny
+	Reason   string `json:"reason"`
+	Trace    []Step `json:"trace"`
+}
+
+// policies holds the rules in the order they are evaluated
+var policies []Rule
+
+// AddRules adds rules to the policy
+func AddRules(rules ...Rule) {
+	policies = append(policies, rules...)
+}
+
+// Authorize decides whether actor may perform action on resource. Any matching deny
+// wins; otherwise any matching allow grants; with no match the action is denied. The
+// actor's permissions must already be resolved for their active company.
+func Authorize(db *gorm.DB, actor *models.User, action Action, resource interface{}) Decision {
+	decision := Decision{Action: action, Resource: Describe(resource)}
+	req := &Request{DB: db, Actor: actor, Action: action, Resource: resource}
+
+	var allow, deny *Step
+	for _, rule := range policies {
+		if !rule.appliesTo(action) {
+			continue
+		}
+
+		step := Step{Rule: rule.Name, Effect: rule.Effect}
+		if rule.Permission != "" && !actor.HasPermission(rule.Permission) {
+			step.Reason = fmt.Sprintf("actor lacks the %s permission", rule.Permission)
+		} else {
+			step.Matched, step.Reason = rule.When(req)
+		}
+		decision.Trace = append(decision.Trace, step)
+
+		if step.Matched {
+			last := &decision.Trace[len(decision.Trace)-1]
+			if rule.Effect == Deny && deny == nil {
+				deny = last
+			} else if rule.Effect == Allow && allow == nil {
+				allow = last
+			}
+		}
+	}
+
+	switch {
+	case deny != nil:
+		decision.Rule, decision.Reason = deny.Rule, deny.Reason
+	case allow != nil:
+		decision.Allowed = true
+		decision.Rule, decision.Reason = allow.Rule, allow.Reason
+	default:
+		decision.Reason = "no rule allows this action"
+	}
+	return decision
+}
+
+// Can is Authorize when only the answer matters
+func Can(db *gorm.DB, actor *models.User, action Action, resource interface{}) bool {
+	return Authorize(db, actor, action, resource).Allowed
+}
+
+func (r Rule) appliesTo(action Action) bool {
+	if len(r.Actions) == 0 {
+		return true
+	}
+	for _, a := range r.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// finders load resources by kind and ID for the explain endpoint
+var finders = map[string]func(db *gorm.DB, id string) (interface{}, error){}
+
+// RegisterResource lets Find load resources of kind
+func RegisterResource(kind string, find func(db *gorm.DB, id string) (interface{}, error)) {
+	finders[kind] = find
+}
+
+// Find loads the resource an action applies to. db should not be tenant scoped, since
+// the policy rather than ownership decides who sees it.
+func Find(db *gorm.DB, kind, id string) (interface{}, error) {
+	find, ok := finders[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %q", kind)
+	}
+	return find(db, id)
+}
+
+// Describe names a resource for decisions and logs
+func Describe(resource interface{}) string {
+	switch r := resource.(type) {
+	case *models.Load:
+		return "load " + r.ID.String()
+	case nil:
+		return "none"
+	default:
+		return fmt.Sprintf("%T", resource)
+	}
+}
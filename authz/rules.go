@@ -0,0 +1,225 @@
+package authz
+
+import (
+	"cargozig_api/models"
+
+	"gorm.io/gorm"
+)
+
+// Load actions
+const (
+	LoadView      Action = "load:view"
+	LoadEdit      Action = "load:edit"
+	LoadPost      Action = "load:post"
+	LoadBid       Action = "load:bid"
+	LoadAward     Action = "load:award"
+	LoadPickup    Action = "load:pickup"
+	LoadRecordPOD Action = "load:record_pod"
+	LoadCancel    Action = "load:cancel"
+	LoadComplete  Action = "load:complete"
+)
+
+// Actions lists every action the policy knows, for the explain endpoint
+var Actions = []Action{LoadView, LoadEdit, LoadPost, LoadBid, LoadAward, LoadPickup, LoadRecordPOD, LoadCancel, LoadComplete}
+
+func init() {
+	RegisterResource("load", func(db *gorm.DB, id string) (interface{}, error) {
+		var load models.Load
+		if err := db.First(&load, "id = ?", id).Error; err != nil {
+			return nil, err
+		}
+		return &load, nil
+	})
+
+	AddRules(
+		Rule{
+			Name:       "system-admin",
+			Effect:     Allow,
+			Permission: models.SystemAdmin,
+			When: func(r *Request) (bool, string) {
+				return true, "system admins can act on any resource"
+			},
+		},
+		Rule{
+			Name:       "load-shipper",
+			Actions:    []Action{LoadView},
+			Effect:     Allow,
+			Permission: models.ViewShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if load.ShipperCompanyID == r.Actor.CompanyID {
+					return true, "actor's company is the shipper"
+				}
+				return false, "actor's company is not the shipper"
+			}),
+		},
+		Rule{
+			Name:       "load-assigned-carrier",
+			Actions:    []Action{LoadView},
+			Effect:     Allow,
+			Permission: models.ViewShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if isCarrierOf(r.Actor, load) {
+					return true, "actor's company is the assigned carrier"
+				}
+				return false, "actor's company is not the assigned carrier"
+			}),
+		},
+		Rule{
+			Name:       "load-posted-publicly",
+			Actions:    []Action{LoadView},
+			Effect:     Allow,
+			Permission: models.ViewShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if !hasRole(r.Actor, models.RoleCarrier) {
+					return false, "actor is not a carrier"
+				}
+				if load.Status != models.LoadStatusPosted {
+					return false, "load is " + string(load.Status) + ", not posted"
+				}
+				return true, "load is posted publicly and actor is a carrier"
+			}),
+		},
+		Rule{
+			Name:       "load-bidder",
+			Actions:    []Action{LoadView},
+			Effect:     Allow,
+			Permission: models.ViewShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				var bids int64
+				r.DB.Model(&models.LoadBid{}).
+					Where("load_id = ? AND carrier_company_id = ?", load.ID, r.Actor.CompanyID).Count(&bids)
+				if bids > 0 {
+					return true, "actor's company bid on the load"
+				}
+				return false, "actor's company has not bid on the load"
+			}),
+		},
+		Rule{
+			Name:       "load-shipper-draft",
+			Actions:    []Action{LoadEdit, LoadPost},
+			Effect:     Allow,
+			Permission: models.EditShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if load.ShipperCompanyID != r.Actor.CompanyID {
+					return false, "actor's company is not the shipper"
+				}
+				if load.Status != models.LoadStatusDraft {
+					return false, "load is " + string(load.Status) + ", only drafts can be changed"
+				}
+				return true, "actor's company is the shipper and the load is a draft"
+			}),
+		},
+		Rule{
+			Name:       "load-carrier-pod",
+			Actions:    []Action{LoadPickup, LoadRecordPOD},
+			Effect:     Allow,
+			Permission: models.ViewShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if isCarrierOf(r.Actor, load) {
+					return true, "actor's company is the assigned carrier"
+				}
+				return false, "actor's company is not the assigned carrier"
+			}),
+		},
+		Rule{
+			Name:       "load-party-progress",
+			Actions:    []Action{LoadPickup, LoadRecordPOD, LoadCancel, LoadComplete},
+			Effect:     Allow,
+			Permission: models.EditShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if load.ShipperCompanyID == r.Actor.CompanyID {
+					return true, "actor's company is the shipper"
+				}
+				if isCarrierOf(r.Actor, load) {
+					return true, "actor's company is the assigned carrier"
+				}
+				return false, "actor's company is not a party to the load"
+			}),
+		},
+		Rule{
+			Name:       "load-carrier-bid",
+			Actions:    []Action{LoadBid},
+			Effect:     Allow,
+			Permission: models.ViewShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if !hasRole(r.Actor, models.RoleCarrier) {
+					return false, "actor is not a carrier"
+				}
+				if load.Status != models.LoadStatusPosted {
+					return false, "load is " + string(load.Status) + ", not posted"
+				}
+				return true, "load is posted and actor is a carrier"
+			}),
+		},
+		Rule{
+			Name:    "load-bid-own-load",
+			Actions: []Action{LoadBid},
+			Effect:  Deny,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if load.ShipperCompanyID == r.Actor.CompanyID {
+					return true, "companies cannot bid on their own loads"
+				}
+				return false, "load belongs to another company"
+			}),
+		},
+		Rule{
+			Name:    "load-bid-carrier-standing",
+			Actions: []Action{LoadBid},
+			Effect:  Deny,
+			When: func(r *Request) (bool, string) {
+				var company models.Company
+				if err := r.DB.First(&company, "id = ?", r.Actor.CompanyID).Error; err != nil {
+					return true, "actor's company could not be found"
+				}
+				if !company.Verified {
+					return true, "actor's company has not passed onboarding review"
+				}
+				if company.ComplianceHold {
+					return true, "actor's company is on compliance hold"
+				}
+				return false, "actor's company is verified and not on hold"
+			},
+		},
+		Rule{
+			Name:       "load-shipper-award",
+			Actions:    []Action{LoadAward},
+			Effect:     Allow,
+			Permission: models.EditShipment,
+			When: onLoad(func(r *Request, load *models.Load) (bool, string) {
+				if load.ShipperCompanyID != r.Actor.CompanyID {
+					return false, "actor's company is not the shipper"
+				}
+				if load.Status != models.LoadStatusPosted {
+					return false, "load is " + string(load.Status) + ", not posted"
+				}
+				return true, "actor's company is the shipper and the load is posted"
+			}),
+		},
+	)
+}
+
+// onLoad adapts a load rule, not matching other resources
+func onLoad(when func(r *Request, load *models.Load) (bool, string)) func(r *Request) (bool, string) {
+	return func(r *Request) (bool, string) {
+		load, ok := r.Resource.(*models.Load)
+		if !ok || load == nil {
+			return false, "resource is not a load"
+		}
+		return when(r, load)
+	}
+}
+
+// isCarrierOf reports whether the actor's company is the load's assigned carrier
+func isCarrierOf(actor *models.User, load *models.Load) bool {
+	return load.CarrierCompanyID != nil && *load.CarrierCompanyID == actor.CompanyID
+}
+
+// hasRole reports whether the actor holds role in their active company
+func hasRole(actor *models.User, role models.Role) bool {
+	for _, r := range actor.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
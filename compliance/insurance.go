@@ -0,0 +1,256 @@
+// Package compliance keeps carriers' insurance coverage current, reminding them
+// before certificates expire and holding them from new bookings when coverage lapses.
+package compliance
+
+import (
+	"cargozig_api/models"
+	"cargozig_api/notify"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReminderDays are how many days before expiry a reminder is sent
+var ReminderDays = []int{30, 14, 3}
+
+// RunInsuranceChecks sends expiry reminders and updates compliance holds for every verified carrier
+func RunInsuranceChecks(db *gorm.DB) error {
+	sent, err := SendExpiryReminders(db)
+	if err != nil {
+		return err
+	}
+
+	var companies []models.Company
+	if err := db.Where("verified = ? AND company_type <> ?", true, "shipper").Find(&companies).Error; err != nil {
+		return err
+	}
+	changed := 0
+	for i := range companies {
+		updated, err := ApplyHold(db, &companies[i])
+		if err != nil {
+			return err
+		}
+		if updated {
+			changed++
+		}
+	}
+
+	fmt.Printf("Insurance checks: %d reminders sent, %d holds changed across %d carriers\n", sent, changed, len(companies))
+	return nil
+}
+
+// CoverageProblems lists what is missing from a carrier's required coverage at t, counting
+// certificates that are verified or still waiting for review
+func CoverageProblems(db *gorm.DB, companyID uuid.UUID, t time.Time) ([]string, error) {
+	return coverageProblems(db, companyID, t, models.CertificatePending, models.CertificateVerified)
+}
+
+// VerifiedCoverageProblems is CoverageProblems counting only certificates a reviewer has
+// verified
+func VerifiedCoverageProblems(db *gorm.DB, companyID uuid.UUID, t time.Time) ([]string, error) {
+	return coverageProblems(db, companyID, t, models.CertificateVerified)
+}
+
+// coverageProblems lists what is missing from the required coverage at t, counting the
+// certificates in the given review statuses
+func coverageProblems(db *gorm.DB, companyID uuid.UUID, t time.Time, statuses ...models.CertificateStatus) ([]string, error) {
+	var certificates []models.InsuranceCertificate
+	if err := db.Where("company_id = ? AND status IN ?", companyID, statuses).Order("expires_at DESC").Find(&certificates).Error; err != nil {
+		return nil, err
+	}
+
+	// Check required types in a stable order so the hold reason doesn't flap
+	var required []models.CoverageType
+	for coverage := range models.RequiredCoverage {
+		required = append(required, coverage)
+	}
+	sort.Slice(required, func(i, j int) bool { return required[i] < required[j] })
+
+	var problems []string
+	for _, coverage := range required {
+		minimum := models.RequiredCoverage[coverage]
+		label := coverageLabel(coverage)
+
+		var latest, underLimit *models.InsuranceCertificate
+		covered := false
+		for i := range certificates {
+			cert := &certificates[i]
+			if cert.CoverageType != coverage {
+				continue
+			}
+			if latest == nil {
+				latest = cert
+			}
+			if cert.ActiveAt(t) {
+				if cert.LimitCents >= minimum {
+					covered = true
+					break
+				}
+				underLimit = cert
+			}
+		}
+
+		switch {
+		case covered:
+		case underLimit != nil:
+			problems = append(problems, fmt.Sprintf("%s limit %s is below the %s minimum", label, dollars(underLimit.LimitCents), dollars(minimum)))
+		case latest != nil && latest.ExpiresAt.After(t):
+			problems = append(problems, fmt.Sprintf("%s coverage does not start until %s", label, latest.EffectiveAt.Format("2006-01-02")))
+		case latest != nil:
+			problems = append(problems, fmt.Sprintf("%s coverage lapsed on %s", label, latest.ExpiresAt.Format("2006-01-02")))
+		default:
+			problems = append(problems, fmt.Sprintf("No %s certificate on file", strings.ToLower(label)))
+		}
+	}
+	return problems, nil
+}
+
+// ApplyHold puts a carrier on compliance hold when required coverage has lapsed and lifts
+// the hold once coverage is back. Certificates waiting for review keep a carrier off hold,
+// but only verified ones lift a hold, so a carrier can't clear its own hold by uploading a
+// certificate. It reports whether the hold changed.
+func ApplyHold(db *gorm.DB, company *models.Company) (bool, error) {
+	now := time.Now()
+	problems, err := CoverageProblems(db, company.ID, now)
+	if company.ComplianceHold && err == nil {
+		problems, err = VerifiedCoverageProblems(db, company.ID, now)
+	}
+	if err != nil {
+		return false, err
+	}
+	reason := strings.Join(problems, "; ")
+
+	switch {
+	case reason != "" && (!company.ComplianceHold || company.HoldReason != reason):
+		updates := map[string]interface{}{"compliance_hold": true, "hold_reason": reason}
+		if !company.ComplianceHold {
+			updates["hold_at"] = now
+		}
+		if err := db.Model(company).Updates(updates).Error; err != nil {
+			return false, err
+		}
+		notify.Send(notify.Message{
+			To:      recipients(db, company),
+			Subject: "Your company is on compliance hold",
+			Body:    fmt.Sprintf("%s cannot be booked on new loads until this is fixed: %s. Upload a current certificate of insurance; the hold is lifted once it has been reviewed.", company.Name, reason),
+			Tags:    map[string]string{"company_id": company.ID.String(), "kind": "compliance_hold"},
+		})
+		fmt.Printf("Company %s placed on compliance hold: %s\n", company.ID, reason)
+		return true, nil
+
+	case reason == "" && company.ComplianceHold:
+		updates := map[string]interface{}{"compliance_hold": false, "hold_reason": "", "hold_at": nil}
+		if err := db.Model(company).Updates(updates).Error; err != nil {
+			return false, err
+		}
+		notify.Send(notify.Message{
+			To:      recipients(db, company),
+			Subject: "Compliance hold lifted",
+			Body:    fmt.Sprintf("%s has current insurance on file and can be booked again.", company.Name),
+			Tags:    map[string]string{"company_id": company.ID.String(), "kind": "compliance_hold_lifted"},
+		})
+		fmt.Printf("Company %s compliance hold lifted\n", company.ID)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// SendExpiryReminders reminds carriers of certificates expiring in the next 30, 14 and
+// 3 days. Each threshold is sent once per certificate; certificates already replaced by
+// a later one of the same type are skipped. It returns how many reminders were sent.
+func SendExpiryReminders(db *gorm.DB) (int, error) {
+	now := time.Now()
+	horizon := now.AddDate(0, 0, ReminderDays[0])
+
+	var expiring []models.InsuranceCertificate
+	if err := db.Where("expires_at > ? AND expires_at <= ? AND status <> ?", now, horizon, models.CertificateRejected).Find(&expiring).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, cert := range expiring {
+		daysLeft := int(math.Ceil(cert.ExpiresAt.Sub(now).Hours() / 24))
+		due := 0
+		for _, days := range ReminderDays {
+			if daysLeft <= days {
+				due = days
+			}
+		}
+		if due == 0 || (cert.ReminderSentDays != 0 && cert.ReminderSentDays <= due) {
+			continue
+		}
+
+		var replacements int64
+		if err := db.Model(&models.InsuranceCertificate{}).
+			Where("company_id = ? AND coverage_type = ? AND expires_at > ? AND status <> ?", cert.CompanyID, cert.CoverageType, cert.ExpiresAt, models.CertificateRejected).
+			Count(&replacements).Error; err != nil {
+			return sent, err
+		}
+		if replacements > 0 {
+			continue
+		}
+
+		var company models.Company
+		if err := db.First(&company, "id = ?", cert.CompanyID).Error; err != nil {
+			continue
+		}
+
+		notify.Send(notify.Message{
+			To:      recipients(db, &company),
+			Subject: fmt.Sprintf("%s insurance expires in %d days", coverageLabel(cert.CoverageType), daysLeft),
+			Body: fmt.Sprintf("The %s certificate for %s (policy %s with %s) expires on %s. Upload a renewed certificate before then to avoid a compliance hold.",
+				strings.ToLower(coverageLabel(cert.CoverageType)), company.Name, cert.PolicyNumber, cert.InsurerName, cert.ExpiresAt.Format("2006-01-02")),
+			Tags: map[string]string{"company_id": company.ID.String(), "kind": "insurance_expiry", "days": fmt.Sprint(due)},
+		})
+		if err := db.Model(&cert).Update("reminder_sent_days", due).Error; err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// recipients are the company's email and its active users' emails
+func recipients(db *gorm.DB, company *models.Company) []string {
+	seen := map[string]bool{}
+	var to []string
+	add := func(email string) {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" && !seen[email] {
+			seen[email] = true
+			to = append(to, email)
+		}
+	}
+
+	add(company.Email)
+	var users []models.User
+	db.Where("company_id = ? AND active = ?", company.ID, true).Find(&users)
+	for _, user := range users {
+		add(user.Email)
+	}
+	return to
+}
+
+// coverageLabel turns a coverage type into words, e.g. "Auto liability"
+func coverageLabel(coverage models.CoverageType) string {
+	label := strings.ReplaceAll(string(coverage), "_", " ")
+	if label == "" {
+		return label
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// dollars formats cents as whole dollars with thousands separators
+func dollars(cents int64) string {
+	s := fmt.Sprint(cents / 100)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return "$" + s
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"cargozig_api/authz"
 	"cargozig_api/models"
+	"cargozig_api/tenant"
 	"fmt"
 	"log"
 	"os"
@@ -73,13 +75,91 @@ func InitDB() (*gorm.DB, error) {
 	}
 
 	// Auto-migrate the database schema
-	if err := db.AutoMigrate(
+	tables := []interface{}{
 		&models.User{},
 		&models.Company{},
-	); err != nil {
+		&models.Load{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.InvoiceSequence{},
+		&models.LedgerAccount{},
+		&models.JournalEntry{},
+		&models.JournalLine{},
+		&models.Escrow{},
+		&models.BankAccount{},
+		&models.PaymentTransaction{},
+		&models.AchFile{},
+		&models.AchFileEntry{},
+		&models.NoticeOfAssignment{},
+		&models.PlatformSetting{},
+		&models.PlatformSettingChange{},
+		&models.CarrierOnboarding{},
+		&models.CompanyDocument{},
+		&models.FMCSACarrier{},
+		&models.FMCSAAuthority{},
+		&models.FMCSAInsurance{},
+		&models.FMCSAImport{},
+		&models.ComplianceFlag{},
+		&models.InsuranceCertificate{},
+		&models.Vehicle{},
+		&models.Trailer{},
+		&models.Driver{},
+		&models.FleetUnavailability{},
+		&models.CapacityPost{},
+		&models.AuditLog{},
+		&models.Invitation{},
+		&models.CompanyMembership{},
+		&models.RoleDefinition{},
+		&models.LoadBid{},
+		&models.ImpersonationSession{},
+		&models.AnalyticsRollup{},
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
 		return nil, fmt.Errorf("failed to auto-migrate database: %v", err)
 	}
 
+	// Emails only need to be unique among rows that aren't deleted, and a load only needs
+	// one escrow that hasn't been refunded or returned, so the partial indexes created above
+	// replace the original unique ones
+	for _, index := range []string{"idx_users_email", "idx_companies_email", "idx_escrows_load_id"} {
+		if err := db.Exec("DROP INDEX IF EXISTS " + index).Error; err != nil {
+			return nil, fmt.Errorf("failed to drop index %s: %v", index, err)
+		}
+	}
+
+	// Bank details saved before they were encrypted at rest are encrypted in place
+	var unsealed []models.BankAccount
+	if err := db.Unscoped().Where("(account_number <> '' AND account_number NOT LIKE ?) OR (routing_number <> '' AND routing_number NOT LIKE ?)",
+		models.SealedPattern, models.SealedPattern).Find(&unsealed).Error; err != nil {
+		return nil, fmt.Errorf("failed to load bank accounts to encrypt: %v", err)
+	}
+	for i := range unsealed {
+		if err := db.Unscoped().Model(&unsealed[i]).Select("routing_number", "account_number").Updates(&unsealed[i]).Error; err != nil {
+			return nil, fmt.Errorf("failed to encrypt bank account %s: %v", unsealed[i].ID, err)
+		}
+	}
+
+	// Trigram indexes back the search on list pages
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return nil, fmt.Errorf("failed to enable pg_trgm extension: %v", err)
+	}
+	for _, index := range models.SearchIndexes {
+		sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING gin ((%s) gin_trgm_ops)", index.Name, index.Table, index.Expression)
+		if err := db.Exec(sql).Error; err != nil {
+			return nil, fmt.Errorf("failed to create search index %s: %v", index.Name, err)
+		}
+	}
+
+	// Scope tenant owned tables to the requesting user's company
+	if err := tenant.Register(db, tables...); err != nil {
+		return nil, fmt.Errorf("failed to register tenant scoping: %v", err)
+	}
+
+	// Built in roles become editable platform roles
+	if err := authz.SeedRoles(db); err != nil {
+		return nil, fmt.Errorf("failed to seed roles: %v", err)
+	}
+
 	// Configure connection pool
 	sqlDB, err := db.DB()
 	if err != nil {
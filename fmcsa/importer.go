@@ -0,0 +1,251 @@
+// Package fmcsa loads FMCSA bulk data files into local snapshot tables and checks
+// carriers against them.
+package fmcsa
+
+import (
+	"cargozig_api/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of bulk file the importer understands
+const (
+	KindCensus    = "census"
+	KindAuthority = "authority"
+	KindInsurance = "insurance"
+)
+
+// importBatchSize is how many rows are inserted per statement
+const importBatchSize = 1000
+
+// ErrUnknownKind is returned for a file kind the importer does not understand
+var ErrUnknownKind = errors.New("unknown FMCSA file kind")
+
+// columns maps upper-cased header names to their position in a record
+type columns map[string]int
+
+// get returns the first of the named columns present in the record
+func (cols columns) get(record []string, names ...string) string {
+	for _, name := range names {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+	}
+	return ""
+}
+
+// has reports whether any of the named columns is present
+func (cols columns) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := cols[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Header aliases, the bulk files are not consistent between datasets
+var (
+	dotColumns    = []string{"DOT_NUMBER", "USDOT_NUMBER", "USDOT", "DOT"}
+	docketColumns = []string{"DOCKET_NUMBER", "MC_NUMBER", "DOCKET", "MC"}
+)
+
+// Import replaces the snapshot for kind with the CSV rows read from r and records the run
+func Import(db *gorm.DB, kind, fileName string, r io.Reader) (*models.FMCSAImport, error) {
+	var model interface{}
+	switch kind {
+	case KindCensus:
+		model = &models.FMCSACarrier{}
+	case KindAuthority:
+		model = &models.FMCSAAuthority{}
+	case KindInsurance:
+		model = &models.FMCSAInsurance{}
+	default:
+		return nil, ErrUnknownKind
+	}
+
+	run := models.FMCSAImport{Kind: kind, FileName: fileName, StartedAt: time.Now()}
+	if err := db.Create(&run).Error; err != nil {
+		return nil, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		reader.ReuseRecord = true
+
+		header, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("reading header: %w", err)
+		}
+		cols := columns{}
+		for i, name := range header {
+			cols[strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+		}
+		if !cols.has(dotColumns...) && !cols.has(docketColumns...) {
+			return errors.New("file has no DOT or docket number column")
+		}
+
+		// The new file replaces the old snapshot entirely
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(model).Error; err != nil {
+			return err
+		}
+
+		batch := newBatch(kind)
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %w", run.Rows+run.Skipped+2, err)
+			}
+			if !batch.add(cols, record) {
+				run.Skipped++
+				continue
+			}
+			run.Rows++
+			if batch.len() >= importBatchSize {
+				if err := batch.flush(tx); err != nil {
+					return err
+				}
+			}
+		}
+		return batch.flush(tx)
+	})
+
+	now := time.Now()
+	run.CompletedAt = &now
+	if err != nil {
+		run.Error = err.Error()
+	}
+	db.Save(&run)
+
+	return &run, err
+}
+
+// batch collects parsed rows of one kind for bulk insert
+type batch struct {
+	kind        string
+	carriers    []models.FMCSACarrier
+	authorities []models.FMCSAAuthority
+	insurance   []models.FMCSAInsurance
+}
+
+func newBatch(kind string) *batch { return &batch{kind: kind} }
+
+func (b *batch) len() int {
+	return len(b.carriers) + len(b.authorities) + len(b.insurance)
+}
+
+// add parses a record into the batch, returning false if the row is unusable
+func (b *batch) add(cols columns, record []string) bool {
+	dot := NormalizeNumber(cols.get(record, dotColumns...))
+	docket := NormalizeNumber(cols.get(record, docketColumns...))
+
+	switch b.kind {
+	case KindCensus:
+		if dot == "" {
+			return false
+		}
+		powerUnits, _ := strconv.Atoi(cols.get(record, "NBR_POWER_UNIT", "POWER_UNITS"))
+		drivers, _ := strconv.Atoi(cols.get(record, "DRIVER_TOTAL", "DRIVERS"))
+		b.carriers = append(b.carriers, models.FMCSACarrier{
+			DOTNumber:  dot,
+			LegalName:  cols.get(record, "LEGAL_NAME", "NAME"),
+			DBAName:    cols.get(record, "DBA_NAME"),
+			Street:     cols.get(record, "PHY_STREET", "STREET"),
+			City:       cols.get(record, "PHY_CITY", "CITY"),
+			State:      cols.get(record, "PHY_STATE", "STATE"),
+			Zip:        cols.get(record, "PHY_ZIP", "ZIP"),
+			Phone:      cols.get(record, "TELEPHONE", "PHONE"),
+			PowerUnits: powerUnits,
+			Drivers:    drivers,
+		})
+
+	case KindAuthority:
+		if dot == "" && docket == "" {
+			return false
+		}
+		authority := models.FMCSAAuthority{
+			DOTNumber:      dot,
+			DocketNumber:   docket,
+			CommonStatus:   strings.ToUpper(cols.get(record, "COMMON_STAT", "COMMON_STATUS")),
+			ContractStatus: strings.ToUpper(cols.get(record, "CONTRACT_STAT", "CONTRACT_STATUS")),
+			BrokerStatus:   strings.ToUpper(cols.get(record, "BROKER_STAT", "BROKER_STATUS")),
+		}
+		// Some extracts carry a single status column instead of one per authority type
+		if status := strings.ToUpper(cols.get(record, "AUTHORITY_STATUS", "STATUS")); status != "" && authority.CommonStatus == "" {
+			authority.CommonStatus = status[:1]
+		}
+		b.authorities = append(b.authorities, authority)
+
+	case KindInsurance:
+		if dot == "" && docket == "" {
+			return false
+		}
+		// FMCSA publishes coverage in thousands of dollars
+		coverage, _ := strconv.ParseInt(cols.get(record, "MAX_COV_AMOUNT", "COVERAGE_AMOUNT"), 10, 64)
+		b.insurance = append(b.insurance, models.FMCSAInsurance{
+			DOTNumber:        dot,
+			DocketNumber:     docket,
+			InsuranceType:    strings.ToUpper(cols.get(record, "INS_TYPE_DESC", "INS_FORM_CODE", "INSURANCE_TYPE")),
+			InsurerName:      cols.get(record, "NAME_COMPANY", "INSURER", "INSURANCE_COMPANY"),
+			PolicyNumber:     cols.get(record, "POLICY_NO", "POLICY_NUMBER"),
+			CoverageCents:    coverage * 1000 * 100,
+			EffectiveDate:    parseDate(cols.get(record, "EFFECTIVE_DATE", "EFF_DATE")),
+			CancellationDate: parseDate(cols.get(record, "CANCL_EFFECTIVE_DATE", "CANCELLATION_DATE", "CANCEL_DATE")),
+		})
+	}
+	return true
+}
+
+// flush inserts the collected rows and empties the batch
+func (b *batch) flush(tx *gorm.DB) error {
+	var err error
+	switch {
+	case len(b.carriers) > 0:
+		// Census files occasionally repeat a DOT number, keep the first
+		err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&b.carriers).Error
+	case len(b.authorities) > 0:
+		err = tx.Create(&b.authorities).Error
+	case len(b.insurance) > 0:
+		err = tx.Create(&b.insurance).Error
+	}
+	b.carriers, b.authorities, b.insurance = nil, nil, nil
+	return err
+}
+
+// NormalizeNumber reduces a DOT or docket number to its digits without leading zeros,
+// so "MC-012345" and "12345" compare equal
+func NormalizeNumber(s string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+	return strings.TrimLeft(digits, "0")
+}
+
+// parseDate reads the date formats found in the bulk files
+func parseDate(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+	for _, layout := range []string{"01/02/2006", "2006-01-02", "20060102", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
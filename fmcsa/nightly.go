@@ -0,0 +1,93 @@
+package fmcsa
+
+import (
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ImportDir is where bulk files are dropped for the nightly job, FMCSA_IMPORT_DIR or ./data/fmcsa
+func ImportDir() string {
+	if dir := os.Getenv("FMCSA_IMPORT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("data", "fmcsa")
+}
+
+// Nightly imports any bulk files in ImportDir newer than the last import of their kind,
+// then rechecks every verified carrier. Files are matched by name: census*.csv,
+// authority*.csv and insurance*.csv.
+func Nightly(db *gorm.DB) error {
+	entries, err := os.ReadDir(ImportDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Only the newest file of each kind matters since an import replaces the snapshot
+	newest := map[string]os.FileInfo{}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if entry.IsDir() || !strings.HasSuffix(name, ".csv") {
+			continue
+		}
+		for _, kind := range []string{KindCensus, KindAuthority, KindInsurance} {
+			if !strings.HasPrefix(name, kind) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if current, ok := newest[kind]; !ok || info.ModTime().After(current.ModTime()) {
+				newest[kind] = info
+			}
+		}
+	}
+
+	for kind, info := range newest {
+		var last models.FMCSAImport
+		err := db.Where("kind = ? AND error = ''", kind).Order("started_at DESC").First(&last).Error
+		if err == nil && !info.ModTime().After(last.StartedAt) {
+			continue // Already imported
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := importFile(db, kind, filepath.Join(ImportDir(), info.Name())); err != nil {
+			fmt.Printf("Error importing FMCSA %s file %s: %v\n", kind, info.Name(), err)
+		}
+	}
+
+	summary, err := RecheckCarriers(db)
+	if errors.Is(err, ErrNoSnapshot) {
+		fmt.Println("Skipping FMCSA recheck:", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("FMCSA recheck: %d carriers checked, %d flagged, %d resolved\n", summary.Checked, summary.Flagged, summary.Resolved)
+	return nil
+}
+
+// importFile imports one bulk file from disk
+func importFile(db *gorm.DB, kind, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	run, err := Import(db, kind, filepath.Base(path), f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported FMCSA %s file %s: %d rows, %d skipped\n", kind, run.FileName, run.Rows, run.Skipped)
+	return nil
+}
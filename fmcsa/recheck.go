@@ -0,0 +1,176 @@
+package fmcsa
+
+import (
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned when a number is not in any snapshot table
+var ErrNotFound = errors.New("carrier not found in FMCSA snapshot")
+
+// ErrNoSnapshot is returned when there is nothing imported to check against
+var ErrNoSnapshot = errors.New("no FMCSA snapshot has been imported")
+
+// Record is everything the snapshot holds for a carrier
+type Record struct {
+	Carrier         *models.FMCSACarrier    `json:"carrier,omitempty"`
+	Authorities     []models.FMCSAAuthority `json:"authorities"`
+	Insurance       []models.FMCSAInsurance `json:"insurance"`
+	AuthorityActive bool                    `json:"authority_active"`
+	InsuranceActive bool                    `json:"insurance_active"`
+}
+
+// Lookup finds a carrier in the snapshot by DOT number, MC number or both
+func Lookup(db *gorm.DB, dot, mc string) (*Record, error) {
+	dot, mc = NormalizeNumber(dot), NormalizeNumber(mc)
+	if dot == "" && mc == "" {
+		return nil, ErrNotFound
+	}
+
+	// Match on either number, an empty one never matches
+	where := "(dot_number = ? AND dot_number <> '') OR (docket_number = ? AND docket_number <> '')"
+	record := &Record{}
+
+	if dot != "" {
+		var carrier models.FMCSACarrier
+		err := db.Where("dot_number = ?", dot).First(&carrier).Error
+		if err == nil {
+			record.Carrier = &carrier
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	if err := db.Where(where, dot, mc).Find(&record.Authorities).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where(where, dot, mc).Order("effective_date DESC").Find(&record.Insurance).Error; err != nil {
+		return nil, err
+	}
+
+	if record.Carrier == nil && len(record.Authorities) == 0 && len(record.Insurance) == 0 {
+		return nil, ErrNotFound
+	}
+
+	now := time.Now()
+	for i := range record.Authorities {
+		if record.Authorities[i].Active() {
+			record.AuthorityActive = true
+		}
+	}
+	for i := range record.Insurance {
+		if record.Insurance[i].ActiveAt(now) {
+			record.InsuranceActive = true
+		}
+	}
+	return record, nil
+}
+
+// RecheckSummary reports what a recheck run found
+type RecheckSummary struct {
+	Checked  int `json:"checked"`
+	Flagged  int `json:"flagged"`
+	Resolved int `json:"resolved"`
+}
+
+// RecheckCarriers checks every verified carrier against the latest snapshot, opening a
+// compliance flag for revoked authority or lapsed insurance and resolving flags that cleared.
+// Checks for a dataset are skipped while that dataset has not been imported.
+func RecheckCarriers(db *gorm.DB) (*RecheckSummary, error) {
+	var authorityRows, insuranceRows int64
+	if err := db.Model(&models.FMCSAAuthority{}).Count(&authorityRows).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.FMCSAInsurance{}).Count(&insuranceRows).Error; err != nil {
+		return nil, err
+	}
+	if authorityRows == 0 && insuranceRows == 0 {
+		return nil, ErrNoSnapshot
+	}
+
+	var companies []models.Company
+	err := db.Where("verified = ? AND company_type <> ? AND (dot_number <> '' OR mc_number <> '')", true, "shipper").
+		Find(&companies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &RecheckSummary{}
+	for _, company := range companies {
+		problems := map[models.ComplianceFlagKind]string{}
+
+		record, err := Lookup(db, company.DOTNumber, company.MCNumber)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			problems[models.FlagNotInSnapshot] = fmt.Sprintf("DOT %s / MC %s not found in the FMCSA snapshot", company.DOTNumber, company.MCNumber)
+		case err != nil:
+			return nil, err
+		default:
+			if authorityRows > 0 {
+				authority := "inactive"
+				if record.AuthorityActive {
+					authority = "active"
+				} else {
+					problems[models.FlagAuthorityRevoked] = "No active common or contract authority on file with FMCSA"
+				}
+				if company.Authority != authority {
+					if err := db.Model(&models.Company{}).Where("id = ?", company.ID).Update("authority", authority).Error; err != nil {
+						return nil, err
+					}
+				}
+			}
+			if insuranceRows > 0 && !record.InsuranceActive {
+				problems[models.FlagInsuranceLapsed] = "No insurance filing in force with FMCSA"
+			}
+		}
+
+		flagged, resolved, err := syncFlags(db, company.ID, problems)
+		if err != nil {
+			return nil, err
+		}
+		summary.Checked++
+		summary.Flagged += flagged
+		summary.Resolved += resolved
+	}
+
+	return summary, nil
+}
+
+// syncFlags opens a flag for each new problem and resolves open flags that no longer apply
+func syncFlags(db *gorm.DB, companyID uuid.UUID, problems map[models.ComplianceFlagKind]string) (flagged, resolved int, err error) {
+	var open []models.ComplianceFlag
+	if err := db.Where("company_id = ? AND resolved_at IS NULL", companyID).Find(&open).Error; err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	openKinds := map[models.ComplianceFlagKind]bool{}
+	for _, flag := range open {
+		if _, still := problems[flag.Kind]; still {
+			openKinds[flag.Kind] = true
+			continue
+		}
+		if err := db.Model(&flag).Update("resolved_at", now).Error; err != nil {
+			return 0, 0, err
+		}
+		resolved++
+	}
+
+	for kind, detail := range problems {
+		if openKinds[kind] {
+			continue
+		}
+		flag := models.ComplianceFlag{CompanyID: companyID, Kind: kind, Detail: detail, DetectedAt: now}
+		if err := db.Create(&flag).Error; err != nil {
+			return 0, 0, err
+		}
+		fmt.Printf("Compliance flag %s raised for company %s: %s\n", kind, companyID, detail)
+		flagged++
+	}
+
+	return flagged, resolved, nil
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"cargozig_api/ledger"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"cargozig_api/payments"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SetupAchRoutes sets up the NACHA file routes, superadmins only
+// /api/ach
+func SetupAchRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+	router.Use(middleware.RequirePermission(models.SystemAdmin))
+
+	router.Get("/files", ListAchFiles)
+	router.Post("/files", middleware.DenyWhileImpersonating(), GenerateAchFile)
+	router.Get("/files/:id", GetAchFile)
+	router.Get("/files/:id/download", DownloadAchFile)
+	router.Post("/returns", middleware.DenyWhileImpersonating(), ProcessAchReturns)
+}
+
+// achSkipped is an approved payable left out of a NACHA file and why
+type achSkipped struct {
+	InvoiceID uuid.UUID `json:"invoice_id"`
+	Number    string    `json:"number"`
+	Reason    string    `json:"reason"`
+}
+
+// ListAchFiles lists generated NACHA files, newest first
+func ListAchFiles(c *fiber.Ctx) error {
+	var files []models.AchFile
+	if err := requestDB(c).Order("created_at DESC").Find(&files).Error; err != nil {
+		fmt.Println("Error listing ACH files:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list ACH files"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "files": files})
+}
+
+// GetAchFile returns a NACHA file with its entries
+func GetAchFile(c *fiber.Ctx) error {
+	var file models.AchFile
+	if err := requestDB(c).Preload("Entries").Where("id = ?", c.Params("id")).First(&file).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "ACH file not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "file": file})
+}
+
+// DownloadAchFile sends the NACHA file as an attachment
+func DownloadAchFile(c *fiber.Ctx) error {
+	var file models.AchFile
+	if err := requestDB(c).Where("id = ?", c.Params("id")).First(&file).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "ACH file not found"})
+	}
+
+	c.Attachment(file.FileName)
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlain)
+	return c.SendString(file.Content)
+}
+
+// GenerateAchFile builds a NACHA credit file from approved carrier payables that are due
+// by the effective date. Assigned payables are paid to the factor and quick-pay fees are
+// kept back. Payables without a bank account or payable balance are skipped and reported.
+func GenerateAchFile(c *fiber.Ctx) error {
+	var req struct {
+		InvoiceIDs    []string `json:"invoice_ids"`
+		SECCode       string   `json:"sec_code"`
+		EffectiveDate string   `json:"effective_date"` // YYYY-MM-DD, defaults to the next business day
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	cfg := payments.NACHAConfigFromEnv()
+	if req.SECCode != "" {
+		cfg.SECCode = strings.ToUpper(req.SECCode)
+	}
+	if err := cfg.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	now := time.Now()
+	effectiveDate := nextBusinessDay(now)
+	if req.EffectiveDate != "" {
+		parsed, err := time.Parse("2006-01-02", req.EffectiveDate)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Effective date must be YYYY-MM-DD"})
+		}
+		effectiveDate = parsed
+	}
+
+	user := currentUser(c)
+	db := requestDB(c)
+	var file models.AchFile
+	var skipped []achSkipped
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("kind = ? AND status = ?", models.InvoiceKindCarrierPayable, models.InvoiceStatusApproved)
+		if len(req.InvoiceIDs) > 0 {
+			query = query.Where("id IN ?", req.InvoiceIDs)
+		} else {
+			query = query.Where("due_at IS NULL OR due_at < ?", effectiveDate.AddDate(0, 0, 1))
+		}
+		var payables []models.Invoice
+		if err := query.Order("created_at ASC").Find(&payables).Error; err != nil {
+			return err
+		}
+
+		var entries []payments.NACHAEntry
+		var included []models.AchFileEntry
+		available := map[uuid.UUID]int64{}
+
+		for _, payable := range payables {
+			var carrier models.Company
+			if err := tx.Where("id = ?", payable.CompanyID).First(&carrier).Error; err != nil {
+				return err
+			}
+			if !carrier.Verified {
+				skipped = append(skipped, achSkipped{payable.ID, payable.Number, "Carrier has not passed onboarding review"})
+				continue
+			}
+
+			payeeID := payable.PayeeID()
+			var bankAccount models.BankAccount
+			if err := tx.Where("company_id = ?", payeeID).
+				Order("is_default DESC, created_at DESC").First(&bankAccount).Error; err != nil {
+				skipped = append(skipped, achSkipped{payable.ID, payable.Number, "Payee has no bank account"})
+				continue
+			}
+
+			balance, ok := available[payable.CompanyID]
+			if !ok {
+				var err error
+				if balance, err = availablePayable(tx, payable.CompanyID); err != nil {
+					return err
+				}
+			}
+			if payable.TotalCents > balance {
+				skipped = append(skipped, achSkipped{payable.ID, payable.Number, "Exceeds the carrier's payable balance"})
+				available[payable.CompanyID] = balance
+				continue
+			}
+			available[payable.CompanyID] = balance - payable.TotalCents
+
+			var payee models.Company
+			if err := tx.Where("id = ?", payeeID).First(&payee).Error; err != nil {
+				return err
+			}
+
+			entries = append(entries, payments.NACHAEntry{
+				ReceiverName:  payee.Name,
+				ReceiverID:    payable.Number,
+				RoutingNumber: string(bankAccount.RoutingNumber),
+				AccountNumber: string(bankAccount.AccountNumber),
+				AccountType:   bankAccount.AccountType,
+				AmountCents:   payable.NetPayoutCents(),
+			})
+			included = append(included, models.AchFileEntry{
+				InvoiceID:      payable.ID,
+				CompanyID:      payable.CompanyID,
+				PayeeCompanyID: payeeID,
+				BankAccountID:  bankAccount.ID,
+				AmountCents:    payable.NetPayoutCents(),
+				FeeCents:       payable.QuickPayFeeCents,
+			})
+		}
+		if len(entries) == 0 {
+			return errNoAchEntries
+		}
+
+		// Trace numbers continue from every entry ever written so returns match exactly one payable
+		var traced int64
+		if err := tx.Unscoped().Model(&models.AchFileEntry{}).Count(&traced).Error; err != nil {
+			return err
+		}
+
+		// The file ID modifier distinguishes files created on the same day
+		var today int64
+		startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		if err := tx.Unscoped().Model(&models.AchFile{}).Where("created_at >= ?", startOfDay).Count(&today).Error; err != nil {
+			return err
+		}
+		if today >= 26 {
+			return errors.New("no file ID modifiers left for today")
+		}
+		modifier := byte('A' + today)
+
+		built, err := payments.BuildNACHAFile(cfg, entries, int(traced)+1, effectiveDate, now, modifier)
+		if err != nil {
+			return err
+		}
+
+		for i := range included {
+			included[i].TraceNumber = built.TraceNumbers[i]
+		}
+		file = models.AchFile{
+			FileName:         fmt.Sprintf("ACH_%s_%c.txt", now.Format("20060102"), modifier),
+			FileIDModifier:   string(modifier),
+			SECCode:          cfg.SECCode,
+			EffectiveDate:    effectiveDate,
+			EntryCount:       built.EntryCount,
+			EntryHash:        built.EntryHash,
+			TotalCreditCents: built.TotalCreditCents,
+			Content:          string(built.Content),
+			CreatedByID:      user.ID,
+			Entries:          included,
+		}
+		if err := tx.Create(&file).Error; err != nil {
+			return err
+		}
+
+		// Money leaves with the file, so the payables are paid and the payout is settled
+		for _, entry := range file.Entries {
+			if err := tx.Model(&models.Invoice{}).Where("id = ?", entry.InvoiceID).
+				Updates(map[string]interface{}{"status": models.InvoiceStatusPaid, "paid_at": now, "return_code": ""}).Error; err != nil {
+				return err
+			}
+			if err := ledger.SettlePayout(tx, entry.CompanyID, entry.AmountCents, "ach:"+entry.TraceNumber); err != nil {
+				return err
+			}
+			if entry.FeeCents > 0 {
+				if err := ledger.ChargeQuickPayFee(tx, entry.CompanyID, entry.FeeCents, "ach:"+entry.TraceNumber); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errNoAchEntries) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No approved payables can be paid", "skipped": skipped})
+		}
+		fmt.Println("Error generating ACH file:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate ACH file"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "file": file, "skipped": skipped})
+}
+
+// ProcessAchReturns reads a bank's return file, uploaded as "file" or sent as the
+// raw body, and marks each returned payable failed with its R-code
+func ProcessAchReturns(c *fiber.Ctx) error {
+	data := c.Body()
+	if upload, err := c.FormFile("file"); err == nil {
+		f, err := upload.Open()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read uploaded file"})
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read uploaded file"})
+		}
+	}
+
+	returns, err := payments.ParseNACHAReturns(data)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var processed []models.AchFileEntry
+	var unmatched []string
+	now := time.Now()
+
+	err = requestDB(c).Transaction(func(tx *gorm.DB) error {
+		for _, ret := range returns {
+			var entry models.AchFileEntry
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("trace_number = ?", ret.OriginalTraceNumber).First(&entry).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				unmatched = append(unmatched, ret.OriginalTraceNumber)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if entry.ReturnedAt != nil {
+				continue // Already processed from an earlier upload
+			}
+
+			entry.ReturnCode = ret.ReturnCode
+			entry.ReturnedAt = &now
+			if err := tx.Save(&entry).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Invoice{}).Where("id = ?", entry.InvoiceID).
+				Updates(map[string]interface{}{"status": models.InvoiceStatusFailed, "paid_at": nil, "return_code": ret.ReturnCode}).Error; err != nil {
+				return err
+			}
+			if err := ledger.ReturnPayout(tx, entry.CompanyID, entry.AmountCents, "ach-return:"+entry.TraceNumber); err != nil {
+				return err
+			}
+			if entry.FeeCents > 0 {
+				if err := ledger.ReverseQuickPayFee(tx, entry.CompanyID, entry.FeeCents, "ach-return:"+entry.TraceNumber); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("ACH entry %s returned with code %s\n", entry.TraceNumber, ret.ReturnCode)
+			processed = append(processed, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error processing ACH returns:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not process ACH returns"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "returned": processed, "unmatched": unmatched})
+}
+
+var errNoAchEntries = errors.New("no payable entries")
+
+// nextBusinessDay returns the next weekday after t
+func nextBusinessDay(t time.Time) time.Time {
+	next := t.AddDate(0, 0, 1)
+	for next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"cargozig_api/analytics"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultSeriesDays is how far back a series goes when no from date is given
+const defaultSeriesDays = 90
+
+// SuperAdminAnalyticsSummary returns the platform totals from the latest analytics snapshot
+func SuperAdminAnalyticsSummary(c *fiber.Ctx) error {
+	summary, err := analytics.Latest(requestDB(c))
+	if err != nil {
+		fmt.Println("Error loading analytics summary:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load analytics"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "summary": summary, "metrics": analytics.Metrics()})
+}
+
+// SuperAdminAnalyticsSeries returns a metric bucketed by day, week or month for charts.
+// Dates are UTC days; to is exclusive.
+//
+//	GET /superadmin/api/analytics/series?metric=signups&interval=week&from=2024-01-01&to=2024-04-01
+func SuperAdminAnalyticsSeries(c *fiber.Ctx) error {
+	metric := c.Query("metric")
+	if !analytics.Known(metric) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown metric", "metrics": analytics.Metrics()})
+	}
+	interval := c.Query("interval", "day")
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -defaultSeriesDays)
+	to := today.AddDate(0, 0, 1)
+	for name, date := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": name + " must be a date such as 2024-01-31"})
+		}
+		*date = parsed
+	}
+	if !from.Before(to) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be before to"})
+	}
+
+	points, err := analytics.Series(requestDB(c), metric, interval, from, to)
+	if err != nil {
+		if !analytics.Intervals[interval] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		fmt.Println("Error loading analytics series:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load analytics"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":   "success",
+		"metric":   metric,
+		"interval": interval,
+		"from":     from.Format("2006-01-02"),
+		"to":       to.Format("2006-01-02"),
+		"points":   points,
+	})
+}
+
+// SuperAdminRefreshAnalytics refreshes the analytics rollups now rather than waiting for
+// the scheduled job
+func SuperAdminRefreshAnalytics(c *fiber.Ctx) error {
+	if err := analytics.Refresh(requestDB(c)); err != nil {
+		fmt.Println("Error refreshing analytics:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not refresh analytics"})
+	}
+
+	summary, err := analytics.Latest(requestDB(c))
+	if err != nil {
+		fmt.Println("Error loading analytics summary:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load analytics"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "summary": summary})
+}
@@ -21,6 +21,8 @@ func SetupApiAuthRoutes(router fiber.Router) {
 	router.Get("/protected", ProtectedRoute)
 	router.Post("/register", RegisterNewUser)
 	router.Post("/newuserregistration", NewUserRegistration)
+	router.Get("/invitations/:token", GetInvitation)
+	router.Post("/invitations/accept", AcceptInvitation)
 
 	// Admin-only routes
 	router.Post("/admin/login", AdminLogin)
@@ -31,7 +33,7 @@ func SetupApiAuthRoutes(router fiber.Router) {
 }
 
 // GenerateJWT creates a JWT token
-func GenerateJWT(userID string, roles models.RoleArray) (string, error) {
+func GenerateJWT(userID string, companyID string, roles models.RoleArray) (string, error) {
 	// Convert roles to strings for JWT
 	roleStrings := make([]string, len(roles))
 	for i, role := range roles {
@@ -40,9 +42,10 @@ func GenerateJWT(userID string, roles models.RoleArray) (string, error) {
 
 	// Define claims for the token
 	claims := jwt.MapClaims{
-		"user_id": userID,
-		"roles":   roleStrings,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(), // Expires in 24 hours
+		"user_id":    userID,
+		"company_id": companyID,
+		"roles":      roleStrings,
+		"exp":        time.Now().Add(sessionLifetime()).Unix(), // Expires after the configured session length
 	}
 
 	// Create a new token with claims
@@ -107,7 +110,7 @@ func RegisterNewUser(c *fiber.Ctx) error {
 	}
 
 	// Generate JWT token
-	token, err := GenerateJWT(newUser.ID.String(), newUser.Roles)
+	token, err := GenerateJWT(newUser.ID.String(), newUser.CompanyID.String(), newUser.Roles)
 	if err != nil {
 		fmt.Println("Error generating token:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
@@ -124,7 +127,7 @@ func RegisterNewUser(c *fiber.Ctx) error {
 	c.Cookie(&fiber.Cookie{
 		Name:     "auth_token",
 		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(sessionLifetime()),
 		HTTPOnly: true,     // Prevents JavaScript access
 		Secure:   secure,   // Works only on HTTPS by default
 		SameSite: sameSite, // Strict, Lax, None
@@ -267,6 +270,13 @@ func NewUserRegistration(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create user"})
 	}
 
+	// The registering user owns the new company
+	if err := tx.Model(&company).Update("owner_user_id", user.ID).Error; err != nil {
+		tx.Rollback()
+		fmt.Println("Error setting company owner:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not complete registration"})
+	}
+
 	// Commit the transaction
 	if err := tx.Commit().Error; err != nil {
 		fmt.Println("Error committing transaction:", err)
@@ -274,7 +284,7 @@ func NewUserRegistration(c *fiber.Ctx) error {
 	}
 
 	// Generate JWT token
-	token, err := GenerateJWT(user.ID.String(), user.Roles)
+	token, err := GenerateJWT(user.ID.String(), user.CompanyID.String(), user.Roles)
 	if err != nil {
 		fmt.Println("Error generating token:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
@@ -291,7 +301,7 @@ func NewUserRegistration(c *fiber.Ctx) error {
 	c.Cookie(&fiber.Cookie{
 		Name:     "auth_token",
 		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(sessionLifetime()),
 		HTTPOnly: true,
 		Secure:   secure,
 		SameSite: sameSite,
@@ -353,8 +363,14 @@ func UserLogin(c *fiber.Ctx) error {
 	user.LastLogin = &now
 	db.Save(&user)
 
+	// Sign in to the home company, or to a membership when the home company has deactivated the user
+	companyID, roles, err := sessionCompany(db, &user)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Account is disabled. Please contact support."})
+	}
+
 	// Generate JWT token
-	token, err := GenerateJWT(user.ID.String(), user.Roles)
+	token, err := GenerateJWT(user.ID.String(), companyID.String(), roles)
 	if err != nil {
 		fmt.Println("Error generating token:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
@@ -372,7 +388,7 @@ func UserLogin(c *fiber.Ctx) error {
 	c.Cookie(&fiber.Cookie{
 		Name:     "auth_token",
 		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(sessionLifetime()),
 		HTTPOnly: true,
 		Secure:   secure,
 		SameSite: sameSite,
@@ -385,7 +401,7 @@ func UserLogin(c *fiber.Ctx) error {
 			"id":       user.ID,
 			"username": user.Username,
 			"email":    user.Email,
-			"roles":    user.Roles,
+			"roles":    roles,
 		},
 	})
 }
@@ -408,6 +424,8 @@ func UserLogout(c *fiber.Ctx) error {
 		Secure:   secure,
 		SameSite: sameSite,
 	})
+	// Signing out while impersonating signs the system admin out too
+	setTokenCookie(c, impersonatorCookie, "", time.Now().Add(-time.Hour))
 
 	return c.JSON(fiber.Map{"status": "success", "message": "Logged out"})
 }
@@ -522,7 +540,7 @@ func AdminLogin(c *fiber.Ctx) error {
 	db.Save(&user)
 
 	// Generate JWT token
-	token, err := GenerateJWT(user.ID.String(), user.Roles)
+	token, err := GenerateJWT(user.ID.String(), user.CompanyID.String(), user.Roles)
 	if err != nil {
 		fmt.Println("Error generating admin token:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
@@ -569,7 +587,7 @@ func AdminLogin(c *fiber.Ctx) error {
 	c.Cookie(&fiber.Cookie{
 		Name:     "admin_auth_token",
 		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(sessionLifetime()),
 		HTTPOnly: true,
 		Secure:   secure,
 		SameSite: sameSite,
@@ -579,7 +597,7 @@ func AdminLogin(c *fiber.Ctx) error {
 	c.Cookie(&fiber.Cookie{
 		Name:     "user_type",
 		Value:    userType,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(sessionLifetime()),
 		HTTPOnly: false, // Allow JavaScript to read for client-side routing
 		Secure:   secure,
 		SameSite: sameSite,
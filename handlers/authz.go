@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/config"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"fmt"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupAuthzRoutes sets up the authorization debugging routes
+// /api/authz
+func SetupAuthzRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/explain", middleware.RequirePermission(models.SystemAdmin), ExplainAuthorization)
+}
+
+// ExplainAuthorization shows how the policy decides whether a user may perform an action
+// on a resource, rule by rule. The user defaults to the caller; company_id picks one of
+// the user's memberships instead of their home company.
+//
+//	GET /api/authz/explain?action=load:view&resource_id=<load id>&user_id=<user id>
+func ExplainAuthorization(c *fiber.Ctx) error {
+	action := authz.Action(c.Query("action"))
+	if action == "" || c.Query("resource_id") == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "action and resource_id are required",
+			"actions": authz.Actions,
+		})
+	}
+
+	db := config.GetDB()
+
+	userID := c.Query("user_id", currentUser(c).ID.String())
+	var actor models.User
+	if err := db.First(&actor, "id = ?", userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if companyID := c.Query("company_id"); companyID != "" && companyID != actor.CompanyID.String() {
+		var membership models.CompanyMembership
+		if err := db.Where("user_id = ? AND company_id = ?", actor.ID, companyID).First(&membership).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User is not a member of that company"})
+		}
+		membership.ApplyTo(&actor)
+	}
+	if err := authz.Resolve(db, &actor); err != nil {
+		fmt.Println("Error resolving permissions:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not resolve permissions"})
+	}
+
+	resource, err := authz.Find(db, action.Kind(), c.Query("resource_id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Resource not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":   "success",
+		"decision": authz.Authorize(db, &actor, action, resource),
+		"actor": fiber.Map{
+			"id":         actor.ID,
+			"company_id": actor.CompanyID,
+			"active":     actor.Active,
+			"roles":      actor.Roles,
+			"allowed":    permissionNames(actor.Resolved.Allowed),
+			"denied":     permissionNames(actor.Resolved.Denied),
+		},
+	})
+}
+
+// permissionNames lists the permissions in a resolved set, sorted
+func permissionNames(set map[models.Permission]bool) []string {
+	names := []string{}
+	for p, ok := range set {
+		if ok {
+			names = append(names, string(p))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"cargozig_api/models"
+	"cargozig_api/tenant"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errBidNotOpen         = errors.New("bid is no longer open")
+	errLoadNotPosted      = errors.New("load is no longer posted")
+	errCarrierNotBookable = errors.New("carrier cannot be booked")
+)
+
+// ListLoadBids lists bids on a load. The shipper sees every bid, carriers only their own.
+func ListLoadBids(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+	user := currentUser(c)
+	db := requestDB(c)
+
+	// Bids belong to the carriers that made them; the shipper reads them across companies
+	if load.ShipperCompanyID == user.CompanyID || user.HasPermission(models.SystemAdmin) {
+		db = tenant.System(db, "load-bids")
+	} else {
+		db = db.Where("carrier_company_id = ?", user.CompanyID)
+	}
+
+	var bids []models.LoadBid
+	if err := db.Where("load_id = ?", load.ID).Order("amount_cents, created_at").Find(&bids).Error; err != nil {
+		fmt.Println("Error listing bids:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list bids"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "bids": bids})
+}
+
+// CreateLoadBid offers to haul a posted load. A carrier has one open bid per load.
+func CreateLoadBid(c *fiber.Ctx) error {
+	var req struct {
+		AmountCents int64  `json:"amount_cents"`
+		Note        string `json:"note"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.AmountCents <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "amount_cents must be positive"})
+	}
+
+	load := authorizedLoad(c)
+	user := currentUser(c)
+	db := requestDB(c)
+
+	var open int64
+	db.Model(&models.LoadBid{}).Where("load_id = ? AND carrier_company_id = ? AND status = ?",
+		load.ID, user.CompanyID, models.BidStatusOpen).Count(&open)
+	if open > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Your company already has an open bid on this load"})
+	}
+
+	bid := models.LoadBid{
+		LoadID:           load.ID,
+		CarrierCompanyID: user.CompanyID,
+		BidderID:         user.ID,
+		AmountCents:      req.AmountCents,
+		Note:             strings.TrimSpace(req.Note),
+		Status:           models.BidStatusOpen,
+	}
+	if err := db.Create(&bid).Error; err != nil {
+		fmt.Println("Error creating bid:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not place bid"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "bid": bid})
+}
+
+// AcceptLoadBid books the load with the bidding carrier at the bid amount and rejects the
+// other open bids
+func AcceptLoadBid(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+	db := tenant.System(requestDB(c), "load-award")
+
+	var bid models.LoadBid
+	var reason string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the load first, so a second award or an edit can't interleave with this one
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", load.ID).First(load).Error; err != nil {
+			return err
+		}
+		if load.Status != models.LoadStatusPosted {
+			return errLoadNotPosted
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND load_id = ?", c.Params("bidID"), load.ID).First(&bid).Error; err != nil {
+			return err
+		}
+		if bid.Status != models.BidStatusOpen {
+			return errBidNotOpen
+		}
+		var ok bool
+		if reason, ok = bookableCarrier(bid.CarrierCompanyID); !ok {
+			return errCarrierNotBookable
+		}
+
+		load.CarrierCompanyID = &bid.CarrierCompanyID
+		load.CarrierLinehaulCents = bid.AmountCents
+		load.Status = models.LoadStatusBooked
+		if err := tx.Model(load).Select("carrier_company_id", "carrier_linehaul_cents", "status").Updates(load).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&bid).Update("status", models.BidStatusAccepted).Error; err != nil {
+			return err
+		}
+		bid.Status = models.BidStatusAccepted
+
+		return tx.Model(&models.LoadBid{}).
+			Where("load_id = ? AND id <> ? AND status = ?", load.ID, bid.ID, models.BidStatusOpen).
+			Update("status", models.BidStatusRejected).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Bid not found"})
+	case errors.Is(err, errCarrierNotBookable):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": reason})
+	case errors.Is(err, errBidNotOpen), errors.Is(err, errLoadNotPosted):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		fmt.Println("Error accepting bid:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not accept bid"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Load booked", "load": load, "bid": bid})
+}
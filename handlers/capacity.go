@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"cargozig_api/tenant"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	metersPerMile = 1609.344
+
+	// maxCapacityWindow is the longest availability window a post may advertise
+	maxCapacityWindow = 14 * 24 * time.Hour
+
+	// defaultSearchRadiusMiles is how far from the requested pickup point to look by default
+	defaultSearchRadiusMiles = 100
+)
+
+// SetupCapacityRoutes sets up the truck capacity posting board
+// /api/capacity
+func SetupCapacityRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	// Broker search
+	router.Get("/search", middleware.RequireRole(models.RoleAdmin), SearchCapacityPosts)
+
+	// Carrier posts
+	carrier := middleware.RequireRole(models.RoleCarrier)
+	router.Get("/posts", carrier, ListCapacityPosts)
+	router.Post("/posts", carrier, middleware.RequireVerifiedCompany(), CreateCapacityPost)
+	router.Get("/posts/:id", carrier, GetCapacityPost)
+	router.Post("/posts/:id/booked", carrier, MarkCapacityPostBooked)
+	router.Delete("/posts/:id", carrier, CancelCapacityPost)
+}
+
+// capacityResult is a search hit with its deadhead distance to the requested pickup point
+type capacityResult struct {
+	models.CapacityPost
+	DeadheadMiles float64 `json:"deadhead_miles" gorm:"->"`
+}
+
+// ListCapacityPosts lists the company's posts, active ones only unless ?all=true
+func ListCapacityPosts(c *fiber.Ctx) error {
+	query := requestDB(c).Where("company_id = ?", currentUser(c).CompanyID)
+	if c.Query("all") != "true" {
+		query = query.Where("status = ?", models.CapacityPostActive)
+	}
+
+	var posts []models.CapacityPost
+	if err := query.Order("available_from").Find(&posts).Error; err != nil {
+		fmt.Println("Error listing capacity posts:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list capacity posts"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "posts": posts})
+}
+
+// CreateCapacityPost posts an empty truck to the board. Equipment and capacity default to
+// the linked vehicle or trailer when one is given.
+func CreateCapacityPost(c *fiber.Ctx) error {
+	var req struct {
+		VehicleID              string           `json:"vehicle_id"`
+		TrailerID              string           `json:"trailer_id"`
+		EquipmentType          string           `json:"equipment_type"`
+		OriginCity             string           `json:"origin_city"`
+		OriginState            string           `json:"origin_state"`
+		Origin                 models.GeoPoint  `json:"origin"`
+		OriginRadiusMiles      int              `json:"origin_radius_miles"`
+		DestinationCity        string           `json:"destination_city"`
+		DestinationState       string           `json:"destination_state"`
+		Destination            *models.GeoPoint `json:"destination"`
+		DestinationRadiusMiles int              `json:"destination_radius_miles"`
+		DestinationStates      []string         `json:"destination_states"`
+		AvailableFrom          time.Time        `json:"available_from"`
+		AvailableTo            time.Time        `json:"available_to"`
+		CapacityLbs            int              `json:"capacity_lbs"`
+		LengthFeet             int              `json:"length_feet"`
+		RatePerMileCents       int64            `json:"rate_per_mile_cents"`
+		Notes                  string           `json:"notes"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	user := currentUser(c)
+	db := requestDB(c)
+
+	post := models.CapacityPost{
+		CompanyID:         user.CompanyID,
+		CreatedByID:       user.ID,
+		EquipmentType:     models.EquipmentType(strings.ToLower(req.EquipmentType)),
+		OriginCity:        strings.TrimSpace(req.OriginCity),
+		OriginState:       strings.ToUpper(strings.TrimSpace(req.OriginState)),
+		Origin:            req.Origin,
+		OriginRadiusMiles: req.OriginRadiusMiles,
+		DestinationCity:   strings.TrimSpace(req.DestinationCity),
+		DestinationState:  strings.ToUpper(strings.TrimSpace(req.DestinationState)),
+		AvailableFrom:     req.AvailableFrom,
+		AvailableTo:       req.AvailableTo,
+		CapacityLbs:       req.CapacityLbs,
+		LengthFeet:        req.LengthFeet,
+		RatePerMileCents:  req.RatePerMileCents,
+		Notes:             strings.TrimSpace(req.Notes),
+		Status:            models.CapacityPostActive,
+	}
+
+	if req.VehicleID != "" {
+		var vehicle models.Vehicle
+		if err := db.Where("id = ? AND company_id = ? AND active = ?", req.VehicleID, user.CompanyID, true).First(&vehicle).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Vehicle not found"})
+		}
+		post.VehicleID = &vehicle.ID
+		if post.EquipmentType == "" {
+			post.EquipmentType = vehicle.EquipmentType
+		}
+		if post.CapacityLbs == 0 {
+			post.CapacityLbs = vehicle.CapacityLbs
+		}
+	}
+	if req.TrailerID != "" {
+		var trailer models.Trailer
+		if err := db.Where("id = ? AND company_id = ? AND active = ?", req.TrailerID, user.CompanyID, true).First(&trailer).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Trailer not found"})
+		}
+		post.TrailerID = &trailer.ID
+		if post.EquipmentType == "" || post.EquipmentType == models.EquipmentPowerOnly {
+			post.EquipmentType = trailer.EquipmentType
+		}
+		if post.CapacityLbs == 0 {
+			post.CapacityLbs = trailer.CapacityLbs
+		}
+		if post.LengthFeet == 0 {
+			post.LengthFeet = trailer.LengthFeet
+		}
+	}
+
+	if !models.ValidEquipmentType(post.EquipmentType) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid equipment type"})
+	}
+	if !post.Origin.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "origin must have a valid lat and lng"})
+	}
+	if post.OriginRadiusMiles < 0 || post.OriginRadiusMiles > 500 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "origin_radius_miles must be between 0 and 500"})
+	}
+	if req.Destination != nil {
+		if !req.Destination.Valid() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "destination must have a valid lat and lng"})
+		}
+		if req.DestinationRadiusMiles <= 0 || req.DestinationRadiusMiles > 500 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "destination_radius_miles must be between 1 and 500"})
+		}
+		post.Destination = req.Destination
+		post.DestinationRadiusMiles = req.DestinationRadiusMiles
+	}
+	var states []string
+	for _, state := range req.DestinationStates {
+		state = strings.ToUpper(strings.TrimSpace(state))
+		if !statePattern.MatchString(state) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "destination_states must be two letter state codes"})
+		}
+		states = append(states, state)
+	}
+	post.DestinationStates = strings.Join(states, ",")
+
+	if post.AvailableFrom.IsZero() || !post.AvailableTo.After(post.AvailableFrom) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "available_to must be after available_from"})
+	}
+	if !post.AvailableTo.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Availability window has already passed"})
+	}
+	if post.AvailableTo.Sub(post.AvailableFrom) > maxCapacityWindow {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Availability window cannot exceed 14 days"})
+	}
+	if post.CapacityLbs < 0 || post.LengthFeet < 0 || post.RatePerMileCents < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Capacity, length and rate cannot be negative"})
+	}
+
+	if err := db.Create(&post).Error; err != nil {
+		fmt.Println("Error creating capacity post:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create capacity post"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "post": post})
+}
+
+// GetCapacityPost returns one of the company's posts
+func GetCapacityPost(c *fiber.Ctx) error {
+	var post models.CapacityPost
+	if err := findFleetItem(c, &post); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Capacity post not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "post": post})
+}
+
+// MarkCapacityPostBooked takes an active post off the board once the truck is covered
+func MarkCapacityPostBooked(c *fiber.Ctx) error {
+	return closeCapacityPost(c, models.CapacityPostBooked)
+}
+
+// CancelCapacityPost takes an active post off the board
+func CancelCapacityPost(c *fiber.Ctx) error {
+	return closeCapacityPost(c, models.CapacityPostCancelled)
+}
+
+// closeCapacityPost moves an active post to status
+func closeCapacityPost(c *fiber.Ctx, status models.CapacityPostStatus) error {
+	result := requestDB(c).Model(&models.CapacityPost{}).
+		Where("id = ? AND company_id = ? AND status = ?", c.Params("id"), currentUser(c).CompanyID, models.CapacityPostActive).
+		Update("status", status)
+	if result.Error != nil {
+		fmt.Println("Error updating capacity post:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update capacity post"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Active capacity post not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Capacity post " + string(status)})
+}
+
+// SearchCapacityPosts finds active trucks that can pick up near ?lat=&lng= within
+// ?radius_miles= (plus each truck's own deadhead radius), nearest first. Optional filters:
+// ?equipment_type=, ?date=YYYY-MM-DD (available that day), and a destination as ?dest_state=
+// and/or ?dest_lat=&dest_lng=. Posts without destination preferences match any destination.
+// Only verified carriers that are not on compliance hold are returned.
+func SearchCapacityPosts(c *fiber.Ctx) error {
+	pickup := models.GeoPoint{Lat: c.QueryFloat("lat"), Lng: c.QueryFloat("lng")}
+	if !pickup.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "lat and lng are required"})
+	}
+	radiusMiles := c.QueryFloat("radius_miles", defaultSearchRadiusMiles)
+	if radiusMiles <= 0 || radiusMiles > 500 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "radius_miles must be between 0 and 500"})
+	}
+
+	// Brokers search every carrier's posts, so the tenant scope is lifted for the search
+	point := "ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography"
+	query := tenant.System(requestDB(c), "capacity-search").Table("capacity_posts").
+		Select("capacity_posts.*, ST_Distance(capacity_posts.origin, "+point+") / ? AS deadhead_miles", pickup.Lng, pickup.Lat, metersPerMile).
+		Joins("JOIN companies ON companies.id = capacity_posts.company_id").
+		Where("capacity_posts.status = ? AND capacity_posts.available_to > ?", models.CapacityPostActive, time.Now()).
+		Where("companies.verified = ? AND companies.compliance_hold = ?", true, false).
+		Where("ST_DWithin(capacity_posts.origin, "+point+", (? + capacity_posts.origin_radius_miles) * ?)",
+			pickup.Lng, pickup.Lat, radiusMiles, metersPerMile)
+
+	if equipment := c.Query("equipment_type"); equipment != "" {
+		query = query.Where("capacity_posts.equipment_type = ?", strings.ToLower(equipment))
+	}
+	if date := c.Query("date"); date != "" {
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+		}
+		query = query.Where("capacity_posts.available_from < ? AND capacity_posts.available_to > ?", day.AddDate(0, 0, 1), day)
+	}
+
+	destState := strings.ToUpper(strings.TrimSpace(c.Query("dest_state")))
+	destination := models.GeoPoint{Lat: c.QueryFloat("dest_lat"), Lng: c.QueryFloat("dest_lng")}
+	if destState != "" || destination.Valid() {
+		query = query.Where(capacityDestinationFilter(requestDB(c), destState, destination))
+	}
+
+	var results []capacityResult
+	if err := query.Preload("Company").Order("deadhead_miles").Limit(200).Find(&results).Error; err != nil {
+		fmt.Println("Error searching capacity posts:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not search capacity"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "results": results})
+}
+
+// capacityDestinationFilter matches posts that would take a load to the given state or point
+func capacityDestinationFilter(db *gorm.DB, state string, point models.GeoPoint) *gorm.DB {
+	filter := db.Where("capacity_posts.destination IS NULL AND capacity_posts.destination_states = '' AND capacity_posts.destination_state = ''")
+	if state != "" {
+		filter = filter.Or("? = ANY(string_to_array(capacity_posts.destination_states, ','))", state).
+			Or("capacity_posts.destination_state = ? AND capacity_posts.destination IS NULL", state)
+	}
+	if point.Valid() {
+		filter = filter.Or("ST_DWithin(capacity_posts.destination, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, capacity_posts.destination_radius_miles * ?)",
+			point.Lng, point.Lat, metersPerMile)
+	}
+	return filter
+}
+
+// ExpireCapacityPosts takes posts off the board once their availability window has passed
+func ExpireCapacityPosts(db *gorm.DB) error {
+	now := time.Now()
+	result := db.Model(&models.CapacityPost{}).
+		Where("status = ? AND available_to <= ?", models.CapacityPostActive, now).
+		Updates(map[string]interface{}{"status": models.CapacityPostExpired, "expired_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		fmt.Printf("Expired %d capacity posts\n", result.RowsAffected)
+	}
+	return nil
+}
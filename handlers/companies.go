@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"cargozig_api/listing"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+var (
+	phonePattern   = regexp.MustCompile(`^\+?[0-9 ().-]{7,20}$`)
+	zipPattern     = regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`)
+	postalPattern  = regexp.MustCompile(`^[A-Za-z0-9 -]{2,12}$`)
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	taxIDPattern   = regexp.MustCompile(`^[0-9]{2}-?[0-9]{7}$`) // US EIN
+)
+
+// companyTypes are the accepted values of Company.CompanyType
+var companyTypes = map[string]bool{"shipper": true, "carrier": true, "both": true}
+
+// companyRequest is the body for creating and updating companies. On update, fields left
+// out keep their current value.
+type companyRequest struct {
+	Name        *string `json:"name"`
+	Email       *string `json:"email"`
+	Phone       *string `json:"phone"`
+	Address     *string `json:"address"`
+	City        *string `json:"city"`
+	State       *string `json:"state"`
+	ZipCode     *string `json:"zip_code"`
+	Country     *string `json:"country"`
+	LogoURL     *string `json:"logo_url"`
+	Website     *string `json:"website"`
+	TaxID       *string `json:"tax_id"`
+	DOTNumber   *string `json:"dot_number"`
+	MCNumber    *string `json:"mc_number"`
+	Authority   *string `json:"authority"`
+	CompanyType *string `json:"company_type"`
+}
+
+// apply copies the provided fields onto company, normalized
+func (req *companyRequest) apply(company *models.Company) {
+	set := func(dst *string, src *string, normalize func(string) string) {
+		if src != nil {
+			*dst = normalize(strings.TrimSpace(*src))
+		}
+	}
+	same := func(s string) string { return s }
+
+	set(&company.Name, req.Name, same)
+	set(&company.Email, req.Email, strings.ToLower)
+	set(&company.Phone, req.Phone, same)
+	set(&company.Address, req.Address, same)
+	set(&company.City, req.City, same)
+	set(&company.State, req.State, strings.ToUpper)
+	set(&company.ZipCode, req.ZipCode, strings.ToUpper)
+	set(&company.Country, req.Country, strings.ToUpper)
+	set(&company.LogoURL, req.LogoURL, same)
+	set(&company.Website, req.Website, same)
+	set(&company.TaxID, req.TaxID, same)
+	set(&company.DOTNumber, req.DOTNumber, same)
+	set(&company.MCNumber, req.MCNumber, func(s string) string {
+		return strings.TrimLeft(strings.TrimPrefix(strings.ToUpper(s), "MC"), "- ")
+	})
+	set(&company.Authority, req.Authority, strings.ToLower)
+	set(&company.CompanyType, req.CompanyType, strings.ToLower)
+}
+
+// validateCompany checks every editable company field, returning problems by JSON field name
+func validateCompany(company *models.Company) map[string]string {
+	problems := map[string]string{}
+
+	if company.Name == "" {
+		problems["name"] = "Name is required"
+	} else if len(company.Name) > 200 {
+		problems["name"] = "Name must be at most 200 characters"
+	}
+	if _, err := mail.ParseAddress(company.Email); err != nil || strings.ContainsAny(company.Email, "<> ") {
+		problems["email"] = "A valid email is required"
+	}
+	if company.Phone != "" && !phonePattern.MatchString(company.Phone) {
+		problems["phone"] = "Phone must be 7 to 20 digits, spaces or ( ) . - characters"
+	}
+	if len(company.Address) > 200 {
+		problems["address"] = "Address must be at most 200 characters"
+	}
+	if len(company.City) > 100 {
+		problems["city"] = "City must be at most 100 characters"
+	}
+	if company.Country != "" && !countryPattern.MatchString(company.Country) {
+		problems["country"] = "Country must be a two letter ISO code such as US"
+	}
+
+	domestic := company.Country == "" || company.Country == "US"
+	if company.State != "" && domestic && !statePattern.MatchString(company.State) {
+		problems["state"] = "State must be a two letter code"
+	}
+	if company.ZipCode != "" {
+		if domestic && !zipPattern.MatchString(company.ZipCode) {
+			problems["zip_code"] = "ZIP code must be 12345 or 12345-6789"
+		} else if !domestic && !postalPattern.MatchString(company.ZipCode) {
+			problems["zip_code"] = "Postal code must be 2 to 12 letters, digits or spaces"
+		}
+	}
+
+	if company.LogoURL != "" && !validWebURL(company.LogoURL) {
+		problems["logo_url"] = "Logo URL must be an http or https URL"
+	}
+	if company.Website != "" && !validWebURL(company.Website) {
+		problems["website"] = "Website must be an http or https URL"
+	}
+	if company.TaxID != "" && !taxIDPattern.MatchString(company.TaxID) {
+		problems["tax_id"] = "Tax ID must be an EIN such as 12-3456789"
+	}
+	if company.DOTNumber != "" && !dotNumberPattern.MatchString(company.DOTNumber) {
+		problems["dot_number"] = "DOT number must be 1 to 8 digits"
+	}
+	if company.MCNumber != "" && !mcNumberPattern.MatchString(company.MCNumber) {
+		problems["mc_number"] = "MC number must be 1 to 8 digits"
+	}
+	if company.Authority != "" && company.Authority != "active" && company.Authority != "inactive" && company.Authority != "pending" {
+		problems["authority"] = "Authority must be active, inactive or pending"
+	}
+	if !companyTypes[company.CompanyType] {
+		problems["company_type"] = "Company type must be shipper, carrier or both"
+	}
+
+	return problems
+}
+
+// validWebURL reports whether s is an absolute http or https URL
+func validWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// companyEmailTaken reports whether another company already uses email
+func companyEmailTaken(db *gorm.DB, email string, except *models.Company) bool {
+	query := db.Model(&models.Company{}).Where("LOWER(email) = ?", email)
+	if except != nil {
+		query = query.Where("id <> ?", except.ID)
+	}
+	var count int64
+	query.Count(&count)
+	return count > 0
+}
+
+// SuperAdminNewCompany renders the create company form
+func SuperAdminNewCompany(c *fiber.Ctx) error {
+	return c.Render("superadmin/company_form", fiber.Map{
+		"Title":      "New Company",
+		"ActivePage": "companies",
+		"Username":   c.Locals("username"),
+		"Company":    models.Company{Active: true, Country: "US"},
+		"IsNew":      true,
+	}, "layouts/superadmin")
+}
+
+// SuperAdminEditCompany renders the edit company form
+func SuperAdminEditCompany(c *fiber.Ctx) error {
+	var company models.Company
+	if err := requestDB(c).First(&company, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Company not found")
+	}
+
+	return c.Render("superadmin/company_form", fiber.Map{
+		"Title":      "Edit " + company.Name,
+		"ActivePage": "companies",
+		"Username":   c.Locals("username"),
+		"Company":    company,
+		"IsNew":      false,
+	}, "layouts/superadmin")
+}
+
+// SuperAdminViewCompany renders a company's details and members
+func SuperAdminViewCompany(c *fiber.Ctx) error {
+	db := requestDB(c)
+
+	var company models.Company
+	if err := db.First(&company, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Company not found")
+	}
+
+	var members []models.User
+	db.Where("company_id = ?", company.ID).Order("username").Find(&members)
+
+	var memberships []models.CompanyMembership
+	db.Preload("User").Where("company_id = ?", company.ID).Order("created_at").Find(&memberships)
+
+	return c.Render("superadmin/company_view", fiber.Map{
+		"Title":       company.Name,
+		"ActivePage":  "companies",
+		"Username":    c.Locals("username"),
+		"Company":     company,
+		"Members":     members,
+		"Memberships": memberships,
+	}, "layouts/superadmin")
+}
+
+// SuperAdminListCompanies lists companies a page at a time, optionally of one ?type=
+//
+//	GET /superadmin/api/companies?q=&type=&active=&verified=&created_from=&created_to=&sort=name&page=&size=
+func SuperAdminListCompanies(c *fiber.Ctx) error {
+	params, err := listing.Parse(c, companyListSpec)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	companyType := strings.ToLower(c.Query("type"))
+	if companyType != "" && !companyTypes[companyType] {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "type must be shipper, carrier or both"})
+	}
+
+	companies, page, err := listCompanies(c, params, companyType)
+	if err != nil {
+		fmt.Println("Error listing companies:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list companies"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "companies": companies, "pagination": page})
+}
+
+// SuperAdminGetCompany returns one company
+func SuperAdminGetCompany(c *fiber.Ctx) error {
+	var company models.Company
+	if err := requestDB(c).First(&company, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "company": company})
+}
+
+// SuperAdminCreateCompany creates a company. New companies are active and unverified.
+func SuperAdminCreateCompany(c *fiber.Ctx) error {
+	var req companyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	company := models.Company{Active: true}
+	req.apply(&company)
+	if problems := validateCompany(&company); len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed", "fields": problems})
+	}
+
+	db := requestDB(c)
+	if companyEmailTaken(db, company.Email, nil) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A company with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+	}
+
+	if err := db.Create(&company).Error; err != nil {
+		if isUniqueViolation(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A company with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+		}
+		fmt.Println("Error creating company:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create company"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "company": company})
+}
+
+// SuperAdminUpdateCompany updates a company's details. Status and verification have
+// their own endpoints.
+func SuperAdminUpdateCompany(c *fiber.Ctx) error {
+	var req companyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	db := requestDB(c)
+	var company models.Company
+	if err := db.First(&company, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	req.apply(&company)
+	if problems := validateCompany(&company); len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed", "fields": problems})
+	}
+	if companyEmailTaken(db, company.Email, &company) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A company with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+	}
+
+	err := db.Model(&company).Select(
+		"name", "email", "phone", "address", "city", "state", "zip_code", "country",
+		"logo_url", "website", "tax_id", "dot_number", "mc_number", "authority", "company_type",
+	).Updates(&company).Error
+	if err != nil {
+		if isUniqueViolation(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A company with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+		}
+		fmt.Println("Error updating company:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update company"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "company": company})
+}
+
+// SuperAdminActivateCompany lets a deactivated company use the platform again
+func SuperAdminActivateCompany(c *fiber.Ctx) error {
+	return setCompanyFlags(c, models.AuditCompanyActivated, map[string]interface{}{"active": true})
+}
+
+// SuperAdminDeactivateCompany suspends a company
+func SuperAdminDeactivateCompany(c *fiber.Ctx) error {
+	return setCompanyFlags(c, models.AuditCompanyDeactivated, map[string]interface{}{"active": false})
+}
+
+// SuperAdminVerifyCompany marks a company verified, or unverified with {"verified": false}.
+// Carriers normally get verified through the onboarding review instead.
+func SuperAdminVerifyCompany(c *fiber.Ctx) error {
+	req := struct {
+		Verified *bool `json:"verified"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	if req.Verified != nil && !*req.Verified {
+		return setCompanyFlags(c, models.AuditCompanyUnverified, map[string]interface{}{"verified": false, "verification_id": ""})
+	}
+	return setCompanyFlags(c, models.AuditCompanyVerified, map[string]interface{}{"verified": true, "verification_id": "superadmin:" + currentUser(c).ID.String()})
+}
+
+// setCompanyFlags applies status updates to the route's company and audits them as action
+func setCompanyFlags(c *fiber.Ctx, action string, updates map[string]interface{}) error {
+	db := requestDB(c)
+	var company models.Company
+	if err := db.First(&company, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	if err := db.Model(&company).Updates(updates).Error; err != nil {
+		fmt.Println("Error updating company:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update company"})
+	}
+	recordAudit(c, action, "company", company.ID.String())
+	db.First(&company, "id = ?", company.ID)
+
+	return c.JSON(fiber.Map{"status": "success", "company": company})
+}
+
+// SuperAdminCompanyMembers lists a company's users and the members it shares with other companies
+func SuperAdminCompanyMembers(c *fiber.Ctx) error {
+	db := requestDB(c)
+	var company models.Company
+	if err := db.First(&company, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	var members []models.User
+	if err := db.Where("company_id = ?", company.ID).Order("username").Find(&members).Error; err != nil {
+		fmt.Println("Error listing company members:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list members"})
+	}
+
+	var memberships []models.CompanyMembership
+	if err := db.Preload("User").Where("company_id = ?", company.ID).Order("created_at").Find(&memberships).Error; err != nil {
+		fmt.Println("Error listing company memberships:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list members"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":        "success",
+		"owner_user_id": company.OwnerUserID,
+		"members":       members,
+		"memberships":   memberships,
+	})
+}
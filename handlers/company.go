@@ -0,0 +1,715 @@
+package handlers
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/config"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"cargozig_api/notify"
+	"cargozig_api/tenant"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// invitationTTL is how long an invitation can be accepted for
+const invitationTTL = 7 * 24 * time.Hour
+
+var (
+	errNotMember        = errors.New("user is not an active member of this company")
+	errInviteInvalid    = errors.New("invitation is invalid or has expired")
+	errAlreadyMember    = errors.New("this person is already a member of the company")
+	errInviteLogin      = errors.New("incorrect password for the existing account")
+	errUsernameRequired = errors.New("a username is required to create your account")
+	errCompanyNotFound  = errors.New("company not found")
+	errNotCompanyAdmin  = errors.New("only the company owner or a member with the manage users permission can do this")
+)
+
+// SetupCompanyRoutes sets up company membership, invitation, ownership and company switching routes
+// /api/company
+func SetupCompanyRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/members", ListCompanyMembers)
+	router.Post("/members/:id/deactivate", middleware.DenyWhileImpersonating(), DeactivateCompanyMember)
+	router.Post("/members/:id/reactivate", middleware.DenyWhileImpersonating(), ReactivateCompanyMember)
+	router.Get("/invitations", ListInvitations)
+	router.Post("/invitations", middleware.DenyWhileImpersonating(), CreateInvitation)
+	router.Delete("/invitations/:id", middleware.DenyWhileImpersonating(), RevokeInvitation)
+	router.Post("/transfer-ownership", middleware.DenyWhileImpersonating(), TransferCompanyOwnership)
+	router.Get("/memberships", ListMyCompanies)
+	router.Post("/switch", middleware.DenyWhileImpersonating(), SwitchCompany)
+}
+
+// ListCompanyMembers lists the users of the current user's company, both those whose home
+// company it is and those with a membership of it
+func ListCompanyMembers(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	var company models.Company
+	if err := db.First(&company, "id = ?", user.CompanyID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	var members []models.User
+	if err := db.Where("company_id = ?", user.CompanyID).Order("username").Find(&members).Error; err != nil {
+		fmt.Println("Error listing company members:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list members"})
+	}
+
+	// Members from other companies are only visible through their membership
+	var memberships []models.CompanyMembership
+	if err := tenant.System(db, "company-memberships").Preload("User").Where("company_id = ?", user.CompanyID).
+		Order("created_at").Find(&memberships).Error; err != nil {
+		fmt.Println("Error listing company memberships:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list members"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":        "success",
+		"owner_user_id": company.OwnerUserID,
+		"members":       members,
+		"memberships":   memberships,
+	})
+}
+
+// DeactivateCompanyMember stops a member from using this company. They keep their
+// account and any memberships of other companies.
+func DeactivateCompanyMember(c *fiber.Ctx) error {
+	return setMemberActive(c, false)
+}
+
+// ReactivateCompanyMember gives a deactivated member access to this company again
+func ReactivateCompanyMember(c *fiber.Ctx) error {
+	return setMemberActive(c, true)
+}
+
+// setMemberActive activates or deactivates a member. Company admins cannot deactivate
+// themselves or the owner.
+func setMemberActive(c *fiber.Ctx, active bool) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	company, err := managedCompany(db, user)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !active && c.Params("id") == user.ID.String() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "You cannot deactivate yourself"})
+	}
+	if !active && company.OwnerUserID != nil && c.Params("id") == company.OwnerUserID.String() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Transfer ownership before deactivating the owner"})
+	}
+
+	action := models.AuditMemberReactivated
+	if !active {
+		action = models.AuditMemberDeactivated
+	}
+
+	var member models.User
+	err = db.Where("id = ? AND company_id = ?", c.Params("id"), company.ID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var membership models.CompanyMembership
+		if err := db.Where("user_id = ? AND company_id = ?", c.Params("id"), company.ID).First(&membership).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Member not found"})
+		}
+		if err := db.Model(&membership).Update("active", active).Error; err != nil {
+			fmt.Println("Error updating membership:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update member"})
+		}
+		recordAudit(c, action, "company_memberships", membership.ID.String())
+		return c.JSON(fiber.Map{"status": "success", "membership": membership})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Member not found"})
+	}
+
+	// Only this company's access is changed, users.active would lock them out of every company
+	if err := db.Model(&member).Update("home_company_active", active).Error; err != nil {
+		fmt.Println("Error updating member:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update member"})
+	}
+	recordAudit(c, action, "users", member.ID.String())
+
+	return c.JSON(fiber.Map{"status": "success", "member": member})
+}
+
+// ListMyCompanies lists the companies the current user can switch between
+func ListMyCompanies(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	home, err := homeUser(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	var homeCompany models.Company
+	if err := config.GetDB().First(&homeCompany, "id = ?", home.CompanyID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	var memberships []models.CompanyMembership
+	if err := config.GetDB().Preload("Company").Where("user_id = ? AND active = ?", user.ID, true).
+		Order("created_at").Find(&memberships).Error; err != nil {
+		fmt.Println("Error listing memberships:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list companies"})
+	}
+
+	companies := []fiber.Map{}
+	if home.HomeCompanyActive {
+		companies = append(companies, fiber.Map{
+			"company_id":   homeCompany.ID,
+			"company_name": homeCompany.Name,
+			"roles":        home.Roles,
+			"home":         true,
+			"current":      homeCompany.ID == user.CompanyID,
+		})
+	}
+	for _, m := range memberships {
+		name := ""
+		if m.Company != nil {
+			name = m.Company.Name
+		}
+		companies = append(companies, fiber.Map{
+			"company_id":   m.CompanyID,
+			"company_name": name,
+			"roles":        m.Roles,
+			"home":         false,
+			"current":      m.CompanyID == user.CompanyID,
+		})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "companies": companies})
+}
+
+// SwitchCompany makes another of the user's companies the active one by reissuing their
+// token with that company in its claims
+func SwitchCompany(c *fiber.Ctx) error {
+	var req struct {
+		CompanyID string `json:"company_id"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	companyID, err := uuid.Parse(req.CompanyID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	home, err := homeUser(currentUser(c).ID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	roles := home.Roles
+	if companyID == home.CompanyID && !home.HomeCompanyActive {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Your access to that company has been deactivated"})
+	}
+	if companyID != home.CompanyID {
+		var membership models.CompanyMembership
+		if err := config.GetDB().Where("user_id = ? AND company_id = ? AND active = ?", home.ID, companyID, true).
+			First(&membership).Error; err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You are not a member of that company"})
+		}
+		roles = membership.Roles
+	}
+
+	token, err := GenerateJWT(home.ID.String(), companyID.String(), roles)
+	if err != nil {
+		fmt.Println("Error generating token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	setAuthCookie(c, token)
+
+	return c.JSON(fiber.Map{"status": "success", "token": token, "company_id": companyID, "roles": roles})
+}
+
+// homeUser loads the user as stored, without an active membership applied
+func homeUser(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := config.GetDB().First(&user, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// sessionCompany picks the company a sign in starts in and the roles held there: the
+// home company, or the oldest active membership once the home company has deactivated the user
+func sessionCompany(db *gorm.DB, user *models.User) (uuid.UUID, models.RoleArray, error) {
+	if user.HomeCompanyActive {
+		return user.CompanyID, user.Roles, nil
+	}
+
+	var membership models.CompanyMembership
+	if err := db.Where("user_id = ? AND active = ?", user.ID, true).Order("created_at").First(&membership).Error; err != nil {
+		return uuid.Nil, nil, err
+	}
+	return membership.CompanyID, membership.Roles, nil
+}
+
+// ListInvitations lists the company's invitations, pending ones only unless ?all=true
+func ListInvitations(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	if _, err := managedCompany(db, user); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	query := db.Where("company_id = ?", user.CompanyID)
+	if c.Query("all") != "true" {
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now())
+	}
+
+	var invitations []models.Invitation
+	if err := query.Order("created_at DESC").Find(&invitations).Error; err != nil {
+		fmt.Println("Error listing invitations:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list invitations"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "invitations": invitations})
+}
+
+// CreateInvitation invites a colleague to the company. The inviter can only grant roles
+// and permissions they hold themselves. The token is only emailed to the invitee.
+func CreateInvitation(c *fiber.Ctx) error {
+	var req struct {
+		Email       string   `json:"email"`
+		Roles       []string `json:"roles"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	user := currentUser(c)
+	db := requestDB(c)
+
+	company, err := managedCompany(db, user)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, err := mail.ParseAddress(email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A valid email is required"})
+	}
+	if len(req.Roles) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "At least one role is required"})
+	}
+
+	var roles models.RoleArray
+	for _, r := range req.Roles {
+		role := models.Role(strings.ToLower(strings.TrimSpace(r)))
+		if ok, err := authz.RoleExists(db, company.ID, role); err != nil || !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid role: " + r})
+		}
+		if !hasRole(user, role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You cannot grant a role you do not hold: " + r})
+		}
+		roles = append(roles, role)
+	}
+	permissions := models.PermissionArray{}
+	for _, p := range req.Permissions {
+		permission := models.Permission(strings.TrimSpace(p))
+		if !user.HasPermission(permission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You cannot grant a permission you do not hold: " + p})
+		}
+		permissions = append(permissions, permission)
+	}
+
+	// People with an account elsewhere are invited too; they join as a membership
+	if isCompanyMember(config.GetDB(), email, company.ID) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": errAlreadyMember.Error()})
+	}
+
+	token, err := newInvitationToken()
+	if err != nil {
+		fmt.Println("Error generating invitation token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create invitation"})
+	}
+
+	invitation := models.Invitation{
+		CompanyID:   company.ID,
+		Email:       email,
+		Roles:       roles,
+		Permissions: permissions,
+		TokenHash:   hashInvitationToken(token),
+		ExpiresAt:   time.Now().Add(invitationTTL),
+		InvitedByID: user.ID,
+	}
+
+	// A new invitation replaces any pending one for the same email
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Invitation{}).
+			Where("company_id = ? AND email = ? AND accepted_at IS NULL AND revoked_at IS NULL", company.ID, email).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		fmt.Println("Error creating invitation:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create invitation"})
+	}
+
+	notify.Send(notify.Message{
+		To:      []string{email},
+		Subject: fmt.Sprintf("%s invited you to %s on CargoZig", user.Username, company.Name),
+		Body:    fmt.Sprintf("Accept the invitation before %s: %s", invitation.ExpiresAt.Format("2006-01-02"), invitationLink(token)),
+		Tags:    map[string]string{"company_id": company.ID.String(), "kind": "invitation"},
+	})
+
+	// The token only goes to the invitee's inbox, so whoever created the invitation can't accept it
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "invitation": invitation})
+}
+
+// RevokeInvitation cancels a pending invitation
+func RevokeInvitation(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	if _, err := managedCompany(db, user); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result := db.Model(&models.Invitation{}).
+		Where("id = ? AND company_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", c.Params("id"), user.CompanyID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		fmt.Println("Error revoking invitation:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke invitation"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Pending invitation not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Invitation revoked"})
+}
+
+// GetInvitation shows who an invitation token is for, so the accept page can be filled in
+func GetInvitation(c *fiber.Ctx) error {
+	var invitation models.Invitation
+	err := config.GetDB().Preload("Company").
+		Where("token_hash = ?", hashInvitationToken(c.Params("token"))).First(&invitation).Error
+	if err != nil || !invitation.Pending(time.Now()) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": errInviteInvalid.Error()})
+	}
+
+	companyName := ""
+	if invitation.Company != nil {
+		companyName = invitation.Company.Name
+	}
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"email":      invitation.Email,
+		"company":    companyName,
+		"roles":      invitation.Roles,
+		"expires_at": invitation.ExpiresAt,
+	})
+}
+
+// AcceptInvitation joins the invitee to the inviting company and signs them in to it.
+// Someone new gets an account in that company; an existing user confirms their password
+// and is given a membership of it alongside their own company.
+func AcceptInvitation(c *fiber.Ctx) error {
+	var req struct {
+		Token    string `json:"token"`
+		Username string `json:"username"` // Only needed for new accounts
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Token == "" || len(req.Password) < 8 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Token and a password of at least 8 characters are required"})
+	}
+
+	var user models.User
+	var invitation models.Invitation
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashInvitationToken(req.Token)).First(&invitation).Error; err != nil {
+			return errInviteInvalid
+		}
+		now := time.Now()
+		if !invitation.Pending(now) {
+			return errInviteInvalid
+		}
+
+		err := tx.Where("LOWER(email) = ?", invitation.Email).First(&user).Error
+		switch {
+		case err == nil:
+			if !user.Active || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+				return errInviteLogin
+			}
+			if user.CompanyID == invitation.CompanyID {
+				return errAlreadyMember
+			}
+			membership := models.CompanyMembership{
+				UserID:      user.ID,
+				CompanyID:   invitation.CompanyID,
+				Roles:       invitation.Roles,
+				Permissions: invitation.Permissions,
+				Active:      true,
+			}
+			// A removed or deactivated membership is brought back with the invited access
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "company_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"roles", "permissions", "active", "updated_at", "deleted_at"}),
+			}).Create(&membership).Error; err != nil {
+				return err
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if strings.TrimSpace(req.Username) == "" {
+				return errUsernameRequired
+			}
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			user = models.User{
+				Username:    strings.TrimSpace(req.Username),
+				Email:       invitation.Email,
+				Password:    string(hashedPassword),
+				CompanyID:   invitation.CompanyID,
+				Roles:       invitation.Roles,
+				Permissions: invitation.Permissions,
+				Active:      true,
+				LastLogin:   &now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Model(&invitation).Updates(map[string]interface{}{"accepted_at": now, "accepted_user_id": user.ID}).Error
+	})
+	switch {
+	case errors.Is(err, errInviteInvalid):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errInviteLogin):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errAlreadyMember):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errUsernameRequired):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		fmt.Println("Error accepting invitation:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not accept invitation"})
+	}
+
+	// Sign in to the company that sent the invitation
+	token, err := GenerateJWT(user.ID.String(), invitation.CompanyID.String(), invitation.Roles)
+	if err != nil {
+		fmt.Println("Error generating token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	setAuthCookie(c, token)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Invitation accepted",
+		"token":   token,
+		"user": fiber.Map{
+			"id":         user.ID,
+			"username":   user.Username,
+			"email":      user.Email,
+			"roles":      invitation.Roles,
+			"company_id": invitation.CompanyID,
+		},
+	})
+}
+
+// TransferCompanyOwnership hands the company to another active member. Only the current
+// owner (or a system admin) can transfer; companies without an owner can be claimed by a
+// member with the manage users permission.
+func TransferCompanyOwnership(c *fiber.Ctx) error {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	user := currentUser(c)
+	db := requestDB(c)
+
+	var company models.Company
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&company, "id = ?", user.CompanyID).Error; err != nil {
+			return err
+		}
+		isOwner := company.OwnerUserID != nil && *company.OwnerUserID == user.ID
+		if !isOwner && !user.HasPermission(models.SystemAdmin) &&
+			!(company.OwnerUserID == nil && user.HasPermission(models.ManageUsers)) {
+			return errNotCompanyAdmin
+		}
+
+		var next models.User
+		if err := tx.Where("id = ? AND company_id = ? AND active = ?", req.UserID, company.ID, true).First(&next).Error; err != nil {
+			return errNotMember
+		}
+		company.OwnerUserID = &next.ID
+		return tx.Model(&company).Update("owner_user_id", next.ID).Error
+	})
+	switch {
+	case errors.Is(err, errNotCompanyAdmin):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the company owner can transfer ownership"})
+	case errors.Is(err, errNotMember):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		fmt.Println("Error transferring ownership:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not transfer ownership"})
+	}
+
+	recordAudit(c, models.AuditOwnershipTransferred, "companies", company.ID.String())
+
+	return c.JSON(fiber.Map{"status": "success", "owner_user_id": company.OwnerUserID})
+}
+
+// managedCompany returns the user's company if they can manage its members: the owner,
+// anyone with the manage users permission, or a system admin
+func managedCompany(db *gorm.DB, user *models.User) (*models.Company, error) {
+	var company models.Company
+	if err := db.First(&company, "id = ?", user.CompanyID).Error; err != nil {
+		return nil, errCompanyNotFound
+	}
+	if company.OwnerUserID != nil && *company.OwnerUserID == user.ID {
+		return &company, nil
+	}
+	if user.HasPermission(models.ManageUsers) || user.HasPermission(models.SystemAdmin) {
+		return &company, nil
+	}
+	return nil, errNotCompanyAdmin
+}
+
+// isCompanyMember reports whether the user with email belongs to the company, either as
+// their home company or through an active membership
+func isCompanyMember(db *gorm.DB, email string, companyID uuid.UUID) bool {
+	memberships := db.Model(&models.CompanyMembership{}).Select("user_id").
+		Where("company_id = ? AND active = ?", companyID, true)
+
+	var count int64
+	db.Model(&models.User{}).
+		Where("LOWER(email) = ?", email).
+		Where("company_id = ? OR id IN (?)", companyID, memberships).
+		Count(&count)
+	return count > 0
+}
+
+// requestedCompanyID returns the company a request names, defaulting to the user's own.
+// Only system admins may name another company.
+func requestedCompanyID(c *fiber.Ctx, requested string) (uuid.UUID, error) {
+	user := currentUser(c)
+	if requested == "" {
+		return user.CompanyID, nil
+	}
+	id, err := uuid.Parse(requested)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if id != user.CompanyID && !user.HasPermission(models.SystemAdmin) {
+		return uuid.Nil, errors.New("company not accessible")
+	}
+	return id, nil
+}
+
+// hasRole reports whether the user holds role
+func hasRole(user *models.User, role models.Role) bool {
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// newInvitationToken returns a random URL safe token
+func newInvitationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashInvitationToken is how tokens are stored and looked up
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// invitationLink is the accept page for a token, on APP_URL when set
+func invitationLink(token string) string {
+	return strings.TrimRight(os.Getenv("APP_URL"), "/") + "/invite/accept?token=" + token
+}
+
+// setAuthCookie sets the auth_token cookie the same way sign in does
+func setAuthCookie(c *fiber.Ctx, token string) {
+	setAuthCookieUntil(c, token, time.Now().Add(sessionLifetime()))
+}
+
+// setAuthCookieUntil sets the auth_token cookie to expire at expires. A past time clears it.
+func setAuthCookieUntil(c *fiber.Ctx, token string, expires time.Time) {
+	setTokenCookie(c, "auth_token", token, expires)
+}
+
+// setTokenCookie sets an HTTP-only cookie holding a session token
+func setTokenCookie(c *fiber.Ctx, name, token string, expires time.Time) {
+	secure := true
+	sameSite := "Strict"
+	if os.Getenv("ENVIRONMENT") == "development" {
+		secure = false
+		sameSite = "None"
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    token,
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+	})
+}
+
+// recordAudit writes an audit log entry for an action taken by the current user. Actions
+// taken while impersonating are recorded against the system admin doing it.
+func recordAudit(c *fiber.Ctx, action, resource, resourceID string) {
+	user := currentUser(c)
+	actor, detail := user, resourceID
+	if impersonator := middleware.Impersonator(c); impersonator != nil {
+		actor = impersonator
+		detail += " while impersonating " + user.ID.String()
+	}
+	entry := models.AuditLog{
+		ActorID:   &actor.ID,
+		CompanyID: &actor.CompanyID,
+		Action:    action,
+		Resource:  resource,
+		Method:    c.Method(),
+		Path:      c.Path(),
+		IP:        c.IP(),
+		Detail:    detail,
+	}
+	if err := config.GetDB().Create(&entry).Error; err != nil {
+		fmt.Println("Error writing audit log:", err)
+	}
+}
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// deleteImpact is what deleting a record would do. It is returned as a preview before a
+// delete is confirmed, and again when a delete is refused.
+type deleteImpact struct {
+	Resource   string            `json:"resource"`
+	ID         uuid.UUID         `json:"id"`
+	Name       string            `json:"name"`
+	Dependents []deleteDependent `json:"dependents"`
+	Blockers   []string          `json:"blockers"`
+	Allowed    bool              `json:"allowed"`
+}
+
+// deleteDependent is a group of records the delete changes along with the record itself
+type deleteDependent struct {
+	Kind            string `json:"kind"`
+	Count           int64  `json:"count"`
+	Action          string `json:"action"`           // What the delete does to them, e.g. "deleted" or "moved"
+	RequiresCascade bool   `json:"requires_cascade"` // Only done when the delete asks to cascade
+
+	apply func(tx *gorm.DB) error // nil when deleting the record takes care of them
+}
+
+// deleteOptions is how a delete should treat the record's dependents
+type deleteOptions struct {
+	Confirm    bool   `json:"confirm"`
+	Cascade    bool   `json:"cascade"`     // Delete or unlink dependents along with the record
+	ReassignTo string `json:"reassign_to"` // Company to move a company's users to, or user to hand a company to
+}
+
+// deletePlanner works out the impact of deleting the record with id. The finished impact's
+// dependents are applied, then the record itself is deleted by remove.
+type deletePlanner func(c *fiber.Ctx, db *gorm.DB, id string, opts deleteOptions) (impact *deleteImpact, remove func(tx *gorm.DB) error, err error)
+
+// add records a group of dependents, skipping empty ones
+func (d *deleteImpact) add(kind string, count int64, action string, cascade bool, apply func(tx *gorm.DB) error) {
+	if count == 0 {
+		return
+	}
+	d.Dependents = append(d.Dependents, deleteDependent{Kind: kind, Count: count, Action: action, RequiresCascade: cascade, apply: apply})
+}
+
+// block records why the delete can't go ahead
+func (d *deleteImpact) block(format string, args ...interface{}) {
+	d.Blockers = append(d.Blockers, fmt.Sprintf(format, args...))
+}
+
+// finish decides whether the delete may go ahead with opts
+func (d *deleteImpact) finish(opts deleteOptions) {
+	if !opts.Cascade {
+		var kinds []string
+		for _, dep := range d.Dependents {
+			if dep.RequiresCascade {
+				kinds = append(kinds, fmt.Sprintf("%d %s", dep.Count, dep.Kind))
+			}
+		}
+		if len(kinds) > 0 {
+			d.block("Deleting also changes %s; delete with cascade to go ahead", strings.Join(kinds, ", "))
+		}
+	}
+	if d.Blockers == nil {
+		d.Blockers = []string{}
+	}
+	if d.Dependents == nil {
+		d.Dependents = []deleteDependent{}
+	}
+	d.Allowed = len(d.Blockers) == 0
+}
+
+// countOf counts the rows query matches, treating a failed count as an error for the caller
+func countOf(query *gorm.DB, err *error) int64 {
+	var count int64
+	if e := query.Count(&count).Error; e != nil && *err == nil {
+		*err = e
+	}
+	return count
+}
+
+// Loads and invoices a company still owes something on. Loads not yet booked are cancelled
+// with the company instead.
+var (
+	unbookedLoadStatuses = []models.LoadStatus{models.LoadStatusDraft, models.LoadStatusPosted}
+	activeLoadStatuses   = []models.LoadStatus{models.LoadStatusBooked, models.LoadStatusInTransit, models.LoadStatusDelivered}
+	unpaidInvoiceStatus  = []models.InvoiceStatus{models.InvoiceStatusSent, models.InvoiceStatusApproved, models.InvoiceStatusFailed}
+	heldEscrowStatuses   = []models.EscrowStatus{models.EscrowStatusFunded, models.EscrowStatusHeld}
+)
+
+// planCompanyDeletion refuses to delete a company with loads in progress, unpaid invoices or
+// money in escrow. Its users are deleted with cascade or moved with reassign_to; everything
+// else it owns needs cascade.
+func planCompanyDeletion(c *fiber.Ctx, db *gorm.DB, id string, opts deleteOptions) (*deleteImpact, func(tx *gorm.DB) error, error) {
+	var company models.Company
+	if err := db.First(&company, "id = ?", id).Error; err != nil {
+		return nil, nil, err
+	}
+	impact := &deleteImpact{Resource: "company", ID: company.ID, Name: company.Name}
+
+	var err error
+	loads := db.Model(&models.Load{}).Where("shipper_company_id = ? OR carrier_company_id = ?", company.ID, company.ID)
+	if n := countOf(loads.Session(&gorm.Session{}).Where("status IN ?", activeLoadStatuses), &err); n > 0 {
+		impact.block("%d loads are booked, in transit or awaiting completion", n)
+	}
+	if n := countOf(db.Model(&models.Invoice{}).Where("company_id = ? AND status IN ?", company.ID, unpaidInvoiceStatus), &err); n > 0 {
+		impact.block("%d invoices are unpaid", n)
+	}
+	escrows := db.Model(&models.Escrow{}).Where("(shipper_company_id = ? OR carrier_company_id = ?) AND status IN ?", company.ID, company.ID, heldEscrowStatuses)
+	if n := countOf(escrows, &err); n > 0 {
+		impact.block("%d escrows still hold funds", n)
+	}
+
+	// Users go with the company or move to another one
+	var users []models.User
+	if e := db.Where("company_id = ?", company.ID).Find(&users).Error; e != nil {
+		return nil, nil, e
+	}
+	userIDs := make([]uuid.UUID, len(users))
+	for i, u := range users {
+		userIDs[i] = u.ID
+	}
+	if opts.ReassignTo != "" {
+		if e := planUserReassignment(db, impact, &company, users, opts.ReassignTo); e != nil {
+			return nil, nil, e
+		}
+	} else if len(users) > 0 {
+		for _, u := range users {
+			if u.ID == currentUser(c).ID {
+				impact.block("You cannot delete the users of your own company; reassign them to another company")
+				break
+			}
+		}
+		remaining, e := remainingSystemAdmins(db, userIDs)
+		if e != nil {
+			return nil, nil, e
+		}
+		if remaining == 0 {
+			impact.block("The company's users include the last active system admin")
+		}
+		impact.add("users", int64(len(users)), "deleted", true, func(tx *gorm.DB) error {
+			return deleteUsersCascade(tx, userIDs)
+		})
+	}
+
+	memberships := db.Model(&models.CompanyMembership{}).Where("company_id = ?", company.ID)
+	impact.add("memberships", countOf(memberships, &err), "deleted", true, func(tx *gorm.DB) error {
+		return tx.Where("company_id = ?", company.ID).Delete(&models.CompanyMembership{}).Error
+	})
+
+	unbooked := loads.Session(&gorm.Session{}).Where("status IN ?", unbookedLoadStatuses)
+	impact.add("unbooked loads", countOf(unbooked, &err), "cancelled", true, func(tx *gorm.DB) error {
+		return tx.Model(&models.Load{}).
+			Where("(shipper_company_id = ? OR carrier_company_id = ?) AND status IN ?", company.ID, company.ID, unbookedLoadStatuses).
+			Update("status", models.LoadStatusCancelled).Error
+	})
+
+	now := time.Now()
+	pending := db.Model(&models.Invitation{}).Where("company_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", company.ID, now)
+	impact.add("pending invitations", countOf(pending, &err), "revoked", true, func(tx *gorm.DB) error {
+		return tx.Model(&models.Invitation{}).
+			Where("company_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", company.ID, now).
+			Update("revoked_at", now).Error
+	})
+
+	// Records only the company uses are soft deleted with it, so restoring them from the
+	// recycle bin brings them back
+	for _, owned := range []struct {
+		kind  string
+		model interface{}
+	}{
+		{"vehicles", &models.Vehicle{}},
+		{"trailers", &models.Trailer{}},
+		{"drivers", &models.Driver{}},
+		{"capacity posts", &models.CapacityPost{}},
+		{"bank accounts", &models.BankAccount{}},
+		{"company roles", &models.RoleDefinition{}},
+	} {
+		model := owned.model
+		impact.add(owned.kind, countOf(db.Model(model).Where("company_id = ?", company.ID), &err), "deleted", true, func(tx *gorm.DB) error {
+			return tx.Where("company_id = ?", company.ID).Delete(model).Error
+		})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	impact.finish(opts)
+	return impact, func(tx *gorm.DB) error {
+		if err := tx.Delete(&company).Error; err != nil {
+			return err
+		}
+		authz.Invalidate()
+		return nil
+	}, nil
+}
+
+// planUserReassignment moves a deleted company's users to the company with targetID, keeping
+// only the roles that company has
+func planUserReassignment(db *gorm.DB, impact *deleteImpact, company *models.Company, users []models.User, targetID string) error {
+	var target models.Company
+	if err := db.First(&target, "id = ?", targetID).Error; err != nil {
+		impact.block("The company to reassign users to was not found")
+		return nil
+	}
+	if target.ID == company.ID {
+		impact.block("Users can't be reassigned to the company being deleted")
+		return nil
+	}
+	if !target.Active {
+		impact.block("%s is not active", target.Name)
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	userIDs := make([]uuid.UUID, len(users))
+	for i, u := range users {
+		userIDs[i] = u.ID
+	}
+	var clashes int64
+	if err := db.Model(&models.CompanyMembership{}).Where("company_id = ? AND user_id IN ?", target.ID, userIDs).Count(&clashes).Error; err != nil {
+		return err
+	}
+	if clashes > 0 {
+		impact.block("%d users are already members of %s", clashes, target.Name)
+	}
+
+	roles, err := authz.Roles(db, target.ID)
+	if err != nil {
+		return err
+	}
+	impact.add("users", int64(len(users)), "moved to "+target.Name, false, func(tx *gorm.DB) error {
+		for _, u := range users {
+			kept := models.RoleArray{}
+			for _, role := range u.Roles {
+				if _, ok := roles[role]; ok {
+					kept = append(kept, role)
+				}
+			}
+			err := tx.Model(&models.User{}).Where("id = ?", u.ID).
+				Updates(map[string]interface{}{"company_id": target.ID, "roles": kept}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// planUserDeletion plans deleting a user, found only if they hold role when one is given.
+// Owners need reassign_to naming the member who takes over their company.
+func planUserDeletion(role models.Role) deletePlanner {
+	return func(c *fiber.Ctx, db *gorm.DB, id string, opts deleteOptions) (*deleteImpact, func(tx *gorm.DB) error, error) {
+		var user models.User
+		if err := db.Preload("Company").First(&user, "id = ?", id).Error; err != nil {
+			return nil, nil, err
+		}
+		if role != "" && !hasRole(&user, role) {
+			return nil, nil, gorm.ErrRecordNotFound
+		}
+		impact := &deleteImpact{Resource: "user", ID: user.ID, Name: user.Username}
+
+		if user.ID == currentUser(c).ID {
+			impact.block("You cannot delete your own account")
+		}
+		remaining, err := remainingSystemAdmins(db, []uuid.UUID{user.ID})
+		if err != nil {
+			return nil, nil, err
+		}
+		if remaining == 0 {
+			impact.block("%s is the last active system admin", user.Username)
+		}
+
+		if user.Company != nil && user.Company.OwnerUserID != nil && *user.Company.OwnerUserID == user.ID {
+			company := user.Company
+			var next models.User
+			switch {
+			case opts.ReassignTo == "":
+				impact.block("%s owns %s; give reassign_to the member who takes it over", user.Username, company.Name)
+			case db.Where("id = ? AND id <> ? AND company_id = ? AND active = ?", opts.ReassignTo, user.ID, company.ID, true).First(&next).Error != nil:
+				impact.block("The new owner must be another active member of %s", company.Name)
+			default:
+				impact.add("owned companies", 1, "transferred to "+next.Username, false, func(tx *gorm.DB) error {
+					return tx.Model(company).Update("owner_user_id", next.ID).Error
+				})
+			}
+		}
+
+		var countErr error
+		impact.add("memberships", countOf(db.Model(&models.CompanyMembership{}).Where("user_id = ?", user.ID), &countErr), "deleted", true, nil)
+		impact.add("driver profiles", countOf(db.Model(&models.Driver{}).Where("user_id = ?", user.ID), &countErr), "unlinked", true, nil)
+		if countErr != nil {
+			return nil, nil, countErr
+		}
+
+		impact.finish(opts)
+		return impact, func(tx *gorm.DB) error {
+			return deleteUsersCascade(tx, []uuid.UUID{user.ID})
+		}, nil
+	}
+}
+
+// deleteUsersCascade deletes users along with their memberships, and unlinks their driver
+// profiles so the drivers stay with their company
+func deleteUsersCascade(tx *gorm.DB, ids []uuid.UUID) error {
+	if err := tx.Where("user_id IN ?", ids).Delete(&models.CompanyMembership{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Driver{}).Where("user_id IN ?", ids).Update("user_id", nil).Error; err != nil {
+		return err
+	}
+	return tx.Where("id IN ?", ids).Delete(&models.User{}).Error
+}
+
+// remainingSystemAdmins counts the active users, apart from those excluded, who hold the
+// system admin permission in their home company
+func remainingSystemAdmins(db *gorm.DB, exclude []uuid.UUID) (int, error) {
+	var roleNames models.RoleArray
+	err := db.Model(&models.RoleDefinition{}).Where("? = ANY(permissions)", models.SystemAdmin).
+		Distinct().Pluck("name", &roleNames).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var candidates []models.User
+	err = db.Where("active = ? AND id NOT IN ?", true, exclude).
+		Where("? = ANY(permissions) OR roles && ?", models.SystemAdmin, roleNames).
+		Find(&candidates).Error
+	if err != nil {
+		return 0, err
+	}
+
+	// Company roles and denies can take the permission away again
+	count := 0
+	for i := range candidates {
+		if err := authz.Resolve(db, &candidates[i]); err != nil {
+			return 0, err
+		}
+		if candidates[i].HasPermission(models.SystemAdmin) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// deleteRefused carries the impact of a delete that can't go ahead
+type deleteRefused struct {
+	impact *deleteImpact
+}
+
+func (e *deleteRefused) Error() string { return "delete refused" }
+
+// previewDelete returns the impact of deleting a record. ?cascade= and ?reassign_to= are
+// taken as the delete would take them.
+func previewDelete(c *fiber.Ctx, label string, plan deletePlanner) error {
+	opts := deleteOptions{Cascade: c.QueryBool("cascade"), ReassignTo: c.Query("reassign_to")}
+
+	impact, _, err := plan(c, requestDB(c), c.Params("id"), opts)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": label + " not found"})
+	}
+	if err != nil {
+		fmt.Println("Error previewing delete:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not work out what the delete affects"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "impact": impact})
+}
+
+// confirmDelete deletes a record once the request confirms it, e.g.
+// {"confirm": true, "cascade": true, "reassign_to": "..."}. Unconfirmed deletes get the
+// impact back to review, and deletes the impact blocks are refused with it.
+func confirmDelete(c *fiber.Ctx, label string, plan deletePlanner) error {
+	var opts deleteOptions
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	var impact *deleteImpact
+	err := requestDB(c).Transaction(func(tx *gorm.DB) error {
+		// Planned inside the transaction so the checks see the rows the delete changes
+		var remove func(tx *gorm.DB) error
+		var err error
+		impact, remove, err = plan(c, tx, c.Params("id"), opts)
+		if err != nil {
+			return err
+		}
+		if !opts.Confirm || !impact.Allowed {
+			return &deleteRefused{impact: impact}
+		}
+
+		for _, dep := range impact.Dependents {
+			if dep.apply == nil {
+				continue
+			}
+			if err := dep.apply(tx); err != nil {
+				return err
+			}
+		}
+		return remove(tx)
+	})
+
+	var refused *deleteRefused
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": label + " not found"})
+	case errors.As(err, &refused) && !refused.impact.Allowed:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": strings.Join(refused.impact.Blockers, "; "), "impact": refused.impact})
+	case errors.As(err, &refused):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Review the impact and send confirm to delete", "impact": refused.impact})
+	case err != nil:
+		fmt.Println("Error deleting "+strings.ToLower(label)+":", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete " + strings.ToLower(label)})
+	}
+
+	detail := impact.ID.String()
+	for _, dep := range impact.Dependents {
+		detail += fmt.Sprintf(", %d %s %s", dep.Count, dep.Kind, dep.Action)
+	}
+	recordAudit(c, models.AuditRecordDeleted, impact.Resource, detail)
+
+	return c.JSON(fiber.Map{"status": "success", "message": label + " deleted", "impact": impact})
+}
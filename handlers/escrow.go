@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"cargozig_api/ledger"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SetupEscrowRoutes sets up the escrow lifecycle routes
+// /api/escrow
+func SetupEscrowRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+	router.Use(middleware.RequirePermission(models.ManagePayments))
+
+	router.Post("/", middleware.DenyWhileImpersonating(), FundEscrow)
+	router.Get("/:id", GetEscrow)
+	router.Post("/:id/hold", middleware.DenyWhileImpersonating(), HoldEscrow)
+	router.Post("/:id/release", middleware.DenyWhileImpersonating(), ReleaseEscrow)
+	router.Post("/:id/refund", middleware.DenyWhileImpersonating(), RefundEscrow)
+}
+
+// FundEscrow records the shipper's funds for a booked load
+func FundEscrow(c *fiber.Ctx) error {
+	var req struct {
+		LoadID      string `json:"load_id"`
+		AmountCents int64  `json:"amount_cents"`
+		FeeCents    int64  `json:"fee_cents"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	load, err := findVisibleLoad(c, req.LoadID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Load not found"})
+	}
+	if load.Status != models.LoadStatusBooked {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Only booked loads can be funded"})
+	}
+
+	user := currentUser(c)
+	escrow, err := ledger.Fund(requestDB(c), load, req.AmountCents, req.FeeCents, &user.ID)
+	if err != nil {
+		return escrowError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "escrow": escrow})
+}
+
+// GetEscrow returns a single escrow
+func GetEscrow(c *fiber.Ctx) error {
+	escrow, err := findVisibleEscrow(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Escrow not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "escrow": escrow})
+}
+
+// HoldEscrow locks funded escrow while the load is moving
+func HoldEscrow(c *fiber.Ctx) error {
+	return runEscrowTransition(c, ledger.Hold)
+}
+
+// ReleaseEscrow pays the carrier once proof of delivery is in
+func ReleaseEscrow(c *fiber.Ctx) error {
+	return runEscrowTransition(c, ledger.Release)
+}
+
+// RefundEscrow returns the funds to the shipper
+func RefundEscrow(c *fiber.Ctx) error {
+	return runEscrowTransition(c, ledger.Refund)
+}
+
+// runEscrowTransition applies a ledger escrow transition to the escrow in the route
+func runEscrowTransition(c *fiber.Ctx, fn func(*gorm.DB, uuid.UUID, *uuid.UUID) (*models.Escrow, error)) error {
+	escrow, err := findVisibleEscrow(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Escrow not found"})
+	}
+
+	user := currentUser(c)
+	escrow, err = fn(requestDB(c), escrow.ID, &user.ID)
+	if err != nil {
+		return escrowError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "escrow": escrow})
+}
+
+// findVisibleEscrow loads an escrow the current user's company is party to
+func findVisibleEscrow(c *fiber.Ctx, id string) (*models.Escrow, error) {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	query := db.Where("id = ?", id)
+	if !user.HasPermission(models.SystemAdmin) {
+		query = query.Where("shipper_company_id = ? OR carrier_company_id = ?", user.CompanyID, user.CompanyID)
+	}
+
+	var escrow models.Escrow
+	if err := query.First(&escrow).Error; err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+// escrowError maps ledger errors to HTTP responses
+func escrowError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ledger.ErrAlreadyFunded):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Load is already funded"})
+	case errors.Is(err, ledger.ErrInvalidTransition), errors.Is(err, ledger.ErrPODRequired),
+		errors.Is(err, ledger.ErrInvalidAmount), errors.Is(err, ledger.ErrUnbalanced):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	fmt.Println("Error updating escrow:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update escrow"})
+}
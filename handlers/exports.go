@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"bufio"
+	"cargozig_api/listing"
+	"cargozig_api/models"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// Export formats, chosen with ?format=
+var exportFormats = map[string]bool{"csv": true, "xlsx": true, "json": true}
+
+// exportFlushRows is how many rows are written between flushes, so downloads start right
+// away and a client that has gone stops the export
+const exportFlushRows = 200
+
+// exportRow is one row of an export. Rows are read straight off a cursor into a single
+// value that is reused, so an export never holds more than one row in memory.
+type exportRow interface {
+	scan(rows *sql.Rows) error
+	values() []interface{} // In the order of the export's columns
+}
+
+// exportSpec describes a superadmin list export. Only the columns named in Select are read,
+// so fields such as User.Password can never end up in a file.
+type exportSpec struct {
+	Name    string   // Used for the file name and the audit log
+	Columns []string // Header row
+	Select  string
+	List    listing.Spec
+	newRow  func() exportRow
+}
+
+// userExportRow is a user as exported
+type userExportRow struct {
+	ID          uuid.UUID        `json:"id"`
+	Username    string           `json:"username"`
+	Email       string           `json:"email"`
+	CompanyID   uuid.UUID        `json:"company_id"`
+	CompanyName string           `json:"company_name"`
+	Roles       models.RoleArray `json:"roles"`
+	Active      bool             `json:"active"`
+	LastLogin   *time.Time       `json:"last_login"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+func (r *userExportRow) scan(rows *sql.Rows) error {
+	*r = userExportRow{}
+	return rows.Scan(&r.ID, &r.Username, &r.Email, &r.CompanyID, &r.CompanyName, &r.Roles, &r.Active, &r.LastLogin, &r.CreatedAt)
+}
+
+func (r *userExportRow) values() []interface{} {
+	roles := make([]string, len(r.Roles))
+	for i, role := range r.Roles {
+		roles[i] = string(role)
+	}
+	var lastLogin interface{}
+	if r.LastLogin != nil {
+		lastLogin = *r.LastLogin
+	}
+	return []interface{}{r.ID.String(), r.Username, r.Email, r.CompanyID.String(), r.CompanyName, strings.Join(roles, ";"), r.Active, lastLogin, r.CreatedAt}
+}
+
+// companyExportRow is a company as exported
+type companyExportRow struct {
+	ID             uuid.UUID `json:"id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	Phone          string    `json:"phone"`
+	Address        string    `json:"address"`
+	City           string    `json:"city"`
+	State          string    `json:"state"`
+	ZipCode        string    `json:"zip_code"`
+	Country        string    `json:"country"`
+	CompanyType    string    `json:"company_type"`
+	DOTNumber      string    `json:"dot_number"`
+	MCNumber       string    `json:"mc_number"`
+	Active         bool      `json:"active"`
+	Verified       bool      `json:"verified"`
+	ComplianceHold bool      `json:"compliance_hold"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (r *companyExportRow) scan(rows *sql.Rows) error {
+	*r = companyExportRow{}
+	return rows.Scan(&r.ID, &r.Name, &r.Email, &r.Phone, &r.Address, &r.City, &r.State, &r.ZipCode, &r.Country,
+		&r.CompanyType, &r.DOTNumber, &r.MCNumber, &r.Active, &r.Verified, &r.ComplianceHold, &r.CreatedAt)
+}
+
+func (r *companyExportRow) values() []interface{} {
+	return []interface{}{r.ID.String(), r.Name, r.Email, r.Phone, r.Address, r.City, r.State, r.ZipCode, r.Country,
+		r.CompanyType, r.DOTNumber, r.MCNumber, r.Active, r.Verified, r.ComplianceHold, r.CreatedAt}
+}
+
+var userExportSpec = exportSpec{
+	Columns: []string{"id", "username", "email", "company_id", "company", "roles", "active", "last_login", "created_at"},
+	Select: "id, username, email, company_id, " +
+		"COALESCE((SELECT name FROM companies WHERE companies.id = users.company_id), '') AS company_name, " +
+		"roles, active, last_login, created_at",
+	List:   userListSpec,
+	newRow: func() exportRow { return &userExportRow{} },
+}
+
+var companyExportSpec = exportSpec{
+	Name: "companies",
+	Columns: []string{"id", "name", "email", "phone", "address", "city", "state", "zip_code", "country",
+		"company_type", "dot_number", "mc_number", "active", "verified", "compliance_hold", "created_at"},
+	Select: "id, name, email, COALESCE(phone, ''), COALESCE(address, ''), COALESCE(city, ''), COALESCE(state, ''), " +
+		"COALESCE(zip_code, ''), COALESCE(country, ''), COALESCE(company_type, ''), COALESCE(dot_number, ''), " +
+		"COALESCE(mc_number, ''), active, verified, compliance_hold, created_at",
+	List:   companyListSpec,
+	newRow: func() exportRow { return &companyExportRow{} },
+}
+
+// Export handlers. Each takes the same search, filter and sort parameters as its list page
+// plus ?format=csv|xlsx|json, and streams every matching row rather than one page.
+
+// SuperAdminExportShippers exports the shippers list
+func SuperAdminExportShippers(c *fiber.Ctx) error {
+	return exportUsers(c, "shippers", models.RoleShipper)
+}
+
+// SuperAdminExportCarriers exports the carriers list
+func SuperAdminExportCarriers(c *fiber.Ctx) error {
+	return exportUsers(c, "carriers", models.RoleCarrier)
+}
+
+// SuperAdminExportBrokers exports the brokers list
+func SuperAdminExportBrokers(c *fiber.Ctx) error {
+	return exportUsers(c, "brokers", models.RoleAdmin)
+}
+
+// SuperAdminExportUsers exports the users list, optionally only those with ?role=
+func SuperAdminExportUsers(c *fiber.Ctx) error {
+	return exportUsers(c, "users", models.Role(c.Query("role")))
+}
+
+// SuperAdminExportCompanies exports the companies list, optionally only those of ?type=
+//
+//	GET /superadmin/api/companies/export?format=xlsx&q=&active=&verified=&type=&sort=
+func SuperAdminExportCompanies(c *fiber.Ctx) error {
+	companyType := strings.ToLower(c.Query("type"))
+	if companyType != "" && !companyTypes[companyType] {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "type must be shipper, carrier or both"})
+	}
+	return streamExport(c, companyExportSpec, companyListQuery(c, companyType))
+}
+
+// exportUsers exports a user list, limited to those holding role when given
+func exportUsers(c *fiber.Ctx, name string, role models.Role) error {
+	spec := userExportSpec
+	spec.Name = name
+	return streamExport(c, spec, userListQuery(c, role))
+}
+
+// streamExport sends every row of query matching the request's filters as a download.
+// The query runs before responding, so a bad filter or database error still gets an error
+// status; the rows are then written from the cursor as the client reads them.
+func streamExport(c *fiber.Ctx, spec exportSpec, query *gorm.DB) error {
+	params, err := listing.Parse(c, spec.List)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	format := strings.ToLower(c.Query("format", "csv"))
+	if !exportFormats[format] {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be csv, xlsx or json"})
+	}
+
+	rows, err := params.Order(params.Filter(query, spec.List)).Select(spec.Select).Rows()
+	if err != nil {
+		fmt.Println("Error exporting "+spec.Name+":", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not export " + spec.Name})
+	}
+
+	recordAudit(c, models.AuditRecordsExported, spec.Name, format+" "+c.Context().QueryArgs().String())
+
+	c.Attachment(fmt.Sprintf("%s-%s.%s", spec.Name, time.Now().Format("2006-01-02"), format))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer rows.Close()
+
+		var err error
+		switch format {
+		case "csv":
+			err = writeCSVExport(w, spec, rows)
+		case "xlsx":
+			err = writeXLSXExport(w, spec, rows)
+		case "json":
+			err = writeJSONExport(w, spec, rows)
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			fmt.Println("Error streaming "+spec.Name+" export:", err)
+		}
+	})
+	return nil
+}
+
+// eachExportRow calls fn with each row read from rows, calling flush every exportFlushRows rows
+func eachExportRow(spec exportSpec, rows *sql.Rows, fn func(exportRow) error, flush func() error) error {
+	row := spec.newRow()
+	for n := 1; rows.Next(); n++ {
+		if err := row.scan(rows); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+		if n%exportFlushRows == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// exportCell formats a value for a CSV cell. Text that a spreadsheet would read as a
+// formula gets a leading quote, so a load note like =HYPERLINK(...) stays text when the
+// file is opened.
+func exportCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case string:
+		return neutralizeFormula(v)
+	case []byte:
+		return neutralizeFormula(string(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// neutralizeFormula prefixes s with a quote when it starts like a formula
+func neutralizeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func writeCSVExport(w *bufio.Writer, spec exportSpec, rows *sql.Rows) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(spec.Columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(spec.Columns))
+	err := eachExportRow(spec, rows, func(row exportRow) error {
+		for i, value := range row.values() {
+			record[i] = exportCell(value)
+		}
+		return out.Write(record)
+	}, func() error {
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeXLSXExport writes a single sheet workbook. The stream writer spills rows to a
+// temporary file as it goes rather than keeping the sheet in memory.
+func writeXLSXExport(w *bufio.Writer, spec exportSpec, rows *sql.Rows) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := f.GetSheetName(0)
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(spec.Columns))
+	for i, column := range spec.Columns {
+		header[i] = column
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	line := 1
+	err = eachExportRow(spec, rows, func(row exportRow) error {
+		line++
+		cell, err := excelize.CoordinatesToCellName(1, line)
+		if err != nil {
+			return err
+		}
+		return sw.SetRow(cell, row.values())
+	}, func() error { return nil })
+	if err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return f.Write(w)
+}
+
+// writeJSONExport writes a JSON array of rows
+func writeJSONExport(w *bufio.Writer, spec exportSpec, rows *sql.Rows) error {
+	if _, err := w.WriteString("["); err != nil {
+		return err
+	}
+
+	first := true
+	err := eachExportRow(spec, rows, func(row exportRow) error {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if !first {
+			w.WriteString(",")
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	}, w.Flush)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.WriteString("]\n")
+	return err
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// openPayableStatuses are the carrier payable statuses that have not been paid out yet
+var openPayableStatuses = []models.InvoiceStatus{
+	models.InvoiceStatusDraft, models.InvoiceStatusApproved, models.InvoiceStatusFailed,
+}
+
+// SetupFactoringRoutes sets up the notice of assignment routes
+// /api/factoring
+func SetupFactoringRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/assignments", middleware.RequirePermission(models.ViewFinancials), ListAssignments)
+	router.Post("/assignments", middleware.RequirePermission(models.SystemAdmin), CreateAssignment)
+	router.Post("/assignments/:id/release", middleware.RequirePermission(models.SystemAdmin), ReleaseAssignment)
+}
+
+// ListAssignments lists the notices of assignment the current company is party to
+func ListAssignments(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	query := requestDB(c).Preload("CarrierCompany").Preload("FactorCompany")
+	if !user.HasPermission(models.SystemAdmin) {
+		query = query.Where("carrier_company_id = ? OR factor_company_id = ?", user.CompanyID, user.CompanyID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var assignments []models.NoticeOfAssignment
+	if err := query.Order("created_at DESC").Find(&assignments).Error; err != nil {
+		fmt.Println("Error listing assignments:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list assignments"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "assignments": assignments})
+}
+
+// CreateAssignment records a factor's notice of assignment and redirects the
+// carrier's unpaid payables to the factor. Quick-pay is dropped on redirected
+// payables since the factor advances the carrier itself.
+func CreateAssignment(c *fiber.Ctx) error {
+	var req struct {
+		CarrierCompanyID string `json:"carrier_company_id"`
+		FactorCompanyID  string `json:"factor_company_id"`
+		DocumentURL      string `json:"document_url"`
+		EffectiveAt      string `json:"effective_at"` // RFC 3339, defaults to now
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	carrierID, err := uuid.Parse(req.CarrierCompanyID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid carrier company ID"})
+	}
+	factorID, err := uuid.Parse(req.FactorCompanyID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid factor company ID"})
+	}
+	if carrierID == factorID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A carrier cannot assign payables to itself"})
+	}
+
+	effectiveAt := time.Now()
+	if req.EffectiveAt != "" {
+		if effectiveAt, err = time.Parse(time.RFC3339, req.EffectiveAt); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "effective_at must be RFC 3339"})
+		}
+	}
+
+	db := requestDB(c)
+	var count int64
+	db.Model(&models.Company{}).Where("id IN ?", []uuid.UUID{carrierID, factorID}).Count(&count)
+	if count != 2 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	user := currentUser(c)
+	assignment := models.NoticeOfAssignment{
+		CarrierCompanyID: carrierID,
+		FactorCompanyID:  factorID,
+		Status:           models.AssignmentStatusActive,
+		DocumentURL:      req.DocumentURL,
+		EffectiveAt:      effectiveAt,
+		CreatedByID:      user.ID,
+	}
+
+	var redirected int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Lock the carrier so two notices cannot become active at once
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", carrierID).First(&models.Company{}).Error; err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.Model(&models.NoticeOfAssignment{}).
+			Where("carrier_company_id = ? AND status = ?", carrierID, models.AssignmentStatusActive).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return errAssignmentActive
+		}
+
+		if err := tx.Create(&assignment).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.Invoice{}).
+			Where("company_id = ? AND kind = ? AND status IN ?", carrierID, models.InvoiceKindCarrierPayable, openPayableStatuses).
+			Updates(map[string]interface{}{
+				"payee_company_id":     factorID,
+				"assignment_id":        assignment.ID,
+				"quick_pay":            false,
+				"quick_pay_fee_cents":  0,
+				"quick_pay_elected_at": nil,
+			})
+		redirected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		if errors.Is(err, errAssignmentActive) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Carrier already has an active notice of assignment"})
+		}
+		fmt.Println("Error creating assignment:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create assignment"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":              "success",
+		"assignment":          assignment,
+		"redirected_payables": redirected,
+	})
+}
+
+// ReleaseAssignment ends a notice of assignment so unpaid payables go back to the carrier
+func ReleaseAssignment(c *fiber.Ctx) error {
+	db := requestDB(c)
+	var assignment models.NoticeOfAssignment
+	var restored int64
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", c.Params("id")).First(&assignment).Error; err != nil {
+			return err
+		}
+		if assignment.Status != models.AssignmentStatusActive {
+			return errAssignmentReleased
+		}
+
+		now := time.Now()
+		assignment.Status = models.AssignmentStatusReleased
+		assignment.ReleasedAt = &now
+		if err := tx.Save(&assignment).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.Invoice{}).
+			Where("assignment_id = ? AND status IN ?", assignment.ID, openPayableStatuses).
+			Updates(map[string]interface{}{"payee_company_id": nil, "assignment_id": nil})
+		restored = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Assignment not found"})
+		}
+		if errors.Is(err, errAssignmentReleased) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Assignment is already released"})
+		}
+		fmt.Println("Error releasing assignment:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not release assignment"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "assignment": assignment, "restored_payables": restored})
+}
+
+var (
+	errAssignmentActive   = errors.New("carrier already has an active assignment")
+	errAssignmentReleased = errors.New("assignment is already released")
+)
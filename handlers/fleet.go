@@ -0,0 +1,746 @@
+package handlers
+
+import (
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxCalendarDays is the longest range the availability calendar covers in one request
+const maxCalendarDays = 92
+
+var (
+	vinPattern   = regexp.MustCompile(`^[A-HJ-NPR-Z0-9]{17}$`) // VINs never use I, O or Q
+	statePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// SetupFleetRoutes sets up the carrier fleet registry and availability calendar
+// /api/fleet
+func SetupFleetRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+	router.Use(middleware.RequireRole(models.RoleCarrier))
+
+	// Carriers can see their fleet while onboarding but only change it once approved
+	verified := middleware.RequireVerifiedCompany()
+
+	router.Get("/vehicles", ListVehicles)
+	router.Post("/vehicles", verified, CreateVehicle)
+	router.Get("/vehicles/:id", GetVehicle)
+	router.Put("/vehicles/:id", verified, UpdateVehicle)
+	router.Delete("/vehicles/:id", verified, DeleteVehicle)
+
+	router.Get("/trailers", ListTrailers)
+	router.Post("/trailers", verified, CreateTrailer)
+	router.Get("/trailers/:id", GetTrailer)
+	router.Put("/trailers/:id", verified, UpdateTrailer)
+	router.Delete("/trailers/:id", verified, DeleteTrailer)
+
+	router.Get("/drivers", ListDrivers)
+	router.Post("/drivers", verified, CreateDriver)
+	router.Get("/drivers/:id", GetDriver)
+	router.Put("/drivers/:id", verified, UpdateDriver)
+	router.Delete("/drivers/:id", verified, DeleteDriver)
+
+	router.Get("/unavailability", ListFleetUnavailability)
+	router.Post("/unavailability", verified, CreateFleetUnavailability)
+	router.Delete("/unavailability/:id", verified, DeleteFleetUnavailability)
+	router.Get("/calendar", GetFleetCalendar)
+}
+
+// vehicleRequest is the body for creating or replacing a vehicle
+type vehicleRequest struct {
+	UnitNumber    string `json:"unit_number"`
+	VIN           string `json:"vin"`
+	Plate         string `json:"plate"`
+	PlateState    string `json:"plate_state"`
+	Make          string `json:"make"`
+	Model         string `json:"model"`
+	Year          int    `json:"year"`
+	EquipmentType string `json:"equipment_type"`
+	CapacityLbs   int    `json:"capacity_lbs"`
+	Active        *bool  `json:"active"`
+}
+
+// apply validates the request and copies it onto vehicle
+func (req *vehicleRequest) apply(vehicle *models.Vehicle) error {
+	vin := strings.ToUpper(strings.TrimSpace(req.VIN))
+	if !vinPattern.MatchString(vin) {
+		return errors.New("VIN must be 17 characters")
+	}
+	plateState := strings.ToUpper(strings.TrimSpace(req.PlateState))
+	if plateState != "" && !statePattern.MatchString(plateState) {
+		return errors.New("plate_state must be a two letter state code")
+	}
+	equipment := models.EquipmentType(strings.ToLower(req.EquipmentType))
+	if !models.ValidEquipmentType(equipment) {
+		return errors.New("Invalid equipment type")
+	}
+	if req.Year != 0 && (req.Year < 1980 || req.Year > time.Now().Year()+1) {
+		return errors.New("Invalid model year")
+	}
+	if req.CapacityLbs < 0 {
+		return errors.New("capacity_lbs cannot be negative")
+	}
+
+	vehicle.UnitNumber = strings.TrimSpace(req.UnitNumber)
+	vehicle.VIN = vin
+	vehicle.Plate = strings.ToUpper(strings.TrimSpace(req.Plate))
+	vehicle.PlateState = plateState
+	vehicle.Make = strings.TrimSpace(req.Make)
+	vehicle.Model = strings.TrimSpace(req.Model)
+	vehicle.Year = req.Year
+	vehicle.EquipmentType = equipment
+	vehicle.CapacityLbs = req.CapacityLbs
+	if req.Active != nil {
+		vehicle.Active = *req.Active
+	}
+	return nil
+}
+
+// ListVehicles lists the company's vehicles, ?active=true for in-service units only
+func ListVehicles(c *fiber.Ctx) error {
+	query := requestDB(c).Where("company_id = ?", currentUser(c).CompanyID)
+	if c.Query("active") == "true" {
+		query = query.Where("active = ?", true)
+	}
+
+	var vehicles []models.Vehicle
+	if err := query.Order("unit_number").Find(&vehicles).Error; err != nil {
+		fmt.Println("Error listing vehicles:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list vehicles"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "vehicles": vehicles})
+}
+
+// CreateVehicle adds a vehicle to the company's fleet
+func CreateVehicle(c *fiber.Ctx) error {
+	var req vehicleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	vehicle := models.Vehicle{CompanyID: currentUser(c).CompanyID, Active: true}
+	if err := req.apply(&vehicle); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	db := requestDB(c)
+	if fleetVINTaken(db, &models.Vehicle{}, vehicle.CompanyID, vehicle.VIN, uuid.Nil) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A vehicle with this VIN is already registered"})
+	}
+	if err := db.Create(&vehicle).Error; err != nil {
+		fmt.Println("Error creating vehicle:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create vehicle"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "vehicle": vehicle})
+}
+
+// GetVehicle returns one of the company's vehicles
+func GetVehicle(c *fiber.Ctx) error {
+	var vehicle models.Vehicle
+	if err := findFleetItem(c, &vehicle); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Vehicle not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "vehicle": vehicle})
+}
+
+// UpdateVehicle replaces a vehicle's details
+func UpdateVehicle(c *fiber.Ctx) error {
+	var vehicle models.Vehicle
+	if err := findFleetItem(c, &vehicle); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Vehicle not found"})
+	}
+
+	var req vehicleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := req.apply(&vehicle); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	db := requestDB(c)
+	if fleetVINTaken(db, &models.Vehicle{}, vehicle.CompanyID, vehicle.VIN, vehicle.ID) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A vehicle with this VIN is already registered"})
+	}
+	if err := db.Save(&vehicle).Error; err != nil {
+		fmt.Println("Error updating vehicle:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update vehicle"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "vehicle": vehicle})
+}
+
+// DeleteVehicle removes a vehicle from the fleet
+func DeleteVehicle(c *fiber.Ctx) error {
+	return deleteFleetItem(c, &models.Vehicle{}, "vehicle_id", "Vehicle")
+}
+
+// trailerRequest is the body for creating or replacing a trailer
+type trailerRequest struct {
+	UnitNumber      string `json:"unit_number"`
+	VIN             string `json:"vin"`
+	Plate           string `json:"plate"`
+	PlateState      string `json:"plate_state"`
+	EquipmentType   string `json:"equipment_type"`
+	LengthFeet      int    `json:"length_feet"`
+	CapacityLbs     int    `json:"capacity_lbs"`
+	CapacityPallets int    `json:"capacity_pallets"`
+	Active          *bool  `json:"active"`
+}
+
+// apply validates the request and copies it onto trailer
+func (req *trailerRequest) apply(trailer *models.Trailer) error {
+	vin := strings.ToUpper(strings.TrimSpace(req.VIN))
+	if !vinPattern.MatchString(vin) {
+		return errors.New("VIN must be 17 characters")
+	}
+	plateState := strings.ToUpper(strings.TrimSpace(req.PlateState))
+	if plateState != "" && !statePattern.MatchString(plateState) {
+		return errors.New("plate_state must be a two letter state code")
+	}
+	equipment := models.EquipmentType(strings.ToLower(req.EquipmentType))
+	if !models.ValidEquipmentType(equipment) || equipment == models.EquipmentPowerOnly {
+		return errors.New("Invalid equipment type")
+	}
+	if req.LengthFeet < 0 || req.CapacityLbs < 0 || req.CapacityPallets < 0 {
+		return errors.New("Length and capacity cannot be negative")
+	}
+
+	trailer.UnitNumber = strings.TrimSpace(req.UnitNumber)
+	trailer.VIN = vin
+	trailer.Plate = strings.ToUpper(strings.TrimSpace(req.Plate))
+	trailer.PlateState = plateState
+	trailer.EquipmentType = equipment
+	trailer.LengthFeet = req.LengthFeet
+	trailer.CapacityLbs = req.CapacityLbs
+	trailer.CapacityPallets = req.CapacityPallets
+	if req.Active != nil {
+		trailer.Active = *req.Active
+	}
+	return nil
+}
+
+// ListTrailers lists the company's trailers, ?active=true for in-service units only
+func ListTrailers(c *fiber.Ctx) error {
+	query := requestDB(c).Where("company_id = ?", currentUser(c).CompanyID)
+	if c.Query("active") == "true" {
+		query = query.Where("active = ?", true)
+	}
+	if equipment := c.Query("equipment_type"); equipment != "" {
+		query = query.Where("equipment_type = ?", equipment)
+	}
+
+	var trailers []models.Trailer
+	if err := query.Order("unit_number").Find(&trailers).Error; err != nil {
+		fmt.Println("Error listing trailers:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list trailers"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "trailers": trailers})
+}
+
+// CreateTrailer adds a trailer to the company's fleet
+func CreateTrailer(c *fiber.Ctx) error {
+	var req trailerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	trailer := models.Trailer{CompanyID: currentUser(c).CompanyID, Active: true}
+	if err := req.apply(&trailer); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	db := requestDB(c)
+	if fleetVINTaken(db, &models.Trailer{}, trailer.CompanyID, trailer.VIN, uuid.Nil) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A trailer with this VIN is already registered"})
+	}
+	if err := db.Create(&trailer).Error; err != nil {
+		fmt.Println("Error creating trailer:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create trailer"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "trailer": trailer})
+}
+
+// GetTrailer returns one of the company's trailers
+func GetTrailer(c *fiber.Ctx) error {
+	var trailer models.Trailer
+	if err := findFleetItem(c, &trailer); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Trailer not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "trailer": trailer})
+}
+
+// UpdateTrailer replaces a trailer's details
+func UpdateTrailer(c *fiber.Ctx) error {
+	var trailer models.Trailer
+	if err := findFleetItem(c, &trailer); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Trailer not found"})
+	}
+
+	var req trailerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := req.apply(&trailer); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	db := requestDB(c)
+	if fleetVINTaken(db, &models.Trailer{}, trailer.CompanyID, trailer.VIN, trailer.ID) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A trailer with this VIN is already registered"})
+	}
+	if err := db.Save(&trailer).Error; err != nil {
+		fmt.Println("Error updating trailer:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update trailer"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "trailer": trailer})
+}
+
+// DeleteTrailer removes a trailer from the fleet
+func DeleteTrailer(c *fiber.Ctx) error {
+	return deleteFleetItem(c, &models.Trailer{}, "trailer_id", "Trailer")
+}
+
+// driverRequest is the body for creating or replacing a driver
+type driverRequest struct {
+	UserID       string `json:"user_id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Phone        string `json:"phone"`
+	Email        string `json:"email"`
+	CDLNumber    string `json:"cdl_number"`
+	CDLState     string `json:"cdl_state"`
+	CDLClass     string `json:"cdl_class"`
+	CDLExpiresAt string `json:"cdl_expires_at"` // YYYY-MM-DD
+	Endorsements string `json:"endorsements"`
+	Active       *bool  `json:"active"`
+}
+
+// apply validates the request and copies it onto driver. A linked login must belong to the driver's company.
+func (req *driverRequest) apply(db *gorm.DB, driver *models.Driver) error {
+	if strings.TrimSpace(req.FirstName) == "" || strings.TrimSpace(req.LastName) == "" {
+		return errors.New("First and last name are required")
+	}
+	if strings.TrimSpace(req.CDLNumber) == "" {
+		return errors.New("cdl_number is required")
+	}
+	cdlState := strings.ToUpper(strings.TrimSpace(req.CDLState))
+	if !statePattern.MatchString(cdlState) {
+		return errors.New("cdl_state must be a two letter state code")
+	}
+	cdlClass := strings.ToUpper(strings.TrimSpace(req.CDLClass))
+	if cdlClass != "A" && cdlClass != "B" && cdlClass != "C" {
+		return errors.New("cdl_class must be A, B or C")
+	}
+	expiresAt, err := time.Parse("2006-01-02", req.CDLExpiresAt)
+	if err != nil {
+		return errors.New("cdl_expires_at must be YYYY-MM-DD")
+	}
+	endorsements, ok := models.NormalizeEndorsements(req.Endorsements)
+	if !ok {
+		return errors.New("Endorsements must be from H, N, P, S, T and X")
+	}
+
+	driver.UserID = nil
+	if req.UserID != "" {
+		var user models.User
+		if err := db.Where("id = ? AND company_id = ?", req.UserID, driver.CompanyID).First(&user).Error; err != nil {
+			return errors.New("User not found in this company")
+		}
+		driver.UserID = &user.ID
+	}
+
+	driver.FirstName = strings.TrimSpace(req.FirstName)
+	driver.LastName = strings.TrimSpace(req.LastName)
+	driver.Phone = strings.TrimSpace(req.Phone)
+	driver.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	driver.CDLNumber = strings.ToUpper(strings.TrimSpace(req.CDLNumber))
+	driver.CDLState = cdlState
+	driver.CDLClass = cdlClass
+	driver.CDLExpiresAt = &expiresAt
+	driver.Endorsements = endorsements
+	if req.Active != nil {
+		driver.Active = *req.Active
+	}
+	return nil
+}
+
+// ListDrivers lists the company's drivers. ?active=true limits to active drivers and
+// ?cdl_expiring_days=N to drivers whose CDL expires within N days.
+func ListDrivers(c *fiber.Ctx) error {
+	query := requestDB(c).Where("company_id = ?", currentUser(c).CompanyID)
+	if c.Query("active") == "true" {
+		query = query.Where("active = ?", true)
+	}
+	if days := c.QueryInt("cdl_expiring_days"); days > 0 {
+		query = query.Where("cdl_expires_at < ?", time.Now().AddDate(0, 0, days))
+	}
+
+	var drivers []models.Driver
+	if err := query.Order("last_name, first_name").Find(&drivers).Error; err != nil {
+		fmt.Println("Error listing drivers:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list drivers"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "drivers": drivers})
+}
+
+// CreateDriver adds a driver to the company
+func CreateDriver(c *fiber.Ctx) error {
+	var req driverRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	db := requestDB(c)
+	driver := models.Driver{CompanyID: currentUser(c).CompanyID, Active: true}
+	if err := req.apply(db, &driver); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := db.Create(&driver).Error; err != nil {
+		fmt.Println("Error creating driver:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create driver"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "driver": driver})
+}
+
+// GetDriver returns one of the company's drivers
+func GetDriver(c *fiber.Ctx) error {
+	var driver models.Driver
+	if err := findFleetItem(c, &driver); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Driver not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "driver": driver})
+}
+
+// UpdateDriver replaces a driver's details
+func UpdateDriver(c *fiber.Ctx) error {
+	var driver models.Driver
+	if err := findFleetItem(c, &driver); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Driver not found"})
+	}
+
+	var req driverRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	db := requestDB(c)
+	if err := req.apply(db, &driver); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Save(&driver).Error; err != nil {
+		fmt.Println("Error updating driver:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update driver"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "driver": driver})
+}
+
+// DeleteDriver removes a driver from the company
+func DeleteDriver(c *fiber.Ctx) error {
+	return deleteFleetItem(c, &models.Driver{}, "driver_id", "Driver")
+}
+
+// ListFleetUnavailability lists blocked out periods overlapping ?from= and ?to=
+func ListFleetUnavailability(c *fiber.Ctx) error {
+	from, to, err := calendarRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var blocks []models.FleetUnavailability
+	if err := requestDB(c).
+		Where("company_id = ? AND starts_at < ? AND ends_at > ?", currentUser(c).CompanyID, to, from).
+		Order("starts_at").Find(&blocks).Error; err != nil {
+		fmt.Println("Error listing fleet unavailability:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list unavailability"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "unavailability": blocks})
+}
+
+// CreateFleetUnavailability blocks out a vehicle, trailer or driver, e.g. for maintenance or time off
+func CreateFleetUnavailability(c *fiber.Ctx) error {
+	var req struct {
+		VehicleID string    `json:"vehicle_id"`
+		TrailerID string    `json:"trailer_id"`
+		DriverID  string    `json:"driver_id"`
+		LoadID    string    `json:"load_id"`
+		Reason    string    `json:"reason"`
+		StartsAt  time.Time `json:"starts_at"`
+		EndsAt    time.Time `json:"ends_at"`
+		Notes     string    `json:"notes"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	reason := models.AvailabilityReason(strings.ToLower(req.Reason))
+	if !models.ValidAvailabilityReason(reason) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid reason"})
+	}
+	if req.StartsAt.IsZero() || !req.EndsAt.After(req.StartsAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ends_at must be after starts_at"})
+	}
+
+	set := 0
+	for _, id := range []string{req.VehicleID, req.TrailerID, req.DriverID} {
+		if id != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Exactly one of vehicle_id, trailer_id and driver_id is required"})
+	}
+
+	user := currentUser(c)
+	db := requestDB(c)
+	block := models.FleetUnavailability{
+		CompanyID: user.CompanyID,
+		Reason:    reason,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		Notes:     strings.TrimSpace(req.Notes),
+	}
+
+	// The blocked item must belong to the caller's company
+	var err error
+	switch {
+	case req.VehicleID != "":
+		var vehicle models.Vehicle
+		err = db.Where("id = ? AND company_id = ?", req.VehicleID, user.CompanyID).First(&vehicle).Error
+		block.VehicleID = &vehicle.ID
+	case req.TrailerID != "":
+		var trailer models.Trailer
+		err = db.Where("id = ? AND company_id = ?", req.TrailerID, user.CompanyID).First(&trailer).Error
+		block.TrailerID = &trailer.ID
+	default:
+		var driver models.Driver
+		err = db.Where("id = ? AND company_id = ?", req.DriverID, user.CompanyID).First(&driver).Error
+		block.DriverID = &driver.ID
+	}
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Vehicle, trailer or driver not found"})
+	}
+
+	if req.LoadID != "" {
+		var load models.Load
+		if err := db.Where("id = ? AND carrier_company_id = ?", req.LoadID, user.CompanyID).First(&load).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Load not found"})
+		}
+		block.LoadID = &load.ID
+	}
+
+	if err := db.Create(&block).Error; err != nil {
+		fmt.Println("Error creating fleet unavailability:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save unavailability"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "unavailability": block})
+}
+
+// DeleteFleetUnavailability removes a blocked out period
+func DeleteFleetUnavailability(c *fiber.Ctx) error {
+	result := requestDB(c).Where("id = ? AND company_id = ?", c.Params("id"), currentUser(c).CompanyID).
+		Delete(&models.FleetUnavailability{})
+	if result.Error != nil {
+		fmt.Println("Error deleting fleet unavailability:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete unavailability"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unavailability not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Unavailability deleted"})
+}
+
+// capacityCount is how many units exist and how many are free on a day
+type capacityCount struct {
+	Total     int `json:"total"`
+	Available int `json:"available"`
+}
+
+// capacityDay is one day of the availability calendar
+type capacityDay struct {
+	Date     string                                  `json:"date"`
+	Drivers  capacityCount                           `json:"drivers"`
+	Vehicles map[models.EquipmentType]*capacityCount `json:"vehicles"`
+	Trailers map[models.EquipmentType]*capacityCount `json:"trailers"`
+}
+
+// GetFleetCalendar returns day by day capacity between ?from= and ?to= (YYYY-MM-DD, two weeks
+// from today by default). Active units count as available unless blocked out for any part of
+// the day; drivers also need a CDL valid on the day.
+func GetFleetCalendar(c *fiber.Ctx) error {
+	from, to, err := calendarRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	companyID := currentUser(c).CompanyID
+	db := requestDB(c)
+
+	var vehicles []models.Vehicle
+	var trailers []models.Trailer
+	var drivers []models.Driver
+	var blocks []models.FleetUnavailability
+	if err := db.Where("company_id = ? AND active = ?", companyID, true).Find(&vehicles).Error; err != nil {
+		fmt.Println("Error loading vehicles for calendar:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not build calendar"})
+	}
+	if err := db.Where("company_id = ? AND active = ?", companyID, true).Find(&trailers).Error; err != nil {
+		fmt.Println("Error loading trailers for calendar:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not build calendar"})
+	}
+	if err := db.Where("company_id = ? AND active = ?", companyID, true).Find(&drivers).Error; err != nil {
+		fmt.Println("Error loading drivers for calendar:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not build calendar"})
+	}
+	if err := db.Where("company_id = ? AND starts_at < ? AND ends_at > ?", companyID, to, from).Find(&blocks).Error; err != nil {
+		fmt.Println("Error loading unavailability for calendar:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not build calendar"})
+	}
+
+	var days []capacityDay
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+
+		// Everything blocked out for any part of the day
+		blocked := map[uuid.UUID]bool{}
+		for _, block := range blocks {
+			if !block.StartsAt.Before(next) || !block.EndsAt.After(day) {
+				continue
+			}
+			for _, id := range []*uuid.UUID{block.VehicleID, block.TrailerID, block.DriverID} {
+				if id != nil {
+					blocked[*id] = true
+				}
+			}
+		}
+
+		entry := capacityDay{
+			Date:     day.Format("2006-01-02"),
+			Vehicles: map[models.EquipmentType]*capacityCount{},
+			Trailers: map[models.EquipmentType]*capacityCount{},
+		}
+		for _, vehicle := range vehicles {
+			count := entry.Vehicles[vehicle.EquipmentType]
+			if count == nil {
+				count = &capacityCount{}
+				entry.Vehicles[vehicle.EquipmentType] = count
+			}
+			count.Total++
+			if !blocked[vehicle.ID] {
+				count.Available++
+			}
+		}
+		for _, trailer := range trailers {
+			count := entry.Trailers[trailer.EquipmentType]
+			if count == nil {
+				count = &capacityCount{}
+				entry.Trailers[trailer.EquipmentType] = count
+			}
+			count.Total++
+			if !blocked[trailer.ID] {
+				count.Available++
+			}
+		}
+		for i := range drivers {
+			entry.Drivers.Total++
+			if !blocked[drivers[i].ID] && drivers[i].CDLValidAt(next) {
+				entry.Drivers.Available++
+			}
+		}
+		days = append(days, entry)
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "from": from.Format("2006-01-02"), "to": to.Format("2006-01-02"), "days": days})
+}
+
+// calendarRange parses ?from= and ?to= as whole days, with to exclusive of the following day
+func calendarRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if v := c.Query("from"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, from, errors.New("from must be YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	to := from.AddDate(0, 0, 14)
+	if v := c.Query("to"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, from, errors.New("to must be YYYY-MM-DD")
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		return from, to, errors.New("to must not be before from")
+	}
+	if to.Sub(from) > maxCalendarDays*24*time.Hour {
+		return from, to, fmt.Errorf("Calendar range cannot exceed %d days", maxCalendarDays)
+	}
+	return from, to, nil
+}
+
+// findFleetItem loads the :id fleet record into dest, scoped to the caller's company
+func findFleetItem(c *fiber.Ctx, dest interface{}) error {
+	return requestDB(c).Where("id = ? AND company_id = ?", c.Params("id"), currentUser(c).CompanyID).First(dest).Error
+}
+
+// deleteFleetItem soft deletes the :id fleet record and any future blocked out periods for it
+func deleteFleetItem(c *fiber.Ctx, model interface{}, blockColumn, label string) error {
+	companyID := currentUser(c).CompanyID
+	id := c.Params("id")
+
+	err := requestDB(c).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND company_id = ?", id, companyID).Delete(model)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where(blockColumn+" = ? AND company_id = ? AND starts_at > ?", id, companyID, time.Now()).
+			Delete(&models.FleetUnavailability{}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": label + " not found"})
+	}
+	if err != nil {
+		fmt.Printf("Error deleting %s: %v\n", strings.ToLower(label), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete " + strings.ToLower(label)})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": label + " deleted"})
+}
+
+// fleetVINTaken reports whether another vehicle or trailer in the company already has the VIN
+func fleetVINTaken(db *gorm.DB, model interface{}, companyID uuid.UUID, vin string, exceptID uuid.UUID) bool {
+	var count int64
+	db.Model(model).Where("company_id = ? AND vin = ? AND id <> ?", companyID, vin, exceptID).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"cargozig_api/fmcsa"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupFMCSARoutes sets up the FMCSA snapshot lookup and import routes
+// /api/fmcsa
+func SetupFMCSARoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/lookup", FMCSALookup)
+
+	router.Get("/imports", middleware.RequirePermission(models.SystemAdmin), ListFMCSAImports)
+	router.Post("/imports/:kind", middleware.RequirePermission(models.SystemAdmin), ImportFMCSAFile)
+	router.Post("/recheck", middleware.RequirePermission(models.SystemAdmin), RecheckCarrierCompliance)
+	router.Get("/flags", middleware.RequirePermission(models.SystemAdmin), ListComplianceFlags)
+}
+
+// FMCSALookup looks a carrier up in the local snapshot by ?dot= and/or ?mc=
+func FMCSALookup(c *fiber.Ctx) error {
+	record, err := fmcsa.Lookup(requestDB(c), c.Query("dot"), c.Query("mc"))
+	if errors.Is(err, fmcsa.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Carrier not found in FMCSA snapshot"})
+	}
+	if err != nil {
+		fmt.Println("Error looking up FMCSA record:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not look up carrier"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "record": record})
+}
+
+// ListFMCSAImports lists recent bulk file imports
+func ListFMCSAImports(c *fiber.Ctx) error {
+	var imports []models.FMCSAImport
+	if err := requestDB(c).Order("started_at DESC").Limit(50).Find(&imports).Error; err != nil {
+		fmt.Println("Error listing FMCSA imports:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list imports"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "imports": imports})
+}
+
+// ImportFMCSAFile replaces a snapshot table with an uploaded CSV. Full census files are
+// too large to upload, drop those in the nightly import directory instead.
+func ImportFMCSAFile(c *fiber.Ctx) error {
+	upload, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A file is required"})
+	}
+	f, err := upload.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read uploaded file"})
+	}
+	defer f.Close()
+
+	run, err := fmcsa.Import(requestDB(c), c.Params("kind"), upload.Filename, f)
+	if errors.Is(err, fmcsa.ErrUnknownKind) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Kind must be census, authority or insurance"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "import": run})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "import": run})
+}
+
+// RecheckCarrierCompliance runs the carrier recheck now instead of waiting for the nightly job
+func RecheckCarrierCompliance(c *fiber.Ctx) error {
+	summary, err := fmcsa.RecheckCarriers(requestDB(c))
+	if errors.Is(err, fmcsa.ErrNoSnapshot) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		fmt.Println("Error rechecking carriers:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not recheck carriers"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "summary": summary})
+}
+
+// ListComplianceFlags lists compliance flags, only open ones unless ?all=true
+func ListComplianceFlags(c *fiber.Ctx) error {
+	query := requestDB(c).Preload("Company")
+	if c.Query("all") != "true" {
+		query = query.Where("resolved_at IS NULL")
+	}
+	if companyID := c.Query("company_id"); companyID != "" {
+		query = query.Where("company_id = ?", companyID)
+	}
+
+	var flags []models.ComplianceFlag
+	if err := query.Order("detected_at DESC").Find(&flags).Error; err != nil {
+		fmt.Println("Error listing compliance flags:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list flags"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "flags": flags})
+}
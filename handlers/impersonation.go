@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SetupImpersonationRoutes sets up the routes used while impersonating a user
+// /api/impersonation
+func SetupImpersonationRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/", GetImpersonation)
+	router.Post("/stop", StopImpersonation)
+}
+
+// impersonatorCookie keeps the system admin's own token while they impersonate someone,
+// so stopping signs them back in rather than out
+const impersonatorCookie = "impersonator_token"
+
+// actorToken returns when token expires if it is actor's own session token, not one issued
+// for an impersonation
+func actorToken(token string, actor *models.User) (time.Time, bool) {
+	claims, err := middleware.ParseJWT(token)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if userID, _ := claims["user_id"].(string); userID != actor.ID.String() {
+		return time.Time{}, false
+	}
+	if sessionID, _ := claims["impersonation_id"].(string); sessionID != "" {
+		return time.Time{}, false
+	}
+	expires, err := claims.GetExpirationTime()
+	if err != nil || expires == nil {
+		return time.Time{}, false
+	}
+	return expires.Time, true
+}
+
+// generateImpersonationJWT creates a token that signs in as the session's user until the
+// session expires. It carries the session and the system admin behind it.
+func generateImpersonationJWT(session *models.ImpersonationSession, user *models.User) (string, error) {
+	roles := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		roles[i] = string(role)
+	}
+
+	claims := jwt.MapClaims{
+		"user_id":          user.ID.String(),
+		"company_id":       user.CompanyID.String(),
+		"roles":            roles,
+		"impersonation_id": session.ID.String(),
+		"impersonator_id":  session.ActorID.String(),
+		"exp":              session.ExpiresAt.Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(middleware.GetJWTSecret())
+}
+
+// SuperAdminImpersonateUser starts a time-boxed session acting as a user, to see exactly
+// what they see. The session's token replaces the auth_token cookie until it is stopped or
+// expires, with the admin's own token kept aside for when it stops; payouts, password
+// changes and other sensitive actions are refused meanwhile.
+//
+//	POST /superadmin/api/users/:id/impersonate {"reason": "..."}
+func SuperAdminImpersonateUser(c *fiber.Ctx) error {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A reason is required to impersonate a user"})
+	}
+
+	actor := currentUser(c)
+	db := requestDB(c)
+
+	var user models.User
+	if err := db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if user.ID == actor.ID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "You cannot impersonate yourself"})
+	}
+	if !user.Active {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Inactive users cannot be impersonated"})
+	}
+	// Impersonating another system admin would only hide who did what
+	if err := authz.Resolve(db, &user); err != nil {
+		fmt.Println("Error resolving permissions:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load permissions"})
+	}
+	if user.HasPermission(models.SystemAdmin) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "System admins cannot be impersonated"})
+	}
+
+	minutes := platformSettingInt(db, models.SettingImpersonationMinutes)
+	if minutes < 1 {
+		minutes = 1
+	}
+	session := models.ImpersonationSession{
+		ActorID:   actor.ID,
+		UserID:    user.ID,
+		Reason:    req.Reason,
+		ExpiresAt: time.Now().Add(time.Duration(minutes) * time.Minute),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		fmt.Println("Error creating impersonation session:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start impersonation"})
+	}
+
+	token, err := generateImpersonationJWT(&session, &user)
+	if err != nil {
+		fmt.Println("Error generating token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	if own := c.Cookies("auth_token"); own != "" {
+		if expires, ok := actorToken(own, actor); ok {
+			setTokenCookie(c, impersonatorCookie, own, expires)
+		}
+	}
+	setAuthCookieUntil(c, token, session.ExpiresAt)
+
+	recordAudit(c, models.AuditImpersonationStarted, "users", user.ID.String()+" session "+session.ID.String()+": "+req.Reason)
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"session":    session,
+		"token":      token,
+		"expires_at": session.ExpiresAt,
+		"redirect":   strings.TrimRight(os.Getenv("APP_URL"), "/") + "/",
+	})
+}
+
+// GetImpersonation reports the impersonation session the request is made in, if any, so
+// apps can show the same banner the server rendered pages do
+func GetImpersonation(c *fiber.Ctx) error {
+	actor := middleware.Impersonator(c)
+	if actor == nil {
+		return c.JSON(fiber.Map{"status": "success", "impersonating": false})
+	}
+
+	user := currentUser(c)
+	var session models.ImpersonationSession
+	if err := requestDB(c).First(&session, "id = ?", c.Locals("impersonation_id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Impersonation session not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":        "success",
+		"impersonating": true,
+		"session":       session,
+		"user":          fiber.Map{"id": user.ID, "username": user.Username},
+		"actor":         fiber.Map{"id": actor.ID, "username": actor.Username},
+	})
+}
+
+// StopImpersonation ends the request's impersonation session and puts back the token the
+// system admin was signed in with before it started
+func StopImpersonation(c *fiber.Ctx) error {
+	actor := middleware.Impersonator(c)
+	if actor == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Not impersonating a user"})
+	}
+
+	user := currentUser(c)
+	now := time.Now()
+	err := requestDB(c).Model(&models.ImpersonationSession{}).
+		Where("id = ? AND ended_at IS NULL", c.Locals("impersonation_id")).
+		Update("ended_at", now).Error
+	if err != nil {
+		fmt.Println("Error ending impersonation session:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not stop impersonation"})
+	}
+	// Without their own token, e.g. when they signed in with a header, the admin is
+	// signed out instead
+	restored := false
+	if own := c.Cookies(impersonatorCookie); own != "" {
+		if expires, ok := actorToken(own, actor); ok && expires.After(now) {
+			setAuthCookieUntil(c, own, expires)
+			restored = true
+		}
+	}
+	if !restored {
+		setAuthCookieUntil(c, "", now.Add(-time.Hour))
+	}
+	setTokenCookie(c, impersonatorCookie, "", now.Add(-time.Hour))
+
+	recordAudit(c, models.AuditImpersonationEnded, "users", user.ID.String()+" session "+fmt.Sprint(c.Locals("impersonation_id")))
+
+	return c.JSON(fiber.Map{"status": "success", "redirect": "/superadmin/users/" + user.ID.String()})
+}
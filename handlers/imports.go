@@ -0,0 +1,633 @@
+package handlers
+
+import (
+	"bytes"
+	"cargozig_api/models"
+	"cargozig_api/notify"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// maxImportRows is the most rows one import file may hold
+const maxImportRows = 500
+
+// How imported users get in: an emailed invitation they accept, or a temporary password
+// handed back to the superadmin
+const (
+	importInvite   = "invite"
+	importPassword = "password"
+)
+
+// importField is a column an import understands. Headers matching the name or one of the
+// aliases, ignoring case, spaces and punctuation, are mapped to it automatically.
+type importField struct {
+	Name     string   `json:"name"`
+	Required bool     `json:"required"`
+	Aliases  []string `json:"-"`
+}
+
+// importFields are the columns of each import type
+var importFields = map[string][]importField{
+	"companies": {
+		{Name: "name", Required: true, Aliases: []string{"company", "company name", "legal name"}},
+		{Name: "email", Required: true, Aliases: []string{"e-mail", "company email"}},
+		{Name: "company_type", Required: true, Aliases: []string{"type"}},
+		{Name: "phone", Aliases: []string{"phone number", "telephone"}},
+		{Name: "address", Aliases: []string{"street", "address 1"}},
+		{Name: "city"},
+		{Name: "state"},
+		{Name: "zip_code", Aliases: []string{"zip", "postal code", "postcode"}},
+		{Name: "country"},
+		{Name: "website", Aliases: []string{"url", "web"}},
+		{Name: "tax_id", Aliases: []string{"ein"}},
+		{Name: "dot_number", Aliases: []string{"dot", "usdot"}},
+		{Name: "mc_number", Aliases: []string{"mc"}},
+	},
+	"users": {
+		{Name: "email", Required: true, Aliases: []string{"e-mail", "user email"}},
+		{Name: "username", Aliases: []string{"user", "name", "user name"}}, // Required when setting passwords
+		{Name: "company", Required: true, Aliases: []string{"company id", "company email", "company name"}},
+		{Name: "roles", Required: true, Aliases: []string{"role"}},
+		{Name: "permissions", Aliases: []string{"permission"}},
+	},
+}
+
+// importRow is one data row of an import file and what became of it
+type importRow struct {
+	Line              int               `json:"line"` // Line in the file, the header being line 1
+	Values            map[string]string `json:"values"`
+	Errors            map[string]string `json:"errors,omitempty"`
+	ID                *uuid.UUID        `json:"id,omitempty"`                 // Record created, once committed
+	TemporaryPassword string            `json:"temporary_password,omitempty"` // Only returned once, on commit
+}
+
+// importReport is the result of validating, and possibly committing, an import file
+type importReport struct {
+	Type        string            `json:"type"`
+	Credentials string            `json:"credentials,omitempty"`
+	Headers     []string          `json:"headers"`
+	Fields      []importField     `json:"fields"`
+	Mapping     map[string]string `json:"mapping"` // Field to the header it is read from
+	Rows        []importRow       `json:"rows"`
+	Valid       int               `json:"valid"`
+	Invalid     int               `json:"invalid"`
+	Committed   bool              `json:"committed"`
+}
+
+// SuperAdminImport renders the bulk import page
+func SuperAdminImport(c *fiber.Ctx) error {
+	return c.Render("superadmin/import", fiber.Map{
+		"Title":      "Bulk Import",
+		"ActivePage": "import",
+		"Username":   c.Locals("username"),
+		"MaxRows":    maxImportRows,
+	}, "layouts/superadmin")
+}
+
+// SuperAdminImportTemplate downloads a CSV with the import type's columns as headers
+func SuperAdminImportTemplate(c *fiber.Ctx) error {
+	fields, ok := importFields[c.Params("type")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown import type"})
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.Name
+	}
+	w.Write(header)
+	w.Flush()
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s_import.csv"`, c.Params("type")))
+	return c.Send(buf.Bytes())
+}
+
+// SuperAdminRunImport validates a CSV or XLSX file of companies or users and, when asked to
+// commit, creates every row in one transaction. Without commit it is a dry run that only
+// reports problems. Form fields:
+//
+//	file         the .csv or .xlsx file, headers on the first row
+//	mapping      optional JSON of field to header, e.g. {"email": "E-mail Address"}
+//	credentials  users only: "invite" to email invitations, "password" for temporary passwords
+//	commit       "true" to create the records
+//
+//	POST /superadmin/api/import/:type
+func SuperAdminRunImport(c *fiber.Ctx) error {
+	kind := c.Params("type")
+	fields, ok := importFields[kind]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown import type"})
+	}
+
+	credentials := c.FormValue("credentials", importInvite)
+	if kind == "users" && credentials != importInvite && credentials != importPassword {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "credentials must be invite or password"})
+	}
+	if kind != "users" {
+		credentials = ""
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "An import file is required"})
+	}
+	headers, records, err := readImportFile(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var requested map[string]string
+	if raw := c.FormValue("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &requested); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mapping must be a JSON object of field to header"})
+		}
+	}
+	mapping, err := importMapping(fields, headers, requested)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	report := &importReport{Type: kind, Credentials: credentials, Headers: headers, Fields: fields, Mapping: mapping, Rows: []importRow{}}
+	column := map[string]int{}
+	for i, h := range headers {
+		column[h] = i
+	}
+	for _, record := range records {
+		row := importRow{Line: record.line, Values: map[string]string{}, Errors: map[string]string{}}
+		for field, header := range mapping {
+			if i := column[header]; i < len(record.cells) {
+				row.Values[field] = strings.TrimSpace(record.cells[i])
+			}
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	db := requestDB(c)
+	var create func(tx *gorm.DB) error
+	var afterCommit func()
+	if kind == "companies" {
+		create = validateCompanyImport(db, report)
+	} else {
+		create, afterCommit = validateUserImport(db, currentUser(c), report)
+	}
+	for i := range report.Rows {
+		if len(report.Rows[i].Errors) == 0 {
+			report.Rows[i].Errors = nil
+			report.Valid++
+		} else {
+			report.Invalid++
+		}
+	}
+
+	if c.FormValue("commit") != "true" {
+		return c.JSON(fiber.Map{"status": "success", "report": report})
+	}
+	if report.Invalid > 0 || report.Valid == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Fix the rows with errors before importing", "report": report})
+	}
+
+	if err := db.Transaction(create); err != nil {
+		if isUniqueViolation(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A record with one of these emails was created meanwhile; run the dry run again", "report": report})
+		}
+		fmt.Println("Error importing "+kind+":", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not import " + kind})
+	}
+	report.Committed = true
+	if afterCommit != nil {
+		afterCommit()
+	}
+
+	recordAudit(c, models.AuditRecordsImported, kind, fmt.Sprintf("%d %s from %s", report.Valid, kind, file.Filename))
+	return c.JSON(fiber.Map{"status": "success", "report": report})
+}
+
+// importRecord is a non-blank data row read from an import file
+type importRecord struct {
+	line  int
+	cells []string
+}
+
+// readImportFile reads the headers and data rows of a .csv or .xlsx file
+func readImportFile(file *multipart.FileHeader) ([]string, []importRecord, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, nil, errors.New("Could not read the import file")
+	}
+	defer f.Close()
+
+	var rows [][]string
+	switch strings.ToLower(filepath.Ext(file.Filename)) {
+	case ".csv":
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = -1
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("Could not read the CSV file: %v", err)
+			}
+			rows = append(rows, row)
+		}
+	case ".xlsx":
+		book, err := excelize.OpenReader(f)
+		if err != nil {
+			return nil, nil, errors.New("Could not read the XLSX file")
+		}
+		defer book.Close()
+		// Only the first sheet is imported
+		if rows, err = book.GetRows(book.GetSheetName(0)); err != nil {
+			return nil, nil, errors.New("Could not read the XLSX file")
+		}
+	default:
+		return nil, nil, errors.New("The import file must be a .csv or .xlsx file")
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, errors.New("The import file is empty")
+	}
+	headers := make([]string, len(rows[0]))
+	seen := map[string]bool{}
+	for i, h := range rows[0] {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if h != "" && seen[h] {
+			return nil, nil, fmt.Errorf("The header %q appears more than once", h)
+		}
+		seen[h] = true
+		headers[i] = h
+	}
+
+	var records []importRecord
+	for i, row := range rows[1:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		records = append(records, importRecord{line: i + 2, cells: row})
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("The import file has no data rows")
+	}
+	if len(records) > maxImportRows {
+		return nil, nil, fmt.Errorf("The import file has %d rows; import at most %d at a time", len(records), maxImportRows)
+	}
+	return headers, records, nil
+}
+
+// importMapping works out which header each field is read from. Headers are matched to
+// fields automatically, then requested maps a field to a different header, or to "" to
+// leave it out.
+func importMapping(fields []importField, headers []string, requested map[string]string) (map[string]string, error) {
+	normalize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, s)
+	}
+
+	mapping := map[string]string{}
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[f.Name] = true
+		names := append([]string{f.Name}, f.Aliases...)
+	match:
+		for _, h := range headers {
+			for _, name := range names {
+				if h != "" && normalize(h) == normalize(name) {
+					mapping[f.Name] = h
+					break match
+				}
+			}
+		}
+	}
+
+	for field, header := range requested {
+		if !known[field] {
+			return nil, fmt.Errorf("Unknown field %q in mapping", field)
+		}
+		if header == "" {
+			delete(mapping, field)
+			continue
+		}
+		found := false
+		for _, h := range headers {
+			found = found || h == header
+		}
+		if !found {
+			return nil, fmt.Errorf("The file has no %q column to map %s from", header, field)
+		}
+		mapping[field] = header
+	}
+	return mapping, nil
+}
+
+// requireImportFields flags the required fields a row leaves empty
+func requireImportFields(report *importReport, row *importRow) {
+	for _, f := range report.Fields {
+		if f.Required && row.Values[f.Name] == "" && row.Errors[f.Name] == "" {
+			if _, mapped := report.Mapping[f.Name]; mapped {
+				row.Errors[f.Name] = "Required"
+			} else {
+				row.Errors[f.Name] = "Required; map a column to " + f.Name
+			}
+		}
+	}
+}
+
+// validateCompanyImport checks each row as a new company and returns how to create them
+func validateCompanyImport(db *gorm.DB, report *importReport) func(tx *gorm.DB) error {
+	companies := make([]models.Company, len(report.Rows))
+	emails := map[string]int{}
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		req := companyRequest{}
+		for field, target := range map[string]**string{
+			"name": &req.Name, "email": &req.Email, "company_type": &req.CompanyType, "phone": &req.Phone,
+			"address": &req.Address, "city": &req.City, "state": &req.State, "zip_code": &req.ZipCode,
+			"country": &req.Country, "website": &req.Website, "tax_id": &req.TaxID,
+			"dot_number": &req.DOTNumber, "mc_number": &req.MCNumber,
+		} {
+			if value, ok := row.Values[field]; ok {
+				*target = &value
+			}
+		}
+
+		company := models.Company{Active: true}
+		req.apply(&company)
+		for field, problem := range validateCompany(&company) {
+			if _, ok := importFieldNamed(report.Fields, field); ok {
+				row.Errors[field] = problem
+			}
+		}
+		requireImportFields(report, row)
+
+		if row.Errors["email"] == "" {
+			if line, dup := emails[company.Email]; dup {
+				row.Errors["email"] = fmt.Sprintf("Same email as line %d", line)
+			} else if companyEmailTaken(db, company.Email, nil) {
+				row.Errors["email"] = "A company with this email already exists"
+			}
+			emails[company.Email] = row.Line
+		}
+		companies[i] = company
+	}
+
+	return func(tx *gorm.DB) error {
+		for i := range companies {
+			if err := tx.Create(&companies[i]).Error; err != nil {
+				return err
+			}
+			report.Rows[i].ID = &companies[i].ID
+		}
+		return nil
+	}
+}
+
+// importFieldNamed finds a field by name
+func importFieldNamed(fields []importField, name string) (importField, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return importField{}, false
+}
+
+// validateUserImport checks each row as a new user of an existing company and returns how
+// to create them, or invite them when the report's credentials are invitations. Invitations
+// are emailed by afterCommit once they are saved.
+func validateUserImport(db *gorm.DB, actor *models.User, report *importReport) (create func(tx *gorm.DB) error, afterCommit func()) {
+	users := make([]models.User, len(report.Rows))
+	emails := map[string]int{}
+	lookup := companyLookup(db)
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		username, email := row.Values["username"], row.Values["email"]
+		user := models.User{Active: true}
+		for field, problem := range (&userRequest{Username: &username, Email: &email}).apply(&user) {
+			row.Errors[field] = problem
+		}
+		if report.Credentials == importInvite && user.Username == "" {
+			delete(row.Errors, "username") // Chosen by the invitee when they accept
+		}
+		requireImportFields(report, row)
+
+		if row.Errors["email"] == "" {
+			if line, dup := emails[user.Email]; dup {
+				row.Errors["email"] = fmt.Sprintf("Same email as line %d", line)
+			} else if userEmailTaken(db, user.Email, nil) {
+				row.Errors["email"] = "A user with this email already exists"
+			}
+			emails[user.Email] = row.Line
+		}
+
+		if value := row.Values["company"]; value != "" {
+			company, problem := lookup(value)
+			if problem != "" {
+				row.Errors["company"] = problem
+			} else {
+				user.CompanyID = company.ID
+				user.Company = company
+			}
+		}
+
+		if user.Company != nil && row.Values["roles"] != "" {
+			roles, _, _, msg := parseUserAccess(db, user.CompanyID, splitImportList(row.Values["roles"]), nil, nil)
+			if msg != "" {
+				row.Errors["roles"] = msg
+			}
+			user.Roles = roles
+		}
+		permissions, _, msg := parseRolePermissions(splitImportList(row.Values["permissions"]), nil)
+		if msg != "" {
+			row.Errors["permissions"] = msg
+		}
+		user.Permissions = permissions
+		users[i] = user
+	}
+
+	var invitations []pendingInvitation
+	create = func(tx *gorm.DB) error {
+		owned := map[uuid.UUID]bool{}
+		invitations = nil
+		for i := range users {
+			user := &users[i]
+			row := &report.Rows[i]
+
+			if report.Credentials == importInvite {
+				invitation, token, err := createImportInvitation(tx, actor, user)
+				if err != nil {
+					return err
+				}
+				row.ID = &invitation.ID
+				invitations = append(invitations, pendingInvitation{invitation: invitation, token: token, company: user.Company})
+				continue
+			}
+
+			password, err := temporaryPassword()
+			if err != nil {
+				return err
+			}
+			hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			user.Password = string(hashed)
+			company := user.Company
+			user.Company = nil
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+			// A company's first user owns it
+			if company.OwnerUserID == nil && !owned[company.ID] {
+				if err := tx.Model(company).Update("owner_user_id", user.ID).Error; err != nil {
+					return err
+				}
+				owned[company.ID] = true
+			}
+			row.ID = &user.ID
+			row.TemporaryPassword = password
+		}
+		return nil
+	}
+	return create, func() { sendImportInvitations(actor, invitations) }
+}
+
+// companyLookup finds the company a user row names by ID, email or exact name, caching
+// what it finds
+func companyLookup(db *gorm.DB) func(value string) (*models.Company, string) {
+	found := map[string]*models.Company{}
+	problems := map[string]string{}
+
+	return func(value string) (*models.Company, string) {
+		key := strings.ToLower(value)
+		if company, ok := found[key]; ok {
+			return company, ""
+		}
+		if problem, ok := problems[key]; ok {
+			return nil, problem
+		}
+
+		var companies []models.Company
+		query := db.Limit(2)
+		if id, err := uuid.Parse(value); err == nil {
+			query = query.Where("id = ?", id)
+		} else if strings.Contains(value, "@") {
+			query = query.Where("LOWER(email) = ?", key)
+		} else {
+			query = query.Where("LOWER(name) = ?", key)
+		}
+		if err := query.Find(&companies).Error; err != nil {
+			fmt.Println("Error finding import company:", err)
+			return nil, "Could not look up the company"
+		}
+
+		switch len(companies) {
+		case 0:
+			problems[key] = "No company with this ID, email or name"
+		case 1:
+			found[key] = &companies[0]
+			return found[key], ""
+		default:
+			problems[key] = "More than one company has this name; use its email or ID"
+		}
+		return nil, problems[key]
+	}
+}
+
+// splitImportList splits a cell listing several values, separated by commas, semicolons or
+// pipes
+func splitImportList(cell string) []string {
+	var values []string
+	for _, v := range strings.FieldsFunc(cell, func(r rune) bool { return r == ',' || r == ';' || r == '|' }) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// pendingInvitation is an imported invitation waiting to be emailed
+type pendingInvitation struct {
+	invitation *models.Invitation
+	token      string
+	company    *models.Company
+}
+
+// createImportInvitation invites an imported user to their company, replacing any pending
+// invitation for the same email
+func createImportInvitation(tx *gorm.DB, actor *models.User, user *models.User) (*models.Invitation, string, error) {
+	token, err := newInvitationToken()
+	if err != nil {
+		return nil, "", err
+	}
+	invitation := &models.Invitation{
+		CompanyID:   user.CompanyID,
+		Email:       user.Email,
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		TokenHash:   hashInvitationToken(token),
+		ExpiresAt:   time.Now().Add(invitationTTL),
+		InvitedByID: actor.ID,
+	}
+	if err := tx.Model(&models.Invitation{}).
+		Where("company_id = ? AND email = ? AND accepted_at IS NULL AND revoked_at IS NULL", user.CompanyID, user.Email).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return nil, "", err
+	}
+	if err := tx.Create(invitation).Error; err != nil {
+		return nil, "", err
+	}
+	return invitation, token, nil
+}
+
+// sendImportInvitations emails imported invitations
+func sendImportInvitations(actor *models.User, invitations []pendingInvitation) {
+	for _, p := range invitations {
+		notify.Send(notify.Message{
+			To:      []string{p.invitation.Email},
+			Subject: fmt.Sprintf("%s invited you to %s on CargoZig", actor.Username, p.company.Name),
+			Body:    fmt.Sprintf("Accept the invitation before %s: %s", p.invitation.ExpiresAt.Format("2006-01-02"), invitationLink(p.token)),
+			Tags:    map[string]string{"company_id": p.company.ID.String(), "kind": "invitation"},
+		})
+	}
+}
+
+// temporaryPassword generates a password for an imported user to sign in with once. It
+// leaves out characters that are easily mistaken for each other.
+func temporaryPassword() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	password := make([]byte, 14)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = alphabet[n.Int64()]
+	}
+	return string(password), nil
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"cargozig_api/compliance"
+	"cargozig_api/config"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SetupInsuranceRoutes sets up the insurance certificate routes
+// /api/insurance
+func SetupInsuranceRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/certificates", middleware.RequireRole(models.RoleCarrier), ListInsuranceCertificates)
+	router.Post("/certificates", middleware.RequireRole(models.RoleCarrier), CreateInsuranceCertificate)
+	router.Get("/coverage", middleware.RequireRole(models.RoleCarrier), GetCoverageStatus)
+	router.Post("/check", middleware.RequirePermission(models.SystemAdmin), RunInsuranceChecks)
+
+	// Superadmin review of uploaded certificates
+	router.Get("/review", middleware.RequirePermission(models.SystemAdmin), ListCertificateReviews)
+	router.Post("/certificates/:id/verify", middleware.RequirePermission(models.SystemAdmin), VerifyInsuranceCertificate)
+	router.Post("/certificates/:id/reject", middleware.RequirePermission(models.SystemAdmin), RejectInsuranceCertificate)
+}
+
+// ListInsuranceCertificates lists a carrier's certificates, newest expiry first
+func ListInsuranceCertificates(c *fiber.Ctx) error {
+	companyID, err := requestedCompanyID(c, c.Query("company_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	var certificates []models.InsuranceCertificate
+	if err := requestDB(c).Where("company_id = ?", companyID).Order("expires_at DESC").Find(&certificates).Error; err != nil {
+		fmt.Println("Error listing insurance certificates:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list certificates"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "certificates": certificates})
+}
+
+// CreateInsuranceCertificate records a certificate of insurance. Carriers must attach the
+// uploaded certificate document, and their certificates wait for a superadmin to check
+// them against it; certificates entered by a superadmin are verified as they are saved.
+// A verified carrier's compliance hold is re-evaluated straight away, though only verified
+// certificates can lift one.
+func CreateInsuranceCertificate(c *fiber.Ctx) error {
+	var req struct {
+		CompanyID    string `json:"company_id"`
+		DocumentID   string `json:"document_id"`
+		CoverageType string `json:"coverage_type"`
+		InsurerName  string `json:"insurer_name"`
+		PolicyNumber string `json:"policy_number"`
+		LimitCents   int64  `json:"limit_cents"`
+		EffectiveAt  string `json:"effective_at"` // YYYY-MM-DD
+		ExpiresAt    string `json:"expires_at"`   // YYYY-MM-DD
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	companyID, err := requestedCompanyID(c, req.CompanyID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	coverage := models.CoverageType(strings.ToLower(req.CoverageType))
+	if !models.ValidCoverageType(coverage) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid coverage type"})
+	}
+	if strings.TrimSpace(req.InsurerName) == "" || strings.TrimSpace(req.PolicyNumber) == "" || req.LimitCents <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Insurer, policy number and limit are required"})
+	}
+	effectiveAt, err := time.Parse("2006-01-02", req.EffectiveAt)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "effective_at must be YYYY-MM-DD"})
+	}
+	expiresAt, err := time.Parse("2006-01-02", req.ExpiresAt)
+	if err != nil || !expiresAt.After(effectiveAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_at must be a YYYY-MM-DD date after effective_at"})
+	}
+
+	user := currentUser(c)
+	db := requestDB(c)
+
+	certificate := models.InsuranceCertificate{
+		CompanyID:    companyID,
+		CoverageType: coverage,
+		InsurerName:  strings.TrimSpace(req.InsurerName),
+		PolicyNumber: strings.TrimSpace(req.PolicyNumber),
+		LimitCents:   req.LimitCents,
+		EffectiveAt:  effectiveAt,
+		ExpiresAt:    expiresAt,
+		Status:       models.CertificatePending,
+	}
+	if user.HasPermission(models.SystemAdmin) {
+		now := time.Now()
+		certificate.Status = models.CertificateVerified
+		certificate.ReviewedAt = &now
+		certificate.ReviewedByID = &user.ID
+	}
+
+	if req.DocumentID != "" {
+		var document models.CompanyDocument
+		if err := db.Where("id = ? AND company_id = ? AND type = ?", req.DocumentID, companyID, models.DocumentInsuranceCertificate).
+			First(&document).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Certificate document not found"})
+		}
+		certificate.DocumentID = &document.ID
+	} else if !user.HasPermission(models.SystemAdmin) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload the certificate document and pass its document_id"})
+	}
+
+	if err := db.Create(&certificate).Error; err != nil {
+		fmt.Println("Error creating insurance certificate:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save certificate"})
+	}
+
+	reapplyHold(db, companyID)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "certificate": certificate})
+}
+
+// ListCertificateReviews lists certificates waiting for review, oldest first
+func ListCertificateReviews(c *fiber.Ctx) error {
+	var certificates []models.InsuranceCertificate
+	if err := requestDB(c).Preload("Document").Where("status = ?", models.CertificatePending).
+		Order("created_at").Find(&certificates).Error; err != nil {
+		fmt.Println("Error listing certificate reviews:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list certificates"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "certificates": certificates})
+}
+
+// VerifyInsuranceCertificate confirms a certificate's details match its document, so it
+// counts towards lifting a compliance hold
+func VerifyInsuranceCertificate(c *fiber.Ctx) error {
+	return reviewCertificate(c, models.CertificateVerified, "")
+}
+
+// RejectInsuranceCertificate sets aside a certificate whose details don't match its
+// document, with the reason given
+func RejectInsuranceCertificate(c *fiber.Ctx) error {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A rejection reason is required"})
+	}
+
+	return reviewCertificate(c, models.CertificateRejected, strings.TrimSpace(req.Reason))
+}
+
+// reviewCertificate records a review decision on a pending certificate and re-evaluates
+// the carrier's compliance hold with it
+func reviewCertificate(c *fiber.Ctx, decision models.CertificateStatus, reason string) error {
+	db := requestDB(c)
+	var certificate models.InsuranceCertificate
+	if err := db.First(&certificate, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Certificate not found"})
+	}
+	if certificate.Status != models.CertificatePending {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("Certificate is already %s", certificate.Status)})
+	}
+
+	now := time.Now()
+	user := currentUser(c)
+	certificate.Status = decision
+	certificate.ReviewedAt = &now
+	certificate.ReviewedByID = &user.ID
+	certificate.RejectionReason = reason
+	result := db.Model(&certificate).Where("status = ?", models.CertificatePending).
+		Select("status", "reviewed_at", "reviewed_by_id", "rejection_reason").Updates(&certificate)
+	if result.Error != nil {
+		fmt.Println("Error reviewing certificate:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not record review"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Certificate has already been reviewed"})
+	}
+
+	reapplyHold(db, certificate.CompanyID)
+
+	return c.JSON(fiber.Map{"status": "success", "certificate": certificate})
+}
+
+// reapplyHold re-evaluates a verified carrier's compliance hold after its certificates change
+func reapplyHold(db *gorm.DB, companyID uuid.UUID) {
+	var company models.Company
+	if err := db.First(&company, "id = ?", companyID).Error; err == nil && company.Verified {
+		if _, err := compliance.ApplyHold(db, &company); err != nil {
+			fmt.Println("Error re-evaluating compliance hold:", err)
+		}
+	}
+}
+
+// GetCoverageStatus reports whether a carrier's required coverage is in force
+func GetCoverageStatus(c *fiber.Ctx) error {
+	companyID, err := requestedCompanyID(c, c.Query("company_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	db := requestDB(c)
+	var company models.Company
+	if err := db.First(&company, "id = ?", companyID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+
+	problems, err := compliance.CoverageProblems(db, companyID, time.Now())
+	if err != nil {
+		fmt.Println("Error checking coverage:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not check coverage"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":          "success",
+		"covered":         len(problems) == 0,
+		"problems":        problems,
+		"compliance_hold": company.ComplianceHold,
+		"hold_reason":     company.HoldReason,
+	})
+}
+
+// RunInsuranceChecks runs the reminder and hold checks now instead of waiting for the daily job
+func RunInsuranceChecks(c *fiber.Ctx) error {
+	if err := compliance.RunInsuranceChecks(requestDB(c)); err != nil {
+		fmt.Println("Error running insurance checks:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not run insurance checks"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Insurance checks complete"})
+}
+
+// bookableCarrier checks a carrier company can be booked on a load
+func bookableCarrier(id uuid.UUID) (string, bool) {
+	var carrier models.Company
+	if err := config.GetDB().First(&carrier, "id = ?", id).Error; err != nil {
+		return "Carrier company not found", false
+	}
+	if !carrier.Verified {
+		return "Carrier has not passed onboarding review", false
+	}
+	if carrier.ComplianceHold {
+		return "Carrier is on compliance hold: " + carrier.HoldReason, false
+	}
+	return "", true
+}
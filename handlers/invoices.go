@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"cargozig_api/tenant"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// invoicePaymentTerms is how long a shipper has to pay an invoice once it is sent,
+// and how long the platform takes to pay an approved carrier payable without quick-pay
+const invoicePaymentTerms = 30 * 24 * time.Hour
+
+// SetupInvoiceRoutes sets up the invoice routes
+// /api/invoices
+func SetupInvoiceRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/", middleware.RequirePermission(models.ViewFinancials), ListInvoices)
+	router.Get("/reports/payouts", middleware.RequirePermission(models.ViewFinancials), PayoutReport)
+	router.Get("/:id", middleware.RequirePermission(models.ViewFinancials), GetInvoice)
+	router.Post("/generate/:loadId", middleware.RequirePermission(models.ManagePayments), GenerateInvoicesForLoad)
+	router.Put("/:id/status", middleware.RequirePermission(models.ManagePayments), middleware.DenyWhileImpersonating(), UpdateInvoiceStatus)
+	router.Post("/:id/quick-pay", middleware.RequirePermission(models.ManagePayments), middleware.RequireVerifiedCompany(), middleware.DenyWhileImpersonating(), ElectQuickPay)
+}
+
+// ListInvoices lists the invoices and payables of the current user's company
+func ListInvoices(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	query := db.Model(&models.Invoice{})
+	if !user.HasPermission(models.SystemAdmin) {
+		query = query.Where("company_id = ?", user.CompanyID)
+	} else if companyID := c.Query("company_id"); companyID != "" {
+		query = query.Where("company_id = ?", companyID)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var invoices []models.Invoice
+	if err := query.Order("created_at DESC").Find(&invoices).Error; err != nil {
+		fmt.Println("Error listing invoices:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list invoices"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "invoices": invoices})
+}
+
+// GetInvoice returns a single invoice with its lines
+func GetInvoice(c *fiber.Ctx) error {
+	invoice, err := findVisibleInvoice(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "invoice": invoice})
+}
+
+// GenerateInvoicesForLoad generates any missing invoices for a completed load
+func GenerateInvoicesForLoad(c *fiber.Ctx) error {
+	db := requestDB(c)
+
+	var load models.Load
+	if err := db.Where("id = ?", c.Params("loadId")).First(&load).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Load not found"})
+	}
+
+	if load.Status != models.LoadStatusCompleted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invoices can only be generated for completed loads"})
+	}
+
+	var invoices []models.Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		invoices, err = generateLoadInvoices(tx, &load)
+		return err
+	})
+	if err != nil {
+		fmt.Println("Error generating invoices:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate invoices"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "invoices": invoices})
+}
+
+// UpdateInvoiceStatus moves an invoice through draft, sent, paid and void. Invoices are
+// normally marked paid by the payment events that settle them; approving a payable and
+// marking an invoice paid by hand are left to system admins.
+func UpdateInvoiceStatus(c *fiber.Ctx) error {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	invoice, err := findVisibleInvoice(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+
+	next := models.InvoiceStatus(req.Status)
+	if (next == models.InvoiceStatusApproved || next == models.InvoiceStatusPaid) && !currentUser(c).HasPermission(models.SystemAdmin) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": fmt.Sprintf("Only system admins can set an invoice %s, payments mark invoices paid when they settle", next),
+		})
+	}
+	if (next == models.InvoiceStatusSent && invoice.Kind != models.InvoiceKindShipper) ||
+		(next == models.InvoiceStatusApproved && invoice.Kind != models.InvoiceKindCarrierPayable) ||
+		!invoice.Status.CanTransitionTo(next) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Cannot change invoice status from %s to %s", invoice.Status, req.Status),
+		})
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": next}
+	switch next {
+	case models.InvoiceStatusSent:
+		updates["issued_at"] = now
+		updates["due_at"] = now.Add(invoicePaymentTerms)
+	case models.InvoiceStatusApproved:
+		updates["issued_at"] = now
+		updates["return_code"] = ""
+		if invoice.QuickPay {
+			updates["due_at"] = now.AddDate(0, 0, int(platformSettingInt(requestDB(c), models.SettingQuickPayDays)))
+		} else {
+			updates["due_at"] = now.Add(invoicePaymentTerms)
+		}
+	case models.InvoiceStatusPaid:
+		updates["paid_at"] = now
+	case models.InvoiceStatusVoid:
+		updates["voided_at"] = now
+	}
+
+	db := requestDB(c)
+	if err := db.Model(invoice).Updates(updates).Error; err != nil {
+		fmt.Println("Error updating invoice status:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update invoice"})
+	}
+	db.Preload("Lines").First(invoice, "id = ?", invoice.ID)
+
+	return c.JSON(fiber.Map{"status": "success", "invoice": invoice})
+}
+
+// ElectQuickPay opts a carrier payable into quick-pay for a fee from the platform fee schedule
+func ElectQuickPay(c *fiber.Ctx) error {
+	invoice, err := findVisibleInvoice(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+	if invoice.Kind != models.InvoiceKindCarrierPayable {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Quick-pay is only available on carrier payables"})
+	}
+	if invoice.Status != models.InvoiceStatusDraft && invoice.Status != models.InvoiceStatusApproved {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Quick-pay can only be elected before the payable is paid"})
+	}
+	if invoice.QuickPay {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Quick-pay is already elected"})
+	}
+	if invoice.PayeeCompanyID != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Payable is assigned to a factoring company"})
+	}
+	// Quick-pay trades part of the payout for speed, so only the company being paid may elect it
+	if invoice.PayeeID() != currentUser(c).CompanyID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the payee can elect quick-pay"})
+	}
+
+	db := requestDB(c)
+	fee := invoice.TotalCents * platformSettingInt(db, models.SettingQuickPayFeeBps) / 10000
+	if minFee := platformSettingInt(db, models.SettingQuickPayMinFeeCents); fee < minFee {
+		fee = minFee
+	}
+	if fee >= invoice.TotalCents {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Payable is too small for quick-pay"})
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"quick_pay":            true,
+		"quick_pay_fee_cents":  fee,
+		"quick_pay_elected_at": now,
+	}
+	if invoice.Status == models.InvoiceStatusApproved {
+		updates["due_at"] = now.AddDate(0, 0, int(platformSettingInt(db, models.SettingQuickPayDays)))
+	}
+
+	if err := db.Model(invoice).Updates(updates).Error; err != nil {
+		fmt.Println("Error electing quick-pay:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not elect quick-pay"})
+	}
+	db.Preload("Lines").First(invoice, "id = ?", invoice.ID)
+
+	return c.JSON(fiber.Map{"status": "success", "invoice": invoice})
+}
+
+// payoutReportRow is the total paid to one payee for one carrier's payables
+type payoutReportRow struct {
+	PayeeCompanyID   uuid.UUID `json:"payee_company_id"`
+	PayeeName        string    `json:"payee_name"`
+	CarrierCompanyID uuid.UUID `json:"carrier_company_id"`
+	CarrierName      string    `json:"carrier_name"`
+	Payables         int64     `json:"payables"`
+	GrossCents       int64     `json:"gross_cents"`
+	FeeCents         int64     `json:"fee_cents"`
+	NetCents         int64     `json:"net_cents"`
+}
+
+// PayoutReport summarises paid carrier payables by who was paid, optionally between from and to (YYYY-MM-DD)
+func PayoutReport(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	query := requestDB(c).Model(&models.Invoice{}).
+		Select(`COALESCE(invoices.payee_company_id, invoices.company_id) AS payee_company_id,
+			payee.name AS payee_name,
+			invoices.company_id AS carrier_company_id,
+			carrier.name AS carrier_name,
+			COUNT(*) AS payables,
+			SUM(invoices.total_cents) AS gross_cents,
+			SUM(invoices.quick_pay_fee_cents) AS fee_cents,
+			SUM(invoices.total_cents - invoices.quick_pay_fee_cents) AS net_cents`).
+		Joins("LEFT JOIN companies payee ON payee.id = COALESCE(invoices.payee_company_id, invoices.company_id)").
+		Joins("LEFT JOIN companies carrier ON carrier.id = invoices.company_id").
+		Where("invoices.kind = ? AND invoices.status = ?", models.InvoiceKindCarrierPayable, models.InvoiceStatusPaid)
+
+	// Carriers see what was paid for their loads, factors see what was paid to them
+	if !user.HasPermission(models.SystemAdmin) {
+		query = query.Where("invoices.company_id = ? OR invoices.payee_company_id = ?", user.CompanyID, user.CompanyID)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be YYYY-MM-DD"})
+		}
+		query = query.Where("invoices.paid_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be YYYY-MM-DD"})
+		}
+		query = query.Where("invoices.paid_at < ?", t.AddDate(0, 0, 1))
+	}
+
+	var rows []payoutReportRow
+	err := query.Group("COALESCE(invoices.payee_company_id, invoices.company_id), payee.name, invoices.company_id, carrier.name").
+		Order("payee_name, carrier_name").
+		Scan(&rows).Error
+	if err != nil {
+		fmt.Println("Error building payout report:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not build payout report"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "payouts": rows})
+}
+
+// findVisibleInvoice loads an invoice that belongs to the current user's company
+func findVisibleInvoice(c *fiber.Ctx, id string) (*models.Invoice, error) {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	query := db.Preload("Lines").Where("id = ?", id)
+	if !user.HasPermission(models.SystemAdmin) {
+		query = query.Where("company_id = ?", user.CompanyID)
+	}
+
+	var invoice models.Invoice
+	if err := query.First(&invoice).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// generateLoadInvoices creates the shipper invoice and carrier payable for a completed load.
+// Invoices that already exist (and are not void) are returned instead of duplicated.
+func generateLoadInvoices(tx *gorm.DB, load *models.Load) ([]models.Invoice, error) {
+	if load.CarrierCompanyID == nil {
+		return nil, errors.New("load has no carrier assigned")
+	}
+
+	// Either party can complete a load but each invoice belongs to one of them
+	tx = tenant.System(tx, "load-invoices")
+
+	// A load cancelled before it moved is billed its TONU charge and nothing else
+	tonu := models.InvoiceLine{Type: models.LineTypeTONU, Description: "Truck ordered not used", AmountCents: load.TONUCents}
+	shipperLines := []models.InvoiceLine{tonu}
+	carrierLines := []models.InvoiceLine{tonu}
+
+	if load.CancelledAt == nil {
+		shipperLines = []models.InvoiceLine{
+			{Type: models.LineTypeLinehaul, Description: "Linehaul", AmountCents: load.ShipperLinehaulCents},
+			{Type: models.LineTypeFuel, Description: "Fuel surcharge", AmountCents: load.ShipperFuelCents},
+		}
+		carrierLines = []models.InvoiceLine{
+			{Type: models.LineTypeLinehaul, Description: "Linehaul", AmountCents: load.CarrierLinehaulCents},
+			{Type: models.LineTypeFuel, Description: "Fuel surcharge", AmountCents: load.CarrierFuelCents},
+		}
+
+		// Accessorials are billed to the shipper and passed through to the carrier
+		accessorials := []models.InvoiceLine{
+			{Type: models.LineTypeDetention, Description: "Detention", AmountCents: load.DetentionCents},
+			{Type: models.LineTypeLumper, Description: "Lumper", AmountCents: load.LumperCents},
+			tonu,
+		}
+		shipperLines = append(shipperLines, accessorials...)
+		carrierLines = append(carrierLines, accessorials...)
+	}
+
+	shipperInvoice, err := createLoadInvoice(tx, load, models.InvoiceKindShipper, load.ShipperCompanyID, shipperLines)
+	if err != nil {
+		return nil, err
+	}
+	carrierPayable, err := createLoadInvoice(tx, load, models.InvoiceKindCarrierPayable, *load.CarrierCompanyID, carrierLines)
+	if err != nil {
+		return nil, err
+	}
+
+	return []models.Invoice{*shipperInvoice, *carrierPayable}, nil
+}
+
+// createLoadInvoice creates a single invoice for a load unless a live one already exists
+func createLoadInvoice(tx *gorm.DB, load *models.Load, kind models.InvoiceKind, companyID uuid.UUID, lines []models.InvoiceLine) (*models.Invoice, error) {
+	var existing models.Invoice
+	err := tx.Preload("Lines").
+		Where("load_id = ? AND kind = ? AND status <> ?", load.ID, kind, models.InvoiceStatusVoid).
+		First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	number, err := nextInvoiceNumber(tx, companyID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := models.Invoice{
+		Number:    number,
+		Kind:      kind,
+		Status:    models.InvoiceStatusDraft,
+		CompanyID: companyID,
+		LoadID:    load.ID,
+		Currency:  "USD",
+	}
+
+	// A carrier that has assigned its receivables to a factor is paid through the factor
+	if kind == models.InvoiceKindCarrierPayable {
+		var assignment models.NoticeOfAssignment
+		err := tx.Where("carrier_company_id = ? AND status = ?", companyID, models.AssignmentStatusActive).First(&assignment).Error
+		if err == nil {
+			invoice.PayeeCompanyID = &assignment.FactorCompanyID
+			invoice.AssignmentID = &assignment.ID
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	// Skip zero amount lines so an invoice only shows charges that apply
+	for _, line := range lines {
+		if line.AmountCents == 0 {
+			continue
+		}
+		invoice.Lines = append(invoice.Lines, line)
+		invoice.TotalCents += line.AmountCents
+	}
+
+	if err := tx.Create(&invoice).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// nextInvoiceNumber reserves the next number in the company's sequence for the given kind
+func nextInvoiceNumber(tx *gorm.DB, companyID uuid.UUID, kind models.InvoiceKind) (string, error) {
+	prefix := "INV"
+	if kind == models.InvoiceKindCarrierPayable {
+		prefix = "PAY"
+	}
+
+	// Make sure the sequence row exists, then lock it for the rest of the transaction
+	initial := models.InvoiceSequence{CompanyID: companyID, Kind: kind, Prefix: prefix, NextNumber: 1}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&initial).Error; err != nil {
+		return "", err
+	}
+	var seq models.InvoiceSequence
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("company_id = ? AND kind = ?", companyID, kind).
+		First(&seq).Error; err != nil {
+		return "", err
+	}
+
+	number := fmt.Sprintf("%s-%06d", seq.Prefix, seq.NextNumber)
+	if err := tx.Model(&seq).Update("next_number", seq.NextNumber+1).Error; err != nil {
+		return "", err
+	}
+	return number, nil
+}
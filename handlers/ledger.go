@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"cargozig_api/ledger"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// SetupLedgerRoutes sets up the read-only ledger routes
+// /api/ledger
+func SetupLedgerRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+	router.Use(middleware.RequirePermission(models.ManagePayments))
+
+	router.Get("/balances", LedgerBalances)
+	router.Get("/entries", LedgerEntries)
+}
+
+// LedgerBalances returns the balance of every account a company has
+func LedgerBalances(c *fiber.Ctx) error {
+	companyID, err := ledgerCompanyID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	balances, err := ledger.CompanyBalances(requestDB(c), companyID)
+	if err != nil {
+		fmt.Println("Error loading ledger balances:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load balances"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "company_id": companyID, "balances": balances})
+}
+
+// LedgerEntries lists journal entries touching a company's accounts
+func LedgerEntries(c *fiber.Ctx) error {
+	companyID, err := ledgerCompanyID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	db := requestDB(c)
+	query := db.Preload("Lines.Account").
+		Where("id IN (?)", db.Table("journal_lines").
+			Select("journal_lines.entry_id").
+			Joins("JOIN ledger_accounts ON ledger_accounts.id = journal_lines.account_id").
+			Where("ledger_accounts.company_id = ?", companyID))
+	if loadID := c.Query("load_id"); loadID != "" {
+		query = query.Where("load_id = ?", loadID)
+	}
+
+	var entries []models.JournalEntry
+	if err := query.Order("posted_at DESC").Limit(c.QueryInt("limit", 100)).Find(&entries).Error; err != nil {
+		fmt.Println("Error loading ledger entries:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load entries"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "entries": entries})
+}
+
+// ledgerCompanyID returns the company whose ledger is requested. Only system admins
+// may look at another company's books.
+func ledgerCompanyID(c *fiber.Ctx) (uuid.UUID, error) {
+	user := currentUser(c)
+	if requested := c.Query("company_id"); requested != "" && user.HasPermission(models.SystemAdmin) {
+		return uuid.Parse(requested)
+	}
+	return user.CompanyID, nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"cargozig_api/listing"
+	"cargozig_api/models"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// userListSpec is how superadmin user lists may be sorted, filtered and searched
+var userListSpec = listing.Spec{
+	Sorts: map[string]string{
+		"username":   "username",
+		"email":      "email",
+		"created_at": "created_at",
+		"last_login": "last_login",
+	},
+	DefaultSort: "username",
+	Search:      models.UserSearch,
+	Filters:     []string{listing.FilterCompany, listing.FilterActive},
+}
+
+// companyListSpec is how superadmin company lists may be sorted, filtered and searched
+var companyListSpec = listing.Spec{
+	Sorts: map[string]string{
+		"name":         "name",
+		"email":        "email",
+		"company_type": "company_type",
+		"created_at":   "created_at",
+	},
+	DefaultSort: "name",
+	Search:      models.CompanySearch,
+	Filters:     []string{listing.FilterActive, listing.FilterVerified},
+}
+
+// userListQuery is every user a superadmin user list covers, limited to those holding role
+// when given
+func userListQuery(c *fiber.Ctx, role models.Role) *gorm.DB {
+	query := requestDB(c).Model(&models.User{})
+	if role != "" {
+		query = query.Where("? = ANY(roles)", role)
+	}
+	return query
+}
+
+// companyListQuery is every company a superadmin company list covers, optionally of one
+// company type
+func companyListQuery(c *fiber.Ctx, companyType string) *gorm.DB {
+	query := requestDB(c).Model(&models.Company{})
+	if companyType != "" {
+		query = query.Where("company_type = ?", companyType)
+	}
+	return query
+}
+
+// listUsers loads the requested page of users, limited to those holding role when given
+func listUsers(c *fiber.Ctx, params listing.Params, role models.Role) ([]models.User, listing.Pagination, error) {
+	var users []models.User
+	page, err := listing.Find(userListQuery(c, role).Preload("Company"), userListSpec, params, &users)
+	return users, page, err
+}
+
+// listCompanies loads the requested page of companies, optionally of one company type
+func listCompanies(c *fiber.Ctx, params listing.Params, companyType string) ([]models.Company, listing.Pagination, error) {
+	var companies []models.Company
+	page, err := listing.Find(companyListQuery(c, companyType), companyListSpec, params, &companies)
+	return companies, page, err
+}
+
+// SuperAdminListUsers lists users a page at a time, optionally only those with ?role=
+//
+//	GET /superadmin/api/users?q=&role=&company_id=&active=&created_from=&created_to=&sort=-created_at&page=&size=
+func SuperAdminListUsers(c *fiber.Ctx) error {
+	params, err := listing.Parse(c, userListSpec)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	users, page, err := listUsers(c, params, models.Role(c.Query("role")))
+	if err != nil {
+		fmt.Println("Error listing users:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list users"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "users": users, "pagination": page})
+}
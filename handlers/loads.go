@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/config"
+	"cargozig_api/ledger"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"cargozig_api/tenant"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SetupLoadRoutes sets up the load routes
+// /api/loads
+func SetupLoadRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	router.Get("/", middleware.RequirePermission(models.ViewShipment), ListLoads)
+	router.Post("/", middleware.RequirePermission(models.CreateShipment), CreateLoad)
+	router.Get("/:id", middleware.RequirePermission(models.ViewShipment), middleware.Authorize(authz.LoadView, loadResource), GetLoad)
+	router.Patch("/:id", middleware.RequirePermission(models.EditShipment), middleware.Authorize(authz.LoadEdit, loadResource), UpdateLoad)
+	router.Post("/:id/post", middleware.RequirePermission(models.EditShipment), middleware.Authorize(authz.LoadPost, loadResource), PostLoad)
+	router.Get("/:id/bids", middleware.RequirePermission(models.ViewShipment), middleware.Authorize(authz.LoadView, loadResource), ListLoadBids)
+	router.Post("/:id/bids", middleware.RequirePermission(models.ViewShipment), middleware.Authorize(authz.LoadBid, loadResource), CreateLoadBid)
+	router.Post("/:id/bids/:bidID/accept", middleware.RequirePermission(models.EditShipment), middleware.Authorize(authz.LoadAward, loadResource), AcceptLoadBid)
+	// Carriers deliver without EditShipment, authz.LoadPickup and authz.LoadRecordPOD decide who may record it
+	router.Post("/:id/pickup", middleware.RequirePermission(models.ViewShipment), middleware.RequireVerifiedCompany(), middleware.Authorize(authz.LoadPickup, loadResource), RecordLoadPickup)
+	router.Post("/:id/pod", middleware.RequirePermission(models.ViewShipment), middleware.RequireVerifiedCompany(), middleware.Authorize(authz.LoadRecordPOD, loadResource), RecordLoadPOD)
+	router.Post("/:id/cancel", middleware.RequirePermission(models.EditShipment), middleware.Authorize(authz.LoadCancel, loadResource), CancelLoad)
+	router.Post("/:id/complete", middleware.RequirePermission(models.EditShipment), middleware.Authorize(authz.LoadComplete, loadResource), CompleteLoad)
+}
+
+// currentUser returns the user loaded by middleware.LoadUser
+func currentUser(c *fiber.Ctx) *models.User {
+	user, _ := c.Locals("user").(*models.User)
+	return user
+}
+
+// requestDB returns the database scoped to the current user's company, see package tenant
+func requestDB(c *fiber.Ctx) *gorm.DB {
+	return config.GetDB().WithContext(c.UserContext())
+}
+
+// CreateLoad creates a new load for the shipper company
+func CreateLoad(c *fiber.Ctx) error {
+	var req struct {
+		ReferenceNumber      string     `json:"reference_number"`
+		ShipperCompanyID     string     `json:"shipper_company_id"`
+		CarrierCompanyID     string     `json:"carrier_company_id"`
+		Origin               string     `json:"origin"`
+		Destination          string     `json:"destination"`
+		PickupAt             *time.Time `json:"pickup_at"`
+		ShipperLinehaulCents int64      `json:"shipper_linehaul_cents"`
+		ShipperFuelCents     int64      `json:"shipper_fuel_cents"`
+		CarrierLinehaulCents int64      `json:"carrier_linehaul_cents"`
+		CarrierFuelCents     int64      `json:"carrier_fuel_cents"`
+		DetentionCents       int64      `json:"detention_cents"`
+		LumperCents          int64      `json:"lumper_cents"`
+		TONUCents            int64      `json:"tonu_cents"`
+	}
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if strings.TrimSpace(req.Origin) == "" || strings.TrimSpace(req.Destination) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing required fields"})
+	}
+	for _, cents := range []int64{
+		req.ShipperLinehaulCents, req.ShipperFuelCents, req.CarrierLinehaulCents, req.CarrierFuelCents,
+		req.DetentionCents, req.LumperCents, req.TONUCents,
+	} {
+		if cents < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Charges cannot be negative"})
+		}
+	}
+
+	user := currentUser(c)
+
+	// Shippers create loads for their own company, admins may create on behalf of a shipper
+	shipperID := user.CompanyID
+	if req.ShipperCompanyID != "" && user.HasPermission(models.SystemAdmin) {
+		id, err := uuid.Parse(req.ShipperCompanyID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shipper company ID"})
+		}
+		shipperID = id
+	}
+
+	load := models.Load{
+		ReferenceNumber:      strings.TrimSpace(req.ReferenceNumber),
+		ShipperCompanyID:     shipperID,
+		Origin:               strings.TrimSpace(req.Origin),
+		Destination:          strings.TrimSpace(req.Destination),
+		PickupAt:             req.PickupAt,
+		Status:               models.LoadStatusDraft,
+		ShipperLinehaulCents: req.ShipperLinehaulCents,
+		ShipperFuelCents:     req.ShipperFuelCents,
+		CarrierLinehaulCents: req.CarrierLinehaulCents,
+		CarrierFuelCents:     req.CarrierFuelCents,
+		DetentionCents:       req.DetentionCents,
+		LumperCents:          req.LumperCents,
+		TONUCents:            req.TONUCents,
+	}
+
+	if req.CarrierCompanyID != "" {
+		id, err := uuid.Parse(req.CarrierCompanyID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid carrier company ID"})
+		}
+		if reason, ok := bookableCarrier(id); !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": reason})
+		}
+		load.CarrierCompanyID = &id
+		load.Status = models.LoadStatusBooked
+	}
+
+	db := requestDB(c)
+	if err := db.Create(&load).Error; err != nil {
+		fmt.Println("Error creating load:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create load"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"load":   load,
+	})
+}
+
+// ListLoads lists loads visible to the current user's company. Carriers also see the load
+// board: every posted load, whichever shipper posted it, so they can bid.
+func ListLoads(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	query := db.Model(&models.Load{})
+	switch {
+	case user.HasPermission(models.SystemAdmin):
+	case hasRole(user, models.RoleCarrier):
+		query = tenant.System(db, "load-board").Model(&models.Load{}).
+			Where("shipper_company_id = ? OR carrier_company_id = ? OR status = ?", user.CompanyID, user.CompanyID, models.LoadStatusPosted)
+	default:
+		query = query.Where("shipper_company_id = ? OR carrier_company_id = ?", user.CompanyID, user.CompanyID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var loads []models.Load
+	if err := query.Order("created_at DESC").Find(&loads).Error; err != nil {
+		fmt.Println("Error listing loads:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list loads"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "loads": loads})
+}
+
+// GetLoad returns a single load
+func GetLoad(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+
+	return c.JSON(fiber.Map{"status": "success", "load": load})
+}
+
+// RecordLoadPickup marks a booked load as picked up and in transit
+func RecordLoadPickup(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+	if load.Status != models.LoadStatusBooked {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("Load is %s, only booked loads can be picked up", load.Status)})
+	}
+
+	now := time.Now()
+	load.PickedUpAt = &now
+	load.Status = models.LoadStatusInTransit
+	result := requestDB(c).Model(load).Where("status = ?", models.LoadStatusBooked).
+		Select("picked_up_at", "status").Updates(load)
+	if result.Error != nil {
+		fmt.Println("Error recording pickup:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not record pickup"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Load has changed, reload and try again"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Pickup recorded", "load": load})
+}
+
+// RecordLoadPOD records proof of delivery for a load in transit or delivered. Escrow stays
+// with the platform until the load is completed, see CompleteLoad.
+func RecordLoadPOD(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+	if load.Status != models.LoadStatusInTransit && load.Status != models.LoadStatusDelivered {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("Load is %s, proof of delivery is recorded once it is in transit", load.Status)})
+	}
+
+	now := time.Now()
+	load.PODReceivedAt = &now
+	if load.DeliveredAt == nil {
+		load.DeliveredAt = &now
+	}
+	load.Status = models.LoadStatusDelivered
+	result := requestDB(c).Model(load).
+		Where("status IN ?", []models.LoadStatus{models.LoadStatusInTransit, models.LoadStatusDelivered}).
+		Select("pod_received_at", "delivered_at", "status").Updates(load)
+	if result.Error != nil {
+		fmt.Println("Error recording POD:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not record proof of delivery"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Load has changed, reload and try again"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Proof of delivery recorded", "load": load})
+}
+
+// CancelLoad cancels a load and refunds any escrow to the shipper
+func CancelLoad(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+	if load.Status == models.LoadStatusCompleted || load.Status == models.LoadStatusCancelled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("Load is already %s", load.Status)})
+	}
+
+	db := requestDB(c)
+	user := currentUser(c)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		load.Status = models.LoadStatusCancelled
+		load.CancelledAt = &now
+		if err := tx.Save(load).Error; err != nil {
+			return err
+		}
+
+		var escrow models.Escrow
+		if err := tx.Where("load_id = ? AND status IN ?", load.ID,
+			[]models.EscrowStatus{models.EscrowStatusFunded, models.EscrowStatusHeld}).First(&escrow).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil // Nothing to refund
+			}
+			return err
+		}
+		_, err := ledger.Refund(tx, escrow.ID, &user.ID)
+		return err
+	})
+	if err != nil {
+		fmt.Println("Error cancelling load:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not cancel load"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Load cancelled", "load": load})
+}
+
+// CompleteLoad marks a load as completed, releases any escrow to the carrier once proof of
+// delivery is in, and generates its shipper invoice and carrier payable
+func CompleteLoad(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+
+	if load.Status == models.LoadStatusCompleted {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Load is already completed"})
+	}
+	if load.Status == models.LoadStatusCancelled && load.TONUCents == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Cancelled load has no TONU charge to bill"})
+	}
+	if load.CarrierCompanyID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Load has no carrier assigned"})
+	}
+
+	db := requestDB(c)
+	tx := db.Begin()
+	if tx.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start transaction"})
+	}
+
+	now := time.Now()
+	if load.DeliveredAt == nil {
+		load.DeliveredAt = &now
+	}
+	load.CompletedAt = &now
+	load.Status = models.LoadStatusCompleted
+	if err := tx.Save(load).Error; err != nil {
+		tx.Rollback()
+		fmt.Println("Error completing load:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not complete load"})
+	}
+
+	// The load is only completed if escrow is released to the carrier with it
+	if err := releaseLoadEscrow(tx, load, &currentUser(c).ID); err != nil {
+		tx.Rollback()
+		if errors.Is(err, ledger.ErrPODRequired) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Record proof of delivery before completing a funded load"})
+		}
+		fmt.Println("Error releasing escrow:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not release escrow"})
+	}
+
+	invoices, err := generateLoadInvoices(tx, load)
+	if err != nil {
+		tx.Rollback()
+		fmt.Println("Error generating invoices:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate invoices"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		fmt.Println("Error committing transaction:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not complete load"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":   "success",
+		"message":  "Load completed",
+		"load":     load,
+		"invoices": invoices,
+	})
+}
+
+// releaseLoadEscrow releases the load's live escrow to the carrier, if it has one
+func releaseLoadEscrow(tx *gorm.DB, load *models.Load, actorID *uuid.UUID) error {
+	var escrow models.Escrow
+	if err := tx.Where("load_id = ? AND status IN ?", load.ID,
+		[]models.EscrowStatus{models.EscrowStatusFunded, models.EscrowStatusHeld}).First(&escrow).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // Nothing to release
+		}
+		return err
+	}
+	_, err := ledger.Release(tx, escrow.ID, actorID)
+	return err
+}
+
+// UpdateLoad changes a draft load's details
+func UpdateLoad(c *fiber.Ctx) error {
+	var req struct {
+		ReferenceNumber      *string    `json:"reference_number"`
+		Origin               *string    `json:"origin"`
+		Destination          *string    `json:"destination"`
+		PickupAt             *time.Time `json:"pickup_at"`
+		ShipperLinehaulCents *int64     `json:"shipper_linehaul_cents"`
+		ShipperFuelCents     *int64     `json:"shipper_fuel_cents"`
+		CarrierLinehaulCents *int64     `json:"carrier_linehaul_cents"`
+		CarrierFuelCents     *int64     `json:"carrier_fuel_cents"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	load := authorizedLoad(c)
+	if req.ReferenceNumber != nil {
+		load.ReferenceNumber = strings.TrimSpace(*req.ReferenceNumber)
+	}
+	if req.Origin != nil {
+		load.Origin = strings.TrimSpace(*req.Origin)
+	}
+	if req.Destination != nil {
+		load.Destination = strings.TrimSpace(*req.Destination)
+	}
+	if req.PickupAt != nil {
+		load.PickupAt = req.PickupAt
+	}
+	for _, charge := range []struct {
+		value *int64
+		field *int64
+	}{
+		{req.ShipperLinehaulCents, &load.ShipperLinehaulCents},
+		{req.ShipperFuelCents, &load.ShipperFuelCents},
+		{req.CarrierLinehaulCents, &load.CarrierLinehaulCents},
+		{req.CarrierFuelCents, &load.CarrierFuelCents},
+	} {
+		if charge.value == nil {
+			continue
+		}
+		if *charge.value < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Charges cannot be negative"})
+		}
+		*charge.field = *charge.value
+	}
+	if load.Origin == "" || load.Destination == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing required fields"})
+	}
+
+	if err := requestDB(c).Save(load).Error; err != nil {
+		fmt.Println("Error updating load:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update load"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "load": load})
+}
+
+// PostLoad publishes a draft load so carriers can see and bid on it
+func PostLoad(c *fiber.Ctx) error {
+	load := authorizedLoad(c)
+	if load.PickupAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Set a pickup time before posting"})
+	}
+
+	load.Status = models.LoadStatusPosted
+	if err := requestDB(c).Model(load).Update("status", load.Status).Error; err != nil {
+		fmt.Println("Error posting load:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not post load"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Load posted", "load": load})
+}
+
+// loadResource fetches the route's load for middleware.Authorize. It is not tenant scoped:
+// the policy decides who may see a load, e.g. carriers can view posted loads.
+func loadResource(c *fiber.Ctx) (interface{}, error) {
+	return authz.Find(tenant.System(requestDB(c), "load-authorize"), "load", c.Params("id"))
+}
+
+// authorizedLoad returns the load checked by middleware.Authorize
+func authorizedLoad(c *fiber.Ctx) *models.Load {
+	load, _ := c.Locals("resource").(*models.Load)
+	return load
+}
+
+// findVisibleLoad loads a load that belongs to the current user's company
+func findVisibleLoad(c *fiber.Ctx, id string) (*models.Load, error) {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	query := db.Where("id = ?", id)
+	if !user.HasPermission(models.SystemAdmin) {
+		query = query.Where("shipper_company_id = ? OR carrier_company_id = ?", user.CompanyID, user.CompanyID)
+	}
+
+	var load models.Load
+	if err := query.First(&load).Error; err != nil {
+		return nil, err
+	}
+	return &load, nil
+}
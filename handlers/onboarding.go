@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"cargozig_api/compliance"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxDocumentSize is the largest compliance document that can be uploaded
+const maxDocumentSize = 10 << 20
+
+// allowedDocumentTypes are the content types accepted for compliance documents
+var allowedDocumentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+}
+
+var (
+	dotNumberPattern = regexp.MustCompile(`^[0-9]{1,8}$`)
+	mcNumberPattern  = regexp.MustCompile(`^[0-9]{1,8}$`)
+)
+
+// onboardingStep is one item of the carrier onboarding checklist
+type onboardingStep struct {
+	Step     string `json:"step"`
+	Label    string `json:"label"`
+	Complete bool   `json:"complete"`
+}
+
+// SetupOnboardingRoutes sets up carrier onboarding and the superadmin review queue
+// /api/onboarding
+func SetupOnboardingRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+
+	// Superadmin review queue
+	router.Get("/review", middleware.RequirePermission(models.SystemAdmin), ListOnboardingReviews)
+	router.Get("/review/:companyId", middleware.RequirePermission(models.SystemAdmin), GetOnboardingReview)
+	router.Post("/review/:companyId/approve", middleware.RequirePermission(models.SystemAdmin), ApproveOnboarding)
+	router.Post("/review/:companyId/reject", middleware.RequirePermission(models.SystemAdmin), RejectOnboarding)
+
+	// Carrier steps
+	carrier := middleware.RequireRole(models.RoleCarrier)
+	router.Get("/", carrier, GetOnboarding)
+	router.Put("/authority", carrier, UpdateCarrierAuthority)
+	router.Get("/documents", carrier, ListCompanyDocuments)
+	router.Post("/documents", carrier, UploadCompanyDocument)
+	router.Get("/documents/:id/download", carrierOrReviewer(carrier), DownloadCompanyDocument)
+	router.Post("/bank-account", carrier, middleware.RequirePermission(models.ManagePayments), middleware.DenyWhileImpersonating(), CreateOnboardingBankAccount)
+	router.Post("/submit", carrier, SubmitOnboarding)
+}
+
+// carrierOrReviewer lets superadmins through as well as carriers, so reviewers can open
+// the documents in the review queue
+func carrierOrReviewer(carrier fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if currentUser(c).HasPermission(models.SystemAdmin) {
+			return c.Next()
+		}
+		return carrier(c)
+	}
+}
+
+// GetOnboarding returns the current company's onboarding status and checklist
+func GetOnboarding(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	company, onboarding, err := loadCarrierOnboarding(db, user.CompanyID)
+	if err != nil {
+		return onboardingError(c, err)
+	}
+
+	steps, err := onboardingChecklist(db, company)
+	if err != nil {
+		fmt.Println("Error building onboarding checklist:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load onboarding"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "onboarding": onboarding, "company": company, "steps": steps})
+}
+
+// UpdateCarrierAuthority records the carrier's MC/DOT numbers and operating authority status
+func UpdateCarrierAuthority(c *fiber.Ctx) error {
+	var req struct {
+		DOTNumber string `json:"dot_number"`
+		MCNumber  string `json:"mc_number"`
+		Authority string `json:"authority"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	req.DOTNumber = strings.TrimSpace(req.DOTNumber)
+	req.MCNumber = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(req.MCNumber)), "MC")
+	req.MCNumber = strings.TrimLeft(req.MCNumber, "- ")
+	req.Authority = strings.ToLower(strings.TrimSpace(req.Authority))
+
+	if !dotNumberPattern.MatchString(req.DOTNumber) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "DOT number must be 1 to 8 digits"})
+	}
+	if req.MCNumber != "" && !mcNumberPattern.MatchString(req.MCNumber) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "MC number must be 1 to 8 digits"})
+	}
+	if req.Authority != "active" && req.Authority != "inactive" && req.Authority != "pending" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Authority must be active, inactive or pending"})
+	}
+
+	user := currentUser(c)
+	db := requestDB(c)
+	company, onboarding, err := loadCarrierOnboarding(db, user.CompanyID)
+	if err != nil {
+		return onboardingError(c, err)
+	}
+	if onboarding.Status == models.OnboardingSubmitted || onboarding.Status == models.OnboardingApproved {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Authority details cannot change once onboarding is submitted"})
+	}
+
+	updates := map[string]interface{}{
+		"dot_number": req.DOTNumber,
+		"mc_number":  req.MCNumber,
+		"authority":  req.Authority,
+	}
+	if err := db.Model(company).Updates(updates).Error; err != nil {
+		fmt.Println("Error updating carrier authority:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update authority details"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "company": company})
+}
+
+// UploadCompanyDocument stores a compliance document uploaded as the "file" form field
+func UploadCompanyDocument(c *fiber.Ctx) error {
+	docType := models.DocumentType(c.FormValue("type"))
+	if !models.ValidDocumentType(docType) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid document type"})
+	}
+
+	upload, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A file is required"})
+	}
+	if upload.Size > maxDocumentSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "File is larger than 10MB"})
+	}
+	// The type is read from the file itself, not the client's Content-Type header, so it
+	// can be trusted when the document is sent back
+	file, err := upload.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read uploaded file"})
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	file.Close()
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := allowedDocumentTypes[contentType]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Only PDF, PNG and JPEG files are accepted"})
+	}
+
+	user := currentUser(c)
+	document := models.CompanyDocument{
+		CompanyID:    user.CompanyID,
+		Type:         docType,
+		FileName:     filepath.Base(upload.Filename),
+		ContentType:  contentType,
+		SizeBytes:    upload.Size,
+		UploadedByID: user.ID,
+	}
+	document.ID = uuid.New()
+
+	dir := filepath.Join(uploadDir(), "companies", user.CompanyID.String())
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		fmt.Println("Error creating upload directory:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not store document"})
+	}
+	document.StoragePath = filepath.Join(dir, document.ID.String()+ext)
+	if err := c.SaveFile(upload, document.StoragePath); err != nil {
+		fmt.Println("Error saving document:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not store document"})
+	}
+
+	if err := requestDB(c).Create(&document).Error; err != nil {
+		os.Remove(document.StoragePath)
+		fmt.Println("Error recording document:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not store document"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "document": document})
+}
+
+// ListCompanyDocuments lists the current company's documents
+func ListCompanyDocuments(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	query := requestDB(c).Where("company_id = ?", user.CompanyID)
+	if docType := c.Query("type"); docType != "" {
+		query = query.Where("type = ?", docType)
+	}
+
+	var documents []models.CompanyDocument
+	if err := query.Order("created_at DESC").Find(&documents).Error; err != nil {
+		fmt.Println("Error listing documents:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list documents"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "documents": documents})
+}
+
+// DownloadCompanyDocument sends a document belonging to the user's company, or any company for superadmins
+func DownloadCompanyDocument(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	query := requestDB(c).Where("id = ?", c.Params("id"))
+	if !user.HasPermission(models.SystemAdmin) {
+		query = query.Where("company_id = ?", user.CompanyID)
+	}
+
+	var document models.CompanyDocument
+	if err := query.First(&document).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Document not found"})
+	}
+
+	c.Attachment(document.FileName)
+	c.Set(fiber.HeaderContentType, document.ContentType)
+	return c.SendFile(document.StoragePath)
+}
+
+// CreateOnboardingBankAccount adds the payout account the onboarding checklist asks for.
+// Once the carrier has submitted for review, bank accounts are changed through
+// /api/payments instead, which requires a verified company.
+func CreateOnboardingBankAccount(c *fiber.Ctx) error {
+	_, onboarding, err := loadCarrierOnboarding(requestDB(c), currentUser(c).CompanyID)
+	if err != nil {
+		return onboardingError(c, err)
+	}
+	if onboarding.Status != models.OnboardingInProgress && onboarding.Status != models.OnboardingRejected {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Onboarding is already %s, manage bank accounts from payments instead", onboarding.Status),
+		})
+	}
+	return CreateBankAccount(c)
+}
+
+// SubmitOnboarding puts the carrier in the review queue once every step is complete
+func SubmitOnboarding(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+
+	company, onboarding, err := loadCarrierOnboarding(db, user.CompanyID)
+	if err != nil {
+		return onboardingError(c, err)
+	}
+	if onboarding.Status != models.OnboardingInProgress && onboarding.Status != models.OnboardingRejected {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("Onboarding is already %s", onboarding.Status)})
+	}
+
+	steps, err := onboardingChecklist(db, company)
+	if err != nil {
+		fmt.Println("Error building onboarding checklist:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not submit onboarding"})
+	}
+	for _, step := range steps {
+		if !step.Complete {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Onboarding is incomplete", "steps": steps})
+		}
+	}
+
+	now := time.Now()
+	onboarding.Status = models.OnboardingSubmitted
+	onboarding.SubmittedAt = &now
+	if err := db.Save(onboarding).Error; err != nil {
+		fmt.Println("Error submitting onboarding:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not submit onboarding"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "onboarding": onboarding})
+}
+
+// ListOnboardingReviews lists carriers waiting for review, or with another status via ?status=
+func ListOnboardingReviews(c *fiber.Ctx) error {
+	status := c.Query("status", string(models.OnboardingSubmitted))
+
+	var queue []models.CarrierOnboarding
+	err := requestDB(c).Preload("Company").
+		Where("status = ?", status).
+		Order("submitted_at ASC NULLS LAST, created_at ASC").
+		Find(&queue).Error
+	if err != nil {
+		fmt.Println("Error listing onboarding reviews:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list onboarding reviews"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "onboardings": queue})
+}
+
+// GetOnboardingReview returns everything a reviewer needs to vet a carrier
+func GetOnboardingReview(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Params("companyId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	db := requestDB(c)
+	company, onboarding, err := loadCarrierOnboarding(db, companyID)
+	if err != nil {
+		return onboardingError(c, err)
+	}
+
+	steps, err := onboardingChecklist(db, company)
+	if err != nil {
+		fmt.Println("Error building onboarding checklist:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load onboarding"})
+	}
+
+	var documents []models.CompanyDocument
+	db.Where("company_id = ?", companyID).Order("created_at DESC").Find(&documents)
+	var bankAccounts []models.BankAccount
+	db.Where("company_id = ?", companyID).Order("created_at DESC").Find(&bankAccounts)
+
+	return c.JSON(fiber.Map{
+		"status":        "success",
+		"onboarding":    onboarding,
+		"company":       company,
+		"steps":         steps,
+		"documents":     documents,
+		"bank_accounts": bankAccounts,
+	})
+}
+
+// ApproveOnboarding verifies the carrier, which lifts the gate on carrier actions
+func ApproveOnboarding(c *fiber.Ctx) error {
+	var req struct {
+		Notes string `json:"notes"`
+	}
+	c.BodyParser(&req)
+
+	return reviewOnboarding(c, models.OnboardingApproved, "", req.Notes)
+}
+
+// RejectOnboarding sends the carrier back to fix the problems given in the reason
+func RejectOnboarding(c *fiber.Ctx) error {
+	var req struct {
+		Reason string `json:"reason"`
+		Notes  string `json:"notes"`
+	}
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A rejection reason is required"})
+	}
+
+	return reviewOnboarding(c, models.OnboardingRejected, strings.TrimSpace(req.Reason), req.Notes)
+}
+
+// reviewOnboarding records a review decision on a submitted onboarding and
+// sets the company's verification to match
+func reviewOnboarding(c *fiber.Ctx, decision models.OnboardingStatus, reason, notes string) error {
+	companyID, err := uuid.Parse(c.Params("companyId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	user := currentUser(c)
+	var onboarding models.CarrierOnboarding
+
+	err = requestDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("company_id = ?", companyID).First(&onboarding).Error; err != nil {
+			return err
+		}
+		if onboarding.Status != models.OnboardingSubmitted {
+			return errNotSubmitted
+		}
+
+		now := time.Now()
+		onboarding.Status = decision
+		onboarding.ReviewedAt = &now
+		onboarding.ReviewedByID = &user.ID
+		onboarding.RejectionReason = reason
+		onboarding.ReviewNotes = notes
+		if err := tx.Save(&onboarding).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"verified": false, "verification_id": ""}
+		if decision == models.OnboardingApproved {
+			updates = map[string]interface{}{"verified": true, "verification_id": onboarding.ID.String()}
+
+			// Approval checks the certificates against their documents, so they count as reviewed
+			err := tx.Model(&models.InsuranceCertificate{}).
+				Where("company_id = ? AND status = ?", companyID, models.CertificatePending).
+				Updates(map[string]interface{}{"status": models.CertificateVerified, "reviewed_at": now, "reviewed_by_id": user.ID}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Company{}).Where("id = ?", companyID).Updates(updates).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Onboarding not found"})
+		}
+		if errors.Is(err, errNotSubmitted) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Only submitted onboardings can be reviewed"})
+		}
+		fmt.Println("Error reviewing onboarding:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not record review"})
+	}
+	if decision == models.OnboardingApproved {
+		reapplyHold(requestDB(c), companyID)
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "onboarding": onboarding})
+}
+
+// loadCarrierOnboarding loads a carrier company and its onboarding record, creating the record on first use
+func loadCarrierOnboarding(db *gorm.DB, companyID uuid.UUID) (*models.Company, *models.CarrierOnboarding, error) {
+	var company models.Company
+	if err := db.First(&company, "id = ?", companyID).Error; err != nil {
+		return nil, nil, err
+	}
+	if company.CompanyType == "shipper" {
+		return nil, nil, errNotCarrier
+	}
+
+	initial := models.CarrierOnboarding{CompanyID: companyID, Status: models.OnboardingInProgress}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&initial).Error; err != nil {
+		return nil, nil, err
+	}
+	var onboarding models.CarrierOnboarding
+	if err := db.Where("company_id = ?", companyID).First(&onboarding).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &company, &onboarding, nil
+}
+
+// onboardingChecklist works out which onboarding steps a carrier has completed
+func onboardingChecklist(db *gorm.DB, company *models.Company) ([]onboardingStep, error) {
+	hasDocument := func(docType models.DocumentType) (bool, error) {
+		var count int64
+		err := db.Model(&models.CompanyDocument{}).Where("company_id = ? AND type = ?", company.ID, docType).Count(&count).Error
+		return count > 0, err
+	}
+
+	w9, err := hasDocument(models.DocumentW9)
+	if err != nil {
+		return nil, err
+	}
+	insurance, err := hasDocument(models.DocumentInsuranceCertificate)
+	if err != nil {
+		return nil, err
+	}
+	// The certificate's details must also be entered and cover the required limits
+	problems, err := compliance.CoverageProblems(db, company.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var bankAccounts int64
+	if err := db.Model(&models.BankAccount{}).Where("company_id = ?", company.ID).Count(&bankAccounts).Error; err != nil {
+		return nil, err
+	}
+
+	return []onboardingStep{
+		{Step: "numbers", Label: "MC/DOT numbers", Complete: company.DOTNumber != ""},
+		{Step: "w9", Label: "W-9", Complete: w9},
+		{Step: "insurance", Label: "Insurance certificate", Complete: insurance && len(problems) == 0},
+		{Step: "authority", Label: "Active operating authority", Complete: company.Authority == "active"},
+		{Step: "banking", Label: "Banking details", Complete: bankAccounts > 0},
+	}, nil
+}
+
+// onboardingError maps the errors from loadCarrierOnboarding to responses
+func onboardingError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	case errors.Is(err, errNotCarrier):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Onboarding is only for carrier companies"})
+	}
+	fmt.Println("Error loading onboarding:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load onboarding"})
+}
+
+// uploadDir is where uploaded files are stored, UPLOAD_DIR or ./uploads
+func uploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+var (
+	errNotCarrier   = errors.New("company is not a carrier")
+	errNotSubmitted = errors.New("onboarding is not submitted")
+)
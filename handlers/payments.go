@@ -0,0 +1,620 @@
+package handlers
+
+import (
+	"cargozig_api/config"
+	"cargozig_api/ledger"
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"cargozig_api/payments"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SetupPaymentRoutes sets up the payment routes
+// /api/payments
+func SetupPaymentRoutes(router fiber.Router) {
+	// Deliver sandbox status changes through the same code path as the webhook
+	if sandbox, ok := payments.Default().(*payments.Sandbox); ok {
+		sandbox.SetEventSink(deliverSandboxEvent)
+	}
+
+	// Provider webhook (signature verified, no user auth) - must come first
+	if len(payments.WebhookSecret()) == 0 {
+		fmt.Println("Warning: PAYMENTS_WEBHOOK_SECRET is not set, payment webhooks will be refused")
+	}
+	router.Post("/webhook", PaymentWebhook)
+
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+	router.Use(middleware.RequirePermission(models.ManagePayments))
+
+	router.Get("/bank-accounts", ListBankAccounts)
+	router.Post("/bank-accounts", middleware.RequireVerifiedCompany(), middleware.DenyWhileImpersonating(), CreateBankAccount)
+	router.Get("/transactions", ListPaymentTransactions)
+	router.Post("/escrow-funding", middleware.DenyWhileImpersonating(), FundEscrowByACH)
+	router.Post("/payouts", middleware.DenyWhileImpersonating(), CreatePayout)
+	router.Post("/refunds", middleware.DenyWhileImpersonating(), CreateRefund)
+
+	// Sandbox helpers for exercising returned ACH end to end
+	router.Post("/sandbox/transfers/:ref/return", middleware.RequirePermission(models.SystemAdmin), SandboxReturnTransfer)
+}
+
+// CreateBankAccount tokenizes a bank account with the processor and stores the token
+func CreateBankAccount(c *fiber.Ctx) error {
+	var req struct {
+		CompanyID     string `json:"company_id"`
+		HolderName    string `json:"holder_name"`
+		RoutingNumber string `json:"routing_number"`
+		AccountNumber string `json:"account_number"`
+		AccountType   string `json:"account_type"`
+		Default       bool   `json:"default"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	companyID, err := requestedCompanyID(c, req.CompanyID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	account := payments.BankAccount{
+		HolderName:    strings.TrimSpace(req.HolderName),
+		RoutingNumber: strings.TrimSpace(req.RoutingNumber),
+		AccountNumber: strings.TrimSpace(req.AccountNumber),
+		AccountType:   strings.ToLower(strings.TrimSpace(req.AccountType)),
+	}
+
+	processor := payments.Default()
+	tokenized, err := processor.TokenizeBankAccount(c.Context(), account)
+	if err != nil {
+		if errors.Is(err, payments.ErrInvalidBankAccount) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		fmt.Println("Error tokenizing bank account:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not tokenize bank account"})
+	}
+
+	bankAccount := models.BankAccount{
+		CompanyID:     companyID,
+		Processor:     processor.Name(),
+		Token:         tokenized.Token,
+		HolderName:    account.HolderName,
+		RoutingNumber: models.SealedString(account.RoutingNumber),
+		AccountNumber: models.SealedString(account.AccountNumber),
+		AccountLast4:  tokenized.AccountLast4,
+		AccountType:   account.AccountType,
+		IsDefault:     req.Default,
+	}
+
+	db := requestDB(c)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if bankAccount.IsDefault {
+			if err := tx.Model(&models.BankAccount{}).Where("company_id = ?", companyID).Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&bankAccount).Error
+	})
+	if err != nil {
+		fmt.Println("Error saving bank account:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save bank account"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "bank_account": bankAccount})
+}
+
+// ListBankAccounts lists a company's bank accounts
+func ListBankAccounts(c *fiber.Ctx) error {
+	companyID, err := requestedCompanyID(c, c.Query("company_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	var accounts []models.BankAccount
+	if err := requestDB(c).Where("company_id = ?", companyID).Order("created_at DESC").Find(&accounts).Error; err != nil {
+		fmt.Println("Error listing bank accounts:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list bank accounts"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "bank_accounts": accounts})
+}
+
+// ListPaymentTransactions lists a company's payment transactions
+func ListPaymentTransactions(c *fiber.Ctx) error {
+	companyID, err := requestedCompanyID(c, c.Query("company_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+	}
+
+	query := requestDB(c).Where("company_id = ?", companyID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var transactions []models.PaymentTransaction
+	if err := query.Order("created_at DESC").Find(&transactions).Error; err != nil {
+		fmt.Println("Error listing payment transactions:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list transactions"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "transactions": transactions})
+}
+
+// FundEscrowByACH funds a booked load's escrow by debiting the shipper's bank account
+func FundEscrowByACH(c *fiber.Ctx) error {
+	var req struct {
+		LoadID        string `json:"load_id"`
+		BankAccountID string `json:"bank_account_id"`
+		AmountCents   int64  `json:"amount_cents"`
+		FeeCents      int64  `json:"fee_cents"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	load, err := findVisibleLoad(c, req.LoadID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Load not found"})
+	}
+	if load.Status != models.LoadStatusBooked {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Only booked loads can be funded"})
+	}
+
+	db := requestDB(c)
+	var bankAccount models.BankAccount
+	if err := db.Where("id = ? AND company_id = ?", req.BankAccountID, load.ShipperCompanyID).First(&bankAccount).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Shipper bank account not found"})
+	}
+
+	if req.AmountCents <= 0 || req.FeeCents < 0 || req.FeeCents > req.AmountCents {
+		return escrowError(c, ledger.ErrInvalidAmount)
+	}
+	var funded, ended int64
+	if err := db.Model(&models.Escrow{}).Where("load_id = ? AND status NOT IN ?", load.ID, models.EndedEscrowStatuses).
+		Count(&funded).Error; err != nil {
+		return escrowError(c, err)
+	}
+	if funded > 0 {
+		return escrowError(c, ledger.ErrAlreadyFunded)
+	}
+	if err := db.Model(&models.Escrow{}).Where("load_id = ? AND status IN ?", load.ID, models.EndedEscrowStatuses).
+		Count(&ended).Error; err != nil {
+		return escrowError(c, err)
+	}
+
+	// The debit is submitted before the escrow is recorded, so no locks are held while the
+	// processor is called. A load has one live escrow at a time, so the load and the number
+	// of earlier refunded or returned escrows make the idempotency key: a retry after a
+	// failed save gets the same transfer back rather than a second debit.
+	idempotencyKey := "escrow:" + load.ID.String()
+	if ended > 0 {
+		idempotencyKey += fmt.Sprintf(":%d", ended+1)
+	}
+	user := currentUser(c)
+	processor := payments.Default()
+	transfer, err := submitTransfer(c, processor.DebitACH, &bankAccount, payments.TransferRequest{
+		AmountCents:    req.AmountCents,
+		Description:    "Escrow " + load.ReferenceNumber,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		fmt.Println("Error submitting escrow debit:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Payment processor rejected the transfer"})
+	}
+
+	var transaction models.PaymentTransaction
+	var escrow *models.Escrow
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		escrow, err = ledger.Fund(tx, load, req.AmountCents, req.FeeCents, &user.ID)
+		if err != nil {
+			return err
+		}
+
+		escrowID := escrow.ID
+		transaction = models.PaymentTransaction{
+			CompanyID:     load.ShipperCompanyID,
+			BankAccountID: bankAccount.ID,
+			Processor:     processor.Name(),
+			ProviderRef:   transfer.ID,
+			Direction:     string(transfer.Direction),
+			Purpose:       models.PurposeEscrowFunding,
+			EscrowID:      &escrowID,
+			AmountCents:   transfer.AmountCents,
+			Status:        string(transfer.Status),
+		}
+		return tx.Create(&transaction).Error
+	})
+	if err != nil {
+		fmt.Println("Error recording escrow debit", transfer.ID+":", err)
+		return escrowError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":      "success",
+		"escrow":      escrow,
+		"transaction": transaction,
+	})
+}
+
+// CreatePayout pays out part or all of a carrier's payable balance
+func CreatePayout(c *fiber.Ctx) error {
+	return disburse(c, models.PurposeCarrierPayout, payments.Default().Payout)
+}
+
+// CreateRefund credits a shipper's refunded escrow back to their bank account
+func CreateRefund(c *fiber.Ctx) error {
+	return disburse(c, models.PurposeRefund, payments.Default().CreditACH)
+}
+
+// disburse pays money the platform owes a company out to one of its bank accounts
+func disburse(c *fiber.Ctx, purpose models.PaymentPurpose, send func(context.Context, payments.TransferRequest) (*payments.Transfer, error)) error {
+	var req struct {
+		BankAccountID string `json:"bank_account_id"`
+		AmountCents   int64  `json:"amount_cents"`
+		InvoiceID     string `json:"invoice_id"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.AmountCents <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Amount must be positive"})
+	}
+
+	db := requestDB(c)
+	var bankAccount models.BankAccount
+	if err := db.Where("id = ?", req.BankAccountID).First(&bankAccount).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Bank account not found"})
+	}
+	if _, err := requestedCompanyID(c, bankAccount.CompanyID.String()); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Bank account not found"})
+	}
+
+	if purpose == models.PurposeCarrierPayout {
+		var carrier models.Company
+		if err := db.Where("id = ?", bankAccount.CompanyID).First(&carrier).Error; err != nil || !carrier.Verified {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Carrier has not passed onboarding review"})
+		}
+	}
+
+	var invoiceID *uuid.UUID
+	var invoice models.Invoice
+	if req.InvoiceID != "" {
+		if err := db.Where("id = ? AND company_id = ? AND kind = ?", req.InvoiceID, bankAccount.CompanyID, models.InvoiceKindCarrierPayable).
+			First(&invoice).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Carrier payable not found"})
+		}
+		if invoice.PayeeCompanyID != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Payable is assigned to a factoring company and is paid through the ACH file"})
+		}
+		invoiceID = &invoice.ID
+	}
+
+	// The amount is reserved as a pending transaction while the payable account is locked,
+	// so concurrent payouts can't both spend the same balance. The processor is called once
+	// the reservation is committed.
+	transaction := models.PaymentTransaction{
+		CompanyID:     bankAccount.CompanyID,
+		BankAccountID: bankAccount.ID,
+		Processor:     payments.Default().Name(),
+		Purpose:       purpose,
+		InvoiceID:     invoiceID,
+		AmountCents:   req.AmountCents,
+		Status:        string(payments.StatusPending),
+	}
+	transaction.ID = uuid.New()
+	transaction.ProviderRef = "pending:" + transaction.ID.String()
+
+	var available int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if available, err = availablePayable(tx, bankAccount.CompanyID); err != nil {
+			return err
+		}
+		if req.AmountCents > available {
+			return errPayableExceeded
+		}
+		if invoiceID != nil {
+			if err := checkPayableDisbursement(tx, &invoice, req.AmountCents); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&transaction).Error
+	})
+	var payableErr *payableDisbursementError
+	if errors.As(err, &payableErr) {
+		return c.Status(payableErr.status).JSON(fiber.Map{"error": payableErr.message})
+	}
+	if errors.Is(err, errPayableExceeded) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":           "Amount exceeds the available payable balance",
+			"available_cents": available,
+		})
+	}
+	if err != nil {
+		fmt.Println("Error reserving payout:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not compute payable balance"})
+	}
+
+	transfer, err := submitTransfer(c, send, &bankAccount, payments.TransferRequest{
+		AmountCents:    req.AmountCents,
+		Description:    string(purpose),
+		IdempotencyKey: "disbursement:" + transaction.ID.String(),
+	})
+	if err != nil {
+		fmt.Println("Error submitting transfer:", err)
+		now := time.Now()
+		if err := db.Model(&transaction).Updates(map[string]interface{}{"status": string(payments.StatusFailed), "failed_at": now}).Error; err != nil {
+			fmt.Println("Error releasing payout reservation:", err)
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Payment processor rejected the transfer"})
+	}
+
+	transaction.ProviderRef = transfer.ID
+	transaction.Direction = string(transfer.Direction)
+	transaction.AmountCents = transfer.AmountCents
+	transaction.Status = string(transfer.Status)
+	if err := db.Model(&transaction).Select("provider_ref", "direction", "amount_cents", "status").Updates(&transaction).Error; err != nil {
+		fmt.Println("Error saving payment transaction:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Transfer submitted but could not be recorded"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "transaction": transaction})
+}
+
+// PaymentWebhook receives transfer status changes from the payment processor
+func PaymentWebhook(c *fiber.Ctx) error {
+	event, err := payments.Default().ParseWebhook(c.Body(), c.Get("X-Payments-Signature"))
+	if errors.Is(err, payments.ErrWebhookNotConfigured) {
+		fmt.Println("Refusing payment webhook:", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Webhook is not configured"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid webhook"})
+	}
+
+	if err := ApplyPaymentEvent(*event); err != nil {
+		fmt.Println("Error applying payment event:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not apply event"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// SandboxReturnTransfer forces a settled sandbox transfer to come back returned
+func SandboxReturnTransfer(c *fiber.Ctx) error {
+	if os.Getenv("APP_ENV") == "production" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "This endpoint is disabled in production"})
+	}
+
+	sandbox, ok := payments.Default().(*payments.Sandbox)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Sandbox processor is not in use"})
+	}
+
+	var req struct {
+		ReturnCode string `json:"return_code"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.ReturnCode == "" {
+		req.ReturnCode = "R01"
+	}
+
+	if err := sandbox.Return(c.Params("ref"), req.ReturnCode); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Transfer returned"})
+}
+
+// ApplyPaymentEvent updates the matching payment transaction and posts the ledger entries
+// for the status change. Duplicate and out-of-order events are ignored.
+func ApplyPaymentEvent(event payments.Event) error {
+	db := config.GetDB()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var transaction models.PaymentTransaction
+		if err := tx.Where("provider_ref = ?", event.TransferID).First(&transaction).Error; err != nil {
+			return err
+		}
+
+		current := payments.TransferStatus(transaction.Status)
+		if current == event.Status || current.Final() || !advances(current, event.Status) {
+			return nil
+		}
+		wasSettled := current == payments.StatusSettled
+
+		now := time.Now()
+		transaction.Status = string(event.Status)
+		transaction.LastEventID = event.ID
+		if event.ReturnCode != "" {
+			transaction.ReturnCode = event.ReturnCode
+		}
+
+		reference := "payment:" + transaction.ProviderRef
+		isDebit := transaction.Purpose == models.PurposeEscrowFunding
+
+		switch event.Status {
+		case payments.StatusSettled:
+			transaction.SettledAt = &now
+			if isDebit {
+				if err := ledger.SettleDebit(tx, transaction.CompanyID, transaction.AmountCents, reference); err != nil {
+					return err
+				}
+			} else {
+				if err := ledger.SettlePayout(tx, transaction.CompanyID, transaction.AmountCents, reference); err != nil {
+					return err
+				}
+				if transaction.InvoiceID != nil {
+					if err := tx.Model(&models.Invoice{}).Where("id = ?", *transaction.InvoiceID).
+						Updates(map[string]interface{}{"status": models.InvoiceStatusPaid, "paid_at": now}).Error; err != nil {
+						return err
+					}
+				}
+			}
+
+		case payments.StatusFailed, payments.StatusReturned:
+			if event.Status == payments.StatusFailed {
+				transaction.FailedAt = &now
+			} else {
+				transaction.ReturnedAt = &now
+			}
+			fmt.Printf("Payment %s %s with code %s\n", transaction.ProviderRef, event.Status, event.ReturnCode)
+
+			if isDebit {
+				if err := ledger.ReturnDebit(tx, transaction.CompanyID, transaction.AmountCents, wasSettled, transaction.EscrowID, reference); err != nil {
+					return err
+				}
+			} else if wasSettled {
+				if err := ledger.ReturnPayout(tx, transaction.CompanyID, transaction.AmountCents, reference); err != nil {
+					return err
+				}
+				// The payable is owed again, so it is marked failed until it is re-approved
+				if transaction.InvoiceID != nil {
+					if err := tx.Model(&models.Invoice{}).Where("id = ?", *transaction.InvoiceID).
+						Updates(map[string]interface{}{"status": models.InvoiceStatusFailed, "paid_at": nil, "return_code": event.ReturnCode}).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return tx.Save(&transaction).Error
+	})
+}
+
+// advances reports whether a transfer may move from one status to another
+func advances(from, to payments.TransferStatus) bool {
+	order := map[payments.TransferStatus]int{
+		payments.StatusPending:    0,
+		payments.StatusProcessing: 1,
+		payments.StatusSettled:    2,
+		payments.StatusFailed:     3,
+		payments.StatusReturned:   3,
+	}
+	if to == payments.StatusFailed && from == payments.StatusSettled {
+		return false // A settled transfer can only come back as a return
+	}
+	if to == payments.StatusReturned && from != payments.StatusSettled {
+		return false
+	}
+	return order[to] > order[from]
+}
+
+// deliverSandboxEvent applies a sandbox event, retrying briefly in case the
+// event arrives before the transaction that caused it has been committed
+func deliverSandboxEvent(event payments.Event) {
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = ApplyPaymentEvent(event); err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if err != nil {
+		fmt.Printf("Error applying sandbox event %s: %v\n", event.ID, err)
+	}
+}
+
+// errPayableExceeded is returned when a disbursement is more than the available payable balance
+var errPayableExceeded = errors.New("amount exceeds the available payable balance")
+
+// payableDisbursementError is why a payable can't be paid out as requested
+type payableDisbursementError struct {
+	status  int
+	message string
+}
+
+func (e *payableDisbursementError) Error() string { return e.message }
+
+// checkPayableDisbursement checks a payout pays exactly what an approved payable is owed,
+// and that no other payout for it is in flight or settled. It should be called in the
+// transaction that holds the payable account lock, see availablePayable, after which the
+// payable is read again under that lock.
+func checkPayableDisbursement(tx *gorm.DB, invoice *models.Invoice, amountCents int64) error {
+	if err := tx.Where("id = ?", invoice.ID).First(invoice).Error; err != nil {
+		return err
+	}
+	if invoice.Status != models.InvoiceStatusApproved {
+		return &payableDisbursementError{fiber.StatusConflict, fmt.Sprintf("Payable is %s, only approved payables can be paid out", invoice.Status)}
+	}
+	if amountCents != invoice.NetPayoutCents() {
+		return &payableDisbursementError{fiber.StatusBadRequest, fmt.Sprintf("Amount must be the payable's net payout of %d cents", invoice.NetPayoutCents())}
+	}
+
+	var existing int64
+	if err := tx.Model(&models.PaymentTransaction{}).
+		Where("invoice_id = ? AND status NOT IN ?", invoice.ID, []string{string(payments.StatusFailed), string(payments.StatusReturned)}).
+		Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return &payableDisbursementError{fiber.StatusConflict, "A payout for this payable is already pending or settled"}
+	}
+	return nil
+}
+
+// availablePayable is the company's payable balance less disbursements still in flight.
+// Within a transaction the payable account stays locked until it ends, so balance checks
+// for the same company are made one at a time.
+func availablePayable(db *gorm.DB, companyID uuid.UUID) (int64, error) {
+	account, err := ledger.EnsureAccount(db, companyID, models.AccountPayable)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", account.ID).First(account).Error; err != nil {
+		return 0, err
+	}
+	balance, err := ledger.Balance(db, *account)
+	if err != nil {
+		return 0, err
+	}
+
+	var inFlight int64
+	err = db.Model(&models.PaymentTransaction{}).
+		Select("COALESCE(SUM(amount_cents), 0)").
+		Where("company_id = ? AND purpose IN ? AND status IN ?", companyID,
+			[]models.PaymentPurpose{models.PurposeCarrierPayout, models.PurposeRefund},
+			[]string{string(payments.StatusPending), string(payments.StatusProcessing)}).
+		Scan(&inFlight).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return balance.BalanceCents - inFlight, nil
+}
+
+// submitTransfer sends a transfer for a stored bank account. If the processor has
+// forgotten the token (the sandbox keeps tokens in memory) the account is re-tokenized once.
+func submitTransfer(c *fiber.Ctx, send func(context.Context, payments.TransferRequest) (*payments.Transfer, error), bankAccount *models.BankAccount, req payments.TransferRequest) (*payments.Transfer, error) {
+	req.Token = bankAccount.Token
+	transfer, err := send(c.Context(), req)
+	if !errors.Is(err, payments.ErrUnknownToken) {
+		return transfer, err
+	}
+
+	tokenized, err := payments.Default().TokenizeBankAccount(c.Context(), payments.BankAccount{
+		HolderName:    bankAccount.HolderName,
+		RoutingNumber: string(bankAccount.RoutingNumber),
+		AccountNumber: string(bankAccount.AccountNumber),
+		AccountType:   bankAccount.AccountType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tokenized.Token != bankAccount.Token {
+		bankAccount.Token = tokenized.Token
+		requestDB(c).Model(bankAccount).Update("token", tokenized.Token)
+	}
+	req.Token = tokenized.Token
+	return send(c.Context(), req)
+}
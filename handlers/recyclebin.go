@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"cargozig_api/listing"
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// recycleType is a kind of record the recycle bin lists, restores and purges
+type recycleType struct {
+	Label   string
+	Search  string   // Listing search expression over the record's own columns
+	Filters []string // Listing filters that apply
+
+	new      func() interface{}                                        // Pointer to an empty record
+	list     func() interface{}                                        // Pointer to an empty slice of records
+	items    func(list interface{}) []recycleItem                      // Summaries of a loaded list
+	conflict func(db *gorm.DB, record interface{}) (field, msg string) // Why the record can't come back as is
+	replace  map[string]string                                         // Fields a restore may change to get past a conflict, to columns
+}
+
+// recycleItem summarizes a deleted record for the recycle bin
+type recycleItem struct {
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	Detail    string     `json:"detail"`
+	CompanyID *uuid.UUID `json:"company_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt time.Time  `json:"deleted_at"`
+}
+
+// recycleTypes are the records the recycle bin handles, by URL name. Purging goes in
+// recycleOrder so records are removed before what they belong to.
+var (
+	recycleTypes = map[string]recycleType{
+		"users": {
+			Label:   "Users",
+			Search:  models.UserSearch,
+			Filters: []string{listing.FilterCompany},
+			new:     func() interface{} { return &models.User{} },
+			list:    func() interface{} { return &[]models.User{} },
+			items: func(list interface{}) []recycleItem {
+				items := []recycleItem{}
+				for _, u := range *list.(*[]models.User) {
+					companyID := u.CompanyID
+					items = append(items, recycleItem{u.ID, u.Username, u.Email, &companyID, u.CreatedAt, u.DeletedAt.Time})
+				}
+				return items
+			},
+			conflict: func(db *gorm.DB, record interface{}) (string, string) {
+				user := record.(*models.User)
+				if !liveRecord(db, &models.Company{}, user.CompanyID) {
+					return "", "Restore the user's company first"
+				}
+				if userEmailTaken(db, strings.ToLower(user.Email), user) {
+					return "email", "Another user now has this email"
+				}
+				return "", ""
+			},
+			replace: map[string]string{"email": "email"},
+		},
+		"companies": {
+			Label:  "Companies",
+			Search: models.CompanySearch,
+			new:    func() interface{} { return &models.Company{} },
+			list:   func() interface{} { return &[]models.Company{} },
+			items: func(list interface{}) []recycleItem {
+				items := []recycleItem{}
+				for _, co := range *list.(*[]models.Company) {
+					items = append(items, recycleItem{co.ID, co.Name, co.Email, nil, co.CreatedAt, co.DeletedAt.Time})
+				}
+				return items
+			},
+			conflict: func(db *gorm.DB, record interface{}) (string, string) {
+				company := record.(*models.Company)
+				if companyEmailTaken(db, strings.ToLower(company.Email), company) {
+					return "email", "Another company now has this email"
+				}
+				return "", ""
+			},
+			replace: map[string]string{"email": "email"},
+		},
+		"vehicles": {
+			Label:   "Vehicles",
+			Search:  "unit_number || ' ' || vin",
+			Filters: []string{listing.FilterCompany},
+			new:     func() interface{} { return &models.Vehicle{} },
+			list:    func() interface{} { return &[]models.Vehicle{} },
+			items: func(list interface{}) []recycleItem {
+				items := []recycleItem{}
+				for _, v := range *list.(*[]models.Vehicle) {
+					companyID := v.CompanyID
+					items = append(items, recycleItem{v.ID, v.UnitNumber, v.VIN, &companyID, v.CreatedAt, v.DeletedAt.Time})
+				}
+				return items
+			},
+			conflict: func(db *gorm.DB, record interface{}) (string, string) {
+				vehicle := record.(*models.Vehicle)
+				return fleetRestoreConflict(db, &models.Vehicle{}, vehicle.CompanyID, vehicle.VIN, vehicle.ID)
+			},
+			replace: map[string]string{"vin": "vin"},
+		},
+		"trailers": {
+			Label:   "Trailers",
+			Search:  "unit_number || ' ' || vin",
+			Filters: []string{listing.FilterCompany},
+			new:     func() interface{} { return &models.Trailer{} },
+			list:    func() interface{} { return &[]models.Trailer{} },
+			items: func(list interface{}) []recycleItem {
+				items := []recycleItem{}
+				for _, t := range *list.(*[]models.Trailer) {
+					companyID := t.CompanyID
+					items = append(items, recycleItem{t.ID, t.UnitNumber, t.VIN, &companyID, t.CreatedAt, t.DeletedAt.Time})
+				}
+				return items
+			},
+			conflict: func(db *gorm.DB, record interface{}) (string, string) {
+				trailer := record.(*models.Trailer)
+				return fleetRestoreConflict(db, &models.Trailer{}, trailer.CompanyID, trailer.VIN, trailer.ID)
+			},
+			replace: map[string]string{"vin": "vin"},
+		},
+		"drivers": {
+			Label:   "Drivers",
+			Search:  "first_name || ' ' || last_name || ' ' || email",
+			Filters: []string{listing.FilterCompany},
+			new:     func() interface{} { return &models.Driver{} },
+			list:    func() interface{} { return &[]models.Driver{} },
+			items: func(list interface{}) []recycleItem {
+				items := []recycleItem{}
+				for _, d := range *list.(*[]models.Driver) {
+					companyID := d.CompanyID
+					items = append(items, recycleItem{d.ID, d.FirstName + " " + d.LastName, d.Email, &companyID, d.CreatedAt, d.DeletedAt.Time})
+				}
+				return items
+			},
+			conflict: func(db *gorm.DB, record interface{}) (string, string) {
+				if !liveRecord(db, &models.Company{}, record.(*models.Driver).CompanyID) {
+					return "", "Restore the driver's company first"
+				}
+				return "", ""
+			},
+		},
+	}
+	recycleOrder = []string{"vehicles", "trailers", "drivers", "users", "companies"}
+)
+
+// recycleSpec returns how a recycle bin list may be sorted, filtered and searched
+func recycleSpec(kind recycleType) listing.Spec {
+	return listing.Spec{
+		Sorts: map[string]string{
+			"deleted_at": "deleted_at",
+			"created_at": "created_at",
+		},
+		DefaultSort: "-deleted_at",
+		Search:      kind.Search,
+		Filters:     kind.Filters,
+	}
+}
+
+// liveRecord reports whether the record with id exists and isn't deleted
+func liveRecord(db *gorm.DB, model interface{}, id uuid.UUID) bool {
+	var count int64
+	db.Model(model).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// fleetRestoreConflict checks a vehicle or trailer's company is live and its VIN free
+func fleetRestoreConflict(db *gorm.DB, model interface{}, companyID uuid.UUID, vin string, id uuid.UUID) (string, string) {
+	if !liveRecord(db, &models.Company{}, companyID) {
+		return "", "Restore the company first"
+	}
+	if vin != "" && fleetVINTaken(db, model, companyID, vin, id) {
+		return "vin", "Another unit in the company now has this VIN"
+	}
+	return "", ""
+}
+
+// SuperAdminRecycleBin renders the recycle bin for one type of record
+func SuperAdminRecycleBin(c *fiber.Ctx) error {
+	name := c.Params("type", "users")
+	kind, ok := recycleTypes[name]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).SendString("Unknown record type")
+	}
+
+	params, err := listing.Parse(c, recycleSpec(kind))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	items, page, err := listDeleted(c, kind, params)
+	if err != nil {
+		fmt.Println("Error listing deleted records:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load the recycle bin")
+	}
+
+	types := []fiber.Map{}
+	for _, key := range recycleOrder {
+		types = append(types, fiber.Map{"Key": key, "Label": recycleTypes[key].Label})
+	}
+
+	return c.Render("superadmin/recycle_bin", fiber.Map{
+		"Title":         "Recycle Bin",
+		"ActivePage":    "recycle_bin",
+		"Username":      c.Locals("username"),
+		"Type":          name,
+		"Types":         types,
+		"Items":         items,
+		"Pagination":    page,
+		"Filters":       params,
+		"RetentionDays": platformSettingInt(requestDB(c), models.SettingRecycleRetentionDays),
+	}, "layouts/superadmin")
+}
+
+// SuperAdminListDeleted lists deleted records of one type, most recently deleted first
+//
+//	GET /superadmin/api/recycle-bin/:type?q=&company_id=&sort=-deleted_at&page=&size=
+func SuperAdminListDeleted(c *fiber.Ctx) error {
+	kind, ok := recycleTypes[c.Params("type")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown record type"})
+	}
+
+	params, err := listing.Parse(c, recycleSpec(kind))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, page, err := listDeleted(c, kind, params)
+	if err != nil {
+		fmt.Println("Error listing deleted records:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list deleted records"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "items": items, "pagination": page})
+}
+
+// listDeleted loads a page of deleted records
+func listDeleted(c *fiber.Ctx, kind recycleType, params listing.Params) ([]recycleItem, listing.Pagination, error) {
+	query := requestDB(c).Unscoped().Model(kind.new()).Where("deleted_at IS NOT NULL")
+	list := kind.list()
+	page, err := listing.Find(query, recycleSpec(kind), params, list)
+	if err != nil {
+		return nil, page, err
+	}
+	return kind.items(list), page, nil
+}
+
+// findDeleted loads the route's deleted record
+func findDeleted(c *fiber.Ctx, kind recycleType) (interface{}, error) {
+	record := kind.new()
+	err := requestDB(c).Unscoped().Where("id = ? AND deleted_at IS NOT NULL", c.Params("id")).First(record).Error
+	return record, err
+}
+
+// restoreConflict is why a deleted record can't be restored
+type restoreConflict struct {
+	field, msg string
+}
+
+func (e *restoreConflict) Error() string { return e.msg }
+
+// SuperAdminRestoreDeleted brings a deleted record back. If a live record has since taken
+// one of its unique values the restore is refused with the field at fault; send a new value
+// for that field to restore it under the new value, e.g. {"email": "new@example.com"}.
+func SuperAdminRestoreDeleted(c *fiber.Ctx) error {
+	kind, ok := recycleTypes[c.Params("type")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown record type"})
+	}
+
+	replacements := map[string]string{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&replacements); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	changes := map[string]interface{}{}
+	for field, value := range replacements {
+		column, ok := kind.replace[field]
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot change " + field + " when restoring"})
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": field + " cannot be empty"})
+		}
+		if column == "email" {
+			value = strings.ToLower(value)
+		}
+		changes[column] = value
+	}
+
+	record, err := findDeleted(c, kind)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Deleted record not found"})
+	}
+
+	err = requestDB(c).Transaction(func(tx *gorm.DB) error {
+		// Replacement values are checked in place of those the record was deleted with
+		if len(changes) > 0 {
+			if err := tx.Unscoped().Model(record).Updates(changes).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().First(record, "id = ?", c.Params("id")).Error; err != nil {
+				return err
+			}
+		}
+		if field, msg := kind.conflict(tx, record); msg != "" {
+			return &restoreConflict{field: field, msg: msg}
+		}
+		return tx.Unscoped().Model(record).Update("deleted_at", nil).Error
+	})
+
+	var conflict *restoreConflict
+	if errors.As(err, &conflict) {
+		body := fiber.Map{"error": conflict.msg}
+		if conflict.field != "" {
+			body["field"] = conflict.field
+		}
+		return c.Status(fiber.StatusConflict).JSON(body)
+	}
+	if isUniqueViolation(err) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Another record now uses one of this record's unique values"})
+	}
+	if err != nil {
+		fmt.Println("Error restoring record:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not restore record"})
+	}
+
+	recordAudit(c, models.AuditRecordRestored, c.Params("type"), c.Params("id"))
+	return c.JSON(fiber.Map{"status": "success", "message": "Record restored"})
+}
+
+// SuperAdminPurgeDeleted permanently removes a deleted record now rather than waiting for
+// the retention period. Records other rows still point at can't be purged.
+func SuperAdminPurgeDeleted(c *fiber.Ctx) error {
+	kind, ok := recycleTypes[c.Params("type")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown record type"})
+	}
+
+	record, err := findDeleted(c, kind)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Deleted record not found"})
+	}
+
+	if err := requestDB(c).Unscoped().Delete(record).Error; err != nil {
+		if isForeignKeyViolation(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Other records still refer to this one, so it can't be purged"})
+		}
+		fmt.Println("Error purging record:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not purge record"})
+	}
+
+	recordAudit(c, models.AuditRecordPurged, c.Params("type"), c.Params("id"))
+	return c.JSON(fiber.Map{"status": "success", "message": "Record purged"})
+}
+
+// PurgeDeletedRecords permanently removes records deleted longer ago than the retention
+// setting. Records other rows still refer to are left for a later run.
+func PurgeDeletedRecords(db *gorm.DB) error {
+	days := platformSettingInt(db, models.SettingRecycleRetentionDays)
+	if days <= 0 {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -int(days))
+
+	for _, name := range recycleOrder {
+		kind := recycleTypes[name]
+
+		var ids []uuid.UUID
+		if err := db.Unscoped().Model(kind.new()).Where("deleted_at < ?", cutoff).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+
+		purged, kept := 0, 0
+		for _, id := range ids {
+			err := db.Unscoped().Where("id = ?", id).Delete(kind.new()).Error
+			if err == nil {
+				purged++
+				continue
+			}
+			if !isForeignKeyViolation(err) {
+				return err
+			}
+			kept++
+		}
+		if purged > 0 || kept > 0 {
+			fmt.Printf("Purged %d deleted %s, %d still referenced\n", purged, name, kept)
+		}
+	}
+	return nil
+}
+
+// isForeignKeyViolation reports whether err is a Postgres foreign key violation
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
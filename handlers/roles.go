@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/models"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// roleNamePattern is the shape of a role name, e.g. dispatcher or billing_clerk
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,39}$`)
+
+// roleRequest is the body for creating and updating roles
+type roleRequest struct {
+	CompanyID   string   `json:"company_id"` // Empty for a platform role
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	Denies      []string `json:"denies"`
+}
+
+// SuperAdminRoles renders the roles and permissions page
+func SuperAdminRoles(c *fiber.Ctx) error {
+	db := requestDB(c)
+
+	var roles []models.RoleDefinition
+	db.Preload("Company").Order("company_id NULLS FIRST, name").Find(&roles)
+
+	var companies []models.Company
+	db.Select("id", "name").Order("name").Find(&companies)
+
+	return c.Render("superadmin/roles", fiber.Map{
+		"Title":       "Roles & Permissions",
+		"ActivePage":  "roles",
+		"Username":    c.Locals("username"),
+		"Roles":       roles,
+		"Companies":   companies,
+		"Permissions": models.AllPermissions,
+	}, "layouts/superadmin")
+}
+
+// SuperAdminListRoles lists platform roles and, with ?company_id=, that company's roles
+func SuperAdminListRoles(c *fiber.Ctx) error {
+	query := requestDB(c).Order("company_id NULLS FIRST, name")
+	if companyID := c.Query("company_id"); companyID != "" {
+		if _, err := uuid.Parse(companyID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+		}
+		query = query.Where("company_id IS NULL OR company_id = ?", companyID)
+	}
+
+	var roles []models.RoleDefinition
+	if err := query.Find(&roles).Error; err != nil {
+		fmt.Println("Error listing roles:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list roles"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "roles": roles, "permissions": models.AllPermissions})
+}
+
+// SuperAdminCreateRole defines a platform role, or a role for one company
+func SuperAdminCreateRole(c *fiber.Ctx) error {
+	var req roleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	name := models.Role(strings.ToLower(strings.TrimSpace(req.Name)))
+	if !roleNamePattern.MatchString(string(name)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Role names are 2-40 lowercase letters, digits or underscores"})
+	}
+	permissions, denies, msg := parseRolePermissions(req.Permissions, req.Denies)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	db := requestDB(c)
+	role := models.RoleDefinition{
+		Name:        name,
+		Description: strings.TrimSpace(req.Description),
+		Permissions: permissions,
+		Denies:      denies,
+	}
+
+	taken := db.Model(&models.RoleDefinition{}).Where("name = ?", name)
+	if req.CompanyID != "" {
+		companyID, err := uuid.Parse(req.CompanyID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid company ID"})
+		}
+		var company models.Company
+		if err := db.First(&company, "id = ?", companyID).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+		}
+		role.CompanyID = &companyID
+		taken = taken.Where("company_id = ?", companyID)
+	} else {
+		taken = taken.Where("company_id IS NULL")
+	}
+
+	var count int64
+	taken.Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A role with this name already exists"})
+	}
+
+	if err := db.Create(&role).Error; err != nil {
+		fmt.Println("Error creating role:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create role"})
+	}
+	authz.Invalidate()
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "role": role})
+}
+
+// SuperAdminUpdateRole changes a role's description, permissions and denies. Names and
+// the company a role belongs to are fixed, since users hold roles by name.
+func SuperAdminUpdateRole(c *fiber.Ctx) error {
+	var req roleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	db := requestDB(c)
+	var role models.RoleDefinition
+	if err := db.First(&role, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Role not found"})
+	}
+
+	permissions, denies, msg := parseRolePermissions(req.Permissions, req.Denies)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	// Keep at least one way into the platform
+	if role.CompanyID == nil && role.Name == models.RoleAdmin && !containsPermission(permissions, models.SystemAdmin) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The platform admin role must keep the system_admin permission"})
+	}
+
+	err := db.Model(&role).Updates(map[string]interface{}{
+		"description": strings.TrimSpace(req.Description),
+		"permissions": permissions,
+		"denies":      denies,
+	}).Error
+	if err != nil {
+		fmt.Println("Error updating role:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update role"})
+	}
+	authz.Invalidate()
+
+	role.Description = strings.TrimSpace(req.Description)
+	role.Permissions = permissions
+	role.Denies = denies
+	return c.JSON(fiber.Map{"status": "success", "role": role})
+}
+
+// SuperAdminDeleteRole removes a role nobody holds any more. Built in roles stay.
+func SuperAdminDeleteRole(c *fiber.Ctx) error {
+	db := requestDB(c)
+	var role models.RoleDefinition
+	if err := db.First(&role, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Role not found"})
+	}
+	if role.BuiltIn {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Built in roles cannot be deleted"})
+	}
+
+	holders := func(model interface{}) int64 {
+		query := db.Model(model).Where("? = ANY(roles)", string(role.Name))
+		if role.CompanyID != nil {
+			query = query.Where("company_id = ?", *role.CompanyID)
+		}
+		var count int64
+		query.Count(&count)
+		return count
+	}
+	if holders(&models.User{})+holders(&models.CompanyMembership{}) > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Remove this role from its users before deleting it"})
+	}
+
+	if err := db.Delete(&role).Error; err != nil {
+		fmt.Println("Error deleting role:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete role"})
+	}
+	authz.Invalidate()
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Role deleted"})
+}
+
+// SuperAdminSetUserAccess sets a user's roles, personal grants and denies in their home
+// company, or in another company through ?company_id= for one of their memberships
+func SuperAdminSetUserAccess(c *fiber.Ctx) error {
+	var req struct {
+		Roles             []string `json:"roles"`
+		Permissions       []string `json:"permissions"`
+		DeniedPermissions []string `json:"denied_permissions"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	db := requestDB(c)
+	var user models.User
+	if err := db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	var membership *models.CompanyMembership
+	companyID := user.CompanyID
+	if id := c.Query("company_id"); id != "" && id != user.CompanyID.String() {
+		membership = &models.CompanyMembership{}
+		if err := db.Where("user_id = ? AND company_id = ?", user.ID, id).First(membership).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Membership not found"})
+		}
+		companyID = membership.CompanyID
+	}
+
+	roles, permissions, denies, msg := parseUserAccess(db, companyID, req.Roles, req.Permissions, req.DeniedPermissions)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	updates := map[string]interface{}{
+		"roles":              roles,
+		"permissions":        permissions,
+		"denied_permissions": denies,
+	}
+	var err error
+	if membership != nil {
+		err = db.Model(membership).Updates(updates).Error
+	} else {
+		err = db.Model(&user).Updates(updates).Error
+	}
+	if err != nil {
+		fmt.Println("Error updating user access:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update user access"})
+	}
+	recordAudit(c, models.AuditUserAccessChanged, "user", user.ID.String()+" in "+companyID.String())
+
+	return c.JSON(fiber.Map{
+		"status":             "success",
+		"company_id":         companyID,
+		"roles":              roles,
+		"permissions":        permissions,
+		"denied_permissions": denies,
+	})
+}
+
+// parseRolePermissions validates permission and deny lists, returning an error message
+// for the first unknown permission
+func parseRolePermissions(grants, denies []string) (models.PermissionArray, models.PermissionArray, string) {
+	parse := func(names []string) (models.PermissionArray, string) {
+		list := models.PermissionArray{}
+		for _, name := range names {
+			p := models.Permission(strings.TrimSpace(name))
+			if !models.ValidPermission(p) {
+				return nil, "Unknown permission: " + name
+			}
+			if !containsPermission(list, p) {
+				list = append(list, p)
+			}
+		}
+		return list, ""
+	}
+
+	permissions, msg := parse(grants)
+	if msg != "" {
+		return nil, nil, msg
+	}
+	denied, msg := parse(denies)
+	if msg != "" {
+		return nil, nil, msg
+	}
+	return permissions, denied, ""
+}
+
+// containsPermission reports whether list includes p
+func containsPermission(list models.PermissionArray, p models.Permission) bool {
+	for _, have := range list {
+		if have == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"cargozig_api/middleware"
+	"cargozig_api/models"
+	"cargozig_api/notify"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// platformSettingDefaults define every setting: its type, limits and the value used until a
+// superadmin overrides it
+var platformSettingDefaults = map[string]models.PlatformSetting{
+	models.SettingQuickPayFeeBps:       {Key: models.SettingQuickPayFeeBps, Type: models.SettingTypeInt, Value: "300", Max: 10000, Description: "Quick-pay fee in basis points of the payable total"},
+	models.SettingQuickPayMinFeeCents:  {Key: models.SettingQuickPayMinFeeCents, Type: models.SettingTypeInt, Value: "2500", Description: "Minimum quick-pay fee in cents"},
+	models.SettingQuickPayDays:         {Key: models.SettingQuickPayDays, Type: models.SettingTypeInt, Value: "2", Max: 90, Description: "Days until a quick-pay payable is paid"},
+	models.SettingRecycleRetentionDays: {Key: models.SettingRecycleRetentionDays, Type: models.SettingTypeInt, Value: "30", Max: 3650, Description: "Days deleted records stay in the recycle bin before being purged"},
+	models.SettingImpersonationMinutes: {Key: models.SettingImpersonationMinutes, Type: models.SettingTypeInt, Value: "30", Min: 1, Max: 480, Description: "Minutes a superadmin impersonation session lasts"},
+
+	models.SettingPlatformName: {Key: models.SettingPlatformName, Type: models.SettingTypeString, Value: "CargoZig", Max: 100, Description: "Platform name, also the sender name on emails"},
+	models.SettingSupportEmail: {Key: models.SettingSupportEmail, Type: models.SettingTypeEmail, Value: "support@cargozig.com", Description: "Support address, also the sender address on emails"},
+	models.SettingTimezone:     {Key: models.SettingTimezone, Type: models.SettingTypeTimezone, Value: "UTC", Description: "Timezone dates are shown in on the superadmin pages"},
+
+	models.SettingSessionHours:    {Key: models.SettingSessionHours, Type: models.SettingTypeInt, Value: "24", Min: 1, Max: 720, Description: "Hours a sign in lasts before the user must sign in again"},
+	models.SettingRequireAdmin2FA: {Key: models.SettingRequireAdmin2FA, Type: models.SettingTypeBool, Value: "false", Description: "Require two-factor sign in for admins. Not yet enforced: the choice is only recorded until two-factor sign in is available"},
+
+	models.SettingSMTPHost:     {Key: models.SettingSMTPHost, Type: models.SettingTypeString, Value: "", Max: 255, Description: "SMTP server email is sent through; blank to use the default notifier"},
+	models.SettingSMTPPort:     {Key: models.SettingSMTPPort, Type: models.SettingTypeInt, Value: "587", Min: 1, Max: 65535, Description: "SMTP server port"},
+	models.SettingSMTPUsername: {Key: models.SettingSMTPUsername, Type: models.SettingTypeString, Value: "", Max: 255, Description: "SMTP sign in name; blank to send without signing in"},
+	models.SettingSMTPPassword: {Key: models.SettingSMTPPassword, Type: models.SettingTypeString, Secret: true, Value: "", Max: 255, Description: "SMTP password"},
+}
+
+// settingTimezones are offered on the settings page. Any IANA zone is accepted.
+var settingTimezones = []string{
+	"UTC", "America/New_York", "America/Chicago", "America/Denver", "America/Phoenix",
+	"America/Los_Angeles", "America/Anchorage", "Pacific/Honolulu", "America/Toronto", "America/Mexico_City",
+}
+
+// errSettingVersion is returned when a setting has changed since the version a request was based on
+var errSettingVersion = errors.New("setting has changed since it was loaded")
+
+// SetupSettingsRoutes sets up the platform settings routes, superadmins only
+// /api/settings
+func SetupSettingsRoutes(router fiber.Router) {
+	router.Use(middleware.AuthenticateUser())
+	router.Use(middleware.LoadUser())
+	router.Use(middleware.RequirePermission(models.SystemAdmin))
+
+	router.Get("/", ListPlatformSettings)
+	router.Put("/", UpdatePlatformSettings)
+	router.Get("/history", ListPlatformSettingChanges)
+	router.Post("/email/test", TestEmailSettings)
+	router.Put("/:key", UpdatePlatformSetting)
+}
+
+// currentPlatformSettings returns every defined setting with its stored value and version.
+// Secret values are left out; Configured says whether one is set.
+func currentPlatformSettings(db *gorm.DB) (map[string]models.PlatformSetting, error) {
+	var stored []models.PlatformSetting
+	if err := db.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	settings := map[string]models.PlatformSetting{}
+	for key, setting := range platformSettingDefaults {
+		settings[key] = setting
+	}
+	for _, setting := range stored {
+		definition, ok := platformSettingDefaults[setting.Key]
+		if !ok {
+			continue
+		}
+		definition.BaseModel = setting.BaseModel
+		definition.Value = setting.Value
+		definition.Version = setting.Version
+		definition.UpdatedByID = setting.UpdatedByID
+		settings[setting.Key] = definition
+	}
+	for key, setting := range settings {
+		if setting.Secret {
+			setting.Configured = setting.Value != ""
+			setting.Value = ""
+			settings[key] = setting
+		}
+	}
+	return settings, nil
+}
+
+// ListPlatformSettings returns every known setting with its current value
+func ListPlatformSettings(c *fiber.Ctx) error {
+	settings, err := currentPlatformSettings(requestDB(c))
+	if err != nil {
+		fmt.Println("Error listing settings:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list settings"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "settings": settings})
+}
+
+// validatePlatformSetting checks value against the setting's type and limits and returns it
+// in its canonical form
+func validatePlatformSetting(definition models.PlatformSetting, value string) (string, error) {
+	value = strings.TrimSpace(value)
+
+	switch definition.Type {
+	case models.SettingTypeInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("must be a whole number")
+		}
+		if n < definition.Min {
+			return "", fmt.Errorf("must be at least %d", definition.Min)
+		}
+		if definition.Max > 0 && n > definition.Max {
+			return "", fmt.Errorf("must be at most %d", definition.Max)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case models.SettingTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("must be true or false")
+		}
+		return strconv.FormatBool(b), nil
+	case models.SettingTypeEmail:
+		address, err := mail.ParseAddress(value)
+		if err != nil || address.Name != "" {
+			return "", fmt.Errorf("must be an email address")
+		}
+		return strings.ToLower(address.Address), nil
+	case models.SettingTypeTimezone:
+		if value == "" || value == "Local" {
+			return "", fmt.Errorf("must be a timezone such as America/Chicago")
+		}
+		if _, err := time.LoadLocation(value); err != nil {
+			return "", fmt.Errorf("must be a timezone such as America/Chicago")
+		}
+		return value, nil
+	default:
+		if definition.Max > 0 && len(value) > int(definition.Max) {
+			return "", fmt.Errorf("must be at most %d characters", definition.Max)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return "", fmt.Errorf("must be a single line")
+		}
+		return value, nil
+	}
+}
+
+// platformSettingUpdate is a change to one setting. Version, when given, must be the
+// setting's current version, so two superadmins editing at once don't overwrite each other.
+type platformSettingUpdate struct {
+	Value   string `json:"value"`
+	Version *int64 `json:"version"`
+}
+
+// savePlatformSettings validates and stores updates in one transaction, recording each
+// change in the setting history. Blank secret values leave the secret as it is. On failure it
+// returns the status and message to respond with.
+func savePlatformSettings(c *fiber.Ctx, updates map[string]platformSettingUpdate) ([]string, int, string) {
+	keys := make([]string, 0, len(updates))
+	values := map[string]string{}
+	for key, update := range updates {
+		definition, ok := platformSettingDefaults[key]
+		if !ok {
+			return nil, fiber.StatusNotFound, "Unknown setting " + key
+		}
+		if definition.Secret && update.Value == "" {
+			continue
+		}
+		value, err := validatePlatformSetting(definition, update.Value)
+		if err != nil {
+			return nil, fiber.StatusBadRequest, key + " " + err.Error()
+		}
+		keys = append(keys, key)
+		values[key] = value
+	}
+	sort.Strings(keys)
+
+	actor := currentUser(c)
+	var changed []string
+	err := requestDB(c).Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			definition := platformSettingDefaults[key]
+
+			var setting models.PlatformSetting
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&setting).Error
+			found := err == nil
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if version := updates[key].Version; version != nil && *version != setting.Version {
+				return fmt.Errorf("%s: %w", key, errSettingVersion)
+			}
+
+			oldValue := definition.Value
+			if found {
+				oldValue = setting.Value
+				if definition.Secret {
+					if oldValue, err = decryptSetting(key, setting.Value); err != nil {
+						oldValue = ""
+					}
+				}
+			}
+			if oldValue == values[key] {
+				continue
+			}
+
+			stored := values[key]
+			if definition.Secret {
+				if stored, err = encryptSetting(key, values[key]); err != nil {
+					return err
+				}
+			}
+			setting.Key = key
+			setting.Value = stored
+			setting.Description = definition.Description
+			setting.Version++
+			setting.UpdatedByID = &actor.ID
+			if err := tx.Save(&setting).Error; err != nil {
+				return err
+			}
+
+			change := models.PlatformSettingChange{
+				Key:      key,
+				Version:  setting.Version,
+				OldValue: oldValue,
+				NewValue: values[key],
+				ActorID:  &actor.ID,
+			}
+			if definition.Secret {
+				change.OldValue, change.NewValue = "", ""
+			}
+			if err := tx.Create(&change).Error; err != nil {
+				return err
+			}
+			changed = append(changed, fmt.Sprintf("%s v%d", key, setting.Version))
+		}
+		return nil
+	})
+	if errors.Is(err, errSettingVersion) {
+		return nil, fiber.StatusConflict, err.Error() + "; reload and try again"
+	}
+	if errors.Is(err, models.ErrNoSealingKey) {
+		return nil, fiber.StatusServiceUnavailable, "Secret settings can't be stored until SETTINGS_ENCRYPTION_KEY is set"
+	}
+	if err != nil {
+		fmt.Println("Error saving settings:", err)
+		return nil, fiber.StatusInternalServerError, "Could not save settings"
+	}
+
+	if len(changed) > 0 {
+		invalidatePlatformSettings()
+		cachedPlatformSettings(requestDB(c))
+		recordAudit(c, models.AuditSettingsChanged, "platform_settings", strings.Join(changed, ", "))
+	}
+	return changed, 0, ""
+}
+
+// UpdatePlatformSettings changes several settings at once, all or none
+//
+//	PUT /api/settings {"settings": {"smtp.host": {"value": "smtp.example.com", "version": 2}}}
+func UpdatePlatformSettings(c *fiber.Ctx) error {
+	var req struct {
+		Settings map[string]platformSettingUpdate `json:"settings"`
+	}
+	if err := c.BodyParser(&req); err != nil || len(req.Settings) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	changed, status, message := savePlatformSettings(c, req.Settings)
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	settings, err := currentPlatformSettings(requestDB(c))
+	if err != nil {
+		fmt.Println("Error listing settings:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list settings"})
+	}
+	return c.JSON(fiber.Map{"status": "success", "changed": changed, "settings": settings})
+}
+
+// UpdatePlatformSetting changes the value of a known setting
+func UpdatePlatformSetting(c *fiber.Ctx) error {
+	key := c.Params("key")
+	if _, ok := platformSettingDefaults[key]; !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown setting"})
+	}
+
+	var req platformSettingUpdate
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	_, status, message := savePlatformSettings(c, map[string]platformSettingUpdate{key: req})
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	settings, err := currentPlatformSettings(requestDB(c))
+	if err != nil {
+		fmt.Println("Error listing settings:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list settings"})
+	}
+	return c.JSON(fiber.Map{"status": "success", "setting": settings[key]})
+}
+
+// ListPlatformSettingChanges returns the most recent setting changes, optionally of one ?key=
+func ListPlatformSettingChanges(c *fiber.Ctx) error {
+	changes, err := platformSettingChanges(requestDB(c), c.Query("key"), 100)
+	if err != nil {
+		fmt.Println("Error listing setting changes:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not list setting changes"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "changes": changes})
+}
+
+// platformSettingChanges loads the latest changes, newest first, with who made them
+func platformSettingChanges(db *gorm.DB, key string, limit int) ([]models.PlatformSettingChange, error) {
+	query := db.Preload("Actor").Order("created_at DESC").Limit(limit)
+	if key != "" {
+		query = query.Where("key = ?", key)
+	}
+
+	var changes []models.PlatformSettingChange
+	err := query.Find(&changes).Error
+	return changes, err
+}
+
+// TestEmailSettings sends a test email to the signed in superadmin through the current
+// email settings
+func TestEmailSettings(c *fiber.Ctx) error {
+	user := currentUser(c)
+	db := requestDB(c)
+	cachedPlatformSettings(db)
+
+	err := notify.Default().Notify(notify.Message{
+		To:      []string{user.Email},
+		Subject: platformSetting(db, models.SettingPlatformName) + " test email",
+		Body:    "This is a test email sent from the system settings page.",
+		Tags:    map[string]string{"kind": "settings_test"},
+	})
+	if err != nil {
+		fmt.Println("Error sending test email:", err)
+		// The server's reply can include hosts and credentials, so it stays in the log
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not send test email, check the SMTP settings and the server log"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Test email sent to " + user.Email})
+}
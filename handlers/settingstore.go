@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"cargozig_api/config"
+	"cargozig_api/models"
+	"cargozig_api/notify"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// platformSettingsTTL is how long settings are cached. Changes made on this instance take
+// effect at once; other instances pick them up within the TTL.
+const platformSettingsTTL = time.Minute
+
+// platformSettingCache holds every stored setting's current value, decrypted
+var platformSettingCache struct {
+	sync.RWMutex
+	values   map[string]string
+	loadedAt time.Time
+}
+
+// settingsKey encrypts secret settings, keyed by SETTINGS_ENCRYPTION_KEY or a key derived
+// from the JWT secret when that isn't set. Changing whichever is used makes stored secrets
+// unreadable, and they must be entered again. With neither set, secrets can't be stored.
+var settingsKey = models.SealingKey{Env: "SETTINGS_ENCRYPTION_KEY", Label: "platform-settings"}
+
+// encryptSetting encrypts a secret setting's value for storage. The key is bound in as
+// associated data, so a value copied to another setting won't decrypt.
+func encryptSetting(key, value string) (string, error) {
+	return settingsKey.Seal(value, []byte(key))
+}
+
+// decryptSetting reverses encryptSetting
+func decryptSetting(key, stored string) (string, error) {
+	return settingsKey.Open(stored, []byte(key))
+}
+
+// cachedPlatformSettings returns the stored settings, reloading them once the cache is
+// older than platformSettingsTTL. If a reload fails the previous values are kept.
+func cachedPlatformSettings(db *gorm.DB) map[string]string {
+	platformSettingCache.RLock()
+	values, loadedAt := platformSettingCache.values, platformSettingCache.loadedAt
+	platformSettingCache.RUnlock()
+	if values != nil && time.Since(loadedAt) < platformSettingsTTL {
+		return values
+	}
+
+	platformSettingCache.Lock()
+	defer platformSettingCache.Unlock()
+	if platformSettingCache.values != nil && time.Since(platformSettingCache.loadedAt) < platformSettingsTTL {
+		return platformSettingCache.values
+	}
+
+	loaded, err := loadPlatformSettings(db)
+	if err != nil {
+		fmt.Println("Error loading settings:", err)
+		if platformSettingCache.values == nil {
+			return map[string]string{}
+		}
+		return platformSettingCache.values
+	}
+	platformSettingCache.values = loaded
+	platformSettingCache.loadedAt = time.Now()
+	applyPlatformSettings(loaded)
+	return loaded
+}
+
+// loadPlatformSettings reads every stored setting, decrypting secrets
+func loadPlatformSettings(db *gorm.DB) (map[string]string, error) {
+	var stored []models.PlatformSetting
+	if err := db.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, setting := range stored {
+		value := setting.Value
+		if platformSettingDefaults[setting.Key].Secret {
+			plain, err := decryptSetting(setting.Key, setting.Value)
+			if err != nil {
+				fmt.Println("Error decrypting setting", setting.Key+":", err)
+				continue
+			}
+			value = plain
+		}
+		values[setting.Key] = value
+	}
+	return values, nil
+}
+
+// invalidatePlatformSettings drops the cached settings so the next read reloads them
+func invalidatePlatformSettings() {
+	platformSettingCache.Lock()
+	defer platformSettingCache.Unlock()
+	platformSettingCache.values = nil
+}
+
+// LoadPlatformSettings loads the settings at startup, so those applied outside of requests,
+// such as the email server, are in effect before the first request
+func LoadPlatformSettings(db *gorm.DB) {
+	cachedPlatformSettings(db)
+}
+
+// platformSetting reads a setting's current value, falling back to its default
+func platformSetting(db *gorm.DB, key string) string {
+	if value, ok := cachedPlatformSettings(db)[key]; ok {
+		return value
+	}
+	return platformSettingDefaults[key].Value
+}
+
+// platformSettingInt reads a whole number setting, falling back to its default
+func platformSettingInt(db *gorm.DB, key string) int64 {
+	n, err := strconv.ParseInt(platformSetting(db, key), 10, 64)
+	if err != nil {
+		n, _ = strconv.ParseInt(platformSettingDefaults[key].Value, 10, 64)
+	}
+	return n
+}
+
+// platformSettingBool reads a true/false setting, falling back to its default
+func platformSettingBool(db *gorm.DB, key string) bool {
+	b, err := strconv.ParseBool(platformSetting(db, key))
+	if err != nil {
+		b, _ = strconv.ParseBool(platformSettingDefaults[key].Value)
+	}
+	return b
+}
+
+// platformLocation is the platform's configured timezone
+func platformLocation(db *gorm.DB) *time.Location {
+	location, err := time.LoadLocation(platformSetting(db, models.SettingTimezone))
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// sessionLifetime is how long a sign in lasts, for both the token and its cookie
+func sessionLifetime() time.Duration {
+	hours := platformSettingInt(config.GetDB(), models.SettingSessionHours)
+	if hours < 1 {
+		hours = 1
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// smtpSettings is the email server configuration last applied
+var smtpSettings struct {
+	sync.Mutex
+	applied *notify.SMTPNotifier
+}
+
+// smtpNotifier builds the email notifier the settings describe, or nil when no server is set
+func smtpNotifier(values map[string]string) *notify.SMTPNotifier {
+	value := func(key string) string {
+		if v, ok := values[key]; ok {
+			return v
+		}
+		return platformSettingDefaults[key].Value
+	}
+	if value(models.SettingSMTPHost) == "" {
+		return nil
+	}
+
+	port, _ := strconv.Atoi(value(models.SettingSMTPPort))
+	return &notify.SMTPNotifier{
+		Host:     value(models.SettingSMTPHost),
+		Port:     port,
+		Username: value(models.SettingSMTPUsername),
+		Password: value(models.SettingSMTPPassword),
+		From:     mail.Address{Name: value(models.SettingPlatformName), Address: value(models.SettingSupportEmail)},
+	}
+}
+
+// applyPlatformSettings puts settings that live outside of requests into effect. Email goes
+// through the configured SMTP server, or back to the environment's notifier once the server
+// is cleared.
+func applyPlatformSettings(values map[string]string) {
+	smtpSettings.Lock()
+	defer smtpSettings.Unlock()
+
+	next := smtpNotifier(values)
+	switch {
+	case next == nil && smtpSettings.applied == nil:
+		return
+	case next != nil && smtpSettings.applied != nil && *next == *smtpSettings.applied:
+		return
+	case next == nil:
+		notify.SetDefault(notify.EnvNotifier())
+	default:
+		notify.SetDefault(*next)
+	}
+	smtpSettings.applied = next
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
-	"cargozig_api/config"
+	"cargozig_api/analytics"
+	"cargozig_api/listing"
 	"cargozig_api/middleware"
 	"cargozig_api/models"
 	"fmt"
+	"slices"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -15,6 +17,7 @@ func SetupSuperAdminRoutes(router fiber.Router) {
 	// All super admin routes require authentication and system_admin permission
 	router.Use(middleware.AuthenticateUser())
 	router.Use(middleware.RequirePermission(models.SystemAdmin))
+	router.Use(middleware.LoadUser())
 
 	// Dashboard
 	router.Get("/dashboard", SuperAdminDashboard)
@@ -33,53 +36,101 @@ func SetupSuperAdminRoutes(router fiber.Router) {
 
 	// Shippers Management
 	router.Get("/shippers", SuperAdminShippers)
+	router.Get("/shippers/new", SuperAdminNewShipper)
 	router.Get("/shippers/:id", SuperAdminViewShipper)
 	router.Get("/shippers/:id/edit", SuperAdminEditShipper)
 
 	// Carriers Management
 	router.Get("/carriers", SuperAdminCarriers)
+	router.Get("/carriers/new", SuperAdminNewCarrier)
 	router.Get("/carriers/:id", SuperAdminViewCarrier)
 	router.Get("/carriers/:id/edit", SuperAdminEditCarrier)
 
 	// Brokers Management
 	router.Get("/brokers", SuperAdminBrokers)
+	router.Get("/brokers/new", SuperAdminNewBroker)
 	router.Get("/brokers/:id", SuperAdminViewBroker)
 	router.Get("/brokers/:id/edit", SuperAdminEditBroker)
 
 	// System Settings
 	router.Get("/settings", SuperAdminSettings)
 	router.Get("/analytics", SuperAdminAnalytics)
+	router.Get("/api/analytics/summary", SuperAdminAnalyticsSummary)
+	router.Get("/api/analytics/series", SuperAdminAnalyticsSeries)
+	router.Post("/api/analytics/refresh", SuperAdminRefreshAnalytics)
 
 	// API endpoints for CRUD operations
+	router.Get("/api/shippers/export", SuperAdminExportShippers)
+	router.Get("/api/shippers/:id/delete-preview", SuperAdminPreviewDeleteShipper)
 	router.Delete("/api/shippers/:id", SuperAdminDeleteShipper)
+	router.Get("/api/carriers/export", SuperAdminExportCarriers)
+	router.Get("/api/carriers/:id/delete-preview", SuperAdminPreviewDeleteCarrier)
 	router.Delete("/api/carriers/:id", SuperAdminDeleteCarrier)
+	router.Get("/api/brokers/export", SuperAdminExportBrokers)
+	router.Get("/api/brokers/:id/delete-preview", SuperAdminPreviewDeleteBroker)
 	router.Delete("/api/brokers/:id", SuperAdminDeleteBroker)
+	router.Get("/api/companies", SuperAdminListCompanies)
+	router.Get("/api/companies/export", SuperAdminExportCompanies)
+	router.Post("/api/companies", SuperAdminCreateCompany)
+	router.Get("/api/companies/:id", SuperAdminGetCompany)
+	router.Put("/api/companies/:id", SuperAdminUpdateCompany)
+	router.Post("/api/companies/:id/activate", SuperAdminActivateCompany)
+	router.Post("/api/companies/:id/deactivate", SuperAdminDeactivateCompany)
+	router.Post("/api/companies/:id/verify", SuperAdminVerifyCompany)
+	router.Get("/api/companies/:id/members", SuperAdminCompanyMembers)
+	router.Get("/api/companies/:id/delete-preview", SuperAdminPreviewDeleteCompany)
 	router.Delete("/api/companies/:id", SuperAdminDeleteCompany)
+	router.Get("/api/users", SuperAdminListUsers)
+	router.Get("/api/users/export", SuperAdminExportUsers)
+	router.Post("/api/users", SuperAdminCreateUser)
+	router.Get("/api/users/:id", SuperAdminGetUser)
+	router.Put("/api/users/:id", SuperAdminUpdateUser)
+	router.Post("/api/users/:id/activate", SuperAdminActivateUser)
+	router.Post("/api/users/:id/deactivate", SuperAdminDeactivateUser)
+	router.Post("/api/users/:id/password", SuperAdminResetPassword)
+	router.Post("/api/users/:id/impersonate", SuperAdminImpersonateUser)
+	router.Get("/api/users/:id/delete-preview", SuperAdminPreviewDeleteUser)
 	router.Delete("/api/users/:id", SuperAdminDeleteUser)
+	router.Put("/api/users/:id/access", SuperAdminSetUserAccess)
+
+	// Roles and permissions
+	router.Get("/roles", SuperAdminRoles)
+	router.Get("/api/roles", SuperAdminListRoles)
+	router.Post("/api/roles", SuperAdminCreateRole)
+	router.Put("/api/roles/:id", SuperAdminUpdateRole)
+	router.Delete("/api/roles/:id", SuperAdminDeleteRole)
+
+	// Bulk import
+	router.Get("/import", SuperAdminImport)
+	router.Get("/api/import/:type/template", SuperAdminImportTemplate)
+	router.Post("/api/import/:type", SuperAdminRunImport)
+
+	// Recycle bin
+	router.Get("/recycle-bin", SuperAdminRecycleBin)
+	router.Get("/recycle-bin/:type", SuperAdminRecycleBin)
+	router.Get("/api/recycle-bin/:type", SuperAdminListDeleted)
+	router.Post("/api/recycle-bin/:type/:id/restore", SuperAdminRestoreDeleted)
+	router.Delete("/api/recycle-bin/:type/:id", SuperAdminPurgeDeleted)
 }
 
 // SuperAdminDashboard renders the super admin dashboard
 func SuperAdminDashboard(c *fiber.Ctx) error {
-	fmt.Println("SuperAdminDashboard called")
-
-	db := config.GetDB()
-
-	// Get statistics
-	var totalCompanies, totalUsers, totalShippers, totalCarriers int64
-	db.Model(&models.Company{}).Count(&totalCompanies)
-	db.Model(&models.User{}).Count(&totalUsers)
-	db.Model(&models.User{}).Where("? = ANY(roles)", models.RoleShipper).Count(&totalShippers)
-	db.Model(&models.User{}).Where("? = ANY(roles)", models.RoleCarrier).Count(&totalCarriers)
+	// Get statistics from the latest analytics snapshot
+	summary, err := analytics.Latest(requestDB(c))
+	if err != nil {
+		fmt.Println("Error loading analytics summary:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load statistics")
+	}
 
 	return c.Render("superadmin/dashboard", fiber.Map{
 		"Title":      "Super Admin Dashboard",
 		"ActivePage": "dashboard",
 		"Username":   c.Locals("username"),
 		"Stats": fiber.Map{
-			"TotalCompanies": totalCompanies,
-			"TotalUsers":     totalUsers,
-			"TotalShippers":  totalShippers,
-			"TotalCarriers":  totalCarriers,
+			"TotalCompanies": summary.TotalCompanies(),
+			"TotalUsers":     summary.Users[models.DimensionTotal],
+			"TotalShippers":  summary.Users[string(models.RoleShipper)],
+			"TotalCarriers":  summary.Users[string(models.RoleCarrier)],
 		},
 		"RecentActivity": []fiber.Map{}, // TODO: Implement activity tracking
 	}, "layouts/superadmin")
@@ -87,17 +138,23 @@ func SuperAdminDashboard(c *fiber.Ctx) error {
 
 // SuperAdminShippers renders the shippers management page
 func SuperAdminShippers(c *fiber.Ctx) error {
-	fmt.Println("SuperAdminShippers called")
+	params, err := listing.Parse(c, userListSpec)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 
-	db := config.GetDB()
+	db := requestDB(c)
 
-	// Get all shippers (users with shipper role)
-	var shippers []models.User
-	db.Preload("Company").Where("? = ANY(roles)", models.RoleShipper).Find(&shippers)
+	// Get a page of shippers (users with shipper role)
+	shippers, page, err := listUsers(c, params, models.RoleShipper)
+	if err != nil {
+		fmt.Println("Error listing shippers:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load shippers")
+	}
 
 	// Get all companies for filter
 	var companies []models.Company
-	db.Find(&companies)
+	db.Select("id", "name").Order("name").Find(&companies)
 
 	return c.Render("superadmin/shippers", fiber.Map{
 		"Title":      "Shippers Management",
@@ -105,29 +162,30 @@ func SuperAdminShippers(c *fiber.Ctx) error {
 		"Username":   c.Locals("username"),
 		"Shippers":   shippers,
 		"Companies":  companies,
-		"Pagination": fiber.Map{
-			"Start":       1,
-			"End":         len(shippers),
-			"Total":       len(shippers),
-			"CurrentPage": 1,
-			"TotalPages":  1,
-		},
+		"Pagination": page,
+		"Filters":    params,
 	}, "layouts/superadmin")
 }
 
 // SuperAdminCarriers renders the carriers management page
 func SuperAdminCarriers(c *fiber.Ctx) error {
-	fmt.Println("SuperAdminCarriers called")
+	params, err := listing.Parse(c, userListSpec)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 
-	db := config.GetDB()
+	db := requestDB(c)
 
-	// Get all carriers (users with carrier role)
-	var carriers []models.User
-	db.Preload("Company").Where("? = ANY(roles)", models.RoleCarrier).Find(&carriers)
+	// Get a page of carriers (users with carrier role)
+	carriers, page, err := listUsers(c, params, models.RoleCarrier)
+	if err != nil {
+		fmt.Println("Error listing carriers:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load carriers")
+	}
 
 	// Get all companies for filter
 	var companies []models.Company
-	db.Find(&companies)
+	db.Select("id", "name").Order("name").Find(&companies)
 
 	return c.Render("superadmin/carriers", fiber.Map{
 		"Title":      "Carriers Management",
@@ -135,29 +193,30 @@ func SuperAdminCarriers(c *fiber.Ctx) error {
 		"Username":   c.Locals("username"),
 		"Carriers":   carriers,
 		"Companies":  companies,
-		"Pagination": fiber.Map{
-			"Start":       1,
-			"End":         len(carriers),
-			"Total":       len(carriers),
-			"CurrentPage": 1,
-			"TotalPages":  1,
-		},
+		"Pagination": page,
+		"Filters":    params,
 	}, "layouts/superadmin")
 }
 
 // SuperAdminBrokers renders the brokers management page
 func SuperAdminBrokers(c *fiber.Ctx) error {
-	fmt.Println("SuperAdminBrokers called")
+	params, err := listing.Parse(c, userListSpec)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 
-	db := config.GetDB()
+	db := requestDB(c)
 
-	// Get all brokers/admins (users with admin role)
-	var brokers []models.User
-	db.Preload("Company").Where("? = ANY(roles)", models.RoleAdmin).Find(&brokers)
+	// Get a page of brokers/admins (users with admin role)
+	brokers, page, err := listUsers(c, params, models.RoleAdmin)
+	if err != nil {
+		fmt.Println("Error listing brokers:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load brokers")
+	}
 
 	// Get all companies for filter
 	var companies []models.Company
-	db.Find(&companies)
+	db.Select("id", "name").Order("name").Find(&companies)
 
 	return c.Render("superadmin/brokers", fiber.Map{
 		"Title":      "Brokers Management",
@@ -165,137 +224,165 @@ func SuperAdminBrokers(c *fiber.Ctx) error {
 		"Username":   c.Locals("username"),
 		"Brokers":    brokers,
 		"Companies":  companies,
-		"Pagination": fiber.Map{
-			"Start":       1,
-			"End":         len(brokers),
-			"Total":       len(brokers),
-			"CurrentPage": 1,
-			"TotalPages":  1,
-		},
+		"Pagination": page,
+		"Filters":    params,
 	}, "layouts/superadmin")
 }
 
 // SuperAdminCompanies renders the companies management page
 func SuperAdminCompanies(c *fiber.Ctx) error {
-	fmt.Println("SuperAdminCompanies called")
-
-	db := config.GetDB()
+	params, err := listing.Parse(c, companyListSpec)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 
-	// Get all companies
-	var companies []models.Company
-	db.Find(&companies)
+	// Get a page of companies
+	companies, page, err := listCompanies(c, params, "")
+	if err != nil {
+		fmt.Println("Error listing companies:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load companies")
+	}
 
 	return c.Render("superadmin/companies", fiber.Map{
 		"Title":      "Companies Management",
 		"ActivePage": "companies",
 		"Username":   c.Locals("username"),
 		"Companies":  companies,
+		"Pagination": page,
+		"Filters":    params,
 	}, "layouts/superadmin")
 }
 
 // SuperAdminUsers renders all users management page
 func SuperAdminUsers(c *fiber.Ctx) error {
-	fmt.Println("SuperAdminUsers called")
+	params, err := listing.Parse(c, userListSpec)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 
-	db := config.GetDB()
+	db := requestDB(c)
 
-	// Get all users
-	var users []models.User
-	db.Preload("Company").Find(&users)
+	// Get a page of users
+	users, page, err := listUsers(c, params, "")
+	if err != nil {
+		fmt.Println("Error listing users:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load users")
+	}
+
+	// Get all companies for filter
+	var companies []models.Company
+	db.Select("id", "name").Order("name").Find(&companies)
 
 	return c.Render("superadmin/users", fiber.Map{
 		"Title":      "Users Management",
 		"ActivePage": "users",
 		"Username":   c.Locals("username"),
 		"Users":      users,
+		"Companies":  companies,
+		"Pagination": page,
+		"Filters":    params,
 	}, "layouts/superadmin")
 }
 
-// SuperAdminSettings renders the system settings page
+// SuperAdminSettings renders the system settings page with the current settings and the
+// latest changes to them
 func SuperAdminSettings(c *fiber.Ctx) error {
+	db := requestDB(c)
+
+	settings, err := currentPlatformSettings(db)
+	if err != nil {
+		fmt.Println("Error listing settings:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load settings")
+	}
+	changes, err := platformSettingChanges(db, "", 25)
+	if err != nil {
+		fmt.Println("Error listing setting changes:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load settings")
+	}
+
+	// Offer the configured timezone even when it isn't one of the usual ones
+	timezones := settingTimezones
+	if current := settings[models.SettingTimezone].Value; !slices.Contains(timezones, current) {
+		timezones = append([]string{current}, timezones...)
+	}
+
 	return c.Render("superadmin/settings", fiber.Map{
 		"Title":      "System Settings",
 		"ActivePage": "settings",
 		"Username":   c.Locals("username"),
+		"Settings":   settings,
+		"Changes":    changes,
+		"Timezones":  timezones,
+		"Location":   platformLocation(db),
+		"Operations": []models.PlatformSetting{
+			settings[models.SettingQuickPayFeeBps],
+			settings[models.SettingQuickPayMinFeeCents],
+			settings[models.SettingQuickPayDays],
+			settings[models.SettingRecycleRetentionDays],
+			settings[models.SettingImpersonationMinutes],
+		},
 	}, "layouts/superadmin")
 }
 
-// SuperAdminAnalytics renders the analytics page
+// SuperAdminAnalytics renders the analytics page. Its charts load from the analytics API.
 func SuperAdminAnalytics(c *fiber.Ctx) error {
+	summary, err := analytics.Latest(requestDB(c))
+	if err != nil {
+		fmt.Println("Error loading analytics summary:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not load analytics")
+	}
+	if summary.AsOf != nil {
+		asOf := summary.AsOf.In(platformLocation(requestDB(c)))
+		summary.AsOf = &asOf
+	}
+
 	return c.Render("superadmin/analytics", fiber.Map{
 		"Title":      "Platform Analytics",
 		"ActivePage": "analytics",
 		"Username":   c.Locals("username"),
+		"Summary":    summary,
 	}, "layouts/superadmin")
 }
 
-// Placeholder view/edit/new handlers (implement as needed)
-func SuperAdminViewCompany(c *fiber.Ctx) error   { return c.SendString("View Company - TODO") }
-func SuperAdminEditCompany(c *fiber.Ctx) error   { return c.SendString("Edit Company - TODO") }
-func SuperAdminNewCompany(c *fiber.Ctx) error    { return c.SendString("New Company - TODO") }
-func SuperAdminViewUser(c *fiber.Ctx) error      { return c.SendString("View User - TODO") }
-func SuperAdminEditUser(c *fiber.Ctx) error      { return c.SendString("Edit User - TODO") }
-func SuperAdminNewUser(c *fiber.Ctx) error       { return c.SendString("New User - TODO") }
-func SuperAdminViewShipper(c *fiber.Ctx) error   { return c.SendString("View Shipper - TODO") }
-func SuperAdminEditShipper(c *fiber.Ctx) error   { return c.SendString("Edit Shipper - TODO") }
-func SuperAdminViewCarrier(c *fiber.Ctx) error   { return c.SendString("View Carrier - TODO") }
-func SuperAdminEditCarrier(c *fiber.Ctx) error   { return c.SendString("Edit Carrier - TODO") }
-func SuperAdminViewBroker(c *fiber.Ctx) error    { return c.SendString("View Broker - TODO") }
-func SuperAdminEditBroker(c *fiber.Ctx) error    { return c.SendString("Edit Broker - TODO") }
-
-// Delete handlers
+// Delete handlers. Each takes {"confirm": true} once the impact from its delete-preview
+// has been reviewed; see confirmDelete.
 func SuperAdminDeleteShipper(c *fiber.Ctx) error {
-	id := c.Params("id")
-	db := config.GetDB()
-	
-	if err := db.Delete(&models.User{}, "id = ?", id).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete shipper"})
-	}
-	
-	return c.JSON(fiber.Map{"status": "success", "message": "Shipper deleted"})
+	return confirmDelete(c, "Shipper", planUserDeletion(models.RoleShipper))
 }
 
 func SuperAdminDeleteCarrier(c *fiber.Ctx) error {
-	id := c.Params("id")
-	db := config.GetDB()
-	
-	if err := db.Delete(&models.User{}, "id = ?", id).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete carrier"})
-	}
-	
-	return c.JSON(fiber.Map{"status": "success", "message": "Carrier deleted"})
+	return confirmDelete(c, "Carrier", planUserDeletion(models.RoleCarrier))
 }
 
 func SuperAdminDeleteBroker(c *fiber.Ctx) error {
-	id := c.Params("id")
-	db := config.GetDB()
-	
-	if err := db.Delete(&models.User{}, "id = ?", id).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete broker"})
-	}
-	
-	return c.JSON(fiber.Map{"status": "success", "message": "Broker deleted"})
+	return confirmDelete(c, "Broker", planUserDeletion(models.RoleAdmin))
 }
 
 func SuperAdminDeleteCompany(c *fiber.Ctx) error {
-	id := c.Params("id")
-	db := config.GetDB()
-	
-	if err := db.Delete(&models.Company{}, "id = ?", id).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete company"})
-	}
-	
-	return c.JSON(fiber.Map{"status": "success", "message": "Company deleted"})
+	return confirmDelete(c, "Company", planCompanyDeletion)
 }
 
 func SuperAdminDeleteUser(c *fiber.Ctx) error {
-	id := c.Params("id")
-	db := config.GetDB()
-	
-	if err := db.Delete(&models.User{}, "id = ?", id).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete user"})
-	}
-	
-	return c.JSON(fiber.Map{"status": "success", "message": "User deleted"})
+	return confirmDelete(c, "User", planUserDeletion(""))
+}
+
+// Delete previews report what a delete would change and anything stopping it
+func SuperAdminPreviewDeleteShipper(c *fiber.Ctx) error {
+	return previewDelete(c, "Shipper", planUserDeletion(models.RoleShipper))
+}
+
+func SuperAdminPreviewDeleteCarrier(c *fiber.Ctx) error {
+	return previewDelete(c, "Carrier", planUserDeletion(models.RoleCarrier))
 }
 
+func SuperAdminPreviewDeleteBroker(c *fiber.Ctx) error {
+	return previewDelete(c, "Broker", planUserDeletion(models.RoleAdmin))
+}
+
+func SuperAdminPreviewDeleteCompany(c *fiber.Ctx) error {
+	return previewDelete(c, "Company", planCompanyDeletion)
+}
+
+func SuperAdminPreviewDeleteUser(c *fiber.Ctx) error {
+	return previewDelete(c, "User", planUserDeletion(""))
+}
@@ -0,0 +1,513 @@
+package handlers
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/models"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// minPasswordLength is the shortest password an administrator may set
+const minPasswordLength = 8
+
+// userRequest is the body for creating and updating users from the superadmin. On update,
+// fields left out keep their current value; roles and permissions apply to the user's
+// home company.
+type userRequest struct {
+	Username          *string   `json:"username"`
+	Email             *string   `json:"email"`
+	CompanyID         *string   `json:"company_id"`
+	ProfileImage      *string   `json:"profile_image"`
+	Active            *bool     `json:"active"`
+	Password          string    `json:"password"` // Only when creating
+	Roles             *[]string `json:"roles"`
+	Permissions       *[]string `json:"permissions"`
+	DeniedPermissions *[]string `json:"denied_permissions"`
+}
+
+// apply copies the provided profile fields onto user, returning problems by JSON field name
+func (req *userRequest) apply(user *models.User) map[string]string {
+	problems := map[string]string{}
+
+	if req.Username != nil {
+		user.Username = strings.TrimSpace(*req.Username)
+	}
+	if req.Email != nil {
+		user.Email = strings.ToLower(strings.TrimSpace(*req.Email))
+	}
+	if req.ProfileImage != nil {
+		user.ProfileImage = strings.TrimSpace(*req.ProfileImage)
+	}
+	if req.Active != nil {
+		user.Active = *req.Active
+	}
+	if req.CompanyID != nil {
+		companyID, err := uuid.Parse(*req.CompanyID)
+		if err != nil {
+			problems["company_id"] = "A company is required"
+		} else {
+			user.CompanyID = companyID
+		}
+	}
+
+	if user.Username == "" {
+		problems["username"] = "Username is required"
+	} else if len(user.Username) > 100 {
+		problems["username"] = "Username must be at most 100 characters"
+	}
+	if _, err := mail.ParseAddress(user.Email); err != nil || strings.ContainsAny(user.Email, "<> ") {
+		problems["email"] = "A valid email is required"
+	}
+	if user.ProfileImage != "" && !validWebURL(user.ProfileImage) {
+		problems["profile_image"] = "Profile image must be an http or https URL"
+	}
+
+	return problems
+}
+
+// parseUserAccess validates roles against those available in the company and permissions
+// against the known permissions
+func parseUserAccess(db *gorm.DB, companyID uuid.UUID, roleNames, grants, denies []string) (models.RoleArray, models.PermissionArray, models.PermissionArray, string) {
+	if len(roleNames) == 0 {
+		return nil, nil, nil, "At least one role is required"
+	}
+
+	roles := models.RoleArray{}
+	for _, r := range roleNames {
+		role := models.Role(strings.ToLower(strings.TrimSpace(r)))
+		if ok, err := authz.RoleExists(db, companyID, role); err != nil || !ok {
+			return nil, nil, nil, "Invalid role: " + r
+		}
+		if !containsRole(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	permissions, denied, msg := parseRolePermissions(grants, denies)
+	if msg != "" {
+		return nil, nil, nil, msg
+	}
+	return roles, permissions, denied, ""
+}
+
+// containsRole reports whether list includes role
+func containsRole(list models.RoleArray, role models.Role) bool {
+	for _, have := range list {
+		if have == role {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionStrings converts a permission list back to the names a request sends
+func permissionStrings(list models.PermissionArray) []string {
+	names := make([]string, len(list))
+	for i, p := range list {
+		names[i] = string(p)
+	}
+	return names
+}
+
+// userEmailTaken reports whether another user already signs in with email
+func userEmailTaken(db *gorm.DB, email string, except *models.User) bool {
+	query := db.Model(&models.User{}).Where("LOWER(email) = ?", email)
+	if except != nil {
+		query = query.Where("id <> ?", except.ID)
+	}
+	var count int64
+	query.Count(&count)
+	return count > 0
+}
+
+// SuperAdminViewUser renders a user's profile, access and company memberships
+func SuperAdminViewUser(c *fiber.Ctx) error { return renderUserView(c, "users", "") }
+
+// SuperAdminViewShipper renders a shipper user
+func SuperAdminViewShipper(c *fiber.Ctx) error {
+	return renderUserView(c, "shippers", models.RoleShipper)
+}
+
+// SuperAdminViewCarrier renders a carrier user
+func SuperAdminViewCarrier(c *fiber.Ctx) error {
+	return renderUserView(c, "carriers", models.RoleCarrier)
+}
+
+// SuperAdminViewBroker renders a broker (admin) user
+func SuperAdminViewBroker(c *fiber.Ctx) error {
+	return renderUserView(c, "brokers", models.RoleAdmin)
+}
+
+// SuperAdminNewUser renders the create user form
+func SuperAdminNewUser(c *fiber.Ctx) error { return renderUserForm(c, "users", "", true) }
+
+// SuperAdminNewShipper renders the create user form with the shipper role selected
+func SuperAdminNewShipper(c *fiber.Ctx) error {
+	return renderUserForm(c, "shippers", models.RoleShipper, true)
+}
+
+// SuperAdminNewCarrier renders the create user form with the carrier role selected
+func SuperAdminNewCarrier(c *fiber.Ctx) error {
+	return renderUserForm(c, "carriers", models.RoleCarrier, true)
+}
+
+// SuperAdminNewBroker renders the create user form with the admin role selected
+func SuperAdminNewBroker(c *fiber.Ctx) error {
+	return renderUserForm(c, "brokers", models.RoleAdmin, true)
+}
+
+// SuperAdminEditUser renders the edit user form
+func SuperAdminEditUser(c *fiber.Ctx) error { return renderUserForm(c, "users", "", false) }
+
+// SuperAdminEditShipper renders the edit form for a shipper user
+func SuperAdminEditShipper(c *fiber.Ctx) error {
+	return renderUserForm(c, "shippers", models.RoleShipper, false)
+}
+
+// SuperAdminEditCarrier renders the edit form for a carrier user
+func SuperAdminEditCarrier(c *fiber.Ctx) error {
+	return renderUserForm(c, "carriers", models.RoleCarrier, false)
+}
+
+// SuperAdminEditBroker renders the edit form for a broker (admin) user
+func SuperAdminEditBroker(c *fiber.Ctx) error {
+	return renderUserForm(c, "brokers", models.RoleAdmin, false)
+}
+
+// renderUserView shows the route's user. The shipper, carrier and broker pages only show
+// users holding that role.
+func renderUserView(c *fiber.Ctx, section string, role models.Role) error {
+	db := requestDB(c)
+
+	var user models.User
+	if err := db.Preload("Company").First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("User not found")
+	}
+	if role != "" && !hasRole(&user, role) {
+		return c.Status(fiber.StatusNotFound).SendString("User not found")
+	}
+
+	var memberships []models.CompanyMembership
+	db.Preload("Company").Where("user_id = ?", user.ID).Order("created_at").Find(&memberships)
+
+	return c.Render("superadmin/user_view", fiber.Map{
+		"Title":       user.Username,
+		"ActivePage":  section,
+		"Section":     section,
+		"Username":    c.Locals("username"),
+		"User":        user,
+		"Memberships": memberships,
+	}, "layouts/superadmin")
+}
+
+// renderUserForm shows the create or edit user form. New users start with role selected.
+func renderUserForm(c *fiber.Ctx, section string, role models.Role, isNew bool) error {
+	db := requestDB(c)
+
+	user := models.User{Active: true}
+	title := "New User"
+	if isNew {
+		if role != "" {
+			user.Roles = models.RoleArray{role}
+		}
+	} else {
+		if err := db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).SendString("User not found")
+		}
+		if role != "" && !hasRole(&user, role) {
+			return c.Status(fiber.StatusNotFound).SendString("User not found")
+		}
+		title = "Edit " + user.Username
+	}
+
+	var companies []models.Company
+	db.Select("id", "name", "company_type").Order("name").Find(&companies)
+
+	var roles []models.RoleDefinition
+	db.Preload("Company").Order("company_id NULLS FIRST, name").Find(&roles)
+
+	return c.Render("superadmin/user_form", fiber.Map{
+		"Title":       title,
+		"ActivePage":  section,
+		"Section":     section,
+		"Username":    c.Locals("username"),
+		"User":        user,
+		"IsNew":       isNew,
+		"Companies":   companies,
+		"Roles":       roles,
+		"Permissions": models.AllPermissions,
+	}, "layouts/superadmin")
+}
+
+// SuperAdminGetUser returns one user with their company memberships
+func SuperAdminGetUser(c *fiber.Ctx) error {
+	db := requestDB(c)
+
+	var user models.User
+	if err := db.Preload("Company").First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	var memberships []models.CompanyMembership
+	if err := db.Preload("Company").Where("user_id = ?", user.ID).Order("created_at").Find(&memberships).Error; err != nil {
+		fmt.Println("Error listing user memberships:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load user"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "user": user, "memberships": memberships})
+}
+
+// SuperAdminCreateUser creates a user in a company with the given roles and permissions
+func SuperAdminCreateUser(c *fiber.Ctx) error {
+	var req userRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	user := models.User{Active: true}
+	problems := req.apply(&user)
+	if req.CompanyID == nil {
+		problems["company_id"] = "A company is required"
+	}
+	if len(req.Password) < minPasswordLength {
+		problems["password"] = fmt.Sprintf("Password must be at least %d characters", minPasswordLength)
+	}
+	if len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed", "fields": problems})
+	}
+
+	db := requestDB(c)
+	var company models.Company
+	if err := db.First(&company, "id = ?", user.CompanyID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed", "fields": fiber.Map{"company_id": "Company not found"}})
+	}
+
+	var roleNames, grants, denies []string
+	if req.Roles != nil {
+		roleNames = *req.Roles
+	}
+	if req.Permissions != nil {
+		grants = *req.Permissions
+	}
+	if req.DeniedPermissions != nil {
+		denies = *req.DeniedPermissions
+	}
+	roles, permissions, denied, msg := parseUserAccess(db, company.ID, roleNames, grants, denies)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	user.Roles = roles
+	user.Permissions = permissions
+	user.DeniedPermissions = denied
+
+	if userEmailTaken(db, user.Email, nil) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A user with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Println("Error hashing password:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+	user.Password = string(hashedPassword)
+
+	// Active defaults to true in the database, so an inactive user is updated after create
+	active := user.Active
+	if err := db.Create(&user).Error; err != nil {
+		if isUniqueViolation(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A user with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+		}
+		fmt.Println("Error creating user:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create user"})
+	}
+	if !active {
+		db.Model(&user).Update("active", false)
+	}
+
+	// A company's first user owns it
+	if company.OwnerUserID == nil {
+		db.Model(&company).Update("owner_user_id", user.ID)
+	}
+
+	recordAudit(c, models.AuditUserCreated, "user", user.ID.String())
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "user": user})
+}
+
+// SuperAdminUpdateUser edits a user's profile, moves them to another company, and sets
+// their roles and permissions in their home company. Moving a user checks their roles
+// against the new company; a user who owns their company must transfer it first.
+func SuperAdminUpdateUser(c *fiber.Ctx) error {
+	var req userRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	db := requestDB(c)
+	var user models.User
+	if err := db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	previousCompanyID := user.CompanyID
+	wasActive := user.Active
+
+	if problems := req.apply(&user); len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed", "fields": problems})
+	}
+	if user.ID == currentUser(c).ID && !user.Active {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "You cannot deactivate your own account"})
+	}
+
+	moved := user.CompanyID != previousCompanyID
+	if moved {
+		var company models.Company
+		if err := db.First(&company, "id = ?", user.CompanyID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Validation failed", "fields": fiber.Map{"company_id": "Company not found"}})
+		}
+		var owned int64
+		db.Model(&models.Company{}).Where("id = ? AND owner_user_id = ?", previousCompanyID, user.ID).Count(&owned)
+		if owned > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This user owns their company, transfer ownership before moving them"})
+		}
+		var shared int64
+		db.Model(&models.CompanyMembership{}).Where("user_id = ? AND company_id = ?", user.ID, user.CompanyID).Count(&shared)
+		if shared > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This user is already a member of that company, remove the membership before moving them"})
+		}
+	}
+
+	// Roles are checked whenever they change, and again when the user changes company
+	accessChanged := req.Roles != nil || req.Permissions != nil || req.DeniedPermissions != nil
+	if accessChanged || moved {
+		roleNames := make([]string, len(user.Roles))
+		for i, r := range user.Roles {
+			roleNames[i] = string(r)
+		}
+		grants := permissionStrings(user.Permissions)
+		denies := permissionStrings(user.DeniedPermissions)
+		if req.Roles != nil {
+			roleNames = *req.Roles
+		}
+		if req.Permissions != nil {
+			grants = *req.Permissions
+		}
+		if req.DeniedPermissions != nil {
+			denies = *req.DeniedPermissions
+		}
+		roles, permissions, denied, msg := parseUserAccess(db, user.CompanyID, roleNames, grants, denies)
+		if msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+		}
+		user.Roles = roles
+		user.Permissions = permissions
+		user.DeniedPermissions = denied
+	}
+
+	if userEmailTaken(db, user.Email, &user) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A user with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+	}
+
+	err := db.Model(&user).Select(
+		"username", "email", "profile_image", "active", "company_id",
+		"roles", "permissions", "denied_permissions",
+	).Updates(&user).Error
+	if err != nil {
+		if isUniqueViolation(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A user with this email already exists", "fields": fiber.Map{"email": "Already in use"}})
+		}
+		fmt.Println("Error updating user:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update user"})
+	}
+
+	recordAudit(c, models.AuditUserUpdated, "user", user.ID.String())
+	if moved {
+		recordAudit(c, models.AuditUserMoved, "user", user.ID.String()+" from "+previousCompanyID.String()+" to "+user.CompanyID.String())
+	}
+	if accessChanged {
+		recordAudit(c, models.AuditUserAccessChanged, "user", user.ID.String())
+	}
+	if wasActive && !user.Active {
+		recordAudit(c, models.AuditUserDeactivated, "user", user.ID.String())
+	} else if !wasActive && user.Active {
+		recordAudit(c, models.AuditUserActivated, "user", user.ID.String())
+	}
+
+	db.Preload("Company").First(&user, "id = ?", user.ID)
+	return c.JSON(fiber.Map{"status": "success", "user": user})
+}
+
+// SuperAdminActivateUser lets a deactivated user sign in again
+func SuperAdminActivateUser(c *fiber.Ctx) error {
+	return setUserActive(c, true)
+}
+
+// SuperAdminDeactivateUser stops a user signing in; their existing sessions stop working
+// on the next request
+func SuperAdminDeactivateUser(c *fiber.Ctx) error {
+	return setUserActive(c, false)
+}
+
+// setUserActive toggles the route's user
+func setUserActive(c *fiber.Ctx, active bool) error {
+	db := requestDB(c)
+	var user models.User
+	if err := db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if user.ID == currentUser(c).ID && !active {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "You cannot deactivate your own account"})
+	}
+
+	if err := db.Model(&user).Update("active", active).Error; err != nil {
+		fmt.Println("Error updating user status:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update user"})
+	}
+
+	action := models.AuditUserDeactivated
+	if active {
+		action = models.AuditUserActivated
+	}
+	recordAudit(c, action, "user", user.ID.String())
+
+	return c.JSON(fiber.Map{"status": "success", "user": user})
+}
+
+// SuperAdminResetPassword sets a new password for a user
+func SuperAdminResetPassword(c *fiber.Ctx) error {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(req.Password) < minPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"fields": fiber.Map{"password": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)},
+		})
+	}
+
+	db := requestDB(c)
+	var user models.User
+	if err := db.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Println("Error hashing password:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+	if err := db.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+		fmt.Println("Error resetting password:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not reset password"})
+	}
+
+	recordAudit(c, models.AuditPasswordReset, "user", user.ID.String())
+	return c.JSON(fiber.Map{"status": "success", "message": "Password reset"})
+}
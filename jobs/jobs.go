@@ -0,0 +1,113 @@
+// Package jobs runs recurring background work such as the nightly compliance recheck.
+package jobs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Schedule returns the next time a job should run after t
+type Schedule func(t time.Time) time.Time
+
+// Daily runs a job once a day at the given local hour and minute
+func Daily(hour, minute int) Schedule {
+	return func(t time.Time) time.Time {
+		next := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, t.Location())
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	}
+}
+
+// Every runs a job at a fixed interval
+func Every(interval time.Duration) Schedule {
+	return func(t time.Time) time.Time { return t.Add(interval) }
+}
+
+// Job is a named piece of recurring work
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(db *gorm.DB) error
+}
+
+var (
+	mu         sync.Mutex
+	registered []Job
+	started    bool
+)
+
+// Register adds a job to run once Start is called
+func Register(name string, schedule Schedule, run func(db *gorm.DB) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered = append(registered, Job{Name: name, Schedule: schedule, Run: run})
+}
+
+// Start runs every registered job on its schedule. Set JOBS_ENABLED=false to
+// keep an instance from running jobs.
+func Start(db *gorm.DB) {
+	mu.Lock()
+	defer mu.Unlock()
+	if started || os.Getenv("JOBS_ENABLED") == "false" {
+		return
+	}
+	started = true
+
+	for _, job := range registered {
+		go loop(db, job)
+	}
+	fmt.Printf("Started %d background jobs\n", len(registered))
+}
+
+// loop waits for each scheduled time and runs the job
+func loop(db *gorm.DB, job Job) {
+	for {
+		next := job.Schedule(time.Now())
+		time.Sleep(time.Until(next))
+		RunOnce(db, job)
+	}
+}
+
+// RunOnce runs a job now unless another instance holds its lock. A panic in the
+// job is logged rather than taking the server down.
+func RunOnce(db *gorm.DB, job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Job %s panicked: %v\n", job.Name, r)
+		}
+	}()
+
+	// A Postgres advisory lock keeps the job to one instance at a time
+	h := fnv.New64a()
+	h.Write([]byte(job.Name))
+	key := int64(h.Sum64())
+
+	err := db.Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			fmt.Printf("Job %s is already running elsewhere, skipping\n", job.Name)
+			return nil
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", key)
+
+		started := time.Now()
+		if err := job.Run(conn); err != nil {
+			return err
+		}
+		fmt.Printf("Job %s finished in %s\n", job.Name, time.Since(started).Round(time.Millisecond))
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Job %s failed: %v\n", job.Name, err)
+	}
+}
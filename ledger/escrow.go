@@ -0,0 +1,164 @@
+package ledger
+
+import (
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidTransition is returned when an escrow cannot move to the requested status
+	ErrInvalidTransition = errors.New("invalid escrow status transition")
+	// ErrAlreadyFunded is returned when a load already has an escrow that wasn't refunded or
+	// returned
+	ErrAlreadyFunded = errors.New("load is already funded")
+	// ErrPODRequired is returned when releasing funds for a load without proof of delivery
+	ErrPODRequired = errors.New("proof of delivery is required before release")
+	// ErrInvalidAmount is returned when the escrow amount or fee is out of range
+	ErrInvalidAmount = errors.New("escrow amount must be positive and cover the fee")
+)
+
+// Fund records the shipper's funds for a booked load:
+// Dr receivable (shipper), Cr escrow held (shipper)
+func Fund(db *gorm.DB, load *models.Load, amountCents, feeCents int64, actorID *uuid.UUID) (*models.Escrow, error) {
+	if amountCents <= 0 || feeCents < 0 || feeCents > amountCents {
+		return nil, ErrInvalidAmount
+	}
+	if load.CarrierCompanyID == nil {
+		return nil, errors.New("load has no carrier assigned")
+	}
+
+	now := time.Now()
+	escrow := models.Escrow{
+		LoadID:           load.ID,
+		ShipperCompanyID: load.ShipperCompanyID,
+		CarrierCompanyID: *load.CarrierCompanyID,
+		AmountCents:      amountCents,
+		FeeCents:         feeCents,
+		Status:           models.EscrowStatusFunded,
+		FundedAt:         &now,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Escrow{}).Where("load_id = ? AND status NOT IN ?", load.ID, models.EndedEscrowStatuses).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyFunded
+		}
+		if err := tx.Create(&escrow).Error; err != nil {
+			return err
+		}
+
+		entry := escrowEntry(&escrow, "Escrow funded", actorID)
+		return Post(tx, entry, []Line{
+			Debit(escrow.ShipperCompanyID, models.AccountReceivable, amountCents),
+			Credit(escrow.ShipperCompanyID, models.AccountEscrowHeld, amountCents),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+// Hold locks funded escrow while the load is moving. No money moves so no entry is posted.
+func Hold(db *gorm.DB, escrowID uuid.UUID, actorID *uuid.UUID) (*models.Escrow, error) {
+	return transition(db, escrowID, func(tx *gorm.DB, escrow *models.Escrow) error {
+		if escrow.Status != models.EscrowStatusFunded {
+			return fmt.Errorf("%w: cannot hold %s escrow", ErrInvalidTransition, escrow.Status)
+		}
+		now := time.Now()
+		escrow.Status = models.EscrowStatusHeld
+		escrow.HeldAt = &now
+		return nil
+	})
+}
+
+// Release pays the carrier once proof of delivery is in and keeps the platform fee:
+// Dr escrow held (shipper), Cr payable (carrier), Cr platform fees (shipper)
+func Release(db *gorm.DB, escrowID uuid.UUID, actorID *uuid.UUID) (*models.Escrow, error) {
+	return transition(db, escrowID, func(tx *gorm.DB, escrow *models.Escrow) error {
+		if escrow.Status != models.EscrowStatusFunded && escrow.Status != models.EscrowStatusHeld {
+			return fmt.Errorf("%w: cannot release %s escrow", ErrInvalidTransition, escrow.Status)
+		}
+
+		var load models.Load
+		if err := tx.Where("id = ?", escrow.LoadID).First(&load).Error; err != nil {
+			return err
+		}
+		if load.PODReceivedAt == nil {
+			return ErrPODRequired
+		}
+
+		now := time.Now()
+		escrow.Status = models.EscrowStatusReleased
+		escrow.ReleasedAt = &now
+
+		lines := []Line{
+			Debit(escrow.ShipperCompanyID, models.AccountEscrowHeld, escrow.AmountCents),
+			Credit(escrow.CarrierCompanyID, models.AccountPayable, escrow.AmountCents-escrow.FeeCents),
+		}
+		if escrow.FeeCents > 0 {
+			lines = append(lines, Credit(escrow.ShipperCompanyID, models.AccountPlatformFees, escrow.FeeCents))
+		}
+		return Post(tx, escrowEntry(escrow, "Escrow released to carrier", actorID), lines)
+	})
+}
+
+// Refund returns the full escrow to the shipper when a load is cancelled:
+// Dr escrow held (shipper), Cr payable (shipper)
+func Refund(db *gorm.DB, escrowID uuid.UUID, actorID *uuid.UUID) (*models.Escrow, error) {
+	return transition(db, escrowID, func(tx *gorm.DB, escrow *models.Escrow) error {
+		if escrow.Status != models.EscrowStatusFunded && escrow.Status != models.EscrowStatusHeld {
+			return fmt.Errorf("%w: cannot refund %s escrow", ErrInvalidTransition, escrow.Status)
+		}
+
+		now := time.Now()
+		escrow.Status = models.EscrowStatusRefunded
+		escrow.RefundedAt = &now
+
+		return Post(tx, escrowEntry(escrow, "Escrow refunded to shipper", actorID), []Line{
+			Debit(escrow.ShipperCompanyID, models.AccountEscrowHeld, escrow.AmountCents),
+			Credit(escrow.ShipperCompanyID, models.AccountPayable, escrow.AmountCents),
+		})
+	})
+}
+
+// transition locks the escrow row, applies fn and saves the result in one transaction
+func transition(db *gorm.DB, escrowID uuid.UUID, fn func(tx *gorm.DB, escrow *models.Escrow) error) (*models.Escrow, error) {
+	var escrow models.Escrow
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", escrowID).First(&escrow).Error; err != nil {
+			return err
+		}
+		if err := fn(tx, &escrow); err != nil {
+			return err
+		}
+		return tx.Save(&escrow).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+// escrowEntry builds the journal entry header for an escrow movement
+func escrowEntry(escrow *models.Escrow, memo string, actorID *uuid.UUID) *models.JournalEntry {
+	loadID := escrow.LoadID
+	escrowID := escrow.ID
+	return &models.JournalEntry{
+		Memo:       memo,
+		Reference:  "escrow:" + escrow.ID.String(),
+		LoadID:     &loadID,
+		EscrowID:   &escrowID,
+		PostedByID: actorID,
+	}
+}
@@ -0,0 +1,145 @@
+// Package ledger implements the platform's internal double-entry ledger.
+// Every company has one account of each models.LedgerAccountType and all
+// money movements are recorded as balanced, immutable journal entries.
+package ledger
+
+import (
+	"cargozig_api/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrUnbalanced is returned when an entry's debits and credits do not match
+	ErrUnbalanced = errors.New("journal entry is not balanced")
+	// ErrEmptyEntry is returned when an entry has no lines or no amount
+	ErrEmptyEntry = errors.New("journal entry has no amount")
+	// ErrInvalidLine is returned when a line is negative or both debits and credits
+	ErrInvalidLine = errors.New("journal line must be a positive debit or credit")
+)
+
+// Line is a debit or credit against a company's account, resolved to a
+// models.LedgerAccount when the entry is posted
+type Line struct {
+	CompanyID   uuid.UUID
+	Account     models.LedgerAccountType
+	DebitCents  int64
+	CreditCents int64
+}
+
+// Debit returns a debit line
+func Debit(companyID uuid.UUID, account models.LedgerAccountType, cents int64) Line {
+	return Line{CompanyID: companyID, Account: account, DebitCents: cents}
+}
+
+// Credit returns a credit line
+func Credit(companyID uuid.UUID, account models.LedgerAccountType, cents int64) Line {
+	return Line{CompanyID: companyID, Account: account, CreditCents: cents}
+}
+
+// AccountBalance is an account together with its totals
+type AccountBalance struct {
+	Account      models.LedgerAccount `json:"account"`
+	DebitsCents  int64                `json:"debits_cents"`
+	CreditsCents int64                `json:"credits_cents"`
+	BalanceCents int64                `json:"balance_cents"` // Signed by the account's normal side
+}
+
+// Post validates and writes a journal entry and its lines in a single transaction
+func Post(db *gorm.DB, entry *models.JournalEntry, lines []Line) error {
+	var debits, credits int64
+	for _, line := range lines {
+		if line.DebitCents < 0 || line.CreditCents < 0 || (line.DebitCents > 0) == (line.CreditCents > 0) {
+			return ErrInvalidLine
+		}
+		debits += line.DebitCents
+		credits += line.CreditCents
+	}
+	if debits == 0 {
+		return ErrEmptyEntry
+	}
+	if debits != credits {
+		return fmt.Errorf("%w: debits %d, credits %d", ErrUnbalanced, debits, credits)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		entry.PostedAt = time.Now()
+		entry.Lines = nil
+		for _, line := range lines {
+			account, err := EnsureAccount(tx, line.CompanyID, line.Account)
+			if err != nil {
+				return err
+			}
+			entry.Lines = append(entry.Lines, models.JournalLine{
+				AccountID:   account.ID,
+				DebitCents:  line.DebitCents,
+				CreditCents: line.CreditCents,
+			})
+		}
+		return tx.Create(entry).Error
+	})
+}
+
+// EnsureAccount returns the company's account of the given type, opening it if needed
+func EnsureAccount(db *gorm.DB, companyID uuid.UUID, accountType models.LedgerAccountType) (*models.LedgerAccount, error) {
+	initial := models.LedgerAccount{CompanyID: companyID, Type: accountType, Currency: "USD"}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&initial).Error; err != nil {
+		return nil, err
+	}
+
+	var account models.LedgerAccount
+	if err := db.Where("company_id = ? AND type = ?", companyID, accountType).First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// Balance returns the totals for a single account
+func Balance(db *gorm.DB, account models.LedgerAccount) (AccountBalance, error) {
+	var totals struct {
+		Debits  int64
+		Credits int64
+	}
+	err := db.Model(&models.JournalLine{}).
+		Select("COALESCE(SUM(debit_cents), 0) AS debits, COALESCE(SUM(credit_cents), 0) AS credits").
+		Where("account_id = ?", account.ID).
+		Scan(&totals).Error
+	if err != nil {
+		return AccountBalance{}, err
+	}
+
+	balance := totals.Credits - totals.Debits
+	if account.Type.DebitNormal() {
+		balance = -balance
+	}
+
+	return AccountBalance{
+		Account:      account,
+		DebitsCents:  totals.Debits,
+		CreditsCents: totals.Credits,
+		BalanceCents: balance,
+	}, nil
+}
+
+// CompanyBalances returns the balance of every account the company has
+func CompanyBalances(db *gorm.DB, companyID uuid.UUID) ([]AccountBalance, error) {
+	var accounts []models.LedgerAccount
+	if err := db.Where("company_id = ?", companyID).Order("type").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	balances := make([]AccountBalance, 0, len(accounts))
+	for _, account := range accounts {
+		balance, err := Balance(db, account)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	return balances, nil
+}
@@ -0,0 +1,167 @@
+package ledger
+
+import (
+	"cargozig_api/models"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dryPool stands in for a connection. It reports being inside a transaction so nested
+// Transaction calls use savepoints rather than trying to begin one; nothing is ever sent
+// to it since the database is opened in DryRun mode.
+type dryPool struct{}
+
+func (dryPool) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, errors.New("dry run")
+}
+func (dryPool) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, errors.New("dry run")
+}
+func (dryPool) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("dry run")
+}
+func (dryPool) QueryRowContext(context.Context, string, ...interface{}) *sql.Row { return nil }
+func (dryPool) Commit() error                                                    { return nil }
+func (dryPool) Rollback() error                                                  { return nil }
+
+// statements records the SQL each statement would have run
+type statements struct {
+	logger.Interface
+	sql []string
+}
+
+func (s *statements) LogMode(logger.LogLevel) logger.Interface { return s }
+
+func (s *statements) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	s.sql = append(s.sql, sql)
+}
+
+// find returns the first recorded statement starting with prefix
+func (s *statements) find(prefix string) string {
+	for _, sql := range s.sql {
+		if strings.HasPrefix(sql, prefix) {
+			return sql
+		}
+	}
+	return ""
+}
+
+// openDryRun returns a database that builds statements without running them, and the
+// record of those statements
+func openDryRun(t *testing.T) (*gorm.DB, *statements) {
+	t.Helper()
+	recorded := &statements{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: dryPool{}}),
+		&gorm.Config{DryRun: true, SkipDefaultTransaction: true, Logger: recorded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, recorded
+}
+
+func TestPostRejectsBadEntries(t *testing.T) {
+	db, recorded := openDryRun(t)
+	shipper, carrier := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name  string
+		lines []Line
+		want  error
+	}{
+		{"no lines", nil, ErrEmptyEntry},
+		{"zero amounts", []Line{Debit(shipper, models.AccountEscrowHeld, 0)}, ErrInvalidLine},
+		{"negative debit", []Line{Debit(shipper, models.AccountEscrowHeld, -100), Credit(carrier, models.AccountPayable, -100)}, ErrInvalidLine},
+		{"debit and credit on one line", []Line{{CompanyID: shipper, Account: models.AccountPayable, DebitCents: 100, CreditCents: 100}}, ErrInvalidLine},
+		{"unbalanced", []Line{Debit(shipper, models.AccountEscrowHeld, 1000), Credit(carrier, models.AccountPayable, 900)}, ErrUnbalanced},
+	}
+	for _, tt := range tests {
+		if err := Post(db, &models.JournalEntry{Memo: tt.name}, tt.lines); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if len(recorded.sql) != 0 {
+		t.Errorf("rejected entries wrote to the database: %v", recorded.sql)
+	}
+}
+
+func TestPostWritesBalancedEntry(t *testing.T) {
+	db, recorded := openDryRun(t)
+	shipper, carrier := uuid.New(), uuid.New()
+
+	entry := &models.JournalEntry{Memo: "Escrow released to carrier"}
+	err := Post(db, entry, []Line{
+		Debit(shipper, models.AccountEscrowHeld, 10000),
+		Credit(carrier, models.AccountPayable, 9500),
+		Credit(shipper, models.AccountPlatformFees, 500),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Lines) != 3 || entry.Lines[0].DebitCents != 10000 || entry.Lines[1].CreditCents != 9500 || entry.Lines[2].CreditCents != 500 {
+		t.Errorf("entry lines = %+v", entry.Lines)
+	}
+	if recorded.find(`INSERT INTO "journal_entries"`) == "" || recorded.find(`INSERT INTO "journal_lines"`) == "" {
+		t.Errorf("entry and lines were not inserted: %v", recorded.sql)
+	}
+}
+
+func TestFundRejectsBadAmounts(t *testing.T) {
+	db, _ := openDryRun(t)
+	carrier := uuid.New()
+	load := &models.Load{ShipperCompanyID: uuid.New(), CarrierCompanyID: &carrier}
+
+	tests := []struct {
+		name   string
+		amount int64
+		fee    int64
+	}{
+		{"zero amount", 0, 0},
+		{"negative amount", -100, 0},
+		{"negative fee", 1000, -1},
+		{"fee over amount", 1000, 1001},
+	}
+	for _, tt := range tests {
+		if _, err := Fund(db, load, tt.amount, tt.fee, nil); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("%s: got %v, want ErrInvalidAmount", tt.name, err)
+		}
+	}
+
+	if _, err := Fund(db, &models.Load{ShipperCompanyID: uuid.New()}, 1000, 0, nil); err == nil {
+		t.Error("funded a load without a carrier")
+	}
+}
+
+func TestFundIgnoresEndedEscrows(t *testing.T) {
+	db, recorded := openDryRun(t)
+	carrier := uuid.New()
+	load := &models.Load{ShipperCompanyID: uuid.New(), CarrierCompanyID: &carrier}
+	load.ID = uuid.New()
+
+	escrow, err := Fund(db, load, 10000, 500, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.Status != models.EscrowStatusFunded || escrow.FundedAt == nil {
+		t.Errorf("escrow = %+v, want funded", escrow)
+	}
+
+	// A refunded or returned escrow must not stop the load being funded again
+	count := recorded.find(`SELECT count(*) FROM "escrows"`)
+	want := `load_id = '` + load.ID.String() + `' AND status NOT IN ('refunded','returned')`
+	if !strings.Contains(count, want) {
+		t.Errorf("existing escrow check = %q, want it to contain %q", count, want)
+	}
+	if recorded.find(`INSERT INTO "escrows"`) == "" || recorded.find(`INSERT INTO "journal_entries"`) == "" {
+		t.Errorf("escrow and its journal entry were not inserted: %v", recorded.sql)
+	}
+}
@@ -0,0 +1,100 @@
+package ledger
+
+import (
+	"cargozig_api/models"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SettleDebit records an ACH debit that has cleared the bank:
+// Dr settlement, Cr receivable
+func SettleDebit(db *gorm.DB, companyID uuid.UUID, amountCents int64, reference string) error {
+	return Post(db, &models.JournalEntry{Memo: "ACH debit settled", Reference: reference}, []Line{
+		Debit(companyID, models.AccountSettlement, amountCents),
+		Credit(companyID, models.AccountReceivable, amountCents),
+	})
+}
+
+// SettlePayout records a payout that has cleared the bank:
+// Dr payable, Cr settlement
+func SettlePayout(db *gorm.DB, companyID uuid.UUID, amountCents int64, reference string) error {
+	return Post(db, &models.JournalEntry{Memo: "Payout settled", Reference: reference}, []Line{
+		Debit(companyID, models.AccountPayable, amountCents),
+		Credit(companyID, models.AccountSettlement, amountCents),
+	})
+}
+
+// ReturnPayout reinstates the payable when a settled payout comes back:
+// Dr settlement, Cr payable
+func ReturnPayout(db *gorm.DB, companyID uuid.UUID, amountCents int64, reference string) error {
+	return Post(db, &models.JournalEntry{Memo: "Payout returned", Reference: reference}, []Line{
+		Debit(companyID, models.AccountSettlement, amountCents),
+		Credit(companyID, models.AccountPayable, amountCents),
+	})
+}
+
+// ChargeQuickPayFee keeps the quick-pay fee out of a carrier's payable:
+// Dr payable, Cr platform fees
+func ChargeQuickPayFee(db *gorm.DB, companyID uuid.UUID, feeCents int64, reference string) error {
+	return Post(db, &models.JournalEntry{Memo: "Quick-pay fee", Reference: reference}, []Line{
+		Debit(companyID, models.AccountPayable, feeCents),
+		Credit(companyID, models.AccountPlatformFees, feeCents),
+	})
+}
+
+// ReverseQuickPayFee gives the fee back when the quick payout is returned:
+// Dr platform fees, Cr payable
+func ReverseQuickPayFee(db *gorm.DB, companyID uuid.UUID, feeCents int64, reference string) error {
+	return Post(db, &models.JournalEntry{Memo: "Quick-pay fee reversed", Reference: reference}, []Line{
+		Debit(companyID, models.AccountPlatformFees, feeCents),
+		Credit(companyID, models.AccountPayable, feeCents),
+	})
+}
+
+// ReturnDebit unwinds an ACH debit that failed or was returned by the bank.
+// A settled debit is first taken back out of settlement (Dr receivable, Cr settlement).
+// If the debit funded an escrow that has not been released yet, the funding is
+// reversed (Dr escrow held, Cr receivable) and the escrow is marked returned.
+// Otherwise the receivable stays open as money the company still owes.
+func ReturnDebit(db *gorm.DB, companyID uuid.UUID, amountCents int64, settled bool, escrowID *uuid.UUID, reference string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if settled {
+			err := Post(tx, &models.JournalEntry{Memo: "ACH debit returned", Reference: reference}, []Line{
+				Debit(companyID, models.AccountReceivable, amountCents),
+				Credit(companyID, models.AccountSettlement, amountCents),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if escrowID == nil {
+			return nil
+		}
+
+		var escrow models.Escrow
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", *escrowID).First(&escrow).Error; err != nil {
+			return err
+		}
+		if escrow.Status != models.EscrowStatusFunded && escrow.Status != models.EscrowStatusHeld {
+			fmt.Printf("Escrow %s is %s, leaving receivable open after returned debit\n", escrow.ID, escrow.Status)
+			return nil
+		}
+
+		now := time.Now()
+		escrow.Status = models.EscrowStatusReturned
+		escrow.ReturnedAt = &now
+		if err := tx.Save(&escrow).Error; err != nil {
+			return err
+		}
+
+		return Post(tx, escrowEntry(&escrow, "Escrow funding returned", nil), []Line{
+			Debit(escrow.ShipperCompanyID, models.AccountEscrowHeld, escrow.AmountCents),
+			Credit(escrow.ShipperCompanyID, models.AccountReceivable, escrow.AmountCents),
+		})
+	})
+}
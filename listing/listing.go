@@ -0,0 +1,266 @@
+// Package listing turns list query parameters into paginated, sorted, filtered and
+// searched queries. The superadmin pages and JSON endpoints share it, so a list reads the
+// same ?page=&size=&sort=&q= parameters whether it is rendered or returned as JSON.
+package listing
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Page sizes
+const (
+	DefaultSize = 25
+	MaxSize     = 100
+)
+
+// Standard filters a Spec can enable
+const (
+	FilterCompany  = "company_id"
+	FilterActive   = "active"
+	FilterVerified = "verified"
+)
+
+// Spec describes what a list may be sorted, filtered and searched by
+type Spec struct {
+	Sorts       map[string]string // ?sort= value to column
+	DefaultSort string            // A Sorts key, prefixed with - for descending
+	Search      string            // SQL expression ?q= is matched against, backed by a trigram index
+	Filters     []string          // Standard filters that apply, e.g. FilterActive
+}
+
+// allows reports whether the spec enables a standard filter
+func (s Spec) allows(filter string) bool {
+	for _, f := range s.Filters {
+		if f == filter {
+			return true
+		}
+	}
+	return false
+}
+
+// Params is a parsed list request. The string fields hold the parameters as given, so
+// pages can put them back into their filter forms.
+type Params struct {
+	Page        int
+	Size        int
+	Sort        string
+	Q           string
+	CompanyID   string
+	Active      string
+	Verified    string
+	CreatedFrom string
+	CreatedTo   string
+
+	column   string
+	desc     bool
+	active   *bool
+	verified *bool
+	from     *time.Time
+	to       *time.Time
+	path     string
+	query    url.Values
+}
+
+// Parse reads list parameters from the request, rejecting unknown sorts and malformed filters
+func Parse(c *fiber.Ctx, spec Spec) (Params, error) {
+	p := Params{
+		Page:        c.QueryInt("page", 1),
+		Size:        c.QueryInt("size", DefaultSize),
+		Sort:        c.Query("sort", spec.DefaultSort),
+		Q:           strings.TrimSpace(c.Query("q")),
+		CompanyID:   c.Query(FilterCompany),
+		Active:      c.Query(FilterActive),
+		Verified:    c.Query(FilterVerified),
+		CreatedFrom: c.Query("created_from"),
+		CreatedTo:   c.Query("created_to"),
+		path:        c.Path(),
+		query:       url.Values{},
+	}
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		p.query.Add(string(key), string(value))
+	})
+
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Size < 1 {
+		p.Size = DefaultSize
+	}
+	if p.Size > MaxSize {
+		p.Size = MaxSize
+	}
+
+	name := strings.TrimPrefix(p.Sort, "-")
+	column, ok := spec.Sorts[name]
+	if !ok {
+		return p, fmt.Errorf("cannot sort by %q", name)
+	}
+	p.column = column
+	p.desc = strings.HasPrefix(p.Sort, "-")
+
+	if p.CompanyID != "" {
+		if !spec.allows(FilterCompany) {
+			return p, fmt.Errorf("cannot filter by company")
+		}
+		if _, err := uuid.Parse(p.CompanyID); err != nil {
+			return p, fmt.Errorf("invalid company_id")
+		}
+	}
+
+	var err error
+	if p.active, err = parseFlag(spec, FilterActive, p.Active); err != nil {
+		return p, err
+	}
+	if p.verified, err = parseFlag(spec, FilterVerified, p.Verified); err != nil {
+		return p, err
+	}
+	if p.from, err = parseDate("created_from", p.CreatedFrom, false); err != nil {
+		return p, err
+	}
+	if p.to, err = parseDate("created_to", p.CreatedTo, true); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// parseFlag reads a true/false filter the spec enables
+func parseFlag(spec Spec, filter, value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if !spec.allows(filter) {
+		return nil, fmt.Errorf("cannot filter by %s", filter)
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", filter)
+	}
+	return &b, nil
+}
+
+// parseDate reads a YYYY-MM-DD date or an RFC 3339 time. Upper bounds are exclusive, so a
+// plain date used as one is moved to the next day to cover the whole day.
+func parseDate(name, value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date such as 2024-01-31", name)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// Filter narrows query to the requested search and filters, without paging or sorting
+func (p Params) Filter(query *gorm.DB, spec Spec) *gorm.DB {
+	if p.Q != "" && spec.Search != "" {
+		query = query.Where("("+spec.Search+") ILIKE ?", "%"+escapeLike(p.Q)+"%")
+	}
+	if p.CompanyID != "" {
+		query = query.Where("company_id = ?", p.CompanyID)
+	}
+	if p.active != nil {
+		query = query.Where("active = ?", *p.active)
+	}
+	if p.verified != nil {
+		query = query.Where("verified = ?", *p.verified)
+	}
+	if p.from != nil {
+		query = query.Where("created_at >= ?", *p.from)
+	}
+	if p.to != nil {
+		query = query.Where("created_at < ?", *p.to)
+	}
+	return query
+}
+
+// Order sorts query by the requested sort. id breaks ties so rows don't move between pages.
+func (p Params) Order(query *gorm.DB) *gorm.DB {
+	order := p.column
+	if p.desc {
+		order += " DESC"
+	}
+	return query.Order(order).Order("id")
+}
+
+// escapeLike stops % and _ in a search term acting as wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Pagination describes the page returned, for templates and JSON
+type Pagination struct {
+	CurrentPage int    `json:"page"`
+	Size        int    `json:"size"`
+	Total       int64  `json:"total"`
+	TotalPages  int    `json:"total_pages"`
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	Sort        string `json:"sort"`
+	PrevURL     string `json:"prev_url,omitempty"`
+	NextURL     string `json:"next_url,omitempty"`
+}
+
+// Find loads one page of query into dest. query must have its model set.
+func Find(query *gorm.DB, spec Spec, p Params, dest interface{}) (Pagination, error) {
+	filtered := p.Filter(query, spec).Session(&gorm.Session{})
+
+	var total int64
+	if err := filtered.Count(&total).Error; err != nil {
+		return Pagination{}, err
+	}
+
+	err := p.Order(filtered).
+		Offset((p.Page - 1) * p.Size).Limit(p.Size).Find(dest).Error
+	if err != nil {
+		return Pagination{}, err
+	}
+
+	page := Pagination{
+		CurrentPage: p.Page,
+		Size:        p.Size,
+		Total:       total,
+		TotalPages:  int(math.Ceil(float64(total) / float64(p.Size))),
+		Sort:        p.Sort,
+	}
+	if offset := (p.Page - 1) * p.Size; int64(offset) < total {
+		page.Start = offset + 1
+		page.End = offset + p.Size
+		if int64(page.End) > total {
+			page.End = int(total)
+		}
+	}
+	if p.Page > 1 {
+		page.PrevURL = p.pageURL(p.Page - 1)
+	}
+	if p.Page < page.TotalPages {
+		page.NextURL = p.pageURL(p.Page + 1)
+	}
+	return page, nil
+}
+
+// pageURL is the current request with a different page
+func (p Params) pageURL(page int) string {
+	query := url.Values{}
+	for key, values := range p.query {
+		query[key] = values
+	}
+	query.Set("page", strconv.Itoa(page))
+	return p.path + "?" + query.Encode()
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"cargozig_api/analytics"
+	"cargozig_api/compliance"
 	"cargozig_api/config"
+	"cargozig_api/fmcsa"
 	"cargozig_api/handlers"
+	"cargozig_api/jobs"
+	"cargozig_api/middleware"
 	"fmt"
 	"log"
 	"os"
@@ -44,6 +49,9 @@ func main() {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 
+	// Put stored platform settings, such as the email server, into effect
+	handlers.LoadPlatformSettings(db)
+
 	// Middleware
 	app.Use(logger.New())
 	app.Use(cors.New(cors.Config{
@@ -61,6 +69,9 @@ func main() {
 		},
 	}))
 
+	// Pages show a banner while a superadmin is impersonating a user
+	app.Use(middleware.ImpersonationBanner())
+
 	// Routes
 	// Public routes (no auth required)
 	handlers.SetupPublicRoutes(app) // public routes
@@ -69,14 +80,55 @@ func main() {
 	adminGroup := app.Group("/toc")             // tactical operations center group admin- index page group
 	superAdminGroup := app.Group("/superadmin") // super admin portal for platform management
 	apiGroup := app.Group("/api")
+	loadGroup := apiGroup.Group("/loads")
+	invoiceGroup := apiGroup.Group("/invoices")
+	escrowGroup := apiGroup.Group("/escrow")
+	ledgerGroup := apiGroup.Group("/ledger")
+	paymentGroup := apiGroup.Group("/payments")
+	achGroup := apiGroup.Group("/ach")
+	factoringGroup := apiGroup.Group("/factoring")
+	settingsGroup := apiGroup.Group("/settings")
+	onboardingGroup := apiGroup.Group("/onboarding")
+	fmcsaGroup := apiGroup.Group("/fmcsa")
+	insuranceGroup := apiGroup.Group("/insurance")
+	fleetGroup := apiGroup.Group("/fleet")
+	capacityGroup := apiGroup.Group("/capacity")
+	companyGroup := apiGroup.Group("/company")
+	authzGroup := apiGroup.Group("/authz")
+	impersonationGroup := apiGroup.Group("/impersonation")
 	//mcpv1Group := app.Group("/mcpv1")
 
 	// Register routes from handlers.
-	handlers.SetupAdminRoutes(adminGroup)           // deathstar
-	handlers.SetupSuperAdminRoutes(superAdminGroup) // super admin portal
-	handlers.SetupApiAuthRoutes(apiGroup)           // api
+	handlers.SetupAdminRoutes(adminGroup)                 // deathstar
+	handlers.SetupSuperAdminRoutes(superAdminGroup)       // super admin portal
+	handlers.SetupApiAuthRoutes(apiGroup)                 // api
+	handlers.SetupLoadRoutes(loadGroup)                   // loads
+	handlers.SetupInvoiceRoutes(invoiceGroup)             // invoices and carrier payables
+	handlers.SetupEscrowRoutes(escrowGroup)               // escrow lifecycle
+	handlers.SetupLedgerRoutes(ledgerGroup)               // ledger balances and entries
+	handlers.SetupPaymentRoutes(paymentGroup)             // bank accounts, ACH and payouts
+	handlers.SetupAchRoutes(achGroup)                     // NACHA payout files and returns
+	handlers.SetupFactoringRoutes(factoringGroup)         // notices of assignment to factors
+	handlers.SetupSettingsRoutes(settingsGroup)           // platform settings such as the quick-pay fee schedule
+	handlers.SetupOnboardingRoutes(onboardingGroup)       // carrier onboarding and review queue
+	handlers.SetupFMCSARoutes(fmcsaGroup)                 // FMCSA snapshot lookup and imports
+	handlers.SetupInsuranceRoutes(insuranceGroup)         // insurance certificates and coverage
+	handlers.SetupFleetRoutes(fleetGroup)                 // carrier trucks, trailers, drivers and availability
+	handlers.SetupCapacityRoutes(capacityGroup)           // truck capacity posting board
+	handlers.SetupCompanyRoutes(companyGroup)             // company members, invitations, ownership and switching
+	handlers.SetupAuthzRoutes(authzGroup)                 // authorization policy explain endpoint
+	handlers.SetupImpersonationRoutes(impersonationGroup) // stop or inspect a superadmin impersonation session
 	//handlers.SetupMcpv1Routes(mcpv1Group) // mcpv1
 
+	// Background jobs
+	jobs.Register("fmcsa_nightly", jobs.Daily(3, 0), fmcsa.Nightly)                            // import new bulk files and recheck carriers
+	jobs.Register("insurance_checks", jobs.Daily(6, 0), compliance.RunInsuranceChecks)         // expiry reminders and compliance holds
+	jobs.Register("capacity_expiry", jobs.Every(15*time.Minute), handlers.ExpireCapacityPosts) // take stale capacity posts off the board
+	jobs.Register("recycle_bin_purge", jobs.Daily(4, 0), handlers.PurgeDeletedRecords)         // remove records deleted past the retention period
+	jobs.Register("analytics_rollup", jobs.Every(15*time.Minute), analytics.Refresh)           // recount recent days for the superadmin dashboard
+	jobs.Register("analytics_rebuild", jobs.Daily(2, 0), analytics.Rebuild)                    // recount every day, dropping deleted records
+	jobs.Start(db)
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"cargozig_api/models"
+	"cargozig_api/tenant"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// auditTestDB builds statements against the tenant owned tables without connecting
+func auditTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=audit_test sslmode=disable"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tenant.Register(db, &models.Load{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// serveAs runs handler behind LoadUser as user and returns the audit entries written
+func serveAs(t *testing.T, user *models.User, handler fiber.Handler) []models.AuditLog {
+	t.Helper()
+
+	var written []models.AuditLog
+	previous := writeAuditLog
+	writeAuditLog = func(entry *models.AuditLog) error {
+		written = append(written, *entry)
+		return nil
+	}
+	t.Cleanup(func() { writeAuditLog = previous })
+
+	app := fiber.New()
+	app.Get("/loads", func(c *fiber.Ctx) error {
+		c.Locals("user", user) // LoadUser takes an already loaded user as it is
+		return c.Next()
+	}, LoadUser(), handler)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/loads", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	return written
+}
+
+func TestSystemAdminBypassIsAudited(t *testing.T) {
+	db := auditTestDB(t)
+	admin := &models.User{CompanyID: uuid.New(), Permissions: models.PermissionArray{models.SystemAdmin}}
+	admin.ID = uuid.New()
+
+	written := serveAs(t, admin, func(c *fiber.Ctx) error {
+		db.WithContext(c.UserContext()).Find(&[]models.Load{})
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	if len(written) != 1 {
+		t.Fatalf("wrote %d audit entries, want 1", len(written))
+	}
+	entry := written[0]
+	if entry.Action != models.AuditTenantBypass || entry.Resource != "loads:query" {
+		t.Errorf("audit entry = %s %q, want %s %q", entry.Action, entry.Resource, models.AuditTenantBypass, "loads:query")
+	}
+	if entry.ActorID == nil || *entry.ActorID != admin.ID || entry.Path != "/loads" {
+		t.Errorf("audit entry does not name the admin and request: %+v", entry)
+	}
+}
+
+func TestSystemLiftIsAudited(t *testing.T) {
+	db := auditTestDB(t)
+	carrier := &models.User{CompanyID: uuid.New(), Roles: models.RoleArray{models.RoleCarrier}}
+	carrier.ID = uuid.New()
+
+	written := serveAs(t, carrier, func(c *fiber.Ctx) error {
+		tenant.System(db.WithContext(c.UserContext()), "load-board").Find(&[]models.Load{})
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	if len(written) != 1 || written[0].Resource != "loads:query:load-board" {
+		t.Errorf("audit entries = %+v, want one for loads:query:load-board", written)
+	}
+}
+
+func TestScopedRequestIsNotAudited(t *testing.T) {
+	db := auditTestDB(t)
+	carrier := &models.User{CompanyID: uuid.New(), Roles: models.RoleArray{models.RoleCarrier}}
+	carrier.ID = uuid.New()
+
+	written := serveAs(t, carrier, func(c *fiber.Ctx) error {
+		db.WithContext(c.UserContext()).Find(&[]models.Load{})
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	if len(written) != 0 {
+		t.Errorf("wrote audit entries for a request that stayed in its company: %+v", written)
+	}
+}
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"cargozig_api/authz"
 	"cargozig_api/config" // Import config to access the initialized database
 	"cargozig_api/models"
+	"cargozig_api/tenant"
 	"fmt"
 	"strings"
 
@@ -81,9 +83,17 @@ func AuthenticateUser() fiber.Handler {
 			}
 		}
 
+		// Tokens issued before company scoping carry no company claim
+		companyID, _ := claims["company_id"].(string)
+
+		// Impersonation tokens name the session they were issued for
+		impersonationID, _ := claims["impersonation_id"].(string)
+
 		// Store user info in context for downstream handlers
 		c.Locals("user_id", userID)
+		c.Locals("company_id", companyID)
 		c.Locals("roles", roles)
+		c.Locals("impersonation_id", impersonationID)
 
 		return c.Next()
 	}
@@ -92,23 +102,16 @@ func AuthenticateUser() fiber.Handler {
 // RequireRole checks if the authenticated user has any of the required roles
 func RequireRole(requiredRoles ...models.Role) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// This middleware should be used after AuthenticateUser
-		userRolesInterface := c.Locals("roles")
-		if userRolesInterface == nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		// This middleware should be used after AuthenticateUser. Roles are the active
+		// company's, not the ones in the token.
+		user, status, message := activeUser(c)
+		if user == nil {
+			return c.Status(status).JSON(fiber.Map{
 				"status":  "error",
-				"message": "Authentication required",
-			})
-		}
-
-		// Convert roles to the correct type
-		userRoles, ok := userRolesInterface.([]models.Role)
-		if !ok {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Invalid role format",
+				"message": message,
 			})
 		}
+		userRoles := []models.Role(user.Roles)
 
 		// Check if user has any of the required roles
 		for _, userRole := range userRoles {
@@ -133,90 +136,133 @@ func RequireRole(requiredRoles ...models.Role) fiber.Handler {
 	}
 }
 
-// RequirePermission checks if the authenticated user has the required permission
+// RequirePermission checks if the authenticated user has the required permission in their
+// active company. Roles, personal grants and denies are resolved by the authz package.
 func RequirePermission(requiredPermission models.Permission) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// This middleware should be used after AuthenticateUser
-		userID := c.Locals("user_id")
-		if userID == nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		user, status, message := activeUser(c)
+		if user == nil {
+			return c.Status(status).JSON(fiber.Map{
 				"status":  "error",
-				"message": "Authentication required",
+				"message": message,
 			})
 		}
 
-		// Get user roles from context
-		userRoles, ok := c.Locals("roles").([]models.Role)
-		if !ok {
-			// If roles aren't in context, we need to fetch the user from DB
-			db := config.GetDB() // Get the database from config
-			var user models.User
-			if err := db.First(&user, "id = ?", userID).Error; err != nil {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"status":  "error",
-					"message": "User not found",
-				})
-			}
-			userRoles = user.Roles
-		}
-
-		// Check if any of the user's roles have the required permission
-		for _, role := range userRoles {
-			if role.HasPermission(requiredPermission) {
-				return c.Next()
-			}
-		}
-
-		// If admin role, allow access
-		for _, role := range userRoles {
-			if role == models.RoleAdmin {
-				return c.Next()
-			}
-		}
-
-		// Check for System Admin permission which overrides other permissions
-		for _, role := range userRoles {
-			permissions := models.DefaultRolePermissions[role]
-			for _, perm := range permissions {
-				if perm == models.SystemAdmin {
-					return c.Next()
-				}
-			}
+		if !user.HasPermission(requiredPermission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Access denied: required permission not granted",
+			})
 		}
 
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Access denied: required permission not granted",
-		})
+		return c.Next()
 	}
 }
 
 // LoadUser fetches the full user record and adds it to the request context
 func LoadUser() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userID := c.Locals("user_id")
-		if userID == nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		user, status, message := activeUser(c)
+		if user == nil {
+			return c.Status(status).JSON(fiber.Map{
 				"status":  "error",
-				"message": "Authentication required",
+				"message": message,
 			})
 		}
 
-		// Fetch the user from the database
-		db := config.GetDB() // Get the database from config
-		var user models.User
-		if err := db.First(&user, "id = ?", userID).Error; err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"status":  "error",
-				"message": "User not found",
-			})
+		// Scope the request's database access to the user's company
+		scope := tenant.ForCompany(user.ID, user.CompanyID)
+		if user.HasPermission(models.SystemAdmin) {
+			scope = tenant.ForSystemAdmin(user.ID, user.CompanyID)
 		}
+		c.SetUserContext(tenant.WithScope(c.UserContext(), scope))
 
-		// Store the full user object in context
-		c.Locals("user", &user)
+		err := c.Next()
+		if tables := scope.BypassedTables(); len(tables) > 0 {
+			auditTenantBypass(c, user, tables)
+		}
+		if actor := Impersonator(c); actor != nil {
+			auditImpersonatedRequest(c, actor, user)
+		}
+		return err
+	}
+}
 
-		return c.Next()
+// activeUser loads the authenticated user as they are in their active company, with their
+// permissions resolved. It is loaded once per request and kept in the context. On failure
+// it returns a nil user with the status and message to respond with.
+func activeUser(c *fiber.Ctx) (*models.User, int, string) {
+	if user, ok := c.Locals("user").(*models.User); ok && user != nil {
+		return user, 0, ""
+	}
+
+	userID := c.Locals("user_id")
+	if userID == nil {
+		return nil, fiber.StatusUnauthorized, "Authentication required"
+	}
+
+	// Fetch the user from the database
+	db := config.GetDB() // Get the database from config
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fiber.StatusUnauthorized, "User not found"
 	}
+
+	// Deactivated members lose access straight away, not when their token expires
+	if !user.Active {
+		return nil, fiber.StatusUnauthorized, "Account is disabled"
+	}
+
+	// An impersonation token only works while its session is live and its system admin
+	// still is one
+	if impersonationID, _ := c.Locals("impersonation_id").(string); impersonationID != "" {
+		actor, message := impersonationActor(db, impersonationID, &user)
+		if actor == nil {
+			return nil, fiber.StatusUnauthorized, message
+		}
+		c.Locals("impersonator", actor)
+	}
+
+	homeCompanyID := user.CompanyID
+
+	// A token for a company other than the user's home company selects one of their
+	// memberships. It is stale once that membership is removed or deactivated.
+	if companyID, _ := c.Locals("company_id").(string); companyID != "" && companyID != user.CompanyID.String() {
+		var membership models.CompanyMembership
+		if err := db.Where("user_id = ? AND company_id = ? AND active = ?", user.ID, companyID, true).
+			First(&membership).Error; err != nil {
+			return nil, fiber.StatusUnauthorized, "Session is for a company you no longer belong to, please sign in again"
+		}
+		membership.ApplyTo(&user)
+		c.Locals("membership", &membership)
+	} else if !user.HomeCompanyActive {
+		// Deactivated by their home company, the user can still sign in to their memberships
+		return nil, fiber.StatusUnauthorized, "Your access to this company has been deactivated, please sign in again"
+	}
+
+	// A deactivated company suspends its members, both in it and when they work for
+	// another company through a membership. user.CompanyID is the membership's company by now.
+	var inactive int64
+	if err := db.Model(&models.Company{}).
+		Where("id IN ? AND active = ?", []interface{}{homeCompanyID, user.CompanyID}, false).
+		Count(&inactive).Error; err != nil {
+		fmt.Println("Error checking company status:", err)
+		return nil, fiber.StatusInternalServerError, "Could not load company"
+	}
+	if inactive > 0 {
+		return nil, fiber.StatusForbidden, "Company is deactivated"
+	}
+
+	if err := authz.Resolve(db, &user); err != nil {
+		fmt.Println("Error resolving permissions:", err)
+		return nil, fiber.StatusInternalServerError, "Could not load permissions"
+	}
+
+	// Store the full user object in context
+	c.Locals("user", &user)
+	c.Locals("roles", []models.Role(user.Roles))
+	return &user, 0, ""
 }
 
 // RequireSuperAdmin ensures the user has super admin permissions and redirects if not
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/config"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ResourceLoader fetches the resource a route acts on, usually from a path parameter
+type ResourceLoader func(c *fiber.Ctx) (interface{}, error)
+
+// Authorize checks the policy allows the user to perform action on the route's resource
+// and stores the resource in c.Locals("resource") for the handler. Resources the user
+// cannot even view are reported as not found.
+func Authorize(action authz.Action, load ResourceLoader) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, status, message := activeUser(c)
+		if user == nil {
+			return c.Status(status).JSON(fiber.Map{
+				"status":  "error",
+				"message": message,
+			})
+		}
+
+		resource, err := load(c)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Not found",
+			})
+		}
+
+		db := config.GetDB()
+		decision := authz.Authorize(db, user, action, resource)
+		if !decision.Allowed {
+			view := authz.Action(action.Kind() + ":view")
+			if action == view || !authz.Can(db, user, view, resource) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"status":  "error",
+					"message": "Not found",
+				})
+			}
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": fmt.Sprintf("Access denied: %s", decision.Reason),
+			})
+		}
+
+		c.Locals("resource", resource)
+		return c.Next()
+	}
+}
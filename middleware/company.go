@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"cargozig_api/config"
+	"cargozig_api/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireVerifiedCompany blocks carrier actions until the user's company has passed
+// onboarding review, and while it is on compliance hold. Shipper-only companies that
+// have never been held and system admins are not affected.
+// This middleware should be used after LoadUser.
+func RequireVerifiedCompany() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(*models.User)
+		if !ok || user == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Authentication required",
+			})
+		}
+		if user.HasPermission(models.SystemAdmin) {
+			return c.Next()
+		}
+
+		var company models.Company
+		if err := config.GetDB().First(&company, "id = ?", user.CompanyID).Error; err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Company not found",
+			})
+		}
+
+		if company.CompanyType != "shipper" && !company.Verified {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Company verification required: complete carrier onboarding and wait for approval",
+			})
+		}
+		if company.ComplianceHold {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Company is on compliance hold: " + company.HoldReason,
+			})
+		}
+
+		return c.Next()
+	}
+}
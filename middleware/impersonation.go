@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"cargozig_api/authz"
+	"cargozig_api/config"
+	"cargozig_api/models"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Impersonation is what the layouts show in their banner while a system admin is
+// impersonating a user
+type Impersonation struct {
+	SessionID string
+	Username  string
+	ActorName string
+	ExpiresAt time.Time
+}
+
+// impersonationActor checks the impersonation session a token was issued for is still live
+// and returns the system admin behind it. On failure it returns nil and the message to
+// respond with.
+func impersonationActor(db *gorm.DB, sessionID string, user *models.User) (*models.User, string) {
+	var session models.ImpersonationSession
+	if err := db.Preload("Actor").First(&session, "id = ? AND user_id = ?", sessionID, user.ID).Error; err != nil {
+		return nil, "Impersonation session not found"
+	}
+	if !session.Live(time.Now()) {
+		return nil, "Impersonation session has ended"
+	}
+
+	actor := session.Actor
+	if actor == nil || !actor.Active {
+		return nil, "Impersonation session has ended"
+	}
+	if err := authz.Resolve(db, actor); err != nil {
+		fmt.Println("Error resolving permissions:", err)
+		return nil, "Could not load permissions"
+	}
+	if !actor.HasPermission(models.SystemAdmin) {
+		return nil, "Impersonation session has ended"
+	}
+	return actor, ""
+}
+
+// Impersonator returns the system admin impersonating the request's user, or nil when the
+// user is acting as themselves. This should be used after LoadUser.
+func Impersonator(c *fiber.Ctx) *models.User {
+	actor, _ := c.Locals("impersonator").(*models.User)
+	return actor
+}
+
+// DenyWhileImpersonating refuses sensitive actions, such as payouts and password changes,
+// while a system admin is impersonating the user. This middleware should be used after
+// LoadUser.
+func DenyWhileImpersonating() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		actor := Impersonator(c)
+		if actor == nil {
+			return c.Next()
+		}
+
+		entry := models.AuditLog{
+			ActorID:   &actor.ID,
+			CompanyID: &actor.CompanyID,
+			Action:    models.AuditImpersonationBlocked,
+			Resource:  "users",
+			Method:    c.Method(),
+			Path:      c.Path(),
+			IP:        c.IP(),
+			Detail:    fmt.Sprint(c.Locals("user_id")),
+		}
+		if err := config.GetDB().Create(&entry).Error; err != nil {
+			fmt.Println("Error writing audit log:", err)
+		}
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Not allowed while impersonating a user",
+		})
+	}
+}
+
+// auditImpersonatedRequest records a request a system admin made as another user
+func auditImpersonatedRequest(c *fiber.Ctx, actor, user *models.User) {
+	entry := models.AuditLog{
+		ActorID:   &actor.ID,
+		CompanyID: &actor.CompanyID,
+		Action:    models.AuditImpersonatedRequest,
+		Resource:  "users",
+		Method:    c.Method(),
+		Path:      c.Path(),
+		IP:        c.IP(),
+		Detail:    fmt.Sprintf("%s, status %d", user.ID, c.Response().StatusCode()),
+	}
+	if err := writeAuditLog(&entry); err != nil {
+		fmt.Println("Error writing audit log:", err)
+	}
+}
+
+// ImpersonationBanner makes an active impersonation session available to every page as
+// .Impersonation, so the layouts can show who is being impersonated and a way to stop
+func ImpersonationBanner() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.Cookies("auth_token")
+		if token == "" {
+			return c.Next()
+		}
+		claims, err := ParseJWT(token)
+		if err != nil {
+			return c.Next()
+		}
+		sessionID, _ := claims["impersonation_id"].(string)
+		if sessionID == "" {
+			return c.Next()
+		}
+
+		var session models.ImpersonationSession
+		err = config.GetDB().Preload("User").Preload("Actor").First(&session, "id = ?", sessionID).Error
+		if err != nil || !session.Live(time.Now()) || session.User == nil || session.Actor == nil {
+			return c.Next()
+		}
+
+		if err := c.Bind(fiber.Map{"Impersonation": &Impersonation{
+			SessionID: session.ID.String(),
+			Username:  session.User.Username,
+			ActorName: session.Actor.Username,
+			ExpiresAt: session.ExpiresAt,
+		}}); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
@@ -38,7 +38,7 @@ func ParseJWT(tokenStr string) (jwt.MapClaims, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return jwtSecret, nil
+		return GetJWTSecret(), nil
 	})
 
 	if err != nil {
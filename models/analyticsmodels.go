@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Analytics metrics. Event metrics count what happened on each day; snapshot metrics
+// record how things stood on each day the rollup was refreshed.
+const (
+	MetricSignups          = "signups"           // Users created, by role
+	MetricCompaniesCreated = "companies_created" // Companies created, by company type
+	MetricContacts         = "contacts"          // Contact form submissions, by status
+
+	MetricUsers       = "users"        // Users, in total and by role
+	MetricCompanies   = "companies"    // Companies, by company type and verification, e.g. "carrier:verified"
+	MetricActiveUsers = "active_users" // Users who signed in within 1, 7 or 30 days, e.g. "7d"
+)
+
+// DimensionTotal is the dimension holding a metric's overall count
+const DimensionTotal = "total"
+
+// AnalyticsRollup is the count of one metric for one dimension on one day (UTC). The
+// rollups are refreshed on a schedule so the superadmin dashboard and analytics pages
+// don't count every user on each load.
+type AnalyticsRollup struct {
+	BaseModel
+	Metric    string    `json:"metric" gorm:"uniqueIndex:idx_analytics_rollup"`
+	Dimension string    `json:"dimension" gorm:"uniqueIndex:idx_analytics_rollup"`
+	Bucket    time.Time `json:"bucket" gorm:"type:date;uniqueIndex:idx_analytics_rollup"`
+	Count     int64     `json:"count"`
+}
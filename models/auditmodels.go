@@ -0,0 +1,61 @@
+package models
+
+import "github.com/google/uuid"
+
+// Audit actions
+const (
+	AuditTenantBypass = "tenant_bypass" // A system admin read or wrote other companies' rows
+)
+
+// Audit actions for user administration
+const (
+	AuditUserCreated       = "user_created"
+	AuditUserUpdated       = "user_updated"
+	AuditUserMoved         = "user_moved" // Home company changed
+	AuditUserAccessChanged = "user_access_changed"
+	AuditUserActivated     = "user_activated"
+	AuditUserDeactivated   = "user_deactivated"
+	AuditPasswordReset     = "password_reset"
+)
+
+// Audit actions for company administration
+const (
+	AuditCompanyActivated   = "company_activated"
+	AuditCompanyDeactivated = "company_deactivated"
+	AuditCompanyVerified    = "company_verified"
+	AuditCompanyUnverified  = "company_unverified"
+)
+
+// Audit actions for the recycle bin
+const (
+	AuditRecordRestored = "record_restored"
+	AuditRecordPurged   = "record_purged"
+)
+
+// Audit actions for superadmin deletes
+const (
+	AuditRecordDeleted = "record_deleted" // Detail lists the dependents changed with it
+)
+
+// Audit actions for bulk imports
+const (
+	AuditRecordsImported = "records_imported" // Detail gives the count and file name
+)
+
+// Audit actions for exports
+const (
+	AuditRecordsExported = "records_exported" // Detail gives the format and filters
+)
+
+// AuditLog records a sensitive action and who took it
+type AuditLog struct {
+	BaseModel
+	ActorID   *uuid.UUID `json:"actor_id,omitempty" gorm:"type:uuid;index"`
+	CompanyID *uuid.UUID `json:"company_id,omitempty" gorm:"type:uuid;index"` // Actor's company
+	Action    string     `json:"action" gorm:"index"`
+	Resource  string     `json:"resource"`
+	Method    string     `json:"method"`
+	Path      string     `json:"path"`
+	IP        string     `json:"ip"`
+	Detail    string     `json:"detail,omitempty"`
+}
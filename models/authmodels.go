@@ -191,19 +191,34 @@ func (base *BaseModel) BeforeCreate(tx *gorm.DB) error {
 
 type User struct {
 	BaseModel
-	Username     string          `json:"username"`
-	Email        string          `json:"email" gorm:"uniqueIndex"`
-	Password     string          `json:"-"` // Never expose the password in JSON responses
-	CompanyID    uuid.UUID       `json:"company_id"`
-	Company      *Company        `json:"company" gorm:"foreignKey:CompanyID"`
-	Roles        RoleArray       `json:"roles" gorm:"type:text[]"`        // Using custom RoleArray type
-	Permissions  PermissionArray `json:"permissions" gorm:"type:text[]"`  // Using custom PermissionArray type
-	ProfileImage string          `json:"profile_image,omitempty"`
-	Active       bool            `json:"active" gorm:"default:true"`
-	LastLogin    *time.Time      `json:"last_login,omitempty"`
+	Username          string          `json:"username"`
+	Email             string          `json:"email" gorm:"uniqueIndex:idx_users_email_live,where:deleted_at IS NULL"`
+	Password          string          `json:"-"` // Never expose the password in JSON responses
+	CompanyID         uuid.UUID       `json:"company_id"`
+	Company           *Company        `json:"company" gorm:"foreignKey:CompanyID"`
+	Roles             RoleArray       `json:"roles" gorm:"type:text[]"`              // Using custom RoleArray type
+	Permissions       PermissionArray `json:"permissions" gorm:"type:text[]"`        // Using custom PermissionArray type
+	DeniedPermissions PermissionArray `json:"denied_permissions" gorm:"type:text[]"` // Denied even when a role grants them
+	ProfileImage      string          `json:"profile_image,omitempty"`
+	Active            bool            `json:"active" gorm:"default:true"`
+	HomeCompanyActive bool            `json:"home_company_active" gorm:"default:true"` // False once the home company deactivates the user; other memberships still work
+	LastLogin         *time.Time      `json:"last_login,omitempty"`
+	Resolved          *PermissionSet  `json:"-" gorm:"-"` // Set by authz.Resolve for the active company
 }
 
 func (u *User) HasPermission(permission Permission) bool {
+	// Once resolved against the database defined roles, that is the only answer
+	if u.Resolved != nil {
+		return u.Resolved.Allows(permission)
+	}
+
+	// Explicit denies win over any grant
+	for _, p := range []Permission(u.DeniedPermissions) {
+		if p == permission {
+			return false
+		}
+	}
+
 	// First check custom permissions assigned directly to the user
 	for _, p := range []Permission(u.Permissions) {
 		if p == permission {
@@ -224,22 +239,29 @@ func (u *User) HasPermission(permission Permission) bool {
 // Company represents a company in the system
 type Company struct {
 	BaseModel
-	Name           string  `json:"name"`
-	Email          string  `json:"email" gorm:"uniqueIndex"`
-	Phone          string  `json:"phone,omitempty"`
-	Address        string  `json:"address,omitempty"`
-	City           string  `json:"city,omitempty"`
-	State          string  `json:"state,omitempty"`
-	ZipCode        string  `json:"zip_code,omitempty"`
-	Country        string  `json:"country,omitempty"`
-	LogoURL        string  `json:"logo_url,omitempty"`
-	Website        string  `json:"website,omitempty"`
-	TaxID          string  `json:"tax_id,omitempty"`
-	CompanyType    string  `json:"company_type"` // "shipper", "carrier", "both"
-	Active         bool    `json:"active" gorm:"default:true"`
-	VerificationID string  `json:"verification_id,omitempty"`
-	Verified       bool    `json:"verified" gorm:"default:false"`
-	Users          *[]User `json:"users,omitempty" gorm:"foreignKey:CompanyID"`
+	Name           string     `json:"name"`
+	Email          string     `json:"email" gorm:"uniqueIndex:idx_companies_email_live,where:deleted_at IS NULL"`
+	Phone          string     `json:"phone,omitempty"`
+	Address        string     `json:"address,omitempty"`
+	City           string     `json:"city,omitempty"`
+	State          string     `json:"state,omitempty"`
+	ZipCode        string     `json:"zip_code,omitempty"`
+	Country        string     `json:"country,omitempty"`
+	LogoURL        string     `json:"logo_url,omitempty"`
+	Website        string     `json:"website,omitempty"`
+	TaxID          string     `json:"tax_id,omitempty"`
+	DOTNumber      string     `json:"dot_number,omitempty" gorm:"index"`
+	MCNumber       string     `json:"mc_number,omitempty" gorm:"index"`
+	Authority      string     `json:"authority,omitempty"` // Operating authority status: "active", "inactive", "pending"
+	CompanyType    string     `json:"company_type"`        // "shipper", "carrier", "both"
+	Active         bool       `json:"active" gorm:"default:true"`
+	VerificationID string     `json:"verification_id,omitempty"`
+	Verified       bool       `json:"verified" gorm:"default:false"`
+	ComplianceHold bool       `json:"compliance_hold" gorm:"default:false;index"` // Set automatically when required coverage lapses
+	HoldReason     string     `json:"hold_reason,omitempty"`
+	HoldAt         *time.Time `json:"hold_at,omitempty"`
+	OwnerUserID    *uuid.UUID `json:"owner_user_id,omitempty" gorm:"type:uuid"` // Can manage members and transfer ownership
+	Users          *[]User    `json:"users,omitempty" gorm:"foreignKey:CompanyID"`
 }
 
 // Contact represents contact form submissions from the website
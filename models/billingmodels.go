@@ -0,0 +1,133 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InvoiceKind distinguishes money owed to the platform from money owed by it
+type InvoiceKind string
+
+const (
+	InvoiceKindShipper        InvoiceKind = "shipper_invoice" // Billed to the shipper
+	InvoiceKindCarrierPayable InvoiceKind = "carrier_payable" // Owed to the carrier
+)
+
+// InvoiceStatus represents the billing state of an invoice
+type InvoiceStatus string
+
+const (
+	InvoiceStatusDraft    InvoiceStatus = "draft"
+	InvoiceStatusSent     InvoiceStatus = "sent"
+	InvoiceStatusApproved InvoiceStatus = "approved" // Carrier payable approved for payout
+	InvoiceStatusPaid     InvoiceStatus = "paid"
+	InvoiceStatusFailed   InvoiceStatus = "failed" // Payout was returned by the carrier's bank
+	InvoiceStatusVoid     InvoiceStatus = "void"
+)
+
+// CanTransitionTo reports whether an invoice may move from s to next.
+// Shipper invoices go draft, sent, paid; carrier payables go draft, approved, paid.
+func (s InvoiceStatus) CanTransitionTo(next InvoiceStatus) bool {
+	switch s {
+	case InvoiceStatusDraft:
+		return next == InvoiceStatusSent || next == InvoiceStatusApproved || next == InvoiceStatusVoid
+	case InvoiceStatusSent, InvoiceStatusApproved:
+		return next == InvoiceStatusPaid || next == InvoiceStatusVoid
+	case InvoiceStatusFailed:
+		return next == InvoiceStatusApproved || next == InvoiceStatusVoid
+	}
+	return false
+}
+
+// InvoiceLineType is the kind of charge on an invoice line
+type InvoiceLineType string
+
+const (
+	LineTypeLinehaul  InvoiceLineType = "linehaul"
+	LineTypeFuel      InvoiceLineType = "fuel"
+	LineTypeDetention InvoiceLineType = "detention"
+	LineTypeLumper    InvoiceLineType = "lumper"
+	LineTypeTONU      InvoiceLineType = "tonu"
+)
+
+// Invoice is either a shipper invoice or a carrier payable generated for a load.
+// Numbers are unique per company and kind.
+type Invoice struct {
+	BaseModel
+	Number     string        `json:"number" gorm:"uniqueIndex:idx_invoice_company_number"`
+	Kind       InvoiceKind   `json:"kind" gorm:"uniqueIndex:idx_invoice_company_number;index"`
+	Status     InvoiceStatus `json:"status" gorm:"default:'draft';index"`
+	CompanyID  uuid.UUID     `json:"company_id" gorm:"type:uuid;uniqueIndex:idx_invoice_company_number"`
+	Company    *Company      `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	LoadID     uuid.UUID     `json:"load_id" gorm:"type:uuid;index"`
+	Load       *Load         `json:"load,omitempty" gorm:"foreignKey:LoadID"`
+	TotalCents int64         `json:"total_cents"`
+	Currency   string        `json:"currency" gorm:"default:'USD'"`
+	IssuedAt   *time.Time    `json:"issued_at,omitempty"`
+	DueAt      *time.Time    `json:"due_at,omitempty"`
+	PaidAt     *time.Time    `json:"paid_at,omitempty"`
+	VoidedAt   *time.Time    `json:"voided_at,omitempty"`
+	ReturnCode string        `json:"return_code,omitempty"` // ACH return reason code when a payout fails
+	Lines      []InvoiceLine `json:"lines,omitempty" gorm:"foreignKey:InvoiceID"`
+
+	// Carrier payables only
+	QuickPay          bool       `json:"quick_pay"`
+	QuickPayFeeCents  int64      `json:"quick_pay_fee_cents"`
+	QuickPayElectedAt *time.Time `json:"quick_pay_elected_at,omitempty"`
+	PayeeCompanyID    *uuid.UUID `json:"payee_company_id,omitempty" gorm:"type:uuid;index"` // Factor the payable is assigned to
+	AssignmentID      *uuid.UUID `json:"assignment_id,omitempty" gorm:"type:uuid;index"`
+}
+
+// PayeeID is the company a carrier payable is paid to, the factor if it has been assigned
+func (i *Invoice) PayeeID() uuid.UUID {
+	if i.PayeeCompanyID != nil {
+		return *i.PayeeCompanyID
+	}
+	return i.CompanyID
+}
+
+// NetPayoutCents is what is actually sent to the payee after any quick-pay fee
+func (i *Invoice) NetPayoutCents() int64 {
+	return i.TotalCents - i.QuickPayFeeCents
+}
+
+// InvoiceLine is a single charge on an invoice
+type InvoiceLine struct {
+	BaseModel
+	InvoiceID   uuid.UUID       `json:"invoice_id" gorm:"type:uuid;index"`
+	Type        InvoiceLineType `json:"type"`
+	Description string          `json:"description"`
+	AmountCents int64           `json:"amount_cents"`
+}
+
+// InvoiceSequence holds the next invoice number for a company and kind
+type InvoiceSequence struct {
+	BaseModel
+	CompanyID  uuid.UUID   `json:"company_id" gorm:"type:uuid;uniqueIndex:idx_invoice_sequence"`
+	Kind       InvoiceKind `json:"kind" gorm:"uniqueIndex:idx_invoice_sequence"`
+	Prefix     string      `json:"prefix"`
+	NextNumber int64       `json:"next_number" gorm:"default:1"`
+}
+
+// AssignmentStatus represents whether a notice of assignment is in force
+type AssignmentStatus string
+
+const (
+	AssignmentStatusActive   AssignmentStatus = "active"
+	AssignmentStatusReleased AssignmentStatus = "released"
+)
+
+// NoticeOfAssignment redirects a carrier's payables to a factoring company
+type NoticeOfAssignment struct {
+	BaseModel
+	CarrierCompanyID uuid.UUID        `json:"carrier_company_id" gorm:"type:uuid;index"`
+	CarrierCompany   *Company         `json:"carrier_company,omitempty" gorm:"foreignKey:CarrierCompanyID"`
+	FactorCompanyID  uuid.UUID        `json:"factor_company_id" gorm:"type:uuid;index"`
+	FactorCompany    *Company         `json:"factor_company,omitempty" gorm:"foreignKey:FactorCompanyID"`
+	Status           AssignmentStatus `json:"status" gorm:"index"`
+	DocumentURL      string           `json:"document_url,omitempty"` // Signed notice from the factor
+	EffectiveAt      time.Time        `json:"effective_at"`
+	ReleasedAt       *time.Time       `json:"released_at,omitempty"`
+	CreatedByID      uuid.UUID        `json:"created_by_id" gorm:"type:uuid"`
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GeoPoint is a WGS84 point stored in a PostGIS geography column
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Valid reports whether the point is a real latitude/longitude
+func (p GeoPoint) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180 && !(p.Lat == 0 && p.Lng == 0)
+}
+
+// Value implements the driver.Valuer interface, writing the point as EWKT
+func (p GeoPoint) Value() (driver.Value, error) {
+	return fmt.Sprintf("SRID=4326;POINT(%f %f)", p.Lng, p.Lat), nil
+}
+
+// Scan implements the sql.Scanner interface, reading the hex EWKB PostGIS returns
+func (p *GeoPoint) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return errors.New("unsupported geography value")
+	}
+
+	b, err := hex.DecodeString(raw)
+	if err != nil || len(b) < 21 {
+		return errors.New("invalid geography point")
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if b[0] == 1 {
+		order = binary.LittleEndian
+	}
+	offset := 5
+	if order.Uint32(b[1:5])&0x20000000 != 0 { // SRID present
+		offset += 4
+	}
+	if len(b) < offset+16 {
+		return errors.New("invalid geography point")
+	}
+	p.Lng = math.Float64frombits(order.Uint64(b[offset : offset+8]))
+	p.Lat = math.Float64frombits(order.Uint64(b[offset+8 : offset+16]))
+	return nil
+}
+
+// GormDataType tells GORM the column type
+func (GeoPoint) GormDataType() string {
+	return "geography(Point,4326)"
+}
+
+// CapacityPostStatus represents where a capacity post is in its lifecycle
+type CapacityPostStatus string
+
+const (
+	CapacityPostActive    CapacityPostStatus = "active"
+	CapacityPostBooked    CapacityPostStatus = "booked"
+	CapacityPostExpired   CapacityPostStatus = "expired"
+	CapacityPostCancelled CapacityPostStatus = "cancelled"
+)
+
+// CapacityPost advertises an empty truck, e.g. "empty in Dallas Tuesday, heading to Atlanta".
+// The truck will deadhead up to OriginRadiusMiles to pick up. Destination preferences are
+// optional: a point and radius, a list of states, or neither for anywhere.
+type CapacityPost struct {
+	BaseModel
+	CompanyID              uuid.UUID          `json:"company_id" gorm:"type:uuid;index"`
+	Company                *Company           `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	CreatedByID            uuid.UUID          `json:"created_by_id" gorm:"type:uuid"`
+	VehicleID              *uuid.UUID         `json:"vehicle_id,omitempty" gorm:"type:uuid"`
+	TrailerID              *uuid.UUID         `json:"trailer_id,omitempty" gorm:"type:uuid"`
+	EquipmentType          EquipmentType      `json:"equipment_type" gorm:"index"`
+	OriginCity             string             `json:"origin_city"`
+	OriginState            string             `json:"origin_state"`
+	Origin                 GeoPoint           `json:"origin" gorm:"not null;index:idx_capacity_posts_origin,type:gist"`
+	OriginRadiusMiles      int                `json:"origin_radius_miles"`
+	DestinationCity        string             `json:"destination_city,omitempty"`
+	DestinationState       string             `json:"destination_state,omitempty"`
+	Destination            *GeoPoint          `json:"destination,omitempty" gorm:"index:idx_capacity_posts_destination,type:gist"`
+	DestinationRadiusMiles int                `json:"destination_radius_miles,omitempty"`
+	DestinationStates      string             `json:"destination_states,omitempty"` // Comma separated, e.g. "GA,FL"
+	AvailableFrom          time.Time          `json:"available_from" gorm:"index"`
+	AvailableTo            time.Time          `json:"available_to" gorm:"index"`
+	CapacityLbs            int                `json:"capacity_lbs"`
+	LengthFeet             int                `json:"length_feet"`
+	RatePerMileCents       int64              `json:"rate_per_mile_cents,omitempty"` // Asking rate, optional
+	Notes                  string             `json:"notes"`
+	Status                 CapacityPostStatus `json:"status" gorm:"default:'active';index"`
+	ExpiredAt              *time.Time         `json:"expired_at,omitempty"`
+}
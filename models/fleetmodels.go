@@ -0,0 +1,145 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EquipmentType is the kind of equipment a truck or trailer provides
+type EquipmentType string
+
+const (
+	EquipmentDryVan      EquipmentType = "dry_van"
+	EquipmentReefer      EquipmentType = "reefer"
+	EquipmentFlatbed     EquipmentType = "flatbed"
+	EquipmentStepDeck    EquipmentType = "step_deck"
+	EquipmentTanker      EquipmentType = "tanker"
+	EquipmentBoxTruck    EquipmentType = "box_truck"
+	EquipmentPowerOnly   EquipmentType = "power_only"
+	EquipmentHopper      EquipmentType = "hopper"
+	EquipmentLowboy      EquipmentType = "lowboy"
+	EquipmentContainer   EquipmentType = "container"
+	EquipmentCarHauler   EquipmentType = "car_hauler"
+	EquipmentSprinterVan EquipmentType = "sprinter_van"
+)
+
+// ValidEquipmentType reports whether t is a known equipment type
+func ValidEquipmentType(t EquipmentType) bool {
+	switch t {
+	case EquipmentDryVan, EquipmentReefer, EquipmentFlatbed, EquipmentStepDeck, EquipmentTanker, EquipmentBoxTruck,
+		EquipmentPowerOnly, EquipmentHopper, EquipmentLowboy, EquipmentContainer, EquipmentCarHauler, EquipmentSprinterVan:
+		return true
+	}
+	return false
+}
+
+// CDL endorsements: hazmat, tank, passenger, school bus, doubles/triples and combined tank/hazmat
+var cdlEndorsements = map[string]bool{"H": true, "N": true, "P": true, "S": true, "T": true, "X": true}
+
+// NormalizeEndorsements uppercases and dedupes a comma separated endorsement list,
+// returning false if any endorsement is unknown
+func NormalizeEndorsements(list string) (string, bool) {
+	seen := map[string]bool{}
+	var out []string
+	for _, e := range strings.Split(list, ",") {
+		e = strings.ToUpper(strings.TrimSpace(e))
+		if e == "" || seen[e] {
+			continue
+		}
+		if !cdlEndorsements[e] {
+			return "", false
+		}
+		seen[e] = true
+		out = append(out, e)
+	}
+	return strings.Join(out, ","), true
+}
+
+// Vehicle is a power unit (tractor or straight truck) operated by a carrier
+type Vehicle struct {
+	BaseModel
+	CompanyID     uuid.UUID     `json:"company_id" gorm:"type:uuid;index"`
+	UnitNumber    string        `json:"unit_number"`
+	VIN           string        `json:"vin" gorm:"index"`
+	Plate         string        `json:"plate"`
+	PlateState    string        `json:"plate_state"`
+	Make          string        `json:"make"`
+	Model         string        `json:"model"`
+	Year          int           `json:"year"`
+	EquipmentType EquipmentType `json:"equipment_type" gorm:"index"` // power_only for tractors, otherwise the body type
+	CapacityLbs   int           `json:"capacity_lbs"`
+	Active        bool          `json:"active"`
+}
+
+// Trailer is a trailer operated by a carrier
+type Trailer struct {
+	BaseModel
+	CompanyID       uuid.UUID     `json:"company_id" gorm:"type:uuid;index"`
+	UnitNumber      string        `json:"unit_number"`
+	VIN             string        `json:"vin" gorm:"index"`
+	Plate           string        `json:"plate"`
+	PlateState      string        `json:"plate_state"`
+	EquipmentType   EquipmentType `json:"equipment_type" gorm:"index"`
+	LengthFeet      int           `json:"length_feet"`
+	CapacityLbs     int           `json:"capacity_lbs"`
+	CapacityPallets int           `json:"capacity_pallets"`
+	Active          bool          `json:"active"`
+}
+
+// Driver is a driver employed or contracted by a carrier
+type Driver struct {
+	BaseModel
+	CompanyID    uuid.UUID  `json:"company_id" gorm:"type:uuid;index"`
+	UserID       *uuid.UUID `json:"user_id,omitempty" gorm:"type:uuid"` // Set when the driver has a login
+	FirstName    string     `json:"first_name"`
+	LastName     string     `json:"last_name"`
+	Phone        string     `json:"phone"`
+	Email        string     `json:"email"`
+	CDLNumber    string     `json:"cdl_number"`
+	CDLState     string     `json:"cdl_state"`
+	CDLClass     string     `json:"cdl_class"` // A, B or C
+	CDLExpiresAt *time.Time `json:"cdl_expires_at,omitempty"`
+	Endorsements string     `json:"endorsements"` // Comma separated, e.g. "H,N,T"
+	Active       bool       `json:"active"`
+}
+
+// CDLValidAt reports whether the driver's CDL is unexpired at t
+func (d *Driver) CDLValidAt(t time.Time) bool {
+	return d.CDLExpiresAt != nil && d.CDLExpiresAt.After(t)
+}
+
+// AvailabilityReason is why a piece of equipment or a driver is out of service
+type AvailabilityReason string
+
+const (
+	AvailabilityMaintenance AvailabilityReason = "maintenance"
+	AvailabilityTimeOff     AvailabilityReason = "time_off"
+	AvailabilityAssigned    AvailabilityReason = "assigned"
+	AvailabilityOther       AvailabilityReason = "other"
+)
+
+// ValidAvailabilityReason reports whether r is a known reason
+func ValidAvailabilityReason(r AvailabilityReason) bool {
+	switch r {
+	case AvailabilityMaintenance, AvailabilityTimeOff, AvailabilityAssigned, AvailabilityOther:
+		return true
+	}
+	return false
+}
+
+// FleetUnavailability blocks out a vehicle, trailer or driver for a period. Exactly one of
+// VehicleID, TrailerID and DriverID is set. The availability calendar is built from these.
+type FleetUnavailability struct {
+	BaseModel
+	CompanyID uuid.UUID          `json:"company_id" gorm:"type:uuid;index"`
+	VehicleID *uuid.UUID         `json:"vehicle_id,omitempty" gorm:"type:uuid;index"`
+	TrailerID *uuid.UUID         `json:"trailer_id,omitempty" gorm:"type:uuid;index"`
+	DriverID  *uuid.UUID         `json:"driver_id,omitempty" gorm:"type:uuid;index"`
+	LoadID    *uuid.UUID         `json:"load_id,omitempty" gorm:"type:uuid"`
+	Reason    AvailabilityReason `json:"reason"`
+	StartsAt  time.Time          `json:"starts_at" gorm:"index"`
+	EndsAt    time.Time          `json:"ends_at" gorm:"index"`
+	Notes     string             `json:"notes"`
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FMCSA snapshot tables hold the latest bulk files so carrier checks never need a live call.
+// Each import replaces the whole table for its kind.
+
+// FMCSACarrier is a row from the FMCSA census file
+type FMCSACarrier struct {
+	BaseModel
+	DOTNumber  string `json:"dot_number" gorm:"uniqueIndex"`
+	LegalName  string `json:"legal_name"`
+	DBAName    string `json:"dba_name,omitempty"`
+	Street     string `json:"street,omitempty"`
+	City       string `json:"city,omitempty"`
+	State      string `json:"state,omitempty"`
+	Zip        string `json:"zip,omitempty"`
+	Phone      string `json:"phone,omitempty"`
+	PowerUnits int    `json:"power_units"`
+	Drivers    int    `json:"drivers"`
+}
+
+// FMCSAAuthority is a carrier's operating authority for one docket
+type FMCSAAuthority struct {
+	BaseModel
+	DOTNumber      string `json:"dot_number" gorm:"index"`
+	DocketNumber   string `json:"docket_number" gorm:"index"` // MC number without the prefix
+	CommonStatus   string `json:"common_status"`              // "A" active, "I" inactive, "N" none
+	ContractStatus string `json:"contract_status"`
+	BrokerStatus   string `json:"broker_status"`
+}
+
+// Active reports whether the docket holds active common or contract carrier authority
+func (a *FMCSAAuthority) Active() bool {
+	return a.CommonStatus == "A" || a.ContractStatus == "A"
+}
+
+// FMCSAInsurance is an insurance filing on record with FMCSA
+type FMCSAInsurance struct {
+	BaseModel
+	DOTNumber        string     `json:"dot_number" gorm:"index"`
+	DocketNumber     string     `json:"docket_number" gorm:"index"`
+	InsuranceType    string     `json:"insurance_type"` // e.g. "BIPD", "CARGO"
+	InsurerName      string     `json:"insurer_name"`
+	PolicyNumber     string     `json:"policy_number"`
+	CoverageCents    int64      `json:"coverage_cents"`
+	EffectiveDate    *time.Time `json:"effective_date,omitempty"`
+	CancellationDate *time.Time `json:"cancellation_date,omitempty"`
+}
+
+// ActiveAt reports whether the filing is in force at t
+func (i *FMCSAInsurance) ActiveAt(t time.Time) bool {
+	if i.EffectiveDate != nil && i.EffectiveDate.After(t) {
+		return false
+	}
+	return i.CancellationDate == nil || i.CancellationDate.After(t)
+}
+
+// FMCSAImport records a bulk file load into the snapshot tables
+type FMCSAImport struct {
+	BaseModel
+	Kind        string     `json:"kind" gorm:"index"` // "census", "authority" or "insurance"
+	FileName    string     `json:"file_name"`
+	Rows        int        `json:"rows"`
+	Skipped     int        `json:"skipped"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ComplianceFlagKind is the problem a compliance check found
+type ComplianceFlagKind string
+
+const (
+	FlagAuthorityRevoked ComplianceFlagKind = "authority_revoked"
+	FlagInsuranceLapsed  ComplianceFlagKind = "insurance_lapsed"
+	FlagNotInSnapshot    ComplianceFlagKind = "not_in_snapshot"
+)
+
+// ComplianceFlag is an open or resolved compliance problem on a company
+type ComplianceFlag struct {
+	BaseModel
+	CompanyID  uuid.UUID          `json:"company_id" gorm:"type:uuid;index"`
+	Company    *Company           `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	Kind       ComplianceFlagKind `json:"kind" gorm:"index"`
+	Detail     string             `json:"detail"`
+	DetectedAt time.Time          `json:"detected_at"`
+	ResolvedAt *time.Time         `json:"resolved_at,omitempty" gorm:"index"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit actions for impersonation
+const (
+	AuditImpersonationStarted = "impersonation_started"
+	AuditImpersonationEnded   = "impersonation_ended"
+	AuditImpersonatedRequest  = "impersonated_request"  // Any request made while impersonating
+	AuditImpersonationBlocked = "impersonation_blocked" // A sensitive action refused while impersonating
+)
+
+// ImpersonationSession lets a system admin act as another user for a limited time, to see
+// exactly what they see when debugging an issue. Tokens issued for the session carry its ID
+// and stop working once it has ended or expired.
+type ImpersonationSession struct {
+	BaseModel
+	ActorID   uuid.UUID  `json:"actor_id" gorm:"type:uuid;index"` // The system admin
+	Actor     *User      `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;index"` // The user being impersonated
+	User      *User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Reason    string     `json:"reason"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// Live reports whether the session can still be used at t
+func (s *ImpersonationSession) Live(t time.Time) bool {
+	return s.EndedAt == nil && s.ExpiresAt.After(t)
+}
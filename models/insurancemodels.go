@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CoverageType is the kind of insurance a certificate proves
+type CoverageType string
+
+const (
+	CoverageCargo            CoverageType = "cargo"
+	CoverageAutoLiability    CoverageType = "auto_liability"
+	CoverageGeneralLiability CoverageType = "general_liability"
+	CoverageWorkersComp      CoverageType = "workers_comp"
+)
+
+// RequiredCoverage is the coverage a carrier must hold to be booked, with minimum limits
+var RequiredCoverage = map[CoverageType]int64{
+	CoverageCargo:         100000_00, // $100,000 cargo
+	CoverageAutoLiability: 750000_00, // $750,000 federal minimum for general freight
+}
+
+// ValidCoverageType reports whether t is a known coverage type
+func ValidCoverageType(t CoverageType) bool {
+	switch t {
+	case CoverageCargo, CoverageAutoLiability, CoverageGeneralLiability, CoverageWorkersComp:
+		return true
+	}
+	return false
+}
+
+// CertificateStatus is where a certificate is in review. The limits and dates on a
+// certificate are entered by the carrier, so a reviewer checks them against the document.
+type CertificateStatus string
+
+const (
+	CertificatePending  CertificateStatus = "pending" // Waiting for a reviewer
+	CertificateVerified CertificateStatus = "verified"
+	CertificateRejected CertificateStatus = "rejected" // Ignored for coverage
+)
+
+// InsuranceCertificate is a certificate of insurance on file for a carrier
+type InsuranceCertificate struct {
+	BaseModel
+	CompanyID        uuid.UUID         `json:"company_id" gorm:"type:uuid;index"`
+	DocumentID       *uuid.UUID        `json:"document_id,omitempty" gorm:"type:uuid"` // Uploaded certificate
+	Document         *CompanyDocument  `json:"document,omitempty" gorm:"foreignKey:DocumentID"`
+	CoverageType     CoverageType      `json:"coverage_type" gorm:"index"`
+	InsurerName      string            `json:"insurer_name"`
+	PolicyNumber     string            `json:"policy_number"`
+	LimitCents       int64             `json:"limit_cents"`
+	EffectiveAt      time.Time         `json:"effective_at"`
+	ExpiresAt        time.Time         `json:"expires_at" gorm:"index"`
+	ReminderSentDays int               `json:"reminder_sent_days,omitempty"` // Smallest reminder threshold already sent
+	Status           CertificateStatus `json:"status" gorm:"default:'pending';index"`
+	ReviewedAt       *time.Time        `json:"reviewed_at,omitempty"`
+	ReviewedByID     *uuid.UUID        `json:"reviewed_by_id,omitempty" gorm:"type:uuid"`
+	RejectionReason  string            `json:"rejection_reason,omitempty"`
+}
+
+// ActiveAt reports whether the certificate is in force at t
+func (c *InsuranceCertificate) ActiveAt(t time.Time) bool {
+	return !c.EffectiveAt.After(t) && c.ExpiresAt.After(t)
+}
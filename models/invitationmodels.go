@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit actions for company membership
+const (
+	AuditOwnershipTransferred = "ownership_transferred"
+	AuditMemberDeactivated    = "member_deactivated"
+	AuditMemberReactivated    = "member_reactivated"
+)
+
+// Invitation invites someone by email to join a company with the given roles and
+// permissions. Only a hash of the token is stored; the token itself is sent to the invitee.
+type Invitation struct {
+	BaseModel
+	CompanyID      uuid.UUID       `json:"company_id" gorm:"type:uuid;index"`
+	Company        *Company        `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	Email          string          `json:"email" gorm:"index"`
+	Roles          RoleArray       `json:"roles" gorm:"type:text[]"`
+	Permissions    PermissionArray `json:"permissions" gorm:"type:text[]"`
+	TokenHash      string          `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+	InvitedByID    uuid.UUID       `json:"invited_by_id" gorm:"type:uuid"`
+	AcceptedAt     *time.Time      `json:"accepted_at,omitempty"`
+	AcceptedUserID *uuid.UUID      `json:"accepted_user_id,omitempty" gorm:"type:uuid"`
+	RevokedAt      *time.Time      `json:"revoked_at,omitempty"`
+}
+
+// Pending reports whether the invitation can still be accepted at t
+func (i *Invitation) Pending(t time.Time) bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && i.ExpiresAt.After(t)
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrImmutableJournal is returned when something tries to change a posted journal entry
+var ErrImmutableJournal = errors.New("journal entries are immutable once posted")
+
+// LedgerAccountType is one of the fixed accounts every company has in the ledger
+type LedgerAccountType string
+
+const (
+	AccountEscrowHeld   LedgerAccountType = "escrow_held"   // Funds held on behalf of the company
+	AccountReceivable   LedgerAccountType = "receivable"    // Money due in to the platform (pending ACH debits)
+	AccountPayable      LedgerAccountType = "payable"       // Money the platform owes the company
+	AccountPlatformFees LedgerAccountType = "platform_fees" // Fees the platform earned from the company
+	AccountSettlement   LedgerAccountType = "settlement"    // Money that has actually moved through the bank
+)
+
+// LedgerAccountTypes lists every account opened for a company
+var LedgerAccountTypes = []LedgerAccountType{
+	AccountEscrowHeld, AccountReceivable, AccountPayable, AccountPlatformFees, AccountSettlement,
+}
+
+// DebitNormal reports whether the account's balance grows with debits
+func (t LedgerAccountType) DebitNormal() bool {
+	return t == AccountReceivable || t == AccountSettlement
+}
+
+// LedgerAccount is a company's account in the double-entry ledger
+type LedgerAccount struct {
+	BaseModel
+	CompanyID uuid.UUID         `json:"company_id" gorm:"type:uuid;uniqueIndex:idx_ledger_account_company_type"`
+	Company   *Company          `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	Type      LedgerAccountType `json:"type" gorm:"uniqueIndex:idx_ledger_account_company_type"`
+	Currency  string            `json:"currency" gorm:"default:'USD'"`
+}
+
+// JournalEntry is a balanced set of journal lines posted together
+type JournalEntry struct {
+	BaseModel
+	Memo       string        `json:"memo"`
+	Reference  string        `json:"reference,omitempty" gorm:"index"`
+	LoadID     *uuid.UUID    `json:"load_id,omitempty" gorm:"type:uuid;index"`
+	EscrowID   *uuid.UUID    `json:"escrow_id,omitempty" gorm:"type:uuid;index"`
+	PostedByID *uuid.UUID    `json:"posted_by_id,omitempty" gorm:"type:uuid"`
+	PostedAt   time.Time     `json:"posted_at"`
+	Lines      []JournalLine `json:"lines,omitempty" gorm:"foreignKey:EntryID"`
+}
+
+// BeforeUpdate prevents posted entries from being changed
+func (e *JournalEntry) BeforeUpdate(tx *gorm.DB) error { return ErrImmutableJournal }
+
+// BeforeDelete prevents posted entries from being removed
+func (e *JournalEntry) BeforeDelete(tx *gorm.DB) error { return ErrImmutableJournal }
+
+// JournalLine is a single debit or credit against a ledger account
+type JournalLine struct {
+	BaseModel
+	EntryID     uuid.UUID      `json:"entry_id" gorm:"type:uuid;index"`
+	AccountID   uuid.UUID      `json:"account_id" gorm:"type:uuid;index"`
+	Account     *LedgerAccount `json:"account,omitempty" gorm:"foreignKey:AccountID"`
+	DebitCents  int64          `json:"debit_cents"`
+	CreditCents int64          `json:"credit_cents"`
+}
+
+// BeforeUpdate prevents posted lines from being changed
+func (l *JournalLine) BeforeUpdate(tx *gorm.DB) error { return ErrImmutableJournal }
+
+// BeforeDelete prevents posted lines from being removed
+func (l *JournalLine) BeforeDelete(tx *gorm.DB) error { return ErrImmutableJournal }
+
+// EscrowStatus represents where escrowed funds are in their lifecycle
+type EscrowStatus string
+
+const (
+	EscrowStatusFunded   EscrowStatus = "funded"   // Shipper funds received on booking
+	EscrowStatusHeld     EscrowStatus = "held"     // Locked while the load is moving
+	EscrowStatusReleased EscrowStatus = "released" // Paid out to the carrier on POD
+	EscrowStatusRefunded EscrowStatus = "refunded" // Returned to the shipper on cancel
+	EscrowStatusReturned EscrowStatus = "returned" // Funding ACH debit came back unpaid
+)
+
+// EndedEscrowStatuses are the statuses that give funds back, after which the load can be
+// funded again
+var EndedEscrowStatuses = []EscrowStatus{EscrowStatusRefunded, EscrowStatusReturned}
+
+// Escrow tracks the funds a shipper has put up for a single load
+type Escrow struct {
+	BaseModel
+	LoadID           uuid.UUID    `json:"load_id" gorm:"type:uuid;uniqueIndex:idx_escrows_live_load,where:status <> 'refunded' AND status <> 'returned'"` // One live escrow per load
+	Load             *Load        `json:"load,omitempty" gorm:"foreignKey:LoadID"`
+	ShipperCompanyID uuid.UUID    `json:"shipper_company_id" gorm:"type:uuid;index"`
+	CarrierCompanyID uuid.UUID    `json:"carrier_company_id" gorm:"type:uuid;index"`
+	AmountCents      int64        `json:"amount_cents"` // Total funded by the shipper
+	FeeCents         int64        `json:"fee_cents"`    // Platform fee kept on release
+	Status           EscrowStatus `json:"status" gorm:"index"`
+	FundedAt         *time.Time   `json:"funded_at,omitempty"`
+	HeldAt           *time.Time   `json:"held_at,omitempty"`
+	ReleasedAt       *time.Time   `json:"released_at,omitempty"`
+	RefundedAt       *time.Time   `json:"refunded_at,omitempty"`
+	ReturnedAt       *time.Time   `json:"returned_at,omitempty"`
+}
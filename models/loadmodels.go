@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoadStatus represents where a load is in its lifecycle
+type LoadStatus string
+
+const (
+	LoadStatusDraft     LoadStatus = "draft"
+	LoadStatusPosted    LoadStatus = "posted"
+	LoadStatusBooked    LoadStatus = "booked"
+	LoadStatusInTransit LoadStatus = "in_transit"
+	LoadStatusDelivered LoadStatus = "delivered"
+	LoadStatusCompleted LoadStatus = "completed"
+	LoadStatusCancelled LoadStatus = "cancelled"
+)
+
+// Load is the minimal shipment record that invoices and payables are generated from.
+// All money amounts are stored in cents to avoid floating point rounding.
+type Load struct {
+	BaseModel
+	ReferenceNumber  string     `json:"reference_number" gorm:"index"`
+	ShipperCompanyID uuid.UUID  `json:"shipper_company_id" gorm:"type:uuid;index"`
+	ShipperCompany   *Company   `json:"shipper_company,omitempty" gorm:"foreignKey:ShipperCompanyID"`
+	CarrierCompanyID *uuid.UUID `json:"carrier_company_id,omitempty" gorm:"type:uuid;index"`
+	CarrierCompany   *Company   `json:"carrier_company,omitempty" gorm:"foreignKey:CarrierCompanyID"`
+	Origin           string     `json:"origin"`
+	Destination      string     `json:"destination"`
+	PickupAt         *time.Time `json:"pickup_at,omitempty"`
+	PickedUpAt       *time.Time `json:"picked_up_at,omitempty"`
+	DeliveredAt      *time.Time `json:"delivered_at,omitempty"`
+	PODReceivedAt    *time.Time `json:"pod_received_at,omitempty"` // Proof of delivery
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+	CancelledAt      *time.Time `json:"cancelled_at,omitempty"`
+	Status           LoadStatus `json:"status" gorm:"default:'draft';index"`
+
+	// Shipper side charges
+	ShipperLinehaulCents int64 `json:"shipper_linehaul_cents"`
+	ShipperFuelCents     int64 `json:"shipper_fuel_cents"`
+
+	// Carrier side charges
+	CarrierLinehaulCents int64 `json:"carrier_linehaul_cents"`
+	CarrierFuelCents     int64 `json:"carrier_fuel_cents"`
+
+	// Accessorials are billed to the shipper and passed through to the carrier
+	DetentionCents int64 `json:"detention_cents"`
+	LumperCents    int64 `json:"lumper_cents"`
+	TONUCents      int64 `json:"tonu_cents"` // Truck ordered not used
+}
+
+// BidStatus represents where a carrier's bid on a load stands
+type BidStatus string
+
+const (
+	BidStatusOpen     BidStatus = "open"
+	BidStatusAccepted BidStatus = "accepted"
+	BidStatusRejected BidStatus = "rejected"
+)
+
+// LoadBid is a carrier's offer to haul a posted load
+type LoadBid struct {
+	BaseModel
+	LoadID           uuid.UUID `json:"load_id" gorm:"type:uuid;index"`
+	CarrierCompanyID uuid.UUID `json:"carrier_company_id" gorm:"type:uuid;index"`
+	BidderID         uuid.UUID `json:"bidder_id" gorm:"type:uuid"`
+	AmountCents      int64     `json:"amount_cents"` // All-in carrier linehaul
+	Note             string    `json:"note,omitempty"`
+	Status           BidStatus `json:"status" gorm:"default:'open';index"`
+}
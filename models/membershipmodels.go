@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// CompanyMembership gives a user access to a company other than their home company
+// (User.CompanyID), with roles and permissions that only apply while that company is
+// active. A broker working for several shipper accounts has one membership per account.
+type CompanyMembership struct {
+	BaseModel
+	UserID            uuid.UUID       `json:"user_id" gorm:"type:uuid;uniqueIndex:idx_membership_user_company"`
+	User              *User           `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	CompanyID         uuid.UUID       `json:"company_id" gorm:"type:uuid;uniqueIndex:idx_membership_user_company;index"`
+	Company           *Company        `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	Roles             RoleArray       `json:"roles" gorm:"type:text[]"`
+	Permissions       PermissionArray `json:"permissions" gorm:"type:text[]"`
+	DeniedPermissions PermissionArray `json:"denied_permissions" gorm:"type:text[]"`
+	Active            bool            `json:"active"`
+}
+
+// ApplyTo makes the membership's company, roles, grants and denies the user's active ones.
+// The user is only changed in memory and must not be saved afterwards.
+func (m *CompanyMembership) ApplyTo(u *User) {
+	u.CompanyID = m.CompanyID
+	u.Roles = m.Roles
+	u.Permissions = m.Permissions
+	u.DeniedPermissions = m.DeniedPermissions
+}
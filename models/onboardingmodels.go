@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OnboardingStatus is where a carrier is in the onboarding and vetting process
+type OnboardingStatus string
+
+const (
+	OnboardingInProgress OnboardingStatus = "in_progress" // Carrier is still filling in the steps
+	OnboardingSubmitted  OnboardingStatus = "submitted"   // Waiting in the superadmin review queue
+	OnboardingApproved   OnboardingStatus = "approved"
+	OnboardingRejected   OnboardingStatus = "rejected" // Carrier may fix the problems and resubmit
+)
+
+// DocumentType is the kind of compliance document a company uploaded
+type DocumentType string
+
+const (
+	DocumentW9                   DocumentType = "w9"
+	DocumentInsuranceCertificate DocumentType = "insurance_certificate"
+	DocumentAuthorityLetter      DocumentType = "authority_letter"
+	DocumentOther                DocumentType = "other"
+)
+
+// ValidDocumentType reports whether t is a known document type
+func ValidDocumentType(t DocumentType) bool {
+	switch t {
+	case DocumentW9, DocumentInsuranceCertificate, DocumentAuthorityLetter, DocumentOther:
+		return true
+	}
+	return false
+}
+
+// CarrierOnboarding tracks a carrier company through onboarding and review
+type CarrierOnboarding struct {
+	BaseModel
+	CompanyID       uuid.UUID        `json:"company_id" gorm:"type:uuid;uniqueIndex"`
+	Company         *Company         `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	Status          OnboardingStatus `json:"status" gorm:"default:'in_progress';index"`
+	SubmittedAt     *time.Time       `json:"submitted_at,omitempty"`
+	ReviewedAt      *time.Time       `json:"reviewed_at,omitempty"`
+	ReviewedByID    *uuid.UUID       `json:"reviewed_by_id,omitempty" gorm:"type:uuid"`
+	RejectionReason string           `json:"rejection_reason,omitempty"`
+	ReviewNotes     string           `json:"review_notes,omitempty"`
+}
+
+// CompanyDocument is a file a company uploaded, such as a W-9 or insurance certificate
+type CompanyDocument struct {
+	BaseModel
+	CompanyID    uuid.UUID    `json:"company_id" gorm:"type:uuid;index"`
+	Type         DocumentType `json:"type" gorm:"index"`
+	FileName     string       `json:"file_name"`
+	ContentType  string       `json:"content_type"`
+	SizeBytes    int64        `json:"size_bytes"`
+	StoragePath  string       `json:"-"` // Location on disk, never exposed
+	UploadedByID uuid.UUID    `json:"uploaded_by_id" gorm:"type:uuid"`
+}
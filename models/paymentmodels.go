@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BankAccount is a tokenized bank account belonging to a company. The bank details are kept
+// for NACHA files and re-tokenizing, and are encrypted at rest.
+type BankAccount struct {
+	BaseModel
+	CompanyID     uuid.UUID    `json:"company_id" gorm:"type:uuid;index"`
+	Company       *Company     `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	Processor     string       `json:"processor"`
+	Token         string       `json:"token" gorm:"index"`
+	HolderName    string       `json:"holder_name"`
+	RoutingNumber SealedString `json:"routing_number"`
+	AccountNumber SealedString `json:"-"` // Never expose the full account number in JSON responses
+	AccountLast4  string       `json:"account_last4"`
+	AccountType   string       `json:"account_type"` // "checking" or "savings"
+	IsDefault     bool         `json:"is_default"`
+}
+
+// SealedString is a string stored encrypted with AES-256-GCM, keyed by
+// BANK_ACCOUNT_ENCRYPTION_KEY or, when that isn't set, a key derived from the JWT secret.
+// Changing whichever is used makes stored values unreadable. Values stored before
+// encryption was added are read as they are.
+type SealedString string
+
+// bankAccountKey is the key SealedString values are encrypted with
+var bankAccountKey = SealingKey{Env: "BANK_ACCOUNT_ENCRYPTION_KEY", Label: "bank-accounts"}
+
+// Value implements the driver.Valuer interface, encrypting the string
+func (s SealedString) Value() (driver.Value, error) {
+	sealed, err := bankAccountKey.Seal(string(s), nil)
+	if err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// Scan implements the sql.Scanner interface, decrypting the stored value
+func (s *SealedString) Scan(value interface{}) error {
+	var stored string
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return errors.New("unsupported sealed value")
+	}
+
+	if !IsSealed(stored) {
+		*s = SealedString(stored)
+		return nil
+	}
+	plain, err := bankAccountKey.Open(stored, nil)
+	if err != nil {
+		return err
+	}
+	*s = SealedString(plain)
+	return nil
+}
+
+// GormDataType tells GORM what database type to use
+func (SealedString) GormDataType() string {
+	return "text"
+}
+
+// PaymentPurpose is why money moved
+type PaymentPurpose string
+
+const (
+	PurposeEscrowFunding PaymentPurpose = "escrow_funding" // Shipper debit into escrow
+	PurposeCarrierPayout PaymentPurpose = "carrier_payout" // Carrier payable paid out
+	PurposeRefund        PaymentPurpose = "refund"         // Refunded escrow credited back to a shipper
+)
+
+// PaymentTransaction is the platform's record of a transfer submitted to the payment processor
+type PaymentTransaction struct {
+	BaseModel
+	CompanyID     uuid.UUID      `json:"company_id" gorm:"type:uuid;index"`
+	BankAccountID uuid.UUID      `json:"bank_account_id" gorm:"type:uuid;index"`
+	Processor     string         `json:"processor"`
+	ProviderRef   string         `json:"provider_ref" gorm:"uniqueIndex"`
+	Direction     string         `json:"direction"` // "debit", "credit" or "payout"
+	Purpose       PaymentPurpose `json:"purpose" gorm:"index"`
+	EscrowID      *uuid.UUID     `json:"escrow_id,omitempty" gorm:"type:uuid;index"`
+	InvoiceID     *uuid.UUID     `json:"invoice_id,omitempty" gorm:"type:uuid;index"`
+	AmountCents   int64          `json:"amount_cents"`
+	Status        string         `json:"status" gorm:"index"` // Mirrors the processor's transfer status
+	ReturnCode    string         `json:"return_code,omitempty"`
+	LastEventID   string         `json:"last_event_id,omitempty"`
+	SettledAt     *time.Time     `json:"settled_at,omitempty"`
+	FailedAt      *time.Time     `json:"failed_at,omitempty"`
+	ReturnedAt    *time.Time     `json:"returned_at,omitempty"`
+}
+
+// AchFile is a generated NACHA file kept as a downloadable artifact
+type AchFile struct {
+	BaseModel
+	FileName         string         `json:"file_name"`
+	FileIDModifier   string         `json:"file_id_modifier"`
+	SECCode          string         `json:"sec_code"`
+	EffectiveDate    time.Time      `json:"effective_date"`
+	EntryCount       int            `json:"entry_count"`
+	EntryHash        int64          `json:"entry_hash"`
+	TotalCreditCents int64          `json:"total_credit_cents"`
+	Content          string         `json:"-" gorm:"type:text"`
+	CreatedByID      uuid.UUID      `json:"created_by_id" gorm:"type:uuid"`
+	Entries          []AchFileEntry `json:"entries,omitempty" gorm:"foreignKey:AchFileID"`
+}
+
+// AchFileEntry links a carrier payable to its entry in a NACHA file
+type AchFileEntry struct {
+	BaseModel
+	AchFileID      uuid.UUID  `json:"ach_file_id" gorm:"type:uuid;index"`
+	InvoiceID      uuid.UUID  `json:"invoice_id" gorm:"type:uuid;index"`
+	CompanyID      uuid.UUID  `json:"company_id" gorm:"type:uuid;index"` // Carrier whose payable is paid
+	BankAccountID  uuid.UUID  `json:"bank_account_id" gorm:"type:uuid"`
+	PayeeCompanyID uuid.UUID  `json:"payee_company_id" gorm:"type:uuid;index"` // Carrier or the factor it assigned to
+	TraceNumber    string     `json:"trace_number" gorm:"uniqueIndex"`
+	AmountCents    int64      `json:"amount_cents"` // Net amount credited
+	FeeCents       int64      `json:"fee_cents"`    // Quick-pay fee kept by the platform
+	ReturnCode     string     `json:"return_code,omitempty"`
+	ReturnedAt     *time.Time `json:"returned_at,omitempty"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// AllPermissions is every permission the platform checks, in display order
+var AllPermissions = []Permission{
+	CreateShipment, ViewShipment, EditShipment, DeleteShipment,
+	ManageRates, ViewRates, AddRoutes, ViewRoutes,
+	ManageUsers, ViewUsers,
+	ViewFinancials, ManagePayments,
+	SystemAdmin, ManageSettings, ViewSettings,
+}
+
+// ValidPermission reports whether p is a known permission
+func ValidPermission(p Permission) bool {
+	for _, known := range AllPermissions {
+		if known == p {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleDefinition is a role stored in the database. Platform roles (no CompanyID) apply to
+// every company and are seeded from DefaultRolePermissions; a company role applies only in
+// its company and replaces a platform role with the same name there. Denies are removed
+// from whatever the user's other roles and grants allow.
+type RoleDefinition struct {
+	BaseModel
+	CompanyID   *uuid.UUID      `json:"company_id,omitempty" gorm:"type:uuid;index"`
+	Company     *Company        `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+	Name        Role            `json:"name" gorm:"index"`
+	Description string          `json:"description,omitempty"`
+	Permissions PermissionArray `json:"permissions" gorm:"type:text[]"`
+	Denies      PermissionArray `json:"denies" gorm:"type:text[]"`
+	BuiltIn     bool            `json:"built_in"` // Seeded platform role, can be edited but not deleted
+}
+
+// PermissionSet is a user's resolved permissions for their active company
+type PermissionSet struct {
+	Allowed map[Permission]bool
+	Denied  map[Permission]bool
+}
+
+// Allows reports whether p is granted and not denied. System admins hold every permission
+// they are not explicitly denied.
+func (s *PermissionSet) Allows(p Permission) bool {
+	if s.Denied[p] {
+		return false
+	}
+	return s.Allowed[p] || s.Allowed[SystemAdmin]
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sealedPrefix marks a value encrypted by SealingKey.Seal
+const sealedPrefix = "enc:v1:"
+
+// SealedPattern matches stored values that are already encrypted, for LIKE
+const SealedPattern = sealedPrefix + "%"
+
+// ErrNoSealingKey is returned when neither a sealing key nor the JWT secret is set
+var ErrNoSealingKey = errors.New("no encryption key is configured")
+
+// SealingKey says where the AES-256-GCM key for one kind of secret value comes from: the
+// environment variable Env or, when that isn't set, the JWT secret bound to Label. Changing
+// whichever is used makes stored values unreadable.
+type SealingKey struct {
+	Env   string
+	Label string
+}
+
+// cipher returns the AEAD for the key, refusing rather than falling back to a key anyone
+// could derive when neither secret is set
+func (k SealingKey) cipher() (cipher.AEAD, error) {
+	secret := []byte(os.Getenv(k.Env))
+	if len(secret) == 0 {
+		jwtSecret := os.Getenv("JWT_SECRET")
+		if jwtSecret == "" {
+			return nil, fmt.Errorf("%s is not set: %w", k.Env, ErrNoSealingKey)
+		}
+		secret = []byte(k.Label + ":" + jwtSecret)
+	}
+	key := sha256.Sum256(secret)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts value for storage. Associated data, when given, is bound in and must be
+// given again to Open.
+func (k SealingKey) Seal(value string, associated []byte) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	aead, err := k.cipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), associated)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open reverses Seal
+func (k SealingKey) Open(stored string, associated []byte) (string, error) {
+	if stored == "" {
+		return "", nil
+	}
+	if !IsSealed(stored) {
+		return "", errors.New("value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	aead, err := k.cipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associated)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// IsSealed reports whether a stored value was encrypted by Seal
+func IsSealed(stored string) bool {
+	return strings.HasPrefix(stored, sealedPrefix)
+}
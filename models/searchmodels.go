@@ -0,0 +1,16 @@
+package models
+
+// Search expressions for list pages. Each is matched with ILIKE against ?q= and backed by a
+// trigram index of the same expression, so the two must stay identical.
+const (
+	UserSearch    = "username || ' ' || email"
+	CompanySearch = "name || ' ' || email || ' ' || COALESCE(dot_number, '') || ' ' || COALESCE(mc_number, '')"
+)
+
+// SearchIndexes are the trigram indexes created at startup, by index name
+var SearchIndexes = []struct {
+	Name, Table, Expression string
+}{
+	{"idx_users_search_trgm", "users", UserSearch},
+	{"idx_companies_search_trgm", "companies", CompanySearch},
+}
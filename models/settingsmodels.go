@@ -0,0 +1,72 @@
+package models
+
+import "github.com/google/uuid"
+
+// Keys for platform settings
+const (
+	SettingQuickPayFeeBps      = "quick_pay.fee_bps"       // Quick-pay fee in basis points of the payable total
+	SettingQuickPayMinFeeCents = "quick_pay.min_fee_cents" // Smallest quick-pay fee charged
+	SettingQuickPayDays        = "quick_pay.days"          // Days until a quick-pay payable is paid
+
+	SettingRecycleRetentionDays = "recycle_bin.retention_days" // Days deleted records are kept before being purged
+
+	SettingImpersonationMinutes = "impersonation.minutes" // How long an impersonation session lasts
+
+	SettingPlatformName = "general.platform_name" // Shown to users and used as the email sender name
+	SettingSupportEmail = "general.support_email" // Where users are told to write, and the email sender address
+	SettingTimezone     = "general.timezone"      // IANA zone the platform reports in
+
+	SettingSessionHours    = "security.session_hours"     // How long a sign in lasts before the token and cookie expire
+	SettingRequireAdmin2FA = "security.require_admin_2fa" // Not yet enforced; recorded for when two-factor sign in is added
+
+	SettingSMTPHost     = "smtp.host" // Email goes through this server when set
+	SettingSMTPPort     = "smtp.port"
+	SettingSMTPUsername = "smtp.username"
+	SettingSMTPPassword = "smtp.password" // Secret
+)
+
+// SettingType is the kind of value a platform setting holds. Values are always stored as
+// text and validated against their type when changed.
+type SettingType string
+
+const (
+	SettingTypeInt      SettingType = "int"
+	SettingTypeBool     SettingType = "bool"
+	SettingTypeString   SettingType = "string"
+	SettingTypeEmail    SettingType = "email"
+	SettingTypeTimezone SettingType = "timezone"
+)
+
+// Audit actions for platform settings
+const (
+	AuditSettingsChanged = "settings_changed" // Detail lists the keys and their new versions
+)
+
+// PlatformSetting is a platform-wide configuration value editable by superadmins. Only the
+// key, value and version are stored; the type, limits and whether it is secret come from
+// the setting's definition in code.
+type PlatformSetting struct {
+	BaseModel
+	Key         string      `json:"key" gorm:"uniqueIndex"`
+	Value       string      `json:"value"` // Encrypted at rest for secret settings, which never return it
+	Description string      `json:"description,omitempty"`
+	Version     int64       `json:"version"` // Incremented on every change; 0 until first saved
+	UpdatedByID *uuid.UUID  `json:"updated_by_id,omitempty" gorm:"type:uuid"`
+	Type        SettingType `json:"type" gorm:"-"`
+	Secret      bool        `json:"secret,omitempty" gorm:"-"`
+	Min         int64       `json:"min,omitempty" gorm:"-"`        // Smallest whole number allowed
+	Max         int64       `json:"max,omitempty" gorm:"-"`        // Largest whole number allowed, or longest text; 0 for no limit
+	Configured  bool        `json:"configured,omitempty" gorm:"-"` // Whether a secret setting has a value
+}
+
+// PlatformSettingChange records one change to a platform setting. Secret settings record
+// that they changed but not their values.
+type PlatformSettingChange struct {
+	BaseModel
+	Key      string     `json:"key" gorm:"index"`
+	Version  int64      `json:"version"`
+	OldValue string     `json:"old_value"`
+	NewValue string     `json:"new_value"`
+	ActorID  *uuid.UUID `json:"actor_id,omitempty" gorm:"type:uuid"`
+	Actor    *User      `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+}
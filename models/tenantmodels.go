@@ -0,0 +1,86 @@
+package models
+
+// Owner columns for tenant scoping. Rows of these models are only visible to, and can
+// only be written by, the companies named in these columns unless the tenant scope is
+// bypassed. Companies, ledger accounts and journal entries are platform records and are
+// not scoped; handlers authorize access to them directly.
+
+func (User) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (Load) OwnerColumns() []string {
+	return []string{"shipper_company_id", "carrier_company_id"}
+}
+
+func (Invoice) OwnerColumns() []string {
+	return []string{"company_id", "payee_company_id"}
+}
+
+func (Escrow) OwnerColumns() []string {
+	return []string{"shipper_company_id", "carrier_company_id"}
+}
+
+func (BankAccount) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (PaymentTransaction) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (AchFileEntry) OwnerColumns() []string {
+	return []string{"company_id", "payee_company_id"}
+}
+
+func (NoticeOfAssignment) OwnerColumns() []string {
+	return []string{"carrier_company_id", "factor_company_id"}
+}
+
+func (CarrierOnboarding) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (CompanyDocument) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (ComplianceFlag) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (InsuranceCertificate) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (Vehicle) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (Trailer) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (Driver) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (FleetUnavailability) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (CapacityPost) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (Invitation) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (CompanyMembership) OwnerColumns() []string {
+	return []string{"company_id"}
+}
+
+func (LoadBid) OwnerColumns() []string {
+	return []string{"carrier_company_id"}
+}
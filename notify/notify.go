@@ -0,0 +1,201 @@
+// Package notify delivers messages to users and companies. The transport is pluggable
+// so email or SMS providers can be added without touching the callers.
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a notification for one or more recipients
+type Message struct {
+	To      []string          `json:"to"` // Email addresses
+	Subject string            `json:"subject"`
+	Body    string            `json:"body"`
+	Tags    map[string]string `json:"tags,omitempty"` // e.g. company_id, kind
+}
+
+// Notifier sends messages
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// LogNotifier writes messages to stdout, used in development and as the fallback
+type LogNotifier struct{}
+
+// Notify prints the message
+func (LogNotifier) Notify(msg Message) error {
+	fmt.Printf("Notification to %s: %s\n%s\n", strings.Join(msg.To, ", "), msg.Subject, msg.Body)
+	return nil
+}
+
+// WebhookNotifier posts messages as JSON to a URL, for relaying to an email or chat service
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts the message to the webhook
+func (w WebhookNotifier) Notify(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// smtpTimeout bounds a whole SMTP exchange when SMTPNotifier.Timeout is not set
+const smtpTimeout = 30 * time.Second
+
+// SMTPNotifier sends messages as plain text email through an SMTP server, upgrading to TLS
+// when the server offers it
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string // Sign in is skipped when blank
+	Password string
+	From     mail.Address
+	Timeout  time.Duration // Connecting and sending together, smtpTimeout when zero
+}
+
+// Notify emails the message to its recipients
+func (s SMTPNotifier) Notify(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	// Headers are built from user supplied text, so line breaks are dropped
+	header := strings.NewReplacer("\r", " ", "\n", " ")
+	var body strings.Builder
+	body.WriteString("From: " + header.Replace(s.From.String()) + "\r\n")
+	body.WriteString("To: " + header.Replace(strings.Join(msg.To, ", ")) + "\r\n")
+	body.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", header.Replace(msg.Subject)) + "\r\n")
+	body.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	return s.send(auth, msg.To, body.String())
+}
+
+// send does what smtp.SendMail does, but within the notifier's timeout so a server that
+// stops answering can't hold up the request sending the message
+func (s SMTPNotifier) send(auth smtp.Auth, to []string, body string) error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = smtpTimeout
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.From.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// EnvNotifier is the notifier the environment configures: a WebhookNotifier when
+// NOTIFY_WEBHOOK_URL is set, otherwise a LogNotifier
+func EnvNotifier() Notifier {
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		return WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return LogNotifier{}
+}
+
+var (
+	defaultNotifier Notifier
+	defaultOnce     sync.Once
+	defaultMu       sync.RWMutex
+)
+
+// Default returns the configured notifier, EnvNotifier unless SetDefault has replaced it
+func Default() Notifier {
+	defaultOnce.Do(func() {
+		defaultNotifier = EnvNotifier()
+	})
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultNotifier
+}
+
+// SetDefault replaces the notifier returned by Default
+func SetDefault(n Notifier) {
+	defaultOnce.Do(func() {})
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultNotifier = n
+}
+
+// Send delivers a message with the default notifier, logging rather than returning failures
+func Send(msg Message) {
+	if len(msg.To) == 0 {
+		return
+	}
+	if err := Default().Notify(msg); err != nil {
+		fmt.Printf("Error sending notification %q: %v\n", msg.Subject, err)
+	}
+}
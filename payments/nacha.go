@@ -0,0 +1,310 @@
+package payments
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	nachaRecordSize     = 94
+	nachaBlockingFactor = 10
+
+	// Service class for a batch that only contains credits
+	serviceClassCreditsOnly = "220"
+)
+
+// NACHA transaction codes for credits
+const (
+	TransactionCodeCheckingCredit = "22"
+	TransactionCodeSavingsCredit  = "32"
+)
+
+// NACHAConfig identifies the originator and the bank receiving the file
+type NACHAConfig struct {
+	ImmediateDestination     string // Routing number of the bank receiving the file
+	ImmediateDestinationName string
+	ImmediateOrigin          string // Usually "1" followed by the originator's EIN
+	ImmediateOriginName      string
+	CompanyName              string
+	CompanyID                string // 10 character company identification
+	ODFIRouting              string // Routing number of the originating bank (first 8 digits are used)
+	EntryDescription         string // Shows on the receiver's statement, e.g. "PAYOUT"
+	SECCode                  string // "PPD" for individuals, "CCD" for businesses
+}
+
+// NACHAConfigFromEnv builds the originator configuration from ACH_* environment variables
+func NACHAConfigFromEnv() NACHAConfig {
+	return NACHAConfig{
+		ImmediateDestination:     os.Getenv("ACH_IMMEDIATE_DESTINATION"),
+		ImmediateDestinationName: os.Getenv("ACH_DESTINATION_NAME"),
+		ImmediateOrigin:          os.Getenv("ACH_IMMEDIATE_ORIGIN"),
+		ImmediateOriginName:      os.Getenv("ACH_ORIGIN_NAME"),
+		CompanyName:              os.Getenv("ACH_COMPANY_NAME"),
+		CompanyID:                os.Getenv("ACH_COMPANY_ID"),
+		ODFIRouting:              os.Getenv("ACH_ODFI_ROUTING"),
+		EntryDescription:         "PAYOUT",
+		SECCode:                  "CCD",
+	}
+}
+
+// Validate checks the configuration has everything a bank will require
+func (cfg NACHAConfig) Validate() error {
+	if !ValidRoutingNumber(cfg.ImmediateDestination) {
+		return errors.New("ACH immediate destination must be a valid routing number")
+	}
+	if !ValidRoutingNumber(cfg.ODFIRouting) {
+		return errors.New("ACH ODFI routing number is invalid")
+	}
+	if cfg.ImmediateOrigin == "" || len(cfg.ImmediateOrigin) > 10 {
+		return errors.New("ACH immediate origin must be 1 to 10 characters")
+	}
+	if cfg.CompanyID == "" || len(cfg.CompanyID) > 10 {
+		return errors.New("ACH company ID must be 1 to 10 characters")
+	}
+	if cfg.CompanyName == "" {
+		return errors.New("ACH company name is required")
+	}
+	if cfg.SECCode != "PPD" && cfg.SECCode != "CCD" {
+		return errors.New("SEC code must be PPD or CCD")
+	}
+	return nil
+}
+
+// NACHAEntry is a single credit to a receiver's account
+type NACHAEntry struct {
+	ReceiverName  string
+	ReceiverID    string // Individual or company identification, up to 15 characters
+	RoutingNumber string
+	AccountNumber string
+	AccountType   string // "checking" or "savings"
+	AmountCents   int64
+}
+
+// NACHAFile is a generated file together with the values banks reconcile against
+type NACHAFile struct {
+	Content          []byte
+	TraceNumbers     []string // Trace number for each entry, in the order given
+	EntryCount       int
+	EntryHash        int64
+	TotalCreditCents int64
+}
+
+// BuildNACHAFile renders a single-batch credit file for the given entries.
+// Trace numbers are numbered from firstTrace so they stay unique across files.
+func BuildNACHAFile(cfg NACHAConfig, entries []NACHAEntry, firstTrace int, effectiveDate time.Time, created time.Time, fileIDModifier byte) (*NACHAFile, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("a NACHA file needs at least one entry")
+	}
+	if fileIDModifier < 'A' || fileIDModifier > 'Z' {
+		return nil, errors.New("file ID modifier must be A-Z")
+	}
+	if firstTrace < 1 || firstTrace+len(entries)-1 > 9999999 {
+		return nil, errors.New("trace sequence out of range")
+	}
+
+	odfi := cfg.ODFIRouting[:8]
+	batchNumber := 1
+	result := &NACHAFile{}
+	var records []string
+
+	// File header
+	records = append(records, "1"+
+		"01"+
+		padLeft(cfg.ImmediateDestination, 10, ' ')+
+		padLeft(cfg.ImmediateOrigin, 10, ' ')+
+		created.Format("060102")+
+		created.Format("1504")+
+		string(fileIDModifier)+
+		"094"+
+		"10"+
+		"1"+
+		padRight(strings.ToUpper(cfg.ImmediateDestinationName), 23)+
+		padRight(strings.ToUpper(cfg.ImmediateOriginName), 23)+
+		padRight("", 8))
+
+	// Batch header
+	records = append(records, "5"+
+		serviceClassCreditsOnly+
+		padRight(strings.ToUpper(cfg.CompanyName), 16)+
+		padRight("", 20)+
+		padRight(cfg.CompanyID, 10)+
+		cfg.SECCode+
+		padRight(strings.ToUpper(cfg.EntryDescription), 10)+
+		padRight("", 6)+
+		effectiveDate.Format("060102")+
+		padRight("", 3)+
+		"1"+
+		odfi+
+		padLeft(strconv.Itoa(batchNumber), 7, '0'))
+
+	for i, entry := range entries {
+		if !ValidRoutingNumber(entry.RoutingNumber) {
+			return nil, fmt.Errorf("entry %d: invalid routing number", i+1)
+		}
+		if entry.AmountCents <= 0 || entry.AmountCents > 9999999999 {
+			return nil, fmt.Errorf("entry %d: amount out of range", i+1)
+		}
+		if entry.AccountNumber == "" || len(entry.AccountNumber) > 17 {
+			return nil, fmt.Errorf("entry %d: invalid account number", i+1)
+		}
+
+		code := TransactionCodeCheckingCredit
+		if entry.AccountType == "savings" {
+			code = TransactionCodeSavingsCredit
+		}
+
+		trace := odfi + padLeft(strconv.Itoa(firstTrace+i), 7, '0')
+		result.TraceNumbers = append(result.TraceNumbers, trace)
+
+		records = append(records, "6"+
+			code+
+			entry.RoutingNumber[:8]+
+			entry.RoutingNumber[8:9]+
+			padRight(entry.AccountNumber, 17)+
+			padLeft(strconv.FormatInt(entry.AmountCents, 10), 10, '0')+
+			padRight(strings.ToUpper(entry.ReceiverID), 15)+
+			padRight(strings.ToUpper(entry.ReceiverName), 22)+
+			"  "+
+			"0"+
+			trace)
+
+		rdfi, _ := strconv.ParseInt(entry.RoutingNumber[:8], 10, 64)
+		result.EntryHash += rdfi
+		result.TotalCreditCents += entry.AmountCents
+		result.EntryCount++
+	}
+
+	// The hash is the sum of the RDFI routing numbers truncated to the rightmost ten digits
+	result.EntryHash %= 10000000000
+	hash := padLeft(strconv.FormatInt(result.EntryHash, 10), 10, '0')
+
+	// Batch control
+	records = append(records, "8"+
+		serviceClassCreditsOnly+
+		padLeft(strconv.Itoa(result.EntryCount), 6, '0')+
+		hash+
+		padLeft("0", 12, '0')+
+		padLeft(strconv.FormatInt(result.TotalCreditCents, 10), 12, '0')+
+		padRight(cfg.CompanyID, 10)+
+		padRight("", 19)+
+		padRight("", 6)+
+		odfi+
+		padLeft(strconv.Itoa(batchNumber), 7, '0'))
+
+	// File control, block count includes the file control record itself
+	blocks := (len(records) + 1 + nachaBlockingFactor - 1) / nachaBlockingFactor
+	records = append(records, "9"+
+		padLeft("1", 6, '0')+
+		padLeft(strconv.Itoa(blocks), 6, '0')+
+		padLeft(strconv.Itoa(result.EntryCount), 8, '0')+
+		hash+
+		padLeft("0", 12, '0')+
+		padLeft(strconv.FormatInt(result.TotalCreditCents, 10), 12, '0')+
+		padRight("", 39))
+
+	// Pad the last block with filler records
+	for len(records)%nachaBlockingFactor != 0 {
+		records = append(records, strings.Repeat("9", nachaRecordSize))
+	}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		if len(record) != nachaRecordSize {
+			return nil, fmt.Errorf("internal error: record %q is %d characters", record[:1], len(record))
+		}
+		buf.WriteString(record)
+		buf.WriteString("\n")
+	}
+	result.Content = buf.Bytes()
+
+	return result, nil
+}
+
+// NACHAReturn is a returned entry read from a bank's return file
+type NACHAReturn struct {
+	OriginalTraceNumber string
+	ReturnCode          string
+	AmountCents         int64
+	AccountNumber       string
+	ReceiverID          string
+}
+
+// ParseNACHAReturns reads the entry detail and return addenda records of a return file
+func ParseNACHAReturns(data []byte) ([]NACHAReturn, error) {
+	var returns []NACHAReturn
+	var pending *NACHAReturn
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		record := strings.TrimRight(scanner.Text(), "\r")
+		if record == "" || strings.Trim(record, "9") == "" {
+			continue // Blank lines and block filler
+		}
+		if len(record) != nachaRecordSize {
+			return nil, fmt.Errorf("line %d: record is %d characters, expected %d", line, len(record), nachaRecordSize)
+		}
+
+		switch record[0] {
+		case '6':
+			amount, err := strconv.ParseInt(record[29:39], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid amount", line)
+			}
+			pending = &NACHAReturn{
+				AmountCents:   amount,
+				AccountNumber: strings.TrimSpace(record[12:29]),
+				ReceiverID:    strings.TrimSpace(record[39:54]),
+			}
+		case '7':
+			if record[1:3] != "99" {
+				continue // Not a return addenda
+			}
+			if pending == nil {
+				return nil, fmt.Errorf("line %d: return addenda without an entry", line)
+			}
+			pending.ReturnCode = record[3:6]
+			pending.OriginalTraceNumber = record[6:21]
+			returns = append(returns, *pending)
+			pending = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return returns, nil
+}
+
+// padRight left-justifies s in a field of width n, truncating if needed.
+// Characters outside printable ASCII are dropped since NACHA files are ASCII only.
+func padRight(s string, n int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) >= n {
+		return s[:n]
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+// padLeft right-justifies s in a field of width n, truncating from the left if needed
+func padLeft(s string, n int, pad byte) string {
+	if len(s) >= n {
+		return s[len(s)-n:]
+	}
+	return strings.Repeat(string(pad), n-len(s)) + s
+}
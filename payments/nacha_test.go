@@ -0,0 +1,342 @@
+package payments
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testEffective = time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	testCreated   = time.Date(2026, 3, 6, 14, 30, 0, 0, time.UTC)
+)
+
+func testNACHAConfig() NACHAConfig {
+	return NACHAConfig{
+		ImmediateDestination:     "021000021",
+		ImmediateDestinationName: "Chase",
+		ImmediateOrigin:          "1123456789",
+		ImmediateOriginName:      "CargoZig",
+		CompanyName:              "CargoZig Inc",
+		CompanyID:                "1123456789",
+		ODFIRouting:              "011000015",
+		EntryDescription:         "PAYOUT",
+		SECCode:                  "CCD",
+	}
+}
+
+func testEntry(amountCents int64) NACHAEntry {
+	return NACHAEntry{
+		ReceiverName:  "Acme Trucking",
+		ReceiverID:    "C-1",
+		RoutingNumber: "091000019",
+		AccountNumber: "123456789",
+		AccountType:   "checking",
+		AmountCents:   amountCents,
+	}
+}
+
+// nachaLines builds a file and splits it into its records
+func nachaLines(t *testing.T, entries []NACHAEntry) (*NACHAFile, []string) {
+	t.Helper()
+	file, err := BuildNACHAFile(testNACHAConfig(), entries, 1, testEffective, testCreated, 'A')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, strings.Split(strings.TrimSuffix(string(file.Content), "\n"), "\n")
+}
+
+func TestNACHARecordsAreFixedWidth(t *testing.T) {
+	file, lines := nachaLines(t, []NACHAEntry{testEntry(12345), testEntry(1)})
+
+	var types string
+	for i, line := range lines {
+		if len(line) != nachaRecordSize {
+			t.Errorf("record %d is %d characters: %q", i+1, len(line), line)
+		}
+		types += line[:1]
+	}
+	if want := "1566899999"; types != want {
+		t.Errorf("record types = %s, want %s", types, want)
+	}
+	if file.EntryCount != 2 || file.TotalCreditCents != 12346 {
+		t.Errorf("entry count %d and total %d, want 2 and 12346", file.EntryCount, file.TotalCreditCents)
+	}
+}
+
+func TestNACHAFieldPadding(t *testing.T) {
+	entry := testEntry(12345)
+	entry.ReceiverName = "Übersee Freight & Logistics Company"
+	entry.ReceiverID = "c-42"
+	_, lines := nachaLines(t, []NACHAEntry{entry})
+	header, batch, detail := lines[0], lines[1], lines[2]
+
+	fields := []struct {
+		name   string
+		record string
+		from   int
+		to     int
+		want   string
+	}{
+		{"immediate destination", header, 3, 13, " 021000021"},
+		{"immediate origin", header, 13, 23, "1123456789"},
+		{"file creation date and time", header, 23, 33, "2603061430"},
+		{"file ID modifier", header, 33, 34, "A"},
+		{"destination name", header, 40, 63, "CHASE                  "},
+		{"company name", batch, 4, 20, "CARGOZIG INC    "},
+		{"effective date", batch, 69, 75, "260309"},
+		{"batch ODFI", batch, 79, 87, "01100001"},
+		{"transaction code", detail, 1, 3, TransactionCodeCheckingCredit},
+		{"RDFI routing", detail, 3, 12, "091000019"},
+		{"account number", detail, 12, 29, "123456789        "},
+		{"amount", detail, 29, 39, "0000012345"},
+		{"receiver ID", detail, 39, 54, "C-42           "},
+		// Non-ASCII is dropped and the name cut to 22 characters
+		{"receiver name", detail, 54, 76, "BERSEE FREIGHT & LOGIS"},
+		{"trace number", detail, 79, 94, "011000010000001"},
+	}
+	for _, f := range fields {
+		if got := f.record[f.from:f.to]; got != f.want {
+			t.Errorf("%s = %q, want %q", f.name, got, f.want)
+		}
+	}
+
+	entry.AccountType = "savings"
+	_, lines = nachaLines(t, []NACHAEntry{entry})
+	if code := lines[2][1:3]; code != TransactionCodeSavingsCredit {
+		t.Errorf("savings transaction code = %s, want %s", code, TransactionCodeSavingsCredit)
+	}
+}
+
+func TestNACHAControlTotals(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []NACHAEntry
+		count   string
+		hash    string
+		total   string
+	}{
+		{
+			name:    "one entry",
+			entries: []NACHAEntry{testEntry(500)},
+			count:   "000001",
+			hash:    "0009100001",
+			total:   "000000000500",
+		},
+		{
+			name: "hash sums the RDFI routing numbers",
+			entries: []NACHAEntry{
+				testEntry(500),
+				{ReceiverName: "Beta", RoutingNumber: "021000021", AccountNumber: "9", AmountCents: 250},
+			},
+			count: "000002",
+			hash:  "0011200003",
+			total: "000000000750",
+		},
+		{
+			// 1,100 entries at 09100001 sum to 10,010,001,100, kept to its last ten digits
+			name:    "hash keeps the rightmost ten digits",
+			entries: repeatEntry(testEntry(1), 1100),
+			count:   "001100",
+			hash:    "0010001100",
+			total:   "000000001100",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, lines := nachaLines(t, tt.entries)
+			batchControl := lines[len(tt.entries)+2]
+			fileControl := lines[len(tt.entries)+3]
+
+			checks := []struct {
+				name string
+				got  string
+				want string
+			}{
+				{"batch entry count", batchControl[4:10], tt.count},
+				{"batch entry hash", batchControl[10:20], tt.hash},
+				{"batch debit total", batchControl[20:32], "000000000000"},
+				{"batch credit total", batchControl[32:44], tt.total},
+				{"file batch count", fileControl[1:7], "000001"},
+				{"file entry count", fileControl[13:21], "00" + tt.count},
+				{"file entry hash", fileControl[21:31], tt.hash},
+				{"file debit total", fileControl[31:43], "000000000000"},
+				{"file credit total", fileControl[43:55], tt.total},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s = %s, want %s", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNACHABlocking(t *testing.T) {
+	// A file is its header, batch header, entries, batch control and file control, filled
+	// out to a multiple of ten records
+	tests := []struct {
+		entries int
+		lines   int
+		blocks  string
+		fillers int
+	}{
+		{entries: 1, lines: 10, blocks: "000001", fillers: 5},
+		{entries: 6, lines: 10, blocks: "000001", fillers: 0},
+		{entries: 7, lines: 20, blocks: "000002", fillers: 9},
+		{entries: 16, lines: 20, blocks: "000002", fillers: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d entries", tt.entries), func(t *testing.T) {
+			_, lines := nachaLines(t, repeatEntry(testEntry(100), tt.entries))
+			if len(lines) != tt.lines {
+				t.Fatalf("file has %d records, want %d", len(lines), tt.lines)
+			}
+			fileControl := lines[tt.entries+3]
+			if blocks := fileControl[7:13]; blocks != tt.blocks {
+				t.Errorf("block count = %s, want %s", blocks, tt.blocks)
+			}
+			fillers := 0
+			for _, line := range lines[tt.entries+4:] {
+				if line != strings.Repeat("9", nachaRecordSize) {
+					t.Errorf("record after the file control is not filler: %q", line)
+				}
+				fillers++
+			}
+			if fillers != tt.fillers {
+				t.Errorf("%d filler records, want %d", fillers, tt.fillers)
+			}
+		})
+	}
+}
+
+func TestNACHATraceNumbers(t *testing.T) {
+	file, err := BuildNACHAFile(testNACHAConfig(), repeatEntry(testEntry(100), 3), 41, testEffective, testCreated, 'B')
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"011000010000041", "011000010000042", "011000010000043"}
+	if strings.Join(file.TraceNumbers, ",") != strings.Join(want, ",") {
+		t.Errorf("trace numbers = %v, want %v", file.TraceNumbers, want)
+	}
+}
+
+func TestBuildNACHAFileRejects(t *testing.T) {
+	badRouting := testEntry(100)
+	badRouting.RoutingNumber = "091000018"
+	noAmount := testEntry(0)
+	longAccount := testEntry(100)
+	longAccount.AccountNumber = strings.Repeat("1", 18)
+	badConfig := testNACHAConfig()
+	badConfig.SECCode = "WEB"
+
+	tests := []struct {
+		name       string
+		cfg        NACHAConfig
+		entries    []NACHAEntry
+		firstTrace int
+		modifier   byte
+	}{
+		{"invalid config", badConfig, []NACHAEntry{testEntry(100)}, 1, 'A'},
+		{"no entries", testNACHAConfig(), nil, 1, 'A'},
+		{"lowercase modifier", testNACHAConfig(), []NACHAEntry{testEntry(100)}, 1, 'a'},
+		{"trace past seven digits", testNACHAConfig(), repeatEntry(testEntry(100), 2), 9999999, 'A'},
+		{"routing checksum", testNACHAConfig(), []NACHAEntry{badRouting}, 1, 'A'},
+		{"zero amount", testNACHAConfig(), []NACHAEntry{noAmount}, 1, 'A'},
+		{"account over 17 digits", testNACHAConfig(), []NACHAEntry{longAccount}, 1, 'A'},
+	}
+	for _, tt := range tests {
+		if _, err := BuildNACHAFile(tt.cfg, tt.entries, tt.firstTrace, testEffective, testCreated, tt.modifier); err == nil {
+			t.Errorf("%s: built a file, want an error", tt.name)
+		}
+	}
+}
+
+// returnAddenda is a return addenda record (type 7, addenda type 99) for trace
+func returnAddenda(code, trace string) string {
+	return padRight("799"+code+trace+"      "+"09100001", nachaRecordSize)
+}
+
+func TestParseNACHAReturns(t *testing.T) {
+	file, lines := nachaLines(t, []NACHAEntry{testEntry(12345), testEntry(678)})
+	first, second := lines[2], lines[3]
+
+	tests := []struct {
+		name    string
+		records []string
+		want    []NACHAReturn
+	}{
+		{
+			name:    "insufficient funds",
+			records: []string{lines[0], lines[1], first, returnAddenda("R01", file.TraceNumbers[0]), lines[4], lines[5]},
+			want: []NACHAReturn{
+				{OriginalTraceNumber: "011000010000001", ReturnCode: "R01", AmountCents: 12345, AccountNumber: "123456789", ReceiverID: "C-1"},
+			},
+		},
+		{
+			name:    "several returns",
+			records: []string{first, returnAddenda("R01", file.TraceNumbers[0]), second, returnAddenda("R03", file.TraceNumbers[1])},
+			want: []NACHAReturn{
+				{OriginalTraceNumber: "011000010000001", ReturnCode: "R01", AmountCents: 12345, AccountNumber: "123456789", ReceiverID: "C-1"},
+				{OriginalTraceNumber: "011000010000002", ReturnCode: "R03", AmountCents: 678, AccountNumber: "123456789", ReceiverID: "C-1"},
+			},
+		},
+		{
+			name:    "entries without a return addenda are not returns",
+			records: []string{first, padRight("705payment info", nachaRecordSize), second, returnAddenda("R03", file.TraceNumbers[1])},
+			want: []NACHAReturn{
+				{OriginalTraceNumber: "011000010000002", ReturnCode: "R03", AmountCents: 678, AccountNumber: "123456789", ReceiverID: "C-1"},
+			},
+		},
+		{
+			name:    "CRLF line endings, blank lines and filler",
+			records: []string{first + "\r", "", returnAddenda("R03", file.TraceNumbers[0]) + "\r", strings.Repeat("9", nachaRecordSize)},
+			want: []NACHAReturn{
+				{OriginalTraceNumber: "011000010000001", ReturnCode: "R03", AmountCents: 12345, AccountNumber: "123456789", ReceiverID: "C-1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returns, err := ParseNACHAReturns([]byte(strings.Join(tt.records, "\n")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(returns) != len(tt.want) {
+				t.Fatalf("parsed %d returns, want %d: %+v", len(returns), len(tt.want), returns)
+			}
+			for i := range tt.want {
+				if returns[i] != tt.want[i] {
+					t.Errorf("return %d = %+v, want %+v", i+1, returns[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseNACHAReturnsRejects(t *testing.T) {
+	_, lines := nachaLines(t, []NACHAEntry{testEntry(100)})
+
+	tests := map[string][]string{
+		"short record":              {lines[2][:93]},
+		"addenda without an entry":  {returnAddenda("R01", "011000010000001")},
+		"amount that isn't numeric": {lines[2][:29] + "00000001X0" + lines[2][39:]},
+	}
+	for name, records := range tests {
+		if _, err := ParseNACHAReturns([]byte(strings.Join(records, "\n"))); err == nil {
+			t.Errorf("%s: parsed, want an error", name)
+		}
+	}
+}
+
+func repeatEntry(entry NACHAEntry, n int) []NACHAEntry {
+	entries := make([]NACHAEntry, n)
+	for i := range entries {
+		entries[i] = entry
+	}
+	return entries
+}
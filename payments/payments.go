@@ -0,0 +1,177 @@
+// Package payments abstracts the bank/ACH provider behind a Processor so the
+// rest of the platform can move money without knowing which provider is in use.
+// A deterministic in-process Sandbox is provided for development and testing.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Direction is which way money moves relative to the platform
+type Direction string
+
+const (
+	DirectionDebit  Direction = "debit"  // Pull money from a customer account
+	DirectionCredit Direction = "credit" // Push money to a customer account
+	DirectionPayout Direction = "payout" // Push a carrier payout
+)
+
+// TransferStatus is the provider's view of a transfer
+type TransferStatus string
+
+const (
+	StatusPending    TransferStatus = "pending"
+	StatusProcessing TransferStatus = "processing"
+	StatusSettled    TransferStatus = "settled"
+	StatusFailed     TransferStatus = "failed"   // Rejected before settlement
+	StatusReturned   TransferStatus = "returned" // Returned by the receiving bank after settlement
+)
+
+// Final reports whether no further status changes are expected
+func (s TransferStatus) Final() bool {
+	return s == StatusFailed || s == StatusReturned
+}
+
+var (
+	// ErrInvalidBankAccount is returned when routing or account numbers are malformed
+	ErrInvalidBankAccount = errors.New("invalid bank account")
+	// ErrUnknownToken is returned when a bank account token is not known to the processor
+	ErrUnknownToken = errors.New("unknown bank account token")
+	// ErrInvalidSignature is returned when a webhook signature does not verify
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrWebhookNotConfigured is returned when no webhook secret is set, so no delivery can be trusted
+	ErrWebhookNotConfigured = errors.New("PAYMENTS_WEBHOOK_SECRET is not set")
+)
+
+// BankAccount holds the raw bank details submitted for tokenization
+type BankAccount struct {
+	HolderName    string
+	RoutingNumber string
+	AccountNumber string
+	AccountType   string // "checking" or "savings"
+}
+
+// Validate checks the routing number checksum and account number format
+func (a BankAccount) Validate() error {
+	if !ValidRoutingNumber(a.RoutingNumber) {
+		return fmt.Errorf("%w: routing number", ErrInvalidBankAccount)
+	}
+	if len(a.AccountNumber) < 4 || len(a.AccountNumber) > 17 {
+		return fmt.Errorf("%w: account number", ErrInvalidBankAccount)
+	}
+	for _, r := range a.AccountNumber {
+		if r < '0' || r > '9' {
+			return fmt.Errorf("%w: account number", ErrInvalidBankAccount)
+		}
+	}
+	if a.AccountType != "checking" && a.AccountType != "savings" {
+		return fmt.Errorf("%w: account type", ErrInvalidBankAccount)
+	}
+	return nil
+}
+
+// Last4 returns the last four digits of the account number
+func (a BankAccount) Last4() string {
+	if len(a.AccountNumber) <= 4 {
+		return a.AccountNumber
+	}
+	return a.AccountNumber[len(a.AccountNumber)-4:]
+}
+
+// TokenizedAccount is what the platform stores in place of raw bank details
+type TokenizedAccount struct {
+	Token        string
+	AccountLast4 string
+}
+
+// TransferRequest asks the processor to move money against a tokenized account
+type TransferRequest struct {
+	Token          string
+	AmountCents    int64
+	Description    string
+	IdempotencyKey string // Repeated requests with the same key return the original transfer
+}
+
+// Transfer is the processor's record of a money movement
+type Transfer struct {
+	ID          string
+	Direction   Direction
+	Token       string
+	AmountCents int64
+	Status      TransferStatus
+	ReturnCode  string // NACHA return reason code such as R01
+	CreatedAt   time.Time
+}
+
+// Event is a transfer status change delivered by the processor's webhook
+type Event struct {
+	ID         string         `json:"id"`
+	TransferID string         `json:"transfer_id"`
+	Status     TransferStatus `json:"status"`
+	ReturnCode string         `json:"return_code,omitempty"`
+	OccurredAt time.Time      `json:"occurred_at"`
+}
+
+// Processor is implemented by every payment provider
+type Processor interface {
+	// Name identifies the processor in stored records
+	Name() string
+	// TokenizeBankAccount exchanges raw bank details for a reusable token
+	TokenizeBankAccount(ctx context.Context, account BankAccount) (*TokenizedAccount, error)
+	// DebitACH pulls money from a tokenized account
+	DebitACH(ctx context.Context, req TransferRequest) (*Transfer, error)
+	// CreditACH pushes money to a tokenized account
+	CreditACH(ctx context.Context, req TransferRequest) (*Transfer, error)
+	// Payout pushes a carrier payout to a tokenized account
+	Payout(ctx context.Context, req TransferRequest) (*Transfer, error)
+	// ParseWebhook verifies and decodes a webhook delivery
+	ParseWebhook(payload []byte, signature string) (*Event, error)
+}
+
+var (
+	defaultProcessor Processor
+	processorOnce    sync.Once
+)
+
+// Default returns the processor configured by PAYMENTS_PROCESSOR, initializing it if necessary
+func Default() Processor {
+	processorOnce.Do(func() {
+		switch name := os.Getenv("PAYMENTS_PROCESSOR"); name {
+		case "", "sandbox":
+			defaultProcessor = NewSandbox(SandboxConfigFromEnv())
+		default:
+			fmt.Printf("Warning: unknown PAYMENTS_PROCESSOR %q, using sandbox\n", name)
+			defaultProcessor = NewSandbox(SandboxConfigFromEnv())
+		}
+	})
+	return defaultProcessor
+}
+
+// WebhookSecret returns the secret used to sign webhook deliveries. It is empty when
+// PAYMENTS_WEBHOOK_SECRET is not set, and webhooks must then be refused.
+func WebhookSecret() []byte {
+	return []byte(os.Getenv("PAYMENTS_WEBHOOK_SECRET"))
+}
+
+// ValidRoutingNumber checks the length and ABA checksum of a routing number
+func ValidRoutingNumber(routing string) bool {
+	if len(routing) != 9 {
+		return false
+	}
+	weights := []int{3, 7, 1, 3, 7, 1, 3, 7, 1}
+	sum := 0
+	for i, r := range routing {
+		d, err := strconv.Atoi(string(r))
+		if err != nil {
+			return false
+		}
+		sum += d * weights[i]
+	}
+	return sum%10 == 0
+}
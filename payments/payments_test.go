@@ -0,0 +1,123 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidRoutingNumber(t *testing.T) {
+	tests := map[string]bool{
+		"021000021":  true,
+		"011000015":  true,
+		"091000019":  true,
+		"091000018":  false, // Checksum
+		"02100002":   false, // Too short
+		"0210000210": false, // Too long
+		"02100002a":  false,
+		"":           false,
+	}
+	for routing, want := range tests {
+		if got := ValidRoutingNumber(routing); got != want {
+			t.Errorf("ValidRoutingNumber(%q) = %v, want %v", routing, got, want)
+		}
+	}
+}
+
+func TestBankAccountValidate(t *testing.T) {
+	valid := BankAccount{HolderName: "Acme", RoutingNumber: "021000021", AccountNumber: "123456789", AccountType: "checking"}
+
+	tests := []struct {
+		name   string
+		change func(*BankAccount)
+		ok     bool
+	}{
+		{"valid", func(a *BankAccount) {}, true},
+		{"savings", func(a *BankAccount) { a.AccountType = "savings" }, true},
+		{"bad routing", func(a *BankAccount) { a.RoutingNumber = "021000022" }, false},
+		{"short account", func(a *BankAccount) { a.AccountNumber = "123" }, false},
+		{"long account", func(a *BankAccount) { a.AccountNumber = "123456789012345678" }, false},
+		{"account with letters", func(a *BankAccount) { a.AccountNumber = "1234abcd" }, false},
+		{"unknown type", func(a *BankAccount) { a.AccountType = "brokerage" }, false},
+	}
+	for _, tt := range tests {
+		account := valid
+		tt.change(&account)
+		err := account.Validate()
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidBankAccount) {
+			t.Errorf("%s: got %v, want ErrInvalidBankAccount", tt.name, err)
+		}
+	}
+}
+
+// quietSandbox never moves transfers on from pending within a test
+func quietSandbox() *Sandbox {
+	return NewSandbox(SandboxConfig{ProcessingDelay: time.Hour})
+}
+
+func TestSandboxIdempotency(t *testing.T) {
+	sandbox := quietSandbox()
+	ctx := context.Background()
+	account, err := sandbox.TokenizeBankAccount(ctx, BankAccount{RoutingNumber: "021000021", AccountNumber: "123456789", AccountType: "checking"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.AccountLast4 != "6789" {
+		t.Errorf("last four = %s, want 6789", account.AccountLast4)
+	}
+
+	first, err := sandbox.DebitACH(ctx, TransferRequest{Token: account.Token, AmountCents: 500, IdempotencyKey: "escrow:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := sandbox.DebitACH(ctx, TransferRequest{Token: account.Token, AmountCents: 500, IdempotencyKey: "escrow:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("retry with the same key made transfer %s, want %s", again.ID, first.ID)
+	}
+
+	other, err := sandbox.DebitACH(ctx, TransferRequest{Token: account.Token, AmountCents: 500, IdempotencyKey: "escrow:1:2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == first.ID {
+		t.Errorf("a new key returned the earlier transfer %s", first.ID)
+	}
+
+	if _, err := sandbox.Payout(ctx, TransferRequest{Token: "sbx_ba_unknown", AmountCents: 500}); !errors.Is(err, ErrUnknownToken) {
+		t.Errorf("unknown token: got %v, want ErrUnknownToken", err)
+	}
+	if _, err := sandbox.Payout(ctx, TransferRequest{Token: account.Token}); err == nil {
+		t.Error("zero amount transfer was accepted")
+	}
+}
+
+func TestSandboxParseWebhook(t *testing.T) {
+	sandbox := quietSandbox()
+	payload := []byte(`{"id":"sbx_ev_000001","transfer_id":"sbx_tr_000001","status":"returned","return_code":"R01"}`)
+
+	t.Setenv("PAYMENTS_WEBHOOK_SECRET", "")
+	if _, err := sandbox.ParseWebhook(payload, SignWebhook(payload)); !errors.Is(err, ErrWebhookNotConfigured) {
+		t.Errorf("without a secret: got %v, want ErrWebhookNotConfigured", err)
+	}
+
+	t.Setenv("PAYMENTS_WEBHOOK_SECRET", "test-secret")
+	event, err := sandbox.ParseWebhook(payload, SignWebhook(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.TransferID != "sbx_tr_000001" || event.Status != StatusReturned || event.ReturnCode != "R01" {
+		t.Errorf("event = %+v", event)
+	}
+
+	tampered := []byte(`{"id":"sbx_ev_000001","transfer_id":"sbx_tr_000001","status":"settled"}`)
+	if _, err := sandbox.ParseWebhook(tampered, SignWebhook(payload)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered payload: got %v, want ErrInvalidSignature", err)
+	}
+}
@@ -0,0 +1,272 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SandboxConfig controls how the sandbox processor behaves
+type SandboxConfig struct {
+	// ProcessingDelay is how long a transfer stays pending before it is processing
+	ProcessingDelay time.Duration
+	// SettleDelay is how long a transfer stays processing before it settles or fails
+	SettleDelay time.Duration
+	// ReturnDelay is how long after settlement a returned transfer comes back
+	ReturnDelay time.Duration
+	// FailureCodes maps account numbers to a return code applied before settlement
+	FailureCodes map[string]string
+	// ReturnCodes maps account numbers to a return code applied after settlement
+	ReturnCodes map[string]string
+	// EventSink receives every status change, as the provider's webhook would
+	EventSink func(Event)
+}
+
+// DefaultSandboxFailureCodes are account numbers that always fail before settlement
+var DefaultSandboxFailureCodes = map[string]string{
+	"000000000002": "R02", // Account closed
+	"000000000003": "R03", // No account / unable to locate account
+	"000000000004": "R04", // Invalid account number
+}
+
+// DefaultSandboxReturnCodes are account numbers that settle and then come back returned
+var DefaultSandboxReturnCodes = map[string]string{
+	"000000000001": "R01", // Insufficient funds
+	"000000000010": "R10", // Customer advises not authorized
+	"000000000029": "R29", // Corporate customer advises not authorized
+}
+
+// SandboxConfigFromEnv builds a sandbox configuration from SANDBOX_* environment variables
+func SandboxConfigFromEnv() SandboxConfig {
+	cfg := SandboxConfig{
+		ProcessingDelay: time.Second,
+		SettleDelay:     5 * time.Second,
+		ReturnDelay:     10 * time.Second,
+		FailureCodes:    DefaultSandboxFailureCodes,
+		ReturnCodes:     DefaultSandboxReturnCodes,
+	}
+	if d, err := time.ParseDuration(os.Getenv("SANDBOX_PROCESSING_DELAY")); err == nil {
+		cfg.ProcessingDelay = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SANDBOX_SETTLE_DELAY")); err == nil {
+		cfg.SettleDelay = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SANDBOX_RETURN_DELAY")); err == nil {
+		cfg.ReturnDelay = d
+	}
+	return cfg
+}
+
+// Sandbox is a deterministic in-process Processor. Tokens are derived from the
+// bank details, transfer IDs are sequential, and outcomes are decided by the
+// account number so every scenario can be reproduced.
+type Sandbox struct {
+	config    SandboxConfig
+	mu        sync.Mutex
+	accounts  map[string]BankAccount
+	transfers map[string]*Transfer
+	idem      map[string]string
+	seq       int
+	eventSeq  int
+}
+
+// NewSandbox returns a sandbox processor with the given configuration
+func NewSandbox(config SandboxConfig) *Sandbox {
+	if config.FailureCodes == nil {
+		config.FailureCodes = map[string]string{}
+	}
+	if config.ReturnCodes == nil {
+		config.ReturnCodes = map[string]string{}
+	}
+	return &Sandbox{
+		config:    config,
+		accounts:  map[string]BankAccount{},
+		transfers: map[string]*Transfer{},
+		idem:      map[string]string{},
+	}
+}
+
+// SetEventSink sets the function that receives status change events
+func (s *Sandbox) SetEventSink(sink func(Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.EventSink = sink
+}
+
+// Name identifies the sandbox in stored records
+func (s *Sandbox) Name() string { return "sandbox" }
+
+// TokenizeBankAccount validates the account and returns a token derived from it
+func (s *Sandbox) TokenizeBankAccount(ctx context.Context, account BankAccount) (*TokenizedAccount, error) {
+	if err := account.Validate(); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(account.RoutingNumber + ":" + account.AccountNumber))
+	token := "sbx_ba_" + hex.EncodeToString(sum[:8])
+
+	s.mu.Lock()
+	s.accounts[token] = account
+	s.mu.Unlock()
+
+	return &TokenizedAccount{Token: token, AccountLast4: account.Last4()}, nil
+}
+
+// DebitACH pulls money from a tokenized account
+func (s *Sandbox) DebitACH(ctx context.Context, req TransferRequest) (*Transfer, error) {
+	return s.submit(DirectionDebit, req)
+}
+
+// CreditACH pushes money to a tokenized account
+func (s *Sandbox) CreditACH(ctx context.Context, req TransferRequest) (*Transfer, error) {
+	return s.submit(DirectionCredit, req)
+}
+
+// Payout pushes a carrier payout to a tokenized account
+func (s *Sandbox) Payout(ctx context.Context, req TransferRequest) (*Transfer, error) {
+	return s.submit(DirectionPayout, req)
+}
+
+// ParseWebhook verifies the HMAC signature of a webhook delivery and decodes it
+func (s *Sandbox) ParseWebhook(payload []byte, signature string) (*Event, error) {
+	if len(WebhookSecret()) == 0 {
+		return nil, ErrWebhookNotConfigured // Anyone could sign with an empty key
+	}
+	if !hmac.Equal([]byte(SignWebhook(payload)), []byte(signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Return forces a settled transfer to come back with the given return code
+func (s *Sandbox) Return(transferID, code string) error {
+	s.mu.Lock()
+	transfer, ok := s.transfers[transferID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown transfer %s", transferID)
+	}
+	if transfer.Status != StatusSettled {
+		s.mu.Unlock()
+		return fmt.Errorf("transfer %s is %s, only settled transfers can be returned", transferID, transfer.Status)
+	}
+	s.mu.Unlock()
+
+	s.setStatus(transferID, StatusReturned, code)
+	return nil
+}
+
+// Transfer returns a copy of the sandbox's record of a transfer
+func (s *Sandbox) Transfer(transferID string) (*Transfer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfer, ok := s.transfers[transferID]
+	if !ok {
+		return nil, false
+	}
+	copied := *transfer
+	return &copied, true
+}
+
+// submit records a new transfer and schedules its lifecycle
+func (s *Sandbox) submit(direction Direction, req TransferRequest) (*Transfer, error) {
+	if req.AmountCents <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+
+	s.mu.Lock()
+	if req.IdempotencyKey != "" {
+		if id, ok := s.idem[req.IdempotencyKey]; ok {
+			copied := *s.transfers[id]
+			s.mu.Unlock()
+			return &copied, nil
+		}
+	}
+
+	account, ok := s.accounts[req.Token]
+	if !ok {
+		s.mu.Unlock()
+		return nil, ErrUnknownToken
+	}
+
+	s.seq++
+	transfer := &Transfer{
+		ID:          fmt.Sprintf("sbx_tr_%06d", s.seq),
+		Direction:   direction,
+		Token:       req.Token,
+		AmountCents: req.AmountCents,
+		Status:      StatusPending,
+		CreatedAt:   time.Now(),
+	}
+	s.transfers[transfer.ID] = transfer
+	if req.IdempotencyKey != "" {
+		s.idem[req.IdempotencyKey] = transfer.ID
+	}
+	failureCode := s.config.FailureCodes[account.AccountNumber]
+	returnCode := s.config.ReturnCodes[account.AccountNumber]
+	copied := *transfer
+	s.mu.Unlock()
+
+	go s.run(transfer.ID, failureCode, returnCode)
+
+	return &copied, nil
+}
+
+// run walks a transfer through its lifecycle using the configured delays
+func (s *Sandbox) run(transferID, failureCode, returnCode string) {
+	time.Sleep(s.config.ProcessingDelay)
+	s.setStatus(transferID, StatusProcessing, "")
+
+	time.Sleep(s.config.SettleDelay)
+	if failureCode != "" {
+		s.setStatus(transferID, StatusFailed, failureCode)
+		return
+	}
+	s.setStatus(transferID, StatusSettled, "")
+
+	if returnCode != "" {
+		time.Sleep(s.config.ReturnDelay)
+		s.setStatus(transferID, StatusReturned, returnCode)
+	}
+}
+
+// setStatus updates a transfer and emits the matching event
+func (s *Sandbox) setStatus(transferID string, status TransferStatus, code string) {
+	s.mu.Lock()
+	transfer := s.transfers[transferID]
+	transfer.Status = status
+	transfer.ReturnCode = code
+	s.eventSeq++
+	event := Event{
+		ID:         fmt.Sprintf("sbx_ev_%06d", s.eventSeq),
+		TransferID: transferID,
+		Status:     status,
+		ReturnCode: code,
+		OccurredAt: time.Now(),
+	}
+	sink := s.config.EventSink
+	s.mu.Unlock()
+
+	if sink != nil {
+		sink(event)
+	}
+}
+
+// SignWebhook returns the hex HMAC-SHA256 signature of a webhook payload
+func SignWebhook(payload []byte) string {
+	mac := hmac.New(sha256.New, WebhookSecret())
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,238 @@
+// Package tenant confines database access to the authenticated user's company.
+//
+// Models that belong to a company implement Owned. Once Register has installed the
+// callbacks, every query, update and delete on an owned table run with a request's
+// context is limited to rows owned by the request's company, and creates of rows owned
+// by another company are refused. Contexts without a Scope (background jobs, webhooks,
+// sign-in) are not restricted. Raw SQL is never rewritten and must filter itself.
+//
+// Every lift of the restriction within a request, by a system admin or through System, is
+// noted on the request's Scope so it can be audited.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrCrossTenant is returned when a create would write a row owned by another company
+var ErrCrossTenant = errors.New("row belongs to another company")
+
+// Owned is implemented by models that belong to a company. OwnerColumns are the columns
+// holding the owning company IDs; a row is visible to a company named in any of them.
+type Owned interface {
+	OwnerColumns() []string
+}
+
+// Scope is the tenant a request runs as
+type Scope struct {
+	ActorID   uuid.UUID
+	CompanyID uuid.UUID
+	Bypass    bool // System admins see every company's rows; each use is recorded
+
+	mu       sync.Mutex
+	bypassed map[string]bool
+}
+
+// ForCompany scopes a request to the actor's company
+func ForCompany(actorID, companyID uuid.UUID) *Scope {
+	return &Scope{ActorID: actorID, CompanyID: companyID}
+}
+
+// ForSystemAdmin lets a system admin read and write across companies
+func ForSystemAdmin(actorID, companyID uuid.UUID) *Scope {
+	return &Scope{ActorID: actorID, CompanyID: companyID, Bypass: true}
+}
+
+// BypassedTables lists the owned tables the restriction was lifted on, e.g. "loads:query"
+// for a system admin or "loads:query:load-board" for a System query
+func (s *Scope) BypassedTables() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tables []string
+	for table := range s.bypassed {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// noteBypass records that the restriction was lifted on table
+func (s *Scope) noteBypass(table, action string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bypassed == nil {
+		s.bypassed = map[string]bool{}
+	}
+	s.bypassed[table+":"+action] = true
+}
+
+type scopeKey struct{}
+type systemKey struct{}
+
+// WithScope returns a context carrying scope
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// FromContext returns the scope carried by ctx, or nil
+func FromContext(ctx context.Context) *Scope {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(scopeKey{}).(*Scope)
+	return scope
+}
+
+// System lifts the tenant scope for work done on a user's behalf that must touch other
+// companies' rows, such as creating the carrier payable when a shipper completes a load or
+// showing carriers the load board. reason names the use, e.g. "load-board", and is noted on
+// the request's scope with each table it reaches. Callers are responsible for having
+// authorized the action.
+func System(db *gorm.DB, reason string) *gorm.DB {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return db.WithContext(context.WithValue(ctx, systemKey{}, reason))
+}
+
+// owned maps table names to their owner columns
+var owned sync.Map
+
+// Register records which of models are tenant owned and installs the callbacks
+func Register(db *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		o, ok := model.(Owned)
+		if !ok {
+			continue
+		}
+		s, err := schema.Parse(model, &sync.Map{}, db.NamingStrategy)
+		if err != nil {
+			return err
+		}
+		for _, column := range o.OwnerColumns() {
+			if s.LookUpField(column) == nil {
+				return errors.New("tenant: " + s.Table + " has no column " + column)
+			}
+		}
+		owned.Store(s.Table, o.OwnerColumns())
+	}
+
+	callbacks := db.Callback()
+	if err := callbacks.Query().Before("gorm:query").Register("tenant:query", restrict("query")); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenant:row", restrict("query")); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenant:update", restrict("update")); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("tenant:delete", restrict("delete")); err != nil {
+		return err
+	}
+	return callbacks.Create().Before("gorm:create").Register("tenant:create", checkCreate)
+}
+
+// active returns the scope and owner columns that apply to the statement, if any. A
+// statement run through System is not restricted, and the lift is noted on the scope.
+func active(db *gorm.DB, action string) (*Scope, []string) {
+	ctx := db.Statement.Context
+	if db.Error != nil || ctx == nil {
+		return nil, nil
+	}
+	scope := FromContext(ctx)
+	if scope == nil {
+		return nil, nil
+	}
+	columns, ok := owned.Load(db.Statement.Table)
+	if !ok {
+		return nil, nil
+	}
+	if reason, lifted := ctx.Value(systemKey{}).(string); lifted {
+		scope.noteBypass(db.Statement.Table, action+":"+reason)
+		return nil, nil
+	}
+	return scope, columns.([]string)
+}
+
+// restrict adds "owner_a = company OR owner_b = company" to the statement
+func restrict(action string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		scope, columns := active(db, action)
+		if scope == nil {
+			return
+		}
+		if scope.Bypass {
+			scope.noteBypass(db.Statement.Table, action)
+			return
+		}
+
+		exprs := make([]clause.Expression, len(columns))
+		for i, column := range columns {
+			exprs[i] = clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: scope.CompanyID}
+		}
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.Or(exprs...)}})
+	}
+}
+
+// checkCreate refuses to create rows the scope's company does not own
+func checkCreate(db *gorm.DB) {
+	scope, columns := active(db, "create")
+	if scope == nil || db.Statement.Schema == nil {
+		return
+	}
+	if scope.Bypass {
+		scope.noteBypass(db.Statement.Table, "create")
+		return
+	}
+
+	ctx := db.Statement.Context
+	rows := db.Statement.ReflectValue
+	check := func(row reflect.Value) bool {
+		for _, column := range columns {
+			field := db.Statement.Schema.LookUpField(column)
+			if field == nil {
+				continue
+			}
+			value, zero := field.ValueOf(ctx, row)
+			if zero {
+				continue
+			}
+			switch id := value.(type) {
+			case uuid.UUID:
+				if id == scope.CompanyID {
+					return true
+				}
+			case *uuid.UUID:
+				if id != nil && *id == scope.CompanyID {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	switch rows.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rows.Len(); i++ {
+			if !check(reflect.Indirect(rows.Index(i))) {
+				db.AddError(ErrCrossTenant)
+				return
+			}
+		}
+	case reflect.Struct:
+		if !check(rows) {
+			db.AddError(ErrCrossTenant)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testLoad is an owned model shaped like models.Load, owned by its shipper and carrier
+type testLoad struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	ShipperCompanyID uuid.UUID  `gorm:"type:uuid"`
+	CarrierCompanyID *uuid.UUID `gorm:"type:uuid"`
+	Status           string
+}
+
+func (testLoad) OwnerColumns() []string {
+	return []string{"shipper_company_id", "carrier_company_id"}
+}
+
+var (
+	companyA = uuid.MustParse("aaaaaaaa-0000-0000-0000-000000000001")
+	companyB = uuid.MustParse("bbbbbbbb-0000-0000-0000-000000000002")
+	rowOfB   = uuid.MustParse("bbbbbbbb-0000-0000-0000-0000000000ff")
+)
+
+// openDryRun returns a Postgres database with the tenant callbacks installed that builds
+// statements without running them, so the SQL the callbacks produce can be inspected. It
+// never connects: there is no ping and writes don't open a transaction.
+func openDryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost dbname=tenant_test sslmode=disable"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(db, &testLoad{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// explain returns the statement's SQL with its variables written in
+func explain(db *gorm.DB) string {
+	return db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...)
+}
+
+// ownerFilter is the condition the callbacks add for company
+func ownerFilter(company uuid.UUID) string {
+	return `("test_loads"."shipper_company_id" = '` + company.String() + `' OR "test_loads"."carrier_company_id" = '` + company.String() + `')`
+}
+
+func scoped(db *gorm.DB, scope *Scope) *gorm.DB {
+	return db.WithContext(WithScope(context.Background(), scope))
+}
+
+func TestReadsOnlyReturnOwnRows(t *testing.T) {
+	db := openDryRun(t)
+	scope := ForCompany(uuid.New(), companyA)
+
+	queries := map[string]*gorm.DB{
+		"find":  scoped(db, scope).Where("status = ?", "posted").Find(&[]testLoad{}),
+		"first": scoped(db, scope).First(&testLoad{}, "id = ?", rowOfB),
+		"count": scoped(db, scope).Model(&testLoad{}).Count(new(int64)),
+		// A caller's own OR must not widen the filter
+		"or": scoped(db, scope).Where("shipper_company_id = ? OR 1 = 1", companyB).Find(&[]testLoad{}),
+	}
+	for name, result := range queries {
+		sql := explain(result)
+		if !strings.Contains(sql, ownerFilter(companyA)) {
+			t.Errorf("%s: company A's query is not limited to its rows: %s", name, sql)
+		}
+	}
+
+	sql := explain(queries["or"])
+	if !strings.Contains(sql, "(shipper_company_id = '"+companyB.String()+"' OR 1 = 1) AND "+ownerFilter(companyA)) {
+		t.Errorf("caller's OR is not kept apart from the owner filter: %s", sql)
+	}
+	if tables := scope.BypassedTables(); len(tables) != 0 {
+		t.Errorf("company scope noted a bypass: %v", tables)
+	}
+}
+
+func TestWritesToOtherCompaniesRowsMatchNothing(t *testing.T) {
+	db := openDryRun(t)
+	scope := ForCompany(uuid.New(), companyA)
+
+	writes := map[string]*gorm.DB{
+		"update":  scoped(db, scope).Model(&testLoad{ID: rowOfB}).Update("status", "cancelled"),
+		"updates": scoped(db, scope).Model(&testLoad{}).Where("id = ?", rowOfB).Updates(map[string]interface{}{"status": "cancelled"}),
+		"delete":  scoped(db, scope).Delete(&testLoad{ID: rowOfB}),
+	}
+	for name, result := range writes {
+		if result.Error != nil {
+			t.Fatalf("%s: %v", name, result.Error)
+		}
+		sql := explain(result)
+		if !strings.Contains(sql, rowOfB.String()) || !strings.Contains(sql, ownerFilter(companyA)) {
+			t.Errorf("%s: company A's write to B's row is not limited to A's rows: %s", name, sql)
+		}
+	}
+}
+
+func TestCreatesForOtherCompaniesAreRefused(t *testing.T) {
+	db := openDryRun(t)
+	scope := ForCompany(uuid.New(), companyA)
+
+	err := scoped(db, scope).Create(&testLoad{ID: uuid.New(), ShipperCompanyID: companyB}).Error
+	if !errors.Is(err, ErrCrossTenant) {
+		t.Errorf("create of B's row: got %v, want ErrCrossTenant", err)
+	}
+
+	rows := []testLoad{
+		{ID: uuid.New(), ShipperCompanyID: companyA},
+		{ID: uuid.New(), ShipperCompanyID: companyB},
+	}
+	if err := scoped(db, scope).Create(&rows).Error; !errors.Is(err, ErrCrossTenant) {
+		t.Errorf("batch create including B's row: got %v, want ErrCrossTenant", err)
+	}
+
+	carrier := companyA
+	own := []testLoad{
+		{ID: uuid.New(), ShipperCompanyID: companyA},
+		{ID: uuid.New(), ShipperCompanyID: companyB, CarrierCompanyID: &carrier},
+	}
+	if err := scoped(db, scope).Create(&own).Error; err != nil {
+		t.Errorf("create of rows A owns: %v", err)
+	}
+}
+
+func TestSystemAdminBypassIsNoted(t *testing.T) {
+	db := openDryRun(t)
+	scope := ForSystemAdmin(uuid.New(), companyA)
+
+	sql := explain(scoped(db, scope).Find(&[]testLoad{}))
+	if strings.Contains(sql, "shipper_company_id") {
+		t.Errorf("system admin's query is limited: %s", sql)
+	}
+	scoped(db, scope).Model(&testLoad{ID: rowOfB}).Update("status", "cancelled")
+	scoped(db, scope).Create(&testLoad{ID: uuid.New(), ShipperCompanyID: companyB})
+
+	want := []string{"test_loads:create", "test_loads:query", "test_loads:update"}
+	if got := scope.BypassedTables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bypassed tables = %v, want %v", got, want)
+	}
+}
+
+func TestSystemLiftIsNoted(t *testing.T) {
+	db := openDryRun(t)
+	scope := ForCompany(uuid.New(), companyA)
+
+	sql := explain(System(scoped(db, scope), "load-board").Where("status = ?", "posted").Find(&[]testLoad{}))
+	if strings.Contains(sql, "shipper_company_id") {
+		t.Errorf("System query is limited: %s", sql)
+	}
+
+	want := []string{"test_loads:query:load-board"}
+	if got := scope.BypassedTables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bypassed tables = %v, want %v", got, want)
+	}
+}
+
+func TestUnscopedContextsAreNotRestricted(t *testing.T) {
+	db := openDryRun(t)
+
+	sql := explain(db.Find(&[]testLoad{}))
+	if strings.Contains(sql, "shipper_company_id") {
+		t.Errorf("query without a scope is limited: %s", sql)
+	}
+	if err := db.Create(&testLoad{ID: uuid.New(), ShipperCompanyID: companyB}).Error; err != nil {
+		t.Errorf("create without a scope: %v", err)
+	}
+}